@@ -3,7 +3,9 @@ package main
 import (
 	"agentsmith/src/agent"
 	"agentsmith/src/logger"
+	"agentsmith/src/mcptools"
 	"agentsmith/src/server"
+	"agentsmith/src/store"
 	"embed"
 	"flag"
 	"fmt"
@@ -19,18 +21,55 @@ var log = logger.Logger("main", 1, 1, 1)
 var uiFS embed.FS
 
 func main() {
+	// code runners run code in a child process of the app binary
+	if mcptools.IsSandbox() {
+		os.Exit(mcptools.ServeSandbox())
+	}
+
 	defer logger.BreakOnError()
 
 	serverOnly := flag.Bool("server", false, "Run only server without UI")
 	port := flag.Int("port", 0, "Specify port for server to listen on")
+	dataDir := flag.String("data-dir", os.Getenv("AS_AGENT_DATA_DIR"), "Directory of the app data, the user data directory of the OS by default")
+	profile := flag.String("profile", os.Getenv("AS_AGENT_PROFILE"), "Profile to use, each profile has its own DB in the data directory")
+	dbFile := flag.String("db", os.Getenv("AS_AGENT_DB_FILE"), "Path of the DB file, overrides --data-dir and --profile")
+	rotateKey := flag.Bool("rotate-key", false, "Re-encrypt secrets with a new key and exit, derived from AS_AGENT_NEW_PASSPHRASE if set")
 	flag.Parse()
 
-	os.Setenv("AS_AGENT_DB_FILE", "app.db")
-	_, err := os.Stat("app.db")
-	if os.IsNotExist(err) {
-		server.InitDB()
+	// creates the DB or runs the schema migrations added since it was created
+	location := store.Location{DataDir: *dataDir, Profile: *profile, DBFile: *dbFile}
+	db, err := store.OpenLocation(location)
+	if err != nil {
+		log.E("Failed to open DB:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	// builtin file and shell tools work in the workspace of the profile
+	workspace, err := location.WorkspaceDir()
+	if err != nil {
+		log.E("Failed to find workspace directory:", err)
+		os.Exit(1)
+	}
+	mcptools.UseWorkspaceDir(workspace)
+
+	// secrets are encrypted with a key derived from the passphrase, or kept in
+	// the keyring of the OS without one
+	err = db.UnlockSecrets(os.Getenv("AS_AGENT_PASSPHRASE"))
+	if err != nil {
+		log.E("Failed to unlock secrets:", err)
+		os.Exit(1)
+	}
+	if *rotateKey {
+		if err = db.RotateKey(os.Getenv("AS_AGENT_NEW_PASSPHRASE")); err != nil {
+			log.E("Failed to rotate key of secrets:", err)
+			os.Exit(1)
+		}
+		log.D("Rotated key of secrets")
+		return
 	}
 
+	agent.UseStore(db)
 	agent.LoadAgent()
 
 	serverReadyCh := make(chan string)
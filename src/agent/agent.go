@@ -4,8 +4,13 @@ package agent
 
 import (
 	"agentsmith/src/ai"
+	"agentsmith/src/knowledge"
 	"agentsmith/src/logger"
 	"agentsmith/src/mcptools"
+	"agentsmith/src/memory"
+	"agentsmith/src/store"
+	"agentsmith/src/util"
+	"context"
 	"errors"
 	"sync"
 
@@ -16,7 +21,6 @@ var log = logger.Logger("agent", 1, 1, 1)
 
 type agent struct {
 	flashSession *Session
-	builtinTools []*mcptools.Tool
 	apiProviders []*ai.APIProvider
 	roles        []*Role
 	mcps         []*mcptools.MCPServer
@@ -24,13 +28,30 @@ type agent struct {
 }
 
 var Agent = agent{
-	builtinTools: make([]*mcptools.Tool, 0),
 	apiProviders: make([]*ai.APIProvider, 0),
 	roles:        make([]*Role, 0),
 	mcps:         make([]*mcptools.MCPServer, 0),
 	sessions:     make([]*Session, 0),
 }
 
+// storage keeps roles and sessions, see UseStore
+var storage store.Store
+
+// UseStore sets the store the agent and the packages it uses save their data
+// in. It must be called before LoadAgent.
+func UseStore(s store.Store) {
+	storage = s
+	ai.UseStore(s)
+	mcptools.UseStore(s)
+	memory.UseStore(s)
+	knowledge.UseStore(s)
+}
+
+// MigrateDB upgrades the schema of the store to the version of the app.
+func MigrateDB() error {
+	return storage.Migrate()
+}
+
 func LoadAgent() {
 	var signal sync.WaitGroup
 
@@ -65,11 +86,11 @@ func LoadAgent() {
 		Agent.mcps = mcptools.LoadMCPServers(onMCPUpdate)
 	}()
 
-	// load builtin tools
+	// load builtin tool settings
 	signal.Add(1)
 	go func() {
 		defer signal.Done()
-		Agent.builtinTools = mcptools.GetBuiltinTools()
+		mcptools.LoadBuiltinToolSettings()
 	}()
 
 	signal.Wait()
@@ -83,6 +104,16 @@ func GetModels() []*ai.Model {
 	return models
 }
 
+// Embed computes embeddings of the texts with the model of any loaded
+// provider.
+func Embed(ctx context.Context, modelID string, texts []string) ([][]float32, error) {
+	model := findModel(modelID)
+	if model == nil {
+		return nil, errors.New("model not found")
+	}
+	return model.Provider.Embed(ctx, model.ID, texts)
+}
+
 func GetProviders() []*ai.APIProvider {
 	return Agent.apiProviders
 }
@@ -119,7 +150,7 @@ func GetTools() []*mcptools.Tool {
 }
 
 func GetBuiltinTools() []*mcptools.Tool {
-	return Agent.builtinTools
+	return mcptools.GetBuiltinTools()
 }
 
 func onMCPUpdate(mcp *mcptools.MCPServer) {
@@ -140,6 +171,7 @@ func DeleteSession(id string) error {
 	for i, session := range Agent.sessions {
 		if session.ID == id {
 			session.Delete()
+			cancelMemoryExtraction(id)
 			Agent.sessions = append(Agent.sessions[:i], Agent.sessions[i+1:]...)
 			return nil
 		}
@@ -148,58 +180,110 @@ func DeleteSession(id string) error {
 	return errors.New("session not found")
 }
 
+// DeleteMessage removes the message from its branch, replies to it are kept.
+// Deleting assistant message also removes the tool calls that led to it,
+// unless they are shared with other branches.
 func DeleteMessage(sessionID string, messageID string) error {
-	// find the session
-	// find the message
-	// if its assistant message - delete backwards possible chain of tool requests and calls
-	for _, session := range Agent.sessions {
-		if session.ID == sessionID {
-			for i, message := range session.Messages {
-				if message.ID == messageID {
-					if message.Origin == ai.MessageOriginUser {
-						session.Messages = append(session.Messages[:i], session.Messages[i+1:]...)
-						session.Save()
-						sseCh <- &SSEMessage{Type: SSEMessageSessionUpdate, Data: session}
-						return nil
-					} else if message.Origin == ai.MessageOriginAI {
-						for k := i - 1; k >= 0; k-- {
-							if session.Messages[k].Origin == ai.MessageOriginUser {
-								session.Messages = append(session.Messages[:k+1], session.Messages[i+1:]...)
-								session.Save()
-								sseCh <- &SSEMessage{Type: SSEMessageSessionUpdate, Data: session}
-								break
-							}
-						}
-						session.Messages = append(session.Messages[:i], session.Messages[i+1:]...)
-						session.Save()
-						sseCh <- &SSEMessage{Type: SSEMessageSessionUpdate, Data: session}
-					}
-					return nil
-				}
+	session, err := findSession(sessionID)
+	if err != nil {
+		log.E("trying to delete message of non existing session", sessionID)
+		return err
+	}
+	message := session.findMessage(messageID)
+	if message == nil {
+		log.E("trying to delete non existing message", sessionID, messageID)
+		return ErrMessageNotFound
+	}
+
+	removed := map[string]bool{message.ID: true}
+	parentID := message.ParentID
+	if message.Origin == ai.MessageOriginAI {
+		for parent := session.findMessage(parentID); parent != nil && parent.Origin != ai.MessageOriginUser; parent = session.findMessage(parentID) {
+			if len(session.children(parent.ID)) > 1 {
+				break
 			}
-			break
+			removed[parent.ID] = true
+			parentID = parent.ParentID
 		}
 	}
-	log.E("trying to delete non existing message", sessionID, messageID)
-	return errors.New("message not found")
+	session.removeMessages(removed, parentID)
+	session.Save()
+	sseCh <- &SSEMessage{Type: SSEMessageSessionUpdate, Data: session}
+	return nil
 }
 
+// TruncateSession removes the message and all replies to it on every branch.
 func TruncateSession(sessionID string, messageID string) error {
-	for _, session := range Agent.sessions {
-		if session.ID == sessionID {
-			for i, message := range session.Messages {
-				if message.ID == messageID {
-					session.Messages = session.Messages[:i]
-					session.Save()
-					sseCh <- &SSEMessage{Type: SSEMessageSessionUpdate, Data: session}
-					return nil
-				}
-			}
-			break
+	session, err := findSession(sessionID)
+	if err != nil {
+		log.E("trying to truncate non existing session", sessionID)
+		return err
+	}
+	message := session.findMessage(messageID)
+	if message == nil {
+		log.E("trying to delete non existing message", sessionID, messageID)
+		return ErrMessageNotFound
+	}
+	session.removeMessages(session.subtree(message.ID), message.ParentID)
+	session.Save()
+	sseCh <- &SSEMessage{Type: SSEMessageSessionUpdate, Data: session}
+	return nil
+}
+
+func ListBuiltinTools() []mcptools.BuiltinToolInfo {
+	return mcptools.ListBuiltinTools()
+}
+
+func SetBuiltinToolEnabled(name string, enabled bool) error {
+	return mcptools.SetBuiltinToolEnabled(name, enabled)
+}
+
+// TestBuiltinTool executes a builtin directly, outside of any chat. Tools that
+// need an agent, like the sub-agent tool, return an error here. Tools that
+// require approval wait for the user decision as they do in a chat.
+func TestBuiltinTool(ctx context.Context, name string, params map[string]any) (string, error) {
+	callRequest := &mcptools.ToolCallRequest{ID: uuid.NewString(), Name: name, Params: params}
+	for _, tool := range mcptools.GetBuiltinTools() {
+		if tool.Name != name || !tool.RequiresApproval {
+			continue
+		}
+		approved, err := requestApproval(ctx, "", callRequest)
+		if err != nil {
+			return "", err
+		}
+		if !approved {
+			return "", ErrToolCallDenied
 		}
 	}
-	log.E("trying to delete non existing message", sessionID, messageID)
-	return errors.New("message not found")
+	return mcptools.CallBuiltinTool(ctx, callRequest)
+}
+
+func GetFetchAudit(sessionID string) ([]mcptools.FetchAuditEntry, error) {
+	return mcptools.GetFetchAudit(sessionID)
+}
+
+func GetDatasets() []*mcptools.Dataset {
+	return mcptools.LoadDatasets()
+}
+
+// AttachDataset registers local SQLite or CSV file for the SQL tools.
+func AttachDataset(name string, path string) (*mcptools.Dataset, error) {
+	if _, err := mcptools.FindDataset(name); err == nil {
+		return nil, errors.New("dataset with this name already exists")
+	}
+	dataset, err := mcptools.NewDataset(name, path)
+	if err != nil {
+		return nil, err
+	}
+	return dataset, dataset.Save()
+}
+
+func DeleteDataset(id string) error {
+	dataset, err := mcptools.FindDataset(id)
+	if err != nil {
+		return err
+	}
+	return dataset.Delete()
 }
 
 func TestMCPServer(name string, transport string, url string, command string, active bool) (res bool) {
@@ -351,7 +435,15 @@ func ReloadAllMCPServers() {
 	}
 }
 
-func TesProvider(Name string, APIURL string, APIKey string, RateLimit int) (res bool) {
+// TesProvider checks the provider lists models. ID is of the provider being
+// edited, if any, its API key is used when APIKey is the masked one.
+func TesProvider(ID string, Name string, APIURL string, APIKey string, RateLimit int) (res bool) {
+	for _, provider := range Agent.apiProviders {
+		if ID != "" && provider.ID == ID {
+			APIKey = util.UnmaskSecret(APIKey, provider.APIKey)
+			break
+		}
+	}
 	provider := &ai.APIProvider{
 		Name:    Name,
 		APIURL:  APIURL,
@@ -366,6 +458,7 @@ func UpdateProvider(ID string, Name string, APIURL string, APIKey string, RateLi
 		if provider.ID == ID {
 			provider.Name = Name
 			provider.RateLimit = RateLimit
+			APIKey = util.UnmaskSecret(APIKey, provider.APIKey)
 			if APIURL != provider.APIURL || APIKey != provider.APIKey {
 				provider.APIURL = APIURL
 				provider.APIKey = APIKey
@@ -412,6 +505,9 @@ func DeleteProvider(id string) (err error) {
 }
 
 func CreateRole(config RoleConfig) (*Role, error) {
+	if err := CheckResponseSchema(config.ResponseSchema); err != nil {
+		return nil, err
+	}
 	role := &Role{
 		ID:     uuid.NewString(),
 		Config: config,
@@ -425,6 +521,9 @@ func CreateRole(config RoleConfig) (*Role, error) {
 }
 
 func UpdateRole(id string, config RoleConfig) (*Role, error) {
+	if err := CheckResponseSchema(config.ResponseSchema); err != nil {
+		return nil, err
+	}
 	for _, role := range Agent.roles {
 		if role.ID == id {
 			role.Config = config
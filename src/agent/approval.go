@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"agentsmith/src/mcptools"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ApprovalTimeout is how long a tool call waits for the user decision before
+// it is treated as denied.
+var ApprovalTimeout = 10 * time.Minute
+
+var ErrToolCallDenied = errors.New("tool call was denied by the user")
+
+// ToolApproval is a pending tool call of a tool that requires approval.
+type ToolApproval struct {
+	ID        string                    `json:"id"`
+	SessionID string                    `json:"sessionId"`
+	Tool      *mcptools.ToolCallRequest `json:"tool"`
+	CreatedAt time.Time                 `json:"createdAt"`
+
+	decision chan bool `json:"-"`
+}
+
+var approvals = struct {
+	mu      sync.Mutex
+	pending map[string]*ToolApproval
+}{pending: make(map[string]*ToolApproval)}
+
+// requestApproval broadcasts tool_approval_request and blocks until the call
+// is approved or denied with ResolveApproval, the approval times out or ctx is
+// done.
+func requestApproval(ctx context.Context, sessionID string, callRequest *mcptools.ToolCallRequest) (bool, error) {
+	approval := &ToolApproval{
+		ID:        uuid.NewString(),
+		SessionID: sessionID,
+		Tool:      callRequest,
+		CreatedAt: time.Now(),
+		decision:  make(chan bool, 1),
+	}
+
+	approvals.mu.Lock()
+	approvals.pending[approval.ID] = approval
+	approvals.mu.Unlock()
+	defer func() {
+		approvals.mu.Lock()
+		delete(approvals.pending, approval.ID)
+		approvals.mu.Unlock()
+	}()
+
+	sseCh <- &SSEMessage{Type: SSEMessageToolApprovalRequest, Data: approval}
+
+	timer := time.NewTimer(ApprovalTimeout)
+	defer timer.Stop()
+	select {
+	case approved := <-approval.decision:
+		return approved, nil
+	case <-timer.C:
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// ResolveApproval approves or denies a pending tool call.
+func ResolveApproval(id string, approved bool) error {
+	approvals.mu.Lock()
+	defer approvals.mu.Unlock()
+
+	approval, ok := approvals.pending[id]
+	if !ok {
+		log.E("trying to resolve non existing approval", id)
+		return errors.New("approval not found")
+	}
+	delete(approvals.pending, id)
+	approval.decision <- approved
+	return nil
+}
+
+// GetPendingApprovals returns tool calls waiting for the user decision.
+func GetPendingApprovals() []ToolApproval {
+	approvals.mu.Lock()
+	defer approvals.mu.Unlock()
+
+	res := make([]ToolApproval, 0, len(approvals.pending))
+	for _, approval := range approvals.pending {
+		res = append(res, *approval)
+	}
+	return res
+}
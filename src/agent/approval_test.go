@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"agentsmith/src/mcptools"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRequestApproval(t *testing.T) {
+	call := &mcptools.ToolCallRequest{Name: "fs_write", Params: map[string]any{"path": "a.txt"}}
+
+	for _, approve := range []bool{true, false} {
+		go func() {
+			msg := <-sseCh
+			approval := msg.Data.(*ToolApproval)
+			if len(GetPendingApprovals()) != 1 {
+				t.Errorf("expected one pending approval")
+			}
+			ResolveApproval(approval.ID, approve)
+		}()
+
+		approved, err := requestApproval(context.Background(), "session", call)
+		if err != nil || approved != approve {
+			t.Fatalf("requestApproval = %v, %v, want %v", approved, err, approve)
+		}
+	}
+	if len(GetPendingApprovals()) != 0 {
+		t.Fatal("resolved approvals must be removed")
+	}
+}
+
+func TestRequestApproval_Timeout(t *testing.T) {
+	saved := ApprovalTimeout
+	ApprovalTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { ApprovalTimeout = saved })
+
+	go func() { <-sseCh }()
+	approved, err := requestApproval(context.Background(), "session", &mcptools.ToolCallRequest{Name: "fs_write"})
+	if err != nil || approved {
+		t.Fatalf("expected timed out approval to be denied, got %v, %v", approved, err)
+	}
+}
+
+// resolveApprovals answers tool approval requests sent while the test runs,
+// other SSE messages are discarded.
+func resolveApprovals(t *testing.T, approve bool) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case msg := <-sseCh:
+				if approval, ok := msg.Data.(*ToolApproval); ok && msg.Type == SSEMessageToolApprovalRequest {
+					ResolveApproval(approval.ID, approve)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() { close(done) })
+}
+
+func TestBuiltinToolRequiresApproval(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AS_AGENT_WORKSPACE_DIR", dir)
+
+	t.Run("denied", func(t *testing.T) {
+		resolveApprovals(t, false)
+		_, err := TestBuiltinTool(context.Background(), "fs_write", map[string]any{"path": "denied.txt", "content": "x"})
+		if !errors.Is(err, ErrToolCallDenied) {
+			t.Fatalf("expected ErrToolCallDenied, got %v", err)
+		}
+		if _, err = os.Stat(filepath.Join(dir, "denied.txt")); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("denied tool call must not write the file, got %v", err)
+		}
+	})
+
+	t.Run("approved", func(t *testing.T) {
+		resolveApprovals(t, true)
+		if _, err := TestBuiltinTool(context.Background(), "fs_write", map[string]any{"path": "approved.txt", "content": "x"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "approved.txt")); err != nil {
+			t.Fatalf("approved tool call must write the file: %v", err)
+		}
+	})
+}
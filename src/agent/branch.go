@@ -0,0 +1,229 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"errors"
+	"slices"
+)
+
+var ErrMessageNotFound = errors.New("message not found")
+
+var ErrNotUserMessage = errors.New("only user messages can be edited")
+
+var ErrNoUserMessage = errors.New("message does not answer a user message")
+
+// BranchInfo describes the place of a message in the conversation tree: all
+// alternatives that share its parent, including the message, oldest first.
+type BranchInfo struct {
+	ParentID string   `json:"parentId"`
+	Siblings []string `json:"siblings"`
+	Index    int      `json:"index"`
+}
+
+func (s *Session) findMessage(id string) *ai.Message {
+	if id == "" {
+		return nil
+	}
+	for _, message := range s.Messages {
+		if message.ID == id {
+			return message
+		}
+	}
+	return nil
+}
+
+// lastMessage returns the last message of the active branch, or nil when the
+// branch is empty.
+func (s *Session) lastMessage() *ai.Message {
+	return s.findMessage(s.ActiveID)
+}
+
+func (s *Session) children(parentID string) []*ai.Message {
+	res := make([]*ai.Message, 0, 2)
+	for _, message := range s.Messages {
+		if message.ParentID == parentID {
+			res = append(res, message)
+		}
+	}
+	return res
+}
+
+// Branch returns messages of the active branch, from the first message to
+// the active one.
+func (s *Session) Branch() []*ai.Message {
+	branch := make([]*ai.Message, 0, len(s.Messages))
+	for message := s.lastMessage(); message != nil && len(branch) < len(s.Messages); message = s.findMessage(message.ParentID) {
+		branch = append(branch, message)
+	}
+	slices.Reverse(branch)
+	return branch
+}
+
+// history returns the active branch without its last message, that is the
+// conversation the last message replies to.
+func (s *Session) history() []*ai.Message {
+	branch := s.Branch()
+	if len(branch) == 0 {
+		return branch
+	}
+	return branch[:len(branch)-1]
+}
+
+func (s *Session) branchInfo(message *ai.Message) BranchInfo {
+	info := BranchInfo{ParentID: message.ParentID, Siblings: make([]string, 0, 2)}
+	for i, sibling := range s.children(message.ParentID) {
+		if sibling.ID == message.ID {
+			info.Index = i
+		}
+		info.Siblings = append(info.Siblings, sibling.ID)
+	}
+	return info
+}
+
+// linkMessages turns messages saved before branching, which are a single
+// branch, into a linked one.
+func (s *Session) linkMessages() {
+	for i, message := range s.Messages {
+		if i > 0 {
+			message.ParentID = s.Messages[i-1].ID
+		}
+		s.ActiveID = message.ID
+	}
+}
+
+// latestLeaf follows the most recent replies from the message to the end of
+// its branch.
+func (s *Session) latestLeaf(id string) string {
+	for {
+		replies := s.children(id)
+		if len(replies) == 0 {
+			return id
+		}
+		id = replies[len(replies)-1].ID
+	}
+}
+
+// removeMessages drops the messages and attaches their remaining replies to
+// parentID. If the active message was removed, the branch continues from
+// parentID.
+func (s *Session) removeMessages(removed map[string]bool, parentID string) {
+	kept := s.Messages[:0]
+	for _, message := range s.Messages {
+		if removed[message.ID] {
+			s.markRemoved(message.ID)
+			continue
+		}
+		if removed[message.ParentID] {
+			message.ParentID = parentID
+			s.markChanged(message.ID)
+		}
+		kept = append(kept, message)
+	}
+	s.Messages = kept
+	if removed[s.ActiveID] {
+		s.ActiveID = parentID
+	}
+}
+
+// subtree returns IDs of the message and all its replies on any branch.
+func (s *Session) subtree(id string) map[string]bool {
+	res := map[string]bool{id: true}
+	for changed := true; changed; {
+		changed = false
+		for _, message := range s.Messages {
+			if res[message.ParentID] && !res[message.ID] {
+				res[message.ID] = true
+				changed = true
+			}
+		}
+	}
+	return res
+}
+
+func findSession(sessionID string) (*Session, error) {
+	for _, session := range Agent.sessions {
+		if session.ID == sessionID {
+			return session, nil
+		}
+	}
+	return nil, errors.New("session not found")
+}
+
+// branchTarget finds the session and its message for the branch operations,
+// which are not allowed while the session is generating.
+func branchTarget(sessionID string, messageID string) (*Session, *ai.Message, error) {
+	session, err := findSession(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sessionRunning(sessionID) {
+		return nil, nil, ErrSessionRunning
+	}
+	message := session.findMessage(messageID)
+	if message == nil {
+		return nil, nil, ErrMessageNotFound
+	}
+	return session, message, nil
+}
+
+func (s *Session) activate(id string) {
+	s.ActiveID = id
+	s.Save()
+	sseCh <- &SSEMessage{Type: SSEMessageSessionUpdate, Data: s}
+}
+
+// ForkSession starts a new branch at the message: the next message added to
+// the session becomes an alternative to it, while the original branch is
+// kept and can be switched back to.
+func ForkSession(sessionID string, messageID string) (*Session, error) {
+	session, message, err := branchTarget(sessionID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	session.activate(message.ParentID)
+	return session, nil
+}
+
+// SwitchBranch makes the branch with the message active. The branch
+// continues with the most recent replies from the message onwards.
+func SwitchBranch(sessionID string, messageID string) (*Session, error) {
+	session, message, err := branchTarget(sessionID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	session.activate(session.latestLeaf(message.ID))
+	return session, nil
+}
+
+// EditMessage prepares the session for the edited text of the user message:
+// the text sent next becomes an alternative to the message, which is kept
+// along with the answers to it.
+func EditMessage(sessionID string, messageID string) (*Session, error) {
+	session, message, err := branchTarget(sessionID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.Origin != ai.MessageOriginUser {
+		return nil, ErrNotUserMessage
+	}
+	session.activate(message.ParentID)
+	return session, nil
+}
+
+// RegenerateMessage prepares the session for a new answer to the user
+// message the given message belongs to: the branch ends with the user
+// message, so the next reply becomes an alternative to the previous answer.
+// A user message can be given directly.
+func RegenerateMessage(sessionID string, messageID string) (*Session, error) {
+	session, message, err := branchTarget(sessionID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	for message.Origin != ai.MessageOriginUser {
+		if message = session.findMessage(message.ParentID); message == nil {
+			return nil, ErrNoUserMessage
+		}
+	}
+	session.activate(message.ID)
+	return session, nil
+}
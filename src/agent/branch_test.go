@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// discardSSE consumes SSE messages sent while the test runs.
+func discardSSE(t *testing.T) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sseCh:
+			case <-done:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() { close(done) })
+}
+
+func newBranchTestSession(t *testing.T) *Session {
+	t.Helper()
+	setupTestDB(t)
+	discardSSE(t)
+
+	session := &Session{ID: "session", Messages: buildExchangeMessages(2)}
+	session.linkMessages()
+	saved := Agent
+	Agent.sessions = []*Session{session}
+	t.Cleanup(func() { Agent = saved })
+	return session
+}
+
+func branchIDs(session *Session) []string {
+	ids := make([]string, 0, len(session.Messages))
+	for _, message := range session.Branch() {
+		ids = append(ids, message.ID)
+	}
+	return ids
+}
+
+func TestForkAndSwitchBranch(t *testing.T) {
+	session := newBranchTestSession(t)
+
+	if _, err := ForkSession(session.ID, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := branchIDs(session); !slices.Equal(ids, []string{"u0", "a0"}) {
+		t.Fatalf("unexpected branch after fork %v", ids)
+	}
+	session.addMessage(&ai.Message{ID: "u1b", Origin: ai.MessageOriginUser, Text: "other question"})
+	session.addMessage(&ai.Message{ID: "a1b", Origin: ai.MessageOriginAI, Text: "other answer"})
+
+	if ids := branchIDs(session); !slices.Equal(ids, []string{"u0", "a0", "u1b", "a1b"}) {
+		t.Fatalf("unexpected new branch %v", ids)
+	}
+	info := session.branchInfo(session.findMessage("u1b"))
+	if info.ParentID != "a0" || info.Index != 1 || !slices.Equal(info.Siblings, []string{"u1", "u1b"}) {
+		t.Errorf("unexpected branch info %+v", info)
+	}
+	if len(session.Messages) != 6 {
+		t.Errorf("original branch must be kept, got %d messages", len(session.Messages))
+	}
+
+	if _, err := SwitchBranch(session.ID, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := branchIDs(session); !slices.Equal(ids, []string{"u0", "a0", "u1", "a1"}) {
+		t.Errorf("unexpected branch after switch %v", ids)
+	}
+	if branch := ai.ActiveBranch(session.Messages); len(branch) != 4 || branch[3].ID != "a1b" {
+		t.Errorf("ActiveBranch must end with the last message, got %d messages", len(branch))
+	}
+
+	if _, err := SwitchBranch(session.ID, "missing"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound, got %v", err)
+	}
+}
+
+func TestDeleteAndTruncateBranches(t *testing.T) {
+	session := newBranchTestSession(t)
+	ForkSession(session.ID, "a1")
+	session.addMessage(&ai.Message{ID: "a1b", Origin: ai.MessageOriginAI, Text: "regenerated answer"})
+
+	// the other answer keeps the question
+	if err := DeleteMessage(session.ID, "a1b"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := branchIDs(session); !slices.Equal(ids, []string{"u0", "a0", "u1"}) {
+		t.Errorf("unexpected branch after delete %v", ids)
+	}
+
+	SwitchBranch(session.ID, "a1")
+	if err := DeleteMessage(session.ID, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := branchIDs(session); !slices.Equal(ids, []string{"u0", "a0", "a1"}) {
+		t.Errorf("replies of deleted message must be kept, got %v", ids)
+	}
+
+	if err := TruncateSession(session.ID, "a0"); err != nil {
+		t.Fatal(err)
+	}
+	if len(session.Messages) != 1 || session.ActiveID != "u0" {
+		t.Errorf("expected only u0 left, got %d messages, active %q", len(session.Messages), session.ActiveID)
+	}
+}
+
+func TestRegenerateMessage(t *testing.T) {
+	session := newBranchTestSession(t)
+	var question string
+	model := newStreamingTestModel(t, func(messages []map[string]any) string {
+		question, _ = messages[len(messages)-1]["content"].(string)
+		return "regenerated answer"
+	})
+	useTestAgent(t, model)
+	session.findMessage("u1").Text = "second question"
+
+	if _, err := EditMessage(session.ID, "a1"); !errors.Is(err, ErrNotUserMessage) {
+		t.Errorf("expected ErrNotUserMessage, got %v", err)
+	}
+	if _, err := RegenerateMessage(session.ID, "a1"); err != nil {
+		t.Fatal(err)
+	}
+	run, err := StartRun(context.Background(), RunKindDirectChat, session.ID, model.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = StartRun(context.Background(), RunKindToolChat, session.ID, model.ID); !errors.Is(err, ErrSessionRunning) {
+		t.Errorf("expected ErrSessionRunning for a second run, got %v", err)
+	}
+	doneCh := make(chan bool, 1)
+	DirectChatRegenerate(run, "", nil, doneCh)
+	if !<-doneCh {
+		t.Fatalf("run failed: %s", run.Reason)
+	}
+
+	if question != "second question" {
+		t.Errorf("expected the user message to be answered again, got %q", question)
+	}
+	last := session.lastMessage()
+	if last.Text != "regenerated answer" || last.ModelID != model.ID || last.ParentID != "u1" {
+		t.Errorf("unexpected regenerated message %+v", last)
+	}
+	if info := session.branchInfo(last); !slices.Equal(info.Siblings, []string{"a1", last.ID}) {
+		t.Errorf("previous answer must be kept as alternative, got %v", info.Siblings)
+	}
+}
+
+func TestMessagesAfter(t *testing.T) {
+	session := &Session{ID: "session", Messages: buildExchangeMessages(2)}
+	session.linkMessages()
+	ids := func(messages []*ai.Message) []string {
+		res := make([]string, 0, len(messages))
+		for _, message := range messages {
+			res = append(res, message.ID)
+		}
+		return res
+	}
+
+	if got := ids(session.messagesAfter("")); !slices.Equal(got, []string{"u0", "a0", "u1", "a1"}) {
+		t.Errorf("expected all messages, got %v", got)
+	}
+	if got := ids(session.messagesAfter("a0")); !slices.Equal(got, []string{"u1", "a1"}) {
+		t.Errorf("expected messages after a0, got %v", got)
+	}
+
+	// after a switch to another branch only the messages after the fork are new
+	session.Messages = append(session.Messages, &ai.Message{ID: "u1b", ParentID: "a0", Origin: ai.MessageOriginUser})
+	session.ActiveID = "u1b"
+	if got := ids(session.messagesAfter("a1")); !slices.Equal(got, []string{"u1b"}) {
+		t.Errorf("expected messages after the fork, got %v", got)
+	}
+}
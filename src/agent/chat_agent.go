@@ -3,35 +3,74 @@ package agent
 import (
 	"agentsmith/src/ai"
 	"agentsmith/src/mcptools"
-	"context"
 )
 
-func DirectChatStreaming(ctx context.Context, sessionID string, modelID string, roleID string, query string, streamDoneCh chan bool) {
-	model := findModel(modelID)
+// DirectChatStreaming streams the model answer to the query into the session,
+// without tools. When schema, or the response schema of the role, is set, the
+// answer must be JSON matching it.
+func DirectChatStreaming(run *Run, roleID string, query string, schema map[string]any, streamDoneCh chan bool) {
+	directChat(run, roleID, query, false, schema, streamDoneCh)
+}
+
+// DirectChatRegenerate answers the last message of the active branch again,
+// it has to be a user message, see RegenerateMessage.
+func DirectChatRegenerate(run *Run, roleID string, schema map[string]any, streamDoneCh chan bool) {
+	directChat(run, roleID, "", true, schema, streamDoneCh)
+}
+
+// directChat streams the answer to the query, or with regenerate to the user
+// message the active branch ends with.
+func directChat(run *Run, roleID string, query string, regenerate bool, schema map[string]any, streamDoneCh chan bool) {
+	end := func(status RunStatus, reason string) {
+		run.finish(status, reason, "")
+		streamDoneCh <- status == RunStatusFinished
+	}
+
+	model := findModel(run.ModelID)
 	if model != nil {
-		model.Provider.WaitForAllowance()
+		ctx := run.Context()
+		if err := model.Provider.WaitForAllowance(ctx); err != nil {
+			run.finishCtxDone(DefaultRunLimits)
+			streamDoneCh <- false
+			return
+		}
 		var session *Session
 		for _, s := range Agent.sessions {
-			if s.ID == sessionID {
+			if s.ID == run.SessionID {
 				session = s
 				break
 			}
 		}
 		if session == nil {
 			log.E("Session not found")
-			streamDoneCh <- false
+			end(RunStatusFailed, "session not found")
 			return
 		}
+		if regenerate {
+			last := session.lastMessage()
+			if last == nil {
+				log.E("No message to regenerate")
+				end(RunStatusFailed, "no message to regenerate")
+				return
+			}
+			query = last.Text
+		}
 
 		sysPrompt := ""
+		roleConfig := RoleConfig{}
 		for _, role := range Agent.roles {
 			if role.ID == roleID {
 				sysPrompt = "## General instruction: \n" + role.Config.GeneralInstruction +
 					"## Role and personality: \n" + role.Config.Role +
 					"## Text style and tone: \n" + role.Config.Style
+				sysPrompt += role.Config.recallMemories(ctx, query)
+				sysPrompt += role.Config.retrieveKnowledge(ctx, query)
+				roleConfig = role.Config
 				break
 			}
 		}
+		schema = roleConfig.responseSchema(schema)
+		sysPrompt += responseSchemaPrompt(model, schema)
 
 		modelResponseCh := make(chan string)
 		modelDoneCh := make(chan bool)
@@ -49,24 +88,54 @@ func DirectChatStreaming(ctx context.Context, sessionID string, modelID string,
 			}
 		}()
 
-		session.AddMessage(ai.MessageOriginUser, query, nil)
-		err := session.AddMessage(ai.MessageOriginAI, "", nil)
+		if !regenerate {
+			session.AddMessage(ai.MessageOriginUser, query, nil)
+		}
+		err := session.addReply(model, roleID)
 		log.CheckW(err, "Failed to add new message in agent")
 
-		model.Provider.ChatCompletionStream(
+		usage, err := model.Provider.ChatCompletionStream(
 			ctx,
-			session.Messages[:len(session.Messages)-1],
+			session.history(),
 			sysPrompt,
 			model,
 			[]*mcptools.Tool{},
+			schema,
 			modelResponseCh,
 			nil,
 		)
+		if ctx.Err() != nil {
+			session.Save()
+			run.finishCtxDone(DefaultRunLimits)
+			streamDoneCh <- false
+			return
+		}
+		session.setUsage(usage)
 		modelDoneCh <- true
+		if err != nil {
+			end(RunStatusFailed, err.Error())
+			return
+		}
+		if schema != nil {
+			value, err := enforceSchema(ctx, session, model, sysPrompt, schema)
+			if ctx.Err() != nil {
+				run.finishCtxDone(DefaultRunLimits)
+				streamDoneCh <- false
+				return
+			}
+			if err != nil {
+				end(RunStatusFailed, err.Error())
+				return
+			}
+			run.setStructured(value)
+		}
 		session.MaybeGenerateTitle(model)
-		streamDoneCh <- true
+		if roleConfig.ExtractMemories {
+			scheduleMemoryExtraction(session, model)
+		}
+		end(RunStatusFinished, "")
 	} else {
 		log.E("Model not found")
-		streamDoneCh <- false
+		end(RunStatusFailed, "model not found")
 	}
 }
@@ -0,0 +1,425 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"agentsmith/src/util"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Sessions are exported to share conversations outside of the app. Markdown
+// and HTML render the active branch as turns of the conversation, with tool
+// calls and their results collapsed. JSON keeps messages of all branches with
+// their data, it is the format sessions are imported from. Reasoning of the
+// models is stripped unless it is kept by the options.
+
+type ExportFormat string
+
+const (
+	ExportFormatMarkdown ExportFormat = "md"
+	ExportFormatJSON     ExportFormat = "json"
+	ExportFormatHTML     ExportFormat = "html"
+
+	ExportThinkingStrip = "strip"
+	ExportThinkingKeep  = "keep"
+
+	// SessionExportFormat marks JSON exports of sessions
+	SessionExportFormat  = "agentsmith-session"
+	SessionExportVersion = 1
+)
+
+var ErrUnknownExportFormat = errors.New("unknown export format, expected md, json or html")
+
+var ErrUnknownThinkingOption = errors.New("thinking must be strip or keep")
+
+type ExportOptions struct {
+	// Format is md when empty
+	Format ExportFormat `form:"format"`
+	// Thinking is strip or keep, reasoning is stripped when empty
+	Thinking string `form:"thinking"`
+}
+
+// Export is an exported file.
+type Export struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// SessionExport is a session in the JSON export.
+type SessionExport struct {
+	Format   string           `json:"format"`
+	Version  int              `json:"version"`
+	ID       string           `json:"id"`
+	Summary  string           `json:"summary"`
+	Date     time.Time        `json:"date"`
+	ActiveID string           `json:"activeId"`
+	Messages []*ExportMessage `json:"messages"`
+}
+
+// ExportMessage is a message with names of its model and role, the IDs only
+// mean something to the app that exported it.
+type ExportMessage struct {
+	ai.Message
+	Model string `json:"model,omitempty"`
+	Role  string `json:"role,omitempty"`
+}
+
+var contentTypes = map[ExportFormat]string{
+	ExportFormatMarkdown: "text/markdown; charset=utf-8",
+	ExportFormatJSON:     "application/json",
+	ExportFormatHTML:     "text/html; charset=utf-8",
+}
+
+func (self *ExportOptions) check() error {
+	if self.Format == "" {
+		self.Format = ExportFormatMarkdown
+	}
+	if _, ok := contentTypes[self.Format]; !ok {
+		return ErrUnknownExportFormat
+	}
+	if self.Thinking != "" && self.Thinking != ExportThinkingStrip && self.Thinking != ExportThinkingKeep {
+		return ErrUnknownThinkingOption
+	}
+	return nil
+}
+
+// ExportSession renders the session in the format of the options.
+func ExportSession(sessionID string, options ExportOptions) (*Export, error) {
+	if err := options.check(); err != nil {
+		return nil, err
+	}
+	session, err := findSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := exportSession(session, options)
+	if err != nil {
+		return nil, err
+	}
+	return &Export{exportFileName(session, string(options.Format)), contentTypes[options.Format], data}, nil
+}
+
+// ExportSessions puts exports of all sessions in one zip file.
+func ExportSessions(options ExportOptions) (*Export, error) {
+	if err := options.check(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, session := range Agent.sessions {
+		data, err := exportSession(session, options)
+		if err != nil {
+			return nil, err
+		}
+		header := &zip.FileHeader{Name: exportFileName(session, string(options.Format)), Method: zip.Deflate, Modified: session.Date}
+		file, err := archive.CreateHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = file.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("agentsmith-sessions-%s.zip", time.Now().Format("2006-01-02"))
+	return &Export{name, "application/zip", buf.Bytes()}, nil
+}
+
+func exportSession(session *Session, options ExportOptions) ([]byte, error) {
+	keepThinking := options.Thinking == ExportThinkingKeep
+	switch options.Format {
+	case ExportFormatJSON:
+		return exportJSON(session, keepThinking)
+	case ExportFormatHTML:
+		return exportHTML(session, keepThinking)
+	default:
+		return exportMarkdown(session, keepThinking), nil
+	}
+}
+
+var notSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// exportFileName is the date, summary and ID of the session, so that files
+// of sessions are sorted by date and don't collide.
+func exportFileName(session *Session, ext string) string {
+	slug := strings.Trim(notSlugChars.ReplaceAllString(strings.ToLower(session.Summary), "-"), "-")
+	if len(slug) > 40 {
+		slug = strings.TrimRight(slug[:40], "-")
+	}
+	if slug == "" {
+		slug = "session"
+	}
+	id := session.ID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return fmt.Sprintf("%s-%s-%s.%s", session.Date.Format("2006-01-02"), slug, id, ext)
+}
+
+func exportJSON(session *Session, keepThinking bool) ([]byte, error) {
+	export := &SessionExport{
+		Format:   SessionExportFormat,
+		Version:  SessionExportVersion,
+		ID:       session.ID,
+		Summary:  session.Summary,
+		Date:     session.Date,
+		ActiveID: session.ActiveID,
+		Messages: make([]*ExportMessage, 0, len(session.Messages)),
+	}
+	for _, message := range session.Messages {
+		exported := &ExportMessage{Message: *message, Model: modelName(message.ModelID), Role: roleName(message.RoleID)}
+		if !keepThinking {
+			_, exported.Text = util.SplitThinking(message.Text)
+		}
+		export.Messages = append(export.Messages, exported)
+	}
+	return json.MarshalIndent(export, "", "  ")
+}
+
+// exportTurn is a message of the active branch as it is rendered.
+type exportTurn struct {
+	Speaker  string
+	Meta     string
+	Thinking string
+	Text     string
+	// Details are collapsed, tool calls of the message or the tool result
+	Details []exportDetail
+}
+
+type exportDetail struct {
+	Summary string
+	Lang    string
+	Body    string
+}
+
+// exportHeader is the summary of the session the export starts with.
+type exportHeader struct {
+	Title  string
+	ID     string
+	Date   string
+	Models []string
+	Roles  []string
+}
+
+var toolCallTag = regexp.MustCompile(`(?s)<tool_call>.*?</tool_call>`)
+
+// exportTurns returns turns of the active branch, without messages that have
+// nothing to show.
+func exportTurns(session *Session, keepThinking bool) (*exportHeader, []*exportTurn) {
+	header := &exportHeader{Title: session.Summary, ID: session.ID, Date: session.Date.Format("2006-01-02 15:04")}
+	turns := make([]*exportTurn, 0, len(session.Messages))
+	for _, message := range session.Branch() {
+		thinking, text := util.SplitThinking(message.Text)
+		if !keepThinking {
+			thinking = ""
+		}
+		turn := &exportTurn{Thinking: thinking, Text: text}
+
+		switch message.Origin {
+		case ai.MessageOriginUser:
+			turn.Speaker = "User"
+		case ai.MessageOriginTool:
+			turn.Speaker, turn.Text = "Tool", ""
+			name := "tool"
+			if len(message.ToolRequests) > 0 {
+				name = message.ToolRequests[0].Name
+			}
+			turn.Details = append(turn.Details, exportDetail{Summary: "Result of " + name, Body: text})
+		case ai.MessageOriginSystem:
+			turn.Speaker = "System"
+		default:
+			turn.Speaker = "Assistant"
+			meta := make([]string, 0, 2)
+			if model := modelName(message.ModelID); model != "" {
+				meta = append(meta, model)
+				header.Models = appendUnique(header.Models, model)
+			}
+			if role := roleName(message.RoleID); role != "" {
+				meta = append(meta, role)
+				header.Roles = appendUnique(header.Roles, role)
+			}
+			turn.Meta = strings.Join(meta, " · ")
+
+			// the tool call the model wrote is shown collapsed instead
+			for _, request := range message.ToolRequests {
+				params, _ := json.MarshalIndent(request.Params, "", "  ")
+				turn.Details = append(turn.Details, exportDetail{Summary: "Tool call: " + request.Name, Lang: "json", Body: string(params)})
+			}
+			if len(message.ToolRequests) > 0 {
+				turn.Text = strings.TrimSpace(toolCallTag.ReplaceAllString(turn.Text, ""))
+				if strings.HasPrefix(turn.Text, "{") || strings.HasPrefix(turn.Text, "```") {
+					turn.Text = ""
+				}
+			}
+		}
+
+		if turn.Text != "" || turn.Thinking != "" || len(turn.Details) > 0 {
+			turns = append(turns, turn)
+		}
+	}
+	return header, turns
+}
+
+func exportMarkdown(session *Session, keepThinking bool) []byte {
+	header, turns := exportTurns(session, keepThinking)
+
+	var md strings.Builder
+	fmt.Fprintf(&md, "# %s\n\n", header.Title)
+	fmt.Fprintf(&md, "- Date: %s\n", header.Date)
+	if len(header.Models) > 0 {
+		fmt.Fprintf(&md, "- Models: %s\n", strings.Join(header.Models, ", "))
+	}
+	if len(header.Roles) > 0 {
+		fmt.Fprintf(&md, "- Roles: %s\n", strings.Join(header.Roles, ", "))
+	}
+	fmt.Fprintf(&md, "- Session: %s\n", header.ID)
+
+	for _, turn := range turns {
+		md.WriteString("\n---\n\n### " + turn.Speaker)
+		if turn.Meta != "" {
+			md.WriteString(" (" + turn.Meta + ")")
+		}
+		md.WriteString("\n\n")
+		if turn.Thinking != "" {
+			writeMarkdownDetails(&md, exportDetail{Summary: "Reasoning", Body: turn.Thinking})
+		}
+		if turn.Text != "" {
+			md.WriteString(turn.Text + "\n\n")
+		}
+		for _, detail := range turn.Details {
+			writeMarkdownDetails(&md, detail)
+		}
+	}
+	return []byte(md.String())
+}
+
+// writeMarkdownDetails writes the detail as a collapsed block with the body
+// in a code fence longer than any backtick run in it.
+func writeMarkdownDetails(md *strings.Builder, detail exportDetail) {
+	fence := "```"
+	for strings.Contains(detail.Body, fence) {
+		fence += "`"
+	}
+	fmt.Fprintf(md, "<details>\n<summary>%s</summary>\n\n%s%s\n%s\n%s\n</details>\n\n",
+		template.HTMLEscapeString(detail.Summary), fence, detail.Lang, detail.Body, fence)
+}
+
+var exportHTMLTemplate = template.Must(template.New("session").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Header.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, sans-serif; max-width: 860px; margin: 2em auto; padding: 0 1em; color: #222; line-height: 1.5; }
+header { border-bottom: 1px solid #ddd; margin-bottom: 1.5em; }
+header dl { display: grid; grid-template-columns: max-content 1fr; gap: 0.2em 1em; color: #555; }
+header dt { font-weight: 600; }
+header dd { margin: 0; }
+.turn { margin: 1em 0; padding: 0.8em 1em; border-radius: 8px; background: #f6f6f6; }
+.turn.user { background: #e8f0fe; }
+.turn.tool { background: #fff; border: 1px solid #e3e3e3; }
+.speaker { font-weight: 600; margin-bottom: 0.4em; }
+.meta { font-weight: normal; color: #777; }
+.text { white-space: pre-wrap; overflow-wrap: anywhere; }
+details { margin: 0.5em 0; }
+summary { cursor: pointer; color: #555; }
+pre { background: #272822; color: #f8f8f2; padding: 0.8em; border-radius: 6px; overflow-x: auto; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Header.Title}}</h1>
+<dl>
+<dt>Date</dt><dd>{{.Header.Date}}</dd>
+{{- with .Header.Models}}
+<dt>Models</dt><dd>{{range $i, $m := .}}{{if $i}}, {{end}}{{$m}}{{end}}</dd>
+{{- end}}
+{{- with .Header.Roles}}
+<dt>Roles</dt><dd>{{range $i, $r := .}}{{if $i}}, {{end}}{{$r}}{{end}}</dd>
+{{- end}}
+<dt>Session</dt><dd>{{.Header.ID}}</dd>
+</dl>
+</header>
+{{- range .Turns}}
+<section class="turn {{.Class}}">
+<div class="speaker">{{.Speaker}}{{with .Meta}} <span class="meta">{{.}}</span>{{end}}</div>
+{{- with .Thinking}}
+<details><summary>Reasoning</summary><div class="text">{{.}}</div></details>
+{{- end}}
+{{- with .Text}}
+<div class="text">{{.}}</div>
+{{- end}}
+{{- range .Details}}
+<details><summary>{{.Summary}}</summary><pre>{{.Body}}</pre></details>
+{{- end}}
+</section>
+{{- end}}
+</body>
+</html>
+`))
+
+// exportHTML renders a single file that needs nothing else to be viewed.
+func exportHTML(session *Session, keepThinking bool) ([]byte, error) {
+	header, turns := exportTurns(session, keepThinking)
+	type htmlTurn struct {
+		*exportTurn
+		Class string
+	}
+	view := struct {
+		Header *exportHeader
+		Turns  []htmlTurn
+	}{header, make([]htmlTurn, 0, len(turns))}
+	for _, turn := range turns {
+		view.Turns = append(view.Turns, htmlTurn{turn, strings.ToLower(turn.Speaker)})
+	}
+
+	var buf bytes.Buffer
+	if err := exportHTMLTemplate.Execute(&buf, view); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// modelName returns the name of the model, or its ID when it's gone.
+func modelName(id string) string {
+	if model := findModel(id); model != nil && model.Name != "" {
+		return model.Name
+	}
+	return id
+}
+
+// roleName returns the name of the role, or its ID when it's gone.
+func roleName(id string) string {
+	if role := findRole(id); role != nil && role.Config.Name != "" {
+		return role.Config.Name
+	}
+	return id
+}
+
+func findRole(id string) *Role {
+	for _, role := range Agent.roles {
+		if role.ID == id {
+			return role
+		}
+	}
+	return nil
+}
+
+func appendUnique(values []string, value string) []string {
+	if slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
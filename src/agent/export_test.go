@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"agentsmith/src/mcptools"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newExportTestSession(t *testing.T) *Session {
+	t.Helper()
+
+	call := &mcptools.ToolCallRequest{Name: "fs_read", Params: map[string]any{"path": "notes.txt"}}
+	session := &Session{
+		ID:       "0123456789abcdef",
+		Date:     time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+		Summary:  "Read the notes",
+		ActiveID: "a1",
+		Messages: []*ai.Message{
+			{ID: "u0", Origin: ai.MessageOriginUser, Text: "What is in <b>notes</b>?"},
+			{ID: "a0", ParentID: "u0", Origin: ai.MessageOriginAI, ModelID: "m1", RoleID: "r1",
+				Text:         "<think>read the file</think><tool_call>{\"name\": \"fs_read\", \"params\": {\"path\": \"notes.txt\"}}</tool_call>",
+				ToolRequests: []*mcptools.ToolCallRequest{call}},
+			{ID: "t0", ParentID: "a0", Origin: ai.MessageOriginTool, Text: "buy milk", ToolRequests: []*mcptools.ToolCallRequest{call}},
+			{ID: "a1", ParentID: "t0", Origin: ai.MessageOriginAI, ModelID: "m1", RoleID: "r1", Text: "<think>short list</think>You need to buy milk."},
+			{ID: "a1b", ParentID: "t0", Origin: ai.MessageOriginAI, ModelID: "m1", Text: "Other branch answer"},
+		},
+	}
+	saved := Agent
+	Agent.sessions = []*Session{session}
+	Agent.roles = []*Role{{ID: "r1", Config: RoleConfig{Name: "Coder"}}}
+	t.Cleanup(func() { Agent = saved })
+	return session
+}
+
+func TestExportMarkdown(t *testing.T) {
+	session := newExportTestSession(t)
+
+	export, err := ExportSession(session.ID, ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Name != "2026-10-19-read-the-notes-01234567.md" {
+		t.Errorf("unexpected file name %s", export.Name)
+	}
+	md := string(export.Data)
+	for _, expected := range []string{
+		"# Read the notes", "- Models: m1", "- Roles: Coder", "### User", "### Assistant (m1 · Coder)",
+		"<summary>Tool call: fs_read</summary>", "\"path\": \"notes.txt\"", "<summary>Result of fs_read</summary>",
+		"buy milk", "You need to buy milk.",
+	} {
+		if !strings.Contains(md, expected) {
+			t.Errorf("markdown is missing %q:\n%s", expected, md)
+		}
+	}
+	for _, unexpected := range []string{"short list", "read the file", "<tool_call>", "Other branch answer"} {
+		if strings.Contains(md, unexpected) {
+			t.Errorf("markdown must not contain %q:\n%s", unexpected, md)
+		}
+	}
+
+	export, _ = ExportSession(session.ID, ExportOptions{Format: ExportFormatMarkdown, Thinking: ExportThinkingKeep})
+	if md = string(export.Data); !strings.Contains(md, "<summary>Reasoning</summary>") || !strings.Contains(md, "short list") {
+		t.Errorf("reasoning must be kept:\n%s", md)
+	}
+}
+
+func TestExportHTMLAndJSON(t *testing.T) {
+	session := newExportTestSession(t)
+
+	export, err := ExportSession(session.ID, ExportOptions{Format: ExportFormatHTML})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(export.Data)
+	if !strings.HasPrefix(page, "<!DOCTYPE html>") || strings.Contains(page, "<script") || strings.Contains(page, "<link") {
+		t.Errorf("expected a self-contained page:\n%s", page)
+	}
+	if !strings.Contains(page, "What is in &lt;b&gt;notes&lt;/b&gt;?") {
+		t.Errorf("message text must be escaped:\n%s", page)
+	}
+
+	export, err = ExportSession(session.ID, ExportOptions{Format: ExportFormatJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported SessionExport
+	if err = json.Unmarshal(export.Data, &exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported.Format != SessionExportFormat || exported.ActiveID != "a1" || len(exported.Messages) != len(session.Messages) {
+		t.Fatalf("unexpected JSON export %+v", exported)
+	}
+	if last := exported.Messages[3]; last.Role != "Coder" || last.RoleID != "r1" || last.Text != "You need to buy milk." {
+		t.Errorf("unexpected exported message %+v", last)
+	}
+
+	if _, err = ExportSession(session.ID, ExportOptions{Format: "pdf"}); !errors.Is(err, ErrUnknownExportFormat) {
+		t.Errorf("expected ErrUnknownExportFormat, got %v", err)
+	}
+}
+
+func TestExportSessionsZip(t *testing.T) {
+	newExportTestSession(t)
+
+	export, err := ExportSessions(ExportOptions{Format: ExportFormatJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(export.Data), int64(len(export.Data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.File) != 1 || archive.File[0].Name != "2026-10-19-read-the-notes-01234567.json" {
+		t.Errorf("unexpected files in zip %v", archive.File)
+	}
+}
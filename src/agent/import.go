@@ -0,0 +1,313 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"agentsmith/src/store"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Sessions are imported from JSON exports of this app and from the
+// conversations.json of ChatGPT data exports, as JSON files or in zip files.
+// Imported sessions and messages get new IDs. The ID a session had in its
+// source is recorded, so importing the same file again skips it. Branches of
+// the conversation are kept, messages that can't be mapped are left out and
+// the children of a left out message are linked to its parent.
+
+const (
+	ImportSourceAgentSmith = "agentsmith"
+	ImportSourceChatGPT    = "chatgpt"
+)
+
+// importMaxEntryBytes is the size of the largest JSON file read from a zip
+// file, the import fails on bigger ones.
+const importMaxEntryBytes = 64 << 20
+
+// importMaxTotalBytes is the size of all JSON files read from a zip file, so a
+// small archive can't expand to more, tests lower it.
+var importMaxTotalBytes int64 = 256 << 20
+
+var ErrUnknownImportFormat = errors.New("unknown import format, expected JSON export of sessions or ChatGPT conversations.json")
+
+// ImportReport lists the sessions imported from a file and the ones skipped.
+type ImportReport struct {
+	Imported []*ImportedSession `json:"imported"`
+	Skipped  []*SkippedImport   `json:"skipped"`
+}
+
+type ImportedSession struct {
+	Source    string `json:"source"`
+	SourceID  string `json:"sourceId"`
+	SessionID string `json:"sessionId"`
+	Summary   string `json:"summary"`
+	Messages  int    `json:"messages"`
+	// SkippedMessages counts messages left out, by reason
+	SkippedMessages map[string]int `json:"skippedMessages,omitempty"`
+}
+
+type SkippedImport struct {
+	Source   string `json:"source"`
+	SourceID string `json:"sourceId"`
+	Summary  string `json:"summary"`
+	Reason   string `json:"reason"`
+}
+
+// importedSession is a session read from a file, not saved yet.
+type importedSession struct {
+	source   string
+	sourceID string
+	session  *Session
+	skipped  map[string]int
+}
+
+// sourceMessage is a message with its ID and parent in the source, message
+// is nil when it is left out.
+type sourceMessage struct {
+	id       string
+	parentID string
+	message  *ai.Message
+}
+
+// ImportSessions imports the sessions of a JSON file, or of the JSON files in
+// a zip file. Files in a zip that are not sessions are ignored.
+func ImportSessions(name string, data []byte) (*ImportReport, error) {
+	var sessions []*importedSession
+	var err error
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		sessions, err = readImportZip(data)
+	} else {
+		sessions, err = readImportJSON(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	report := &ImportReport{Imported: make([]*ImportedSession, 0, len(sessions)), Skipped: make([]*SkippedImport, 0)}
+	for _, imported := range sessions {
+		imported.save(report)
+	}
+	log.D("Imported sessions from", name, len(report.Imported), "skipped", len(report.Skipped))
+	return report, nil
+}
+
+func readImportZip(data []byte) ([]*importedSession, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*importedSession, 0)
+	found := false
+	total := int64(0)
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || !strings.EqualFold(path.Ext(file.Name), ".json") {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(io.LimitReader(r, min(importMaxEntryBytes, importMaxTotalBytes-total)+1))
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(content) > importMaxEntryBytes {
+			return nil, fmt.Errorf("%s: file is larger than %d bytes", file.Name, importMaxEntryBytes)
+		}
+		if total += int64(len(content)); total > importMaxTotalBytes {
+			return nil, fmt.Errorf("JSON files of the archive are larger than %d bytes", importMaxTotalBytes)
+		}
+		read, err := readImportJSON(content)
+		if errors.Is(err, ErrUnknownImportFormat) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+		found = true
+		sessions = append(sessions, read...)
+	}
+	if !found {
+		return nil, ErrUnknownImportFormat
+	}
+	return sessions, nil
+}
+
+// readImportJSON reads one session or conversation, or an array of them.
+func readImportJSON(data []byte) ([]*importedSession, error) {
+	data = bytes.TrimSpace(data)
+	items := make([]json.RawMessage, 0)
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, ErrUnknownImportFormat
+		}
+	} else {
+		items = append(items, data)
+	}
+
+	sessions := make([]*importedSession, 0, len(items))
+	for _, item := range items {
+		var probe struct {
+			Format  string          `json:"format"`
+			Version int             `json:"version"`
+			Mapping json.RawMessage `json:"mapping"`
+		}
+		if err := json.Unmarshal(item, &probe); err != nil {
+			return nil, ErrUnknownImportFormat
+		}
+
+		switch {
+		case probe.Format == SessionExportFormat:
+			if probe.Version > SessionExportVersion {
+				return nil, fmt.Errorf("session export version %d is newer than supported %d", probe.Version, SessionExportVersion)
+			}
+			var export SessionExport
+			if err := json.Unmarshal(item, &export); err != nil {
+				return nil, err
+			}
+			sessions = append(sessions, importExport(&export))
+		case len(probe.Mapping) > 0:
+			var conversation chatGPTConversation
+			if err := json.Unmarshal(item, &conversation); err != nil {
+				return nil, err
+			}
+			sessions = append(sessions, importChatGPT(&conversation))
+		default:
+			return nil, ErrUnknownImportFormat
+		}
+	}
+	return sessions, nil
+}
+
+func newImportedSession(source string, sourceID string, summary string, date time.Time) *importedSession {
+	return &importedSession{
+		source:   source,
+		sourceID: sourceID,
+		session:  &Session{ID: uuid.NewString(), Date: date, Summary: summary, Messages: make([]*ai.Message, 0)},
+		skipped:  make(map[string]int),
+	}
+}
+
+// link gives the kept messages new IDs and links each to its nearest kept
+// ancestor. The active branch ends with the nearest kept ancestor of the
+// active message of the source, or with the last message.
+func (self *importedSession) link(messages []*sourceMessage, activeSourceID string) {
+	byID := make(map[string]*sourceMessage, len(messages))
+	newIDs := make(map[string]string, len(messages))
+	for _, m := range messages {
+		byID[m.id] = m
+		if m.message != nil {
+			newIDs[m.id] = uuid.NewString()
+		}
+	}
+	keptAncestor := func(id string) string {
+		for steps := 0; id != "" && steps <= len(messages); steps++ {
+			if newID, ok := newIDs[id]; ok {
+				return newID
+			}
+			m, ok := byID[id]
+			if !ok {
+				return ""
+			}
+			id = m.parentID
+		}
+		return ""
+	}
+
+	session := self.session
+	for _, m := range messages {
+		if m.message == nil {
+			continue
+		}
+		m.message.ID = newIDs[m.id]
+		m.message.ParentID = keptAncestor(m.parentID)
+		if m.message.CreatedAt.IsZero() {
+			m.message.CreatedAt = session.Date
+		}
+		session.Messages = append(session.Messages, m.message)
+	}
+	session.ActiveID = keptAncestor(activeSourceID)
+	if session.ActiveID == "" && len(session.Messages) > 0 {
+		session.ActiveID = session.Messages[len(session.Messages)-1].ID
+	}
+}
+
+// save stores the session and adds it to the sessions of the agent, unless
+// it has no messages or was imported before.
+func (self *importedSession) save(report *ImportReport) {
+	session := self.session
+	skip := func(reason string) {
+		report.Skipped = append(report.Skipped, &SkippedImport{self.source, self.sourceID, session.Summary, reason})
+	}
+	if len(session.Messages) == 0 {
+		skip("no messages")
+		return
+	}
+	if self.source == ImportSourceAgentSmith {
+		if _, err := findSession(self.sourceID); err == nil {
+			skip("session exists")
+			return
+		}
+	}
+
+	records := make([]*store.Message, 0, len(session.Messages))
+	for _, message := range session.Messages {
+		record, err := messageRecord(session.ID, message)
+		if err != nil {
+			skip("failed to encode message: " + err.Error())
+			return
+		}
+		records = append(records, record)
+	}
+	record := &store.Session{ID: session.ID, Date: session.Date, Summary: session.Summary, ActiveID: session.ActiveID}
+	err := storage.ImportSession(self.source, self.sourceID, record, records)
+	if errors.Is(err, store.ErrAlreadyImported) {
+		skip("already imported")
+		return
+	}
+	if err != nil {
+		log.W("Failed to save imported session", self.sourceID, err)
+		skip("failed to save: " + err.Error())
+		return
+	}
+	session.setStored()
+	Agent.sessions = append(Agent.sessions, session)
+
+	imported := &ImportedSession{self.source, self.sourceID, session.ID, session.Summary, len(session.Messages), nil}
+	if len(self.skipped) > 0 {
+		imported.SkippedMessages = self.skipped
+	}
+	report.Imported = append(report.Imported, imported)
+}
+
+// importExport reads a session exported by this app. Roles are kept only if
+// they exist here, their IDs are of the app that exported the session.
+func importExport(export *SessionExport) *importedSession {
+	imported := newImportedSession(ImportSourceAgentSmith, export.ID, export.Summary, export.Date)
+	messages := make([]*sourceMessage, 0, len(export.Messages))
+	for _, exported := range export.Messages {
+		message := exported.Message
+		m := &sourceMessage{id: message.ID, parentID: message.ParentID}
+		switch message.Origin {
+		case ai.MessageOriginUser, ai.MessageOriginAI, ai.MessageOriginTool, ai.MessageOriginSystem:
+			if findRole(message.RoleID) == nil {
+				message.RoleID = ""
+			}
+			m.message = &message
+		default:
+			imported.skipped["unknown origin"]++
+		}
+		messages = append(messages, m)
+	}
+	imported.link(messages, export.ActiveID)
+	return imported
+}
@@ -0,0 +1,182 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"agentsmith/src/mcptools"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ChatGPT data exports have the conversations in conversations.json. Each
+// conversation is a tree of nodes in mapping, current_node is the last
+// message of the branch shown. Tool calls are assistant messages to another
+// recipient than "all", their results are messages of the tool role.
+
+type chatGPTConversation struct {
+	ID             string                  `json:"id"`
+	ConversationID string                  `json:"conversation_id"`
+	Title          string                  `json:"title"`
+	CreateTime     float64                 `json:"create_time"`
+	UpdateTime     float64                 `json:"update_time"`
+	CurrentNode    string                  `json:"current_node"`
+	Mapping        map[string]*chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	ID       string          `json:"id"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+	Message  *chatGPTMessage `json:"message"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+		Name string `json:"name"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Text        string            `json:"text"`
+		Result      string            `json:"result"`
+	} `json:"content"`
+	Recipient string `json:"recipient"`
+	Metadata  struct {
+		Hidden    bool   `json:"is_visually_hidden_from_conversation"`
+		ModelSlug string `json:"model_slug"`
+	} `json:"metadata"`
+}
+
+func chatGPTTime(seconds float64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(int64(seconds * 1e6))
+}
+
+// importChatGPT reads a conversation of a ChatGPT export, nodes are visited
+// from the root so that parents come before their children.
+func importChatGPT(conversation *chatGPTConversation) *importedSession {
+	id := conversation.ConversationID
+	if id == "" {
+		id = conversation.ID
+	}
+	date := chatGPTTime(conversation.UpdateTime)
+	if date.IsZero() {
+		date = chatGPTTime(conversation.CreateTime)
+	}
+	if date.IsZero() {
+		date = time.Now()
+	}
+	title := strings.TrimSpace(conversation.Title)
+	if title == "" {
+		title = "Imported chat"
+	}
+	imported := newImportedSession(ImportSourceChatGPT, id, title, date)
+
+	roots := make([]string, 0, 1)
+	for nodeID, node := range conversation.Mapping {
+		if node.Parent == "" || conversation.Mapping[node.Parent] == nil {
+			roots = append(roots, nodeID)
+		}
+	}
+	slices.Sort(roots)
+
+	messages := make([]*sourceMessage, 0, len(conversation.Mapping))
+	visited := make(map[string]bool, len(conversation.Mapping))
+	var visit func(nodeID string)
+	visit = func(nodeID string) {
+		node := conversation.Mapping[nodeID]
+		if node == nil || visited[nodeID] {
+			return
+		}
+		visited[nodeID] = true
+		m := &sourceMessage{id: nodeID, parentID: node.Parent}
+		if node.Message != nil {
+			var reason string
+			m.message, reason = chatGPTMessageToMessage(node.Message)
+			if reason != "" {
+				imported.skipped[reason]++
+			}
+		}
+		messages = append(messages, m)
+		for _, child := range node.Children {
+			visit(child)
+		}
+	}
+	for _, root := range roots {
+		visit(root)
+	}
+
+	imported.link(messages, conversation.CurrentNode)
+	return imported
+}
+
+// chatGPTMessageToMessage maps the message, or returns why it is left out.
+// Images and other attachments are left out of messages that have text.
+func chatGPTMessageToMessage(source *chatGPTMessage) (*ai.Message, string) {
+	if source.Metadata.Hidden {
+		return nil, "hidden"
+	}
+
+	var text string
+	switch source.Content.ContentType {
+	case "text", "multimodal_text":
+		parts := make([]string, 0, len(source.Content.Parts))
+		for _, part := range source.Content.Parts {
+			var s string
+			if json.Unmarshal(part, &s) == nil {
+				parts = append(parts, s)
+			}
+		}
+		text = strings.Join(parts, "\n")
+		if strings.TrimSpace(text) == "" && len(source.Content.Parts) > 0 && source.Content.ContentType == "multimodal_text" {
+			return nil, "attachments"
+		}
+	case "code", "execution_output", "tether_quote":
+		text = source.Content.Text
+	case "tether_browsing_display":
+		text = source.Content.Result
+	case "thoughts", "reasoning_recap":
+		return nil, "reasoning"
+	default:
+		return nil, "unsupported content: " + source.Content.ContentType
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, "empty"
+	}
+
+	message := &ai.Message{Text: text, CreatedAt: chatGPTTime(source.CreateTime)}
+	switch source.Author.Role {
+	case "user":
+		message.Origin = ai.MessageOriginUser
+	case "assistant":
+		message.Origin = ai.MessageOriginAI
+		message.ModelID = source.Metadata.ModelSlug
+		if source.Recipient != "" && source.Recipient != "all" {
+			// written the way models call tools here
+			call := &mcptools.ToolCallRequest{Name: source.Recipient, Params: map[string]any{}}
+			if json.Unmarshal([]byte(text), &call.Params) != nil {
+				call.Params = map[string]any{"input": text}
+			}
+			data, _ := json.Marshal(call)
+			message.Text = "<tool_call>" + string(data) + "</tool_call>"
+			message.ToolRequests = []*mcptools.ToolCallRequest{call}
+		} else if source.Content.ContentType == "code" {
+			message.Text = "```\n" + text + "\n```"
+		}
+	case "tool":
+		message.Origin = ai.MessageOriginTool
+		if source.Author.Name != "" {
+			message.ToolRequests = []*mcptools.ToolCallRequest{{Name: source.Author.Name, Params: map[string]any{}}}
+		}
+	case "system":
+		return nil, "system"
+	default:
+		return nil, "unknown author: " + source.Author.Role
+	}
+	return message, ""
+}
@@ -0,0 +1,195 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func branchTexts(session *Session) []string {
+	texts := make([]string, 0)
+	for _, message := range session.Branch() {
+		texts = append(texts, message.Text)
+	}
+	return texts
+}
+
+func TestImportChatGPT(t *testing.T) {
+	setupTestDB(t)
+	saved := Agent
+	Agent.sessions = []*Session{}
+	t.Cleanup(func() { Agent = saved })
+
+	data, err := os.ReadFile("testdata/conversations.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := ImportSessions("conversations.json", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Imported) != 1 || len(report.Skipped) != 1 || report.Skipped[0].Reason != "no messages" {
+		t.Fatalf("expected one imported and one empty conversation, got %+v %+v", report.Imported, report.Skipped)
+	}
+	imported := report.Imported[0]
+	if imported.SourceID != "conv-1" || imported.Messages != 6 ||
+		!maps.Equal(imported.SkippedMessages, map[string]int{"hidden": 1, "reasoning": 1}) {
+		t.Errorf("unexpected report %+v", imported)
+	}
+
+	session, err := findSession(imported.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Summary != "Plot a sine" || !session.Date.Equal(time.UnixMicro(1700000100250000)) {
+		t.Errorf("unexpected session %s %v", session.Summary, session.Date)
+	}
+	if texts := branchTexts(session); !slices.Equal(texts, []string{"Plot sin(x)", "And cos(x)?", "Same, with cos."}) {
+		t.Errorf("unexpected active branch %q", texts)
+	}
+	if first := session.Branch()[0]; first.Origin != ai.MessageOriginUser || !first.CreatedAt.Equal(time.Unix(1700000010, 0)) {
+		t.Errorf("unexpected first message %+v", first)
+	}
+
+	// the other branch, the thoughts left out are bridged
+	session.ActiveID = session.Messages[3].ID
+	branch := session.Branch()
+	if len(branch) != 4 || branch[3].Text != "Here is the plot." || branch[3].ModelID != "gpt-4o" {
+		t.Fatalf("unexpected branch %q", branchTexts(session))
+	}
+	call, result := branch[1], branch[2]
+	if call.Origin != ai.MessageOriginAI || len(call.ToolRequests) != 1 || call.ToolRequests[0].Name != "python" ||
+		call.ToolRequests[0].Params["input"] != "import numpy as np" {
+		t.Errorf("unexpected tool call %+v", call)
+	}
+	if result.Origin != ai.MessageOriginTool || result.Text != "<plot>" || result.ToolRequests[0].Name != "python" {
+		t.Errorf("unexpected tool result %+v", result)
+	}
+	if stored := storedMessages(t, session.ID); len(stored) != 6 {
+		t.Errorf("expected 6 stored messages, got %d", len(stored))
+	}
+
+	report, _ = ImportSessions("conversations.json", data)
+	if len(report.Imported) != 0 || report.Skipped[0].Reason != "already imported" {
+		t.Errorf("conversation must not be imported twice, got %+v %+v", report.Imported, report.Skipped)
+	}
+
+	if err = DeleteSession(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if report, _ = ImportSessions("conversations.json", data); len(report.Imported) != 1 {
+		t.Errorf("deleted conversation must be imported again, got %+v", report.Skipped)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	setupTestDB(t)
+	original := newExportTestSession(t)
+
+	export, err := ExportSession(original.ID, ExportOptions{Format: ExportFormatJSON, Thinking: ExportThinkingKeep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := ImportSessions(export.Name, export.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Imported) != 0 || len(report.Skipped) != 1 || report.Skipped[0].Reason != "session exists" {
+		t.Fatalf("session that exists must be skipped, got %+v %+v", report.Imported, report.Skipped)
+	}
+
+	Agent.sessions = []*Session{}
+	report, err = ImportSessions(export.Name, export.Data)
+	if err != nil || len(report.Imported) != 1 {
+		t.Fatalf("expected imported session, got %+v %v", report, err)
+	}
+	session, _ := findSession(report.Imported[0].SessionID)
+	if session.ID == original.ID || session.Summary != original.Summary || len(session.Messages) != len(original.Messages) {
+		t.Errorf("unexpected session %+v", session)
+	}
+	if texts, expected := branchTexts(session), branchTexts(original); !slices.Equal(texts, expected) {
+		t.Errorf("expected branch %q, got %q", expected, texts)
+	}
+	for _, message := range session.Messages {
+		if original.findMessage(message.ID) != nil {
+			t.Errorf("message %s kept its ID", message.ID)
+		}
+	}
+	if last := session.lastMessage(); last.RoleID != "r1" {
+		t.Errorf("role that exists must be kept, got %q", last.RoleID)
+	}
+
+	report, _ = ImportSessions(export.Name, export.Data)
+	if len(report.Imported) != 0 || report.Skipped[0].Reason != "already imported" {
+		t.Errorf("export must not be imported twice, got %+v", report.Skipped)
+	}
+
+	Agent.sessions = []*Session{original}
+	zipped, err := ExportSessions(ExportOptions{Format: ExportFormatJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestDB(t)
+	Agent.sessions = []*Session{}
+	if report, err = ImportSessions(zipped.Name, zipped.Data); err != nil || len(report.Imported) != 1 {
+		t.Errorf("expected session imported from zip, got %+v %v", report, err)
+	}
+
+	if _, err = ImportSessions("other.json", []byte(`{"foo": 1}`)); !errors.Is(err, ErrUnknownImportFormat) {
+		t.Errorf("expected ErrUnknownImportFormat, got %v", err)
+	}
+}
+
+func TestImportZipEntryTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	w, err := archive.Create("big.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(bytes.Repeat([]byte(" "), importMaxEntryBytes+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err = archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ImportSessions("big.zip", buf.Bytes())
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("expected error for entry over the limit, got %v", err)
+	}
+}
+
+func TestImportZipTooLarge(t *testing.T) {
+	limit := importMaxTotalBytes
+	importMaxTotalBytes = 1 << 10
+	t.Cleanup(func() { importMaxTotalBytes = limit })
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for i := range 3 {
+		w, err := archive.Create(fmt.Sprintf("part%d.json", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(bytes.Repeat([]byte(" "), 500)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ImportSessions("parts.zip", buf.Bytes())
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("expected error for archive over the limit, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"agentsmith/src/knowledge"
+	"context"
+	"errors"
+)
+
+// defaultRetrievalTopK is how many chunks are retrieved for a role that does
+// not set RetrievalTopK.
+const defaultRetrievalTopK = 4
+
+const knowledgePrompt = `
+## Knowledge base context:
+Excerpts from the user's knowledge bases that may be relevant to the last user message are below.
+Use them when they help to answer and cite the source of every excerpt you use in square brackets, e.g. [handbook/setup.md:10-24].
+If the excerpts are not relevant, ignore them.
+
+`
+
+func init() {
+	knowledge.Embedder = Embed
+}
+
+func GetKnowledgeBases() []*knowledge.KnowledgeBase {
+	return knowledge.LoadKnowledgeBases()
+}
+
+func CreateKnowledgeBase(name string, description string, embeddingModel string) (*knowledge.KnowledgeBase, error) {
+	if _, err := knowledge.FindKnowledgeBase(name); err == nil {
+		return nil, errors.New("knowledge base with this name already exists")
+	}
+	if embeddingModel != "" && findModel(embeddingModel) == nil {
+		return nil, errors.New("embedding model not found")
+	}
+	kb, err := knowledge.NewKnowledgeBase(name, description, embeddingModel)
+	if err != nil {
+		return nil, err
+	}
+	return kb, kb.Save()
+}
+
+// UpdateKnowledgeBase changes description and embedding model. Documents
+// added before the model changed keep their embeddings, so they should be
+// uploaded again.
+func UpdateKnowledgeBase(id string, description string, embeddingModel string) (*knowledge.KnowledgeBase, error) {
+	kb, err := knowledge.FindKnowledgeBase(id)
+	if err != nil {
+		return nil, err
+	}
+	if embeddingModel != "" && findModel(embeddingModel) == nil {
+		return nil, errors.New("embedding model not found")
+	}
+	kb.Description = description
+	kb.EmbeddingModel = embeddingModel
+	return kb, kb.Save()
+}
+
+func DeleteKnowledgeBase(id string) error {
+	kb, err := knowledge.FindKnowledgeBase(id)
+	if err != nil {
+		return err
+	}
+	return kb.Delete()
+}
+
+func GetKnowledgeDocuments(kbID string) ([]*knowledge.Document, error) {
+	kb, err := knowledge.FindKnowledgeBase(kbID)
+	if err != nil {
+		return nil, err
+	}
+	return kb.LoadDocuments()
+}
+
+func AddKnowledgeDocument(ctx context.Context, kbID string, name string, data []byte) (*knowledge.Document, error) {
+	kb, err := knowledge.FindKnowledgeBase(kbID)
+	if err != nil {
+		return nil, err
+	}
+	return kb.AddDocument(ctx, name, data)
+}
+
+func DeleteKnowledgeDocument(kbID string, docID string) error {
+	kb, err := knowledge.FindKnowledgeBase(kbID)
+	if err != nil {
+		return err
+	}
+	return kb.DeleteDocument(docID)
+}
+
+// SearchKnowledge searches the named knowledge bases, or all of them when
+// names is empty.
+func SearchKnowledge(ctx context.Context, names []string, query string, limit int) ([]*knowledge.SearchResult, error) {
+	kbs := knowledge.LoadKnowledgeBases()
+	if len(names) > 0 {
+		kbs = kbs[:0]
+		for _, name := range names {
+			kb, err := knowledge.FindKnowledgeBase(name)
+			if err != nil {
+				return nil, err
+			}
+			kbs = append(kbs, kb)
+		}
+	}
+	return knowledge.Search(ctx, kbs, query, limit)
+}
+
+// retrieveKnowledge returns system prompt section with chunks of the role's
+// knowledge bases relevant to the query, or an empty string. Knowledge bases
+// deleted since the role was configured are skipped.
+func (self RoleConfig) retrieveKnowledge(ctx context.Context, query string) string {
+	if len(self.KnowledgeBases) == 0 {
+		return ""
+	}
+	kbs := make([]*knowledge.KnowledgeBase, 0, len(self.KnowledgeBases))
+	for _, name := range self.KnowledgeBases {
+		kb, err := knowledge.FindKnowledgeBase(name)
+		if err != nil {
+			log.W("Role knowledge base is not available:", err)
+			continue
+		}
+		kbs = append(kbs, kb)
+	}
+
+	topK := self.RetrievalTopK
+	if topK <= 0 {
+		topK = defaultRetrievalTopK
+	}
+	results, err := knowledge.Search(ctx, kbs, query, topK)
+	if err != nil {
+		log.W("Knowledge retrieval failed:", err)
+		return ""
+	}
+	if len(results) == 0 {
+		return ""
+	}
+	return knowledgePrompt + knowledge.FormatResults(results) + "\n"
+}
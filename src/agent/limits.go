@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"agentsmith/src/mcptools"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// RunLimits are the circuit breakers applied to a single agent run. A zero
+// value for any field disables that particular limit.
+type RunLimits struct {
+	MaxToolSteps int           // tool calls the model may make before the run is stopped
+	MaxDuration  time.Duration // wall-clock time for the whole run, including tool calls
+	MaxTokens    int           // prompt+completion tokens summed over all model calls
+	MaxRepeats   int           // identical tool calls, or identical consecutive tool outputs
+}
+
+var DefaultRunLimits = RunLimits{
+	MaxToolSteps: 25,
+	MaxDuration:  15 * time.Minute,
+	MaxTokens:    0,
+	MaxRepeats:   3,
+}
+
+// WithOverrides returns a copy of the limits where every positive argument
+// replaces the corresponding field. It is used to apply per-request limits on
+// top of DefaultRunLimits.
+func (self RunLimits) WithOverrides(maxToolSteps int, maxDuration time.Duration, maxTokens int, maxRepeats int) RunLimits {
+	if maxToolSteps > 0 {
+		self.MaxToolSteps = maxToolSteps
+	}
+	if maxDuration > 0 {
+		self.MaxDuration = maxDuration
+	}
+	if maxTokens > 0 {
+		self.MaxTokens = maxTokens
+	}
+	if maxRepeats > 0 {
+		self.MaxRepeats = maxRepeats
+	}
+	return self
+}
+
+type LimitKind string
+
+const (
+	LimitToolSteps      LimitKind = "max_tool_steps"
+	LimitDuration       LimitKind = "max_duration"
+	LimitTokens         LimitKind = "max_tokens"
+	LimitRepeatedCall   LimitKind = "repeated_tool_call"
+	LimitRepeatedOutput LimitKind = "repeated_tool_output"
+)
+
+// LimitError is returned by runGuard when one of the run limits trips.
+type LimitError struct {
+	Kind   LimitKind `json:"limit"`
+	Reason string    `json:"reason"`
+}
+
+func (self *LimitError) Error() string {
+	return self.Reason
+}
+
+// runGuard keeps the per-run counters checked against RunLimits.
+type runGuard struct {
+	limits        RunLimits
+	steps         int
+	tokens        int
+	calls         map[string]int
+	lastOutput    string
+	outputRepeats int
+}
+
+func newRunGuard(limits RunLimits) *runGuard {
+	return &runGuard{limits: limits, calls: make(map[string]int)}
+}
+
+// addUsage accounts tokens of one model call. When the provider did not report
+// usage, the count is estimated from the text that went in and out.
+func (self *runGuard) addUsage(usage *ai.OpenAIChatCompletionUsage, prompt []*ai.Message, sysPrompt string, completion string) *LimitError {
+	if usage != nil && usage.TotalTokens > 0 {
+		self.tokens += usage.TotalTokens
+	} else {
+		chars := len(sysPrompt) + len(completion)
+		for _, message := range prompt {
+			chars += len(message.Text)
+		}
+		self.tokens += estimateTokens(chars)
+	}
+	return self.checkTokens()
+}
+
+// checkTokens checks the tokens used so far, including tokens of sub-agents.
+func (self *runGuard) checkTokens() *LimitError {
+	if self.limits.MaxTokens > 0 && self.tokens > self.limits.MaxTokens {
+		return &LimitError{LimitTokens, fmt.Sprintf("token limit of %d exceeded (used ~%d)", self.limits.MaxTokens, self.tokens)}
+	}
+	return nil
+}
+
+// checkToolCall must be called before a tool is executed.
+func (self *runGuard) checkToolCall(callRequest *mcptools.ToolCallRequest) *LimitError {
+	self.steps++
+	if self.limits.MaxToolSteps > 0 && self.steps > self.limits.MaxToolSteps {
+		return &LimitError{LimitToolSteps, fmt.Sprintf("tool step limit of %d reached", self.limits.MaxToolSteps)}
+	}
+
+	paramsJSON, _ := json.Marshal(callRequest.Params)
+	signature := callRequest.Name + ":" + string(paramsJSON)
+	self.calls[signature]++
+	if self.limits.MaxRepeats > 0 && self.calls[signature] >= self.limits.MaxRepeats {
+		return &LimitError{LimitRepeatedCall, fmt.Sprintf("tool %s was called %d times with identical parameters", callRequest.Name, self.calls[signature])}
+	}
+	return nil
+}
+
+// checkToolOutput must be called with the result of every executed tool.
+func (self *runGuard) checkToolOutput(output string) *LimitError {
+	if output == self.lastOutput {
+		self.outputRepeats++
+	} else {
+		self.lastOutput = output
+		self.outputRepeats = 1
+	}
+	if self.limits.MaxRepeats > 0 && self.outputRepeats >= self.limits.MaxRepeats {
+		return &LimitError{LimitRepeatedOutput, fmt.Sprintf("tools returned identical output %d times in a row", self.outputRepeats)}
+	}
+	return nil
+}
+
+func durationLimitError(limits RunLimits) *LimitError {
+	return &LimitError{LimitDuration, fmt.Sprintf("time limit of %s reached", limits.MaxDuration)}
+}
+
+// estimateTokens uses the common ~4 characters per token approximation.
+func estimateTokens(chars int) int {
+	return (chars + 3) / 4
+}
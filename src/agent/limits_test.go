@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"agentsmith/src/mcptools"
+	"testing"
+)
+
+func TestRunGuard_ToolStepLimit(t *testing.T) {
+	guard := newRunGuard(RunLimits{MaxToolSteps: 2})
+
+	for i := 0; i < 2; i++ {
+		call := &mcptools.ToolCallRequest{Name: "time", Params: map[string]any{"i": i}}
+		if err := guard.checkToolCall(call); err != nil {
+			t.Fatalf("step %d: unexpected limit error: %v", i, err)
+		}
+	}
+
+	err := guard.checkToolCall(&mcptools.ToolCallRequest{Name: "time", Params: map[string]any{"i": 2}})
+	if err == nil || err.Kind != LimitToolSteps {
+		t.Fatalf("expected %s limit error, got %v", LimitToolSteps, err)
+	}
+}
+
+func TestRunGuard_RepeatedToolCall(t *testing.T) {
+	guard := newRunGuard(RunLimits{MaxRepeats: 3})
+	call := &mcptools.ToolCallRequest{Name: "search", Params: map[string]any{"q": "weather"}}
+	other := &mcptools.ToolCallRequest{Name: "search", Params: map[string]any{"q": "news"}}
+
+	// interleaving other calls must not hide the loop
+	for _, c := range []*mcptools.ToolCallRequest{call, other, call} {
+		if err := guard.checkToolCall(c); err != nil {
+			t.Fatalf("unexpected limit error: %v", err)
+		}
+	}
+
+	err := guard.checkToolCall(call)
+	if err == nil || err.Kind != LimitRepeatedCall {
+		t.Fatalf("expected %s limit error, got %v", LimitRepeatedCall, err)
+	}
+}
+
+func TestRunGuard_RepeatedToolOutput(t *testing.T) {
+	guard := newRunGuard(RunLimits{MaxRepeats: 2})
+
+	if err := guard.checkToolOutput("a"); err != nil {
+		t.Fatalf("unexpected limit error: %v", err)
+	}
+	if err := guard.checkToolOutput("b"); err != nil {
+		t.Fatalf("unexpected limit error: %v", err)
+	}
+	err := guard.checkToolOutput("b")
+	if err == nil || err.Kind != LimitRepeatedOutput {
+		t.Fatalf("expected %s limit error, got %v", LimitRepeatedOutput, err)
+	}
+}
+
+func TestRunGuard_TokenLimit(t *testing.T) {
+	guard := newRunGuard(RunLimits{MaxTokens: 100})
+
+	if err := guard.addUsage(&ai.OpenAIChatCompletionUsage{TotalTokens: 60}, nil, "", ""); err != nil {
+		t.Fatalf("unexpected limit error: %v", err)
+	}
+
+	// no usage reported by the provider - 200 chars are estimated as 50 tokens
+	prompt := []*ai.Message{{Text: string(make([]byte, 200))}}
+	err := guard.addUsage(nil, prompt, "", "")
+	if err == nil || err.Kind != LimitTokens {
+		t.Fatalf("expected %s limit error, got %v", LimitTokens, err)
+	}
+}
+
+func TestRunLimits_WithOverrides(t *testing.T) {
+	limits := DefaultRunLimits.WithOverrides(5, 0, 1000, 0)
+
+	if limits.MaxToolSteps != 5 || limits.MaxTokens != 1000 {
+		t.Fatalf("overrides not applied: %+v", limits)
+	}
+	if limits.MaxDuration != DefaultRunLimits.MaxDuration || limits.MaxRepeats != DefaultRunLimits.MaxRepeats {
+		t.Fatalf("zero overrides must keep defaults: %+v", limits)
+	}
+}
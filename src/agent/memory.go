@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"agentsmith/src/memory"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// MemoryExtractionDelay is how long a session has to be idle to be considered
+// ended. Facts are then extracted from it for roles with ExtractMemories.
+var MemoryExtractionDelay = 10 * time.Minute
+
+// memoryRecallLimit is how many memories are added to the system prompt.
+const memoryRecallLimit = 8
+
+const memoryPrompt = `
+## Memories:
+Facts remembered from previous conversations with the user. Take them into account, but the user's current messages take precedence.
+Use memory_forget with the ID of a fact that turns out to be wrong or outdated.
+`
+
+// memoryExtraction tracks pending extractions. The last message extracted
+// from each session is kept in the DB, so messages are not extracted twice,
+// also after a restart.
+var memoryExtraction = struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}{timers: make(map[string]*time.Timer)}
+
+func GetMemories() []*memory.Memory {
+	return memory.LoadMemories()
+}
+
+// SaveMemory remembers a fact entered by the user.
+func SaveMemory(content string) (*memory.Memory, error) {
+	return memory.Save(content, memory.MemorySourceUser, "")
+}
+
+func ForgetMemory(id string) error {
+	return memory.Forget(id)
+}
+
+func SearchMemories(ctx context.Context, query string, limit int) ([]*memory.Memory, error) {
+	return memory.Search(ctx, query, limit)
+}
+
+// recallMemories returns system prompt section with memories relevant to the
+// query, or an empty string when the role does not use memory.
+func (self RoleConfig) recallMemories(ctx context.Context, query string) string {
+	if !self.Memory {
+		return ""
+	}
+	memories, err := memory.Search(ctx, query, memoryRecallLimit)
+	if err != nil {
+		log.W("Memory recall failed:", err)
+		return ""
+	}
+	if len(memories) == 0 {
+		return ""
+	}
+	return memoryPrompt + memory.Format(memories) + "\n"
+}
+
+// scheduleMemoryExtraction (re)starts the idle timer of the session. When
+// the session stays idle for MemoryExtractionDelay, facts are extracted from
+// the messages added since the last extraction.
+func scheduleMemoryExtraction(session *Session, model *ai.Model) {
+	if session.temporary || model == nil {
+		return
+	}
+	memoryExtraction.mu.Lock()
+	defer memoryExtraction.mu.Unlock()
+
+	if timer, ok := memoryExtraction.timers[session.ID]; ok {
+		timer.Stop()
+	}
+	memoryExtraction.timers[session.ID] = time.AfterFunc(MemoryExtractionDelay, func() {
+		memoryExtraction.mu.Lock()
+		delete(memoryExtraction.timers, session.ID)
+		memoryExtraction.mu.Unlock()
+
+		if _, err := extractSessionMemories(context.Background(), session, model); err != nil {
+			log.W("Failed to extract memories from session", session.ID, err)
+		}
+	})
+}
+
+// cancelMemoryExtraction drops pending extraction of a deleted session.
+func cancelMemoryExtraction(sessionID string) {
+	memoryExtraction.mu.Lock()
+	defer memoryExtraction.mu.Unlock()
+
+	if timer, ok := memoryExtraction.timers[sessionID]; ok {
+		timer.Stop()
+		delete(memoryExtraction.timers, sessionID)
+	}
+	if err := memory.SetLastExtracted(sessionID, ""); err != nil {
+		log.W("Failed to forget memory extraction of session", sessionID, err)
+	}
+}
+
+// ExtractSessionMemories extracts facts from the session right away.
+func ExtractSessionMemories(ctx context.Context, sessionID string, modelID string) ([]*memory.Memory, error) {
+	model := findModel(modelID)
+	if model == nil {
+		return nil, errors.New("model not found")
+	}
+	for _, session := range Agent.sessions {
+		if session.ID == sessionID {
+			return extractSessionMemories(ctx, session, model)
+		}
+	}
+	return nil, errors.New("session not found")
+}
+
+func extractSessionMemories(ctx context.Context, session *Session, model *ai.Model) ([]*memory.Memory, error) {
+	memoryExtraction.mu.Lock()
+	lastID, err := memory.LastExtracted(session.ID)
+	if err != nil {
+		memoryExtraction.mu.Unlock()
+		return nil, err
+	}
+	messages := session.messagesAfter(lastID)
+	if len(messages) == 0 {
+		memoryExtraction.mu.Unlock()
+		return nil, nil
+	}
+	err = memory.SetLastExtracted(session.ID, messages[len(messages)-1].ID)
+	memoryExtraction.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	memories, err := memory.Extract(ctx, model, messages, session.ID)
+	if err != nil {
+		// let the next extraction retry these messages
+		memoryExtraction.mu.Lock()
+		if err := memory.SetLastExtracted(session.ID, lastID); err != nil {
+			log.W("Failed to restore memory extraction of session", session.ID, err)
+		}
+		memoryExtraction.mu.Unlock()
+	}
+	return memories, err
+}
+
+// messagesAfter returns messages of the active branch after the message with
+// the ID. When the message is on another branch, the branches share the
+// messages up to their fork and the ones after it are returned. All messages
+// are returned when the message is not found.
+func (s *Session) messagesAfter(id string) []*ai.Message {
+	seen := make(map[string]bool)
+	for message := s.findMessage(id); message != nil && !seen[message.ID]; message = s.findMessage(message.ParentID) {
+		seen[message.ID] = true
+	}
+	branch := s.Branch()
+	from := 0
+	for i, message := range branch {
+		if seen[message.ID] {
+			from = i + 1
+		}
+	}
+	return branch[from:]
+}
@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"agentsmith/src/store"
+	"encoding/json"
+)
+
+// Messages are stored one per row, so saving a session writes only what
+// changed since the last save: new messages, the message being streamed and
+// messages re-linked by deletions. Changes are collected by markChanged and
+// markRemoved and written by Session.Save.
+
+func (s *Session) markChanged(ids ...string) {
+	if s.temporary {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.changed == nil {
+		s.changed = make(map[string]bool)
+	}
+	for _, id := range ids {
+		s.changed[id] = true
+	}
+}
+
+func (s *Session) markRemoved(ids ...string) {
+	if s.temporary {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removed = append(s.removed, ids...)
+}
+
+// takeChanges returns the unsaved changes and forgets them, restoreChanges
+// puts them back when saving fails.
+func (s *Session) takeChanges() (changed map[string]bool, removed []string, stored bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed, removed, stored = s.changed, s.removed, s.stored
+	s.changed, s.removed = nil, nil
+	return
+}
+
+func (s *Session) setStored() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stored = true
+}
+
+func (s *Session) restoreChanges(changed map[string]bool, removed []string) {
+	for id := range changed {
+		s.markChanged(id)
+	}
+	s.markRemoved(removed...)
+}
+
+// messageRecord converts the message to how the store keeps it.
+func messageRecord(sessionID string, message *ai.Message) (*store.Message, error) {
+	record := &store.Message{
+		ID:        message.ID,
+		SessionID: sessionID,
+		ParentID:  message.ParentID,
+		Origin:    string(message.Origin),
+		Text:      message.Text,
+		ModelID:   message.ModelID,
+		RoleID:    message.RoleID,
+		CreatedAt: message.CreatedAt,
+	}
+	var err error
+	if len(message.ToolRequests) > 0 {
+		if record.ToolRequests, err = json.Marshal(message.ToolRequests); err != nil {
+			return nil, err
+		}
+	}
+	if len(message.Transcript) > 0 {
+		if record.Transcript, err = json.Marshal(message.Transcript); err != nil {
+			return nil, err
+		}
+	}
+	if message.Structured != nil {
+		if record.Structured, err = json.Marshal(message.Structured); err != nil {
+			return nil, err
+		}
+	}
+	if message.Usage != nil {
+		if record.Usage, err = json.Marshal(message.Usage); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+// loadMessages returns messages of all sessions by session ID, in the order
+// they were added.
+func loadMessages() (map[string][]*ai.Message, error) {
+	records, err := storage.Messages()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make(map[string][]*ai.Message)
+	for _, record := range records {
+		message := &ai.Message{
+			ID:        record.ID,
+			ParentID:  record.ParentID,
+			Origin:    ai.MessageOrigin(record.Origin),
+			Text:      record.Text,
+			ModelID:   record.ModelID,
+			RoleID:    record.RoleID,
+			CreatedAt: record.CreatedAt,
+		}
+		unmarshalColumn(record.ToolRequests, &message.ToolRequests, message.ID)
+		unmarshalColumn(record.Transcript, &message.Transcript, message.ID)
+		unmarshalColumn(record.Structured, &message.Structured, message.ID)
+		unmarshalColumn(record.Usage, &message.Usage, message.ID)
+		messages[record.SessionID] = append(messages[record.SessionID], message)
+	}
+	return messages, nil
+}
+
+func unmarshalColumn(data json.RawMessage, dest any, messageID string) {
+	if len(data) > 0 {
+		err := json.Unmarshal(data, dest)
+		log.CheckW(err, "Failed to unmarshal column of message", messageID)
+	}
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"testing"
+	"time"
+)
+
+func storedMessages(t *testing.T, sessionID string) []*ai.Message {
+	t.Helper()
+	messages, err := loadMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messages[sessionID]
+}
+
+func TestSaveMessagesIncrementally(t *testing.T) {
+	setupTestDB(t)
+	discardSSE(t)
+
+	session := &Session{ID: "session", Date: time.Now(), Messages: buildExchangeMessages(1)}
+	session.linkMessages()
+	if err := session.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if messages := storedMessages(t, session.ID); len(messages) != 2 {
+		t.Fatalf("expected 2 stored messages, got %d", len(messages))
+	}
+
+	// rows not changed since the last save are not written again
+	_, err := storage.DB().Exec("UPDATE messages SET text='untouched' WHERE id='u0';")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.AddMessage(ai.MessageOriginUser, "next question", nil)
+	session.addMessage(&ai.Message{ID: "reply", Origin: ai.MessageOriginAI, ModelID: "model", RoleID: "role"})
+	session.Save()
+	session.UpdateLastMessage("streamed answer")
+	session.setUsage(&ai.OpenAIChatCompletionUsage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15})
+	session.Save()
+
+	messages := storedMessages(t, session.ID)
+	if len(messages) != 4 || messages[0].Text != "untouched" {
+		t.Fatalf("unexpected stored messages %+v", messages)
+	}
+	reply := messages[3]
+	if reply.Text != "streamed answer" || reply.ModelID != "model" || reply.RoleID != "role" || reply.ParentID != messages[2].ID ||
+		reply.Usage == nil || reply.Usage.TotalTokens != 15 || reply.CreatedAt.IsZero() {
+		t.Errorf("unexpected stored reply %+v", reply)
+	}
+
+	session.ClearMessages()
+	session.Save()
+	if messages = storedMessages(t, session.ID); len(messages) != 0 {
+		t.Errorf("cleared messages must be deleted, got %d", len(messages))
+	}
+}
@@ -2,18 +2,49 @@ package agent
 
 import (
 	"agentsmith/src/logger"
-	"database/sql"
+	"agentsmith/src/mcptools"
+	"agentsmith/src/store"
 	"encoding/json"
-	"os"
 
 	"github.com/google/uuid"
 )
 
+type CodeRunner string
+
+const (
+	CodeRunnerJS   CodeRunner = "js"
+	CodeRunnerLua  CodeRunner = "lua"
+	CodeRunnerBoth CodeRunner = "both"
+	CodeRunnerNone CodeRunner = "none"
+)
+
+// codeRunnerTools maps code runner choice of a role to builtin tool names.
+var codeRunnerTools = map[CodeRunner]string{
+	CodeRunnerJS:  "js_code_runner",
+	CodeRunnerLua: "lua_code_runner",
+}
+
 type RoleConfig struct {
 	Name               string `json:"name"`
 	GeneralInstruction string `json:"generalInstruction"`
 	Role               string `json:"role"`
 	Style              string `json:"style"`
+	// CodeRunner selects which code runner tool is offered to the model, Lua
+	// when empty as it was before there was a choice.
+	CodeRunner CodeRunner `json:"codeRunner,omitempty"`
+	// KnowledgeBases are names of knowledge bases searched for every user
+	// message, the best chunks are added to the system prompt.
+	KnowledgeBases []string `json:"knowledgeBases,omitempty"`
+	// RetrievalTopK is how many chunks are added, 4 when zero.
+	RetrievalTopK int `json:"retrievalTopK,omitempty"`
+	// Memory adds facts remembered from previous sessions that are relevant
+	// to the user message to the system prompt.
+	Memory bool `json:"memory,omitempty"`
+	// ExtractMemories saves facts from sessions with this role once they end.
+	ExtractMemories bool `json:"extractMemories,omitempty"`
+	// ResponseSchema is JSON Schema answers of the role must match, unless
+	// the chat request has its own.
+	ResponseSchema map[string]any `json:"responseSchema,omitempty"`
 }
 
 type Role struct {
@@ -22,44 +53,23 @@ type Role struct {
 }
 
 func LoadRoles() []*Role {
-	log.D("Loading roles from", os.Getenv("AS_AGENT_DB_FILE"))
+	log.D("Loading roles")
 	defer logger.BreakOnError()
 	roles := make([]*Role, 0, 32)
 
-	// Open a connection to the SQLite database
-	db, err := sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	log.CheckE(err, nil, "Failed to open role db")
-	defer db.Close()
-
-	query := "SELECT id, data FROM roles;"
-	rows, err := db.Query(query)
+	records, err := storage.Roles()
 	log.CheckE(err, nil, "Failed to select roles from DB")
-	defer rows.Close()
-
-	for rows.Next() {
-		var role Role
-		var dataJSON string
 
-		// Scan the row data into variables
-		err = rows.Scan(&role.ID, &dataJSON)
-		if err != nil {
-			log.W("Failed to scan role row:", err)
-			continue
-		}
-
-		// Unmarshal the JSON data from the 'data' column into Config
-		if dataJSON != "" {
-			err = json.Unmarshal([]byte(dataJSON), &role.Config)
+	for _, record := range records {
+		role := &Role{ID: record.ID}
+		if len(record.Config) > 0 {
+			err = json.Unmarshal(record.Config, &role.Config)
 			if err != nil {
 				log.W("Failed to unmarshal config for role:", role.ID, err)
 				role.Config = RoleConfig{}
 			}
-		} else {
-			role.Config = RoleConfig{}
 		}
-
-		// Append the successfully loaded role to the slice
-		roles = append(roles, &role)
+		roles = append(roles, role)
 	}
 
 	log.D("Loaded roles from DB:", len(roles))
@@ -68,38 +78,20 @@ func LoadRoles() []*Role {
 
 func newRole() *Role {
 	role := &Role{
-		ID: uuid.NewString(),
-		Config: struct {
-			Name               string `json:"name"`
-			GeneralInstruction string `json:"generalInstruction"`
-			Role               string `json:"role"`
-			Style              string `json:"style"`
-		}{},
+		ID:     uuid.NewString(),
+		Config: RoleConfig{},
 	}
 	return role
 }
 
 func (self *Role) Save() (err error) {
-	log.D("Saving role to ", os.Getenv("AS_AGENT_DB_FILE"))
+	log.D("Saving role", self.ID)
 	defer logger.BreakOnError()
 
-	var db *sql.DB
-	db, err = sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	log.CheckE(err, nil, "Failed to open DB")
-	defer db.Close()
-
 	configJSON, err := json.Marshal(self.Config)
 	log.CheckE(err, nil, "Failed to marshal config for role ", self.ID)
 
-	// Use INSERT OR REPLACE (UPSERT) to handle both new and existing roles
-	query := `
-	INSERT INTO roles (id, data)
-	VALUES (?, ?)
-	ON CONFLICT(id) DO UPDATE SET
-		data=excluded.data;
-	`
-
-	_, err = db.Exec(query, self.ID, string(configJSON))
+	err = storage.SaveRole(&store.Role{ID: self.ID, Config: configJSON})
 	log.CheckW(err, "Failed to update role DB")
 
 	log.D("Saved role", self.ID)
@@ -107,13 +99,31 @@ func (self *Role) Save() (err error) {
 }
 
 func (self *Role) Delete() {
-	log.D("Deleting role from ", os.Getenv("AS_AGENT_DB_FILE"))
+	log.D("Deleting role", self.ID)
 	defer logger.BreakOnError()
 
-	db, err := sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	log.CheckE(err, nil, "Failed to open DB")
-	defer db.Close()
+	err := storage.DeleteRole(self.ID)
+	log.CheckW(err, "Failed to delete role from DB")
+}
+
+// filterCodeRunners removes code runner tools that the role did not choose.
+func (self RoleConfig) filterCodeRunners(tools []*mcptools.Tool) []*mcptools.Tool {
+	choice := self.CodeRunner
+	if choice == "" {
+		choice = CodeRunnerLua
+	}
 
-	query := "DELETE FROM roles WHERE id=?"
-	db.Exec(query, self.ID)
+	res := make([]*mcptools.Tool, 0, len(tools))
+	for _, tool := range tools {
+		keep := true
+		for runner, name := range codeRunnerTools {
+			if tool.Name == name {
+				keep = choice == CodeRunnerBoth || choice == runner
+			}
+		}
+		if keep {
+			res = append(res, tool)
+		}
+	}
+	return res
 }
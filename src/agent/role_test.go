@@ -0,0 +1,27 @@
+package agent
+
+import (
+	"agentsmith/src/mcptools"
+	"slices"
+	"testing"
+)
+
+func TestRoleConfig_FilterCodeRunners(t *testing.T) {
+	tools := []*mcptools.Tool{{Name: "time"}, {Name: "lua_code_runner"}, {Name: "js_code_runner"}}
+
+	for runner, want := range map[CodeRunner][]string{
+		"":             {"time", "lua_code_runner"},
+		CodeRunnerJS:   {"time", "js_code_runner"},
+		CodeRunnerLua:  {"time", "lua_code_runner"},
+		CodeRunnerBoth: {"time", "lua_code_runner", "js_code_runner"},
+		CodeRunnerNone: {"time"},
+	} {
+		names := make([]string, 0, 3)
+		for _, tool := range (RoleConfig{CodeRunner: runner}).filterCodeRunners(tools) {
+			names = append(names, tool.Name)
+		}
+		if !slices.Equal(names, want) {
+			t.Errorf("code runner %q: tools = %v, want %v", runner, names, want)
+		}
+	}
+}
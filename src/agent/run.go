@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type RunKind string
+
+const (
+	RunKindToolChat   RunKind = "toolchat"
+	RunKindDirectChat RunKind = "directchat"
+)
+
+type RunStatus string
+
+const (
+	RunStatusRunning       RunStatus = "running"
+	RunStatusFinished      RunStatus = "finished"
+	RunStatusFailed        RunStatus = "failed"
+	RunStatusCancelled     RunStatus = "cancelled"
+	RunStatusLimitExceeded RunStatus = "limit_exceeded"
+)
+
+// maxFinishedRuns is how many completed runs are kept for /runs listing.
+const maxFinishedRuns = 50
+
+var errRunCancelled = errors.New("cancelled by user")
+
+var ErrSessionRunning = errors.New("session has a running generation")
+
+// Run is a single chat generation registered so that any client can list it
+// and cancel it, not only the one holding the HTTP stream open.
+type Run struct {
+	ID         string     `json:"id"`
+	Kind       RunKind    `json:"kind"`
+	SessionID  string     `json:"sessionId"`
+	ModelID    string     `json:"modelId"`
+	Status     RunStatus  `json:"status"`
+	Reason     string     `json:"reason,omitempty"`
+	Limit      LimitKind  `json:"limit,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// Structured is the answer validated against the response schema
+	Structured any `json:"structured,omitempty"`
+
+	ctx    context.Context         `json:"-"`
+	cancel context.CancelCauseFunc `json:"-"`
+}
+
+var runs = struct {
+	mu   sync.Mutex
+	list []*Run
+}{list: make([]*Run, 0, 16)}
+
+// StartRun registers a new run bound to parent context and broadcasts
+// run_started. The run context is cancelled when parent is done or CancelRun
+// is called with the run ID. A session runs one generation at a time, so two
+// loops don't append to the same branch.
+func StartRun(parent context.Context, kind RunKind, sessionID string, modelID string) (*Run, error) {
+	runs.mu.Lock()
+	if sessionID != "" && sessionRunningLocked(sessionID) {
+		runs.mu.Unlock()
+		return nil, ErrSessionRunning
+	}
+
+	ctx, cancel := context.WithCancelCause(parent)
+	run := &Run{
+		ID:        uuid.NewString(),
+		Kind:      kind,
+		SessionID: sessionID,
+		ModelID:   modelID,
+		Status:    RunStatusRunning,
+		StartedAt: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	runs.list = append(runs.list, run)
+	snapshot := *run
+	runs.mu.Unlock()
+
+	sseCh <- &SSEMessage{Type: SSEMessageRunStarted, Data: snapshot}
+	return run, nil
+}
+
+func (self *Run) Context() context.Context {
+	return self.ctx
+}
+
+// setStructured records the validated answer, it has to be set before the
+// run finishes to be broadcast with run_finished.
+func (self *Run) setStructured(value any) {
+	runs.mu.Lock()
+	defer runs.mu.Unlock()
+	self.Structured = value
+}
+
+// finish records the final status of the run, releases its context and
+// broadcasts the matching SSE event. Only the first call has an effect.
+func (self *Run) finish(status RunStatus, reason string, limit LimitKind) {
+	runs.mu.Lock()
+	if self.Status != RunStatusRunning {
+		runs.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	self.Status = status
+	self.Reason = reason
+	self.Limit = limit
+	self.FinishedAt = &now
+	snapshot := *self
+	pruneFinishedRuns()
+	runs.mu.Unlock()
+
+	self.cancel(nil)
+
+	var eventType SSEMessageType
+	switch status {
+	case RunStatusFinished:
+		eventType = SSEMessageRunFinished
+	case RunStatusCancelled:
+		eventType = SSEMessageRunCancelled
+	case RunStatusLimitExceeded:
+		eventType = SSEMessageRunLimitExceeded
+	default:
+		eventType = SSEMessageRunFailed
+	}
+	sseCh <- &SSEMessage{Type: eventType, Data: snapshot}
+}
+
+// finishCtxDone finishes the run after its context was done, telling apart a
+// user cancellation, a client disconnect and the run time limit.
+func (self *Run) finishCtxDone(limits RunLimits) {
+	cause := context.Cause(self.ctx)
+	switch {
+	case errors.Is(cause, context.DeadlineExceeded):
+		limitErr := durationLimitError(limits)
+		self.finish(RunStatusLimitExceeded, limitErr.Reason, limitErr.Kind)
+	case errors.Is(cause, errRunCancelled):
+		self.finish(RunStatusCancelled, cause.Error(), "")
+	default:
+		self.finish(RunStatusCancelled, "client disconnected", "")
+	}
+}
+
+// pruneFinishedRuns drops the oldest finished runs above maxFinishedRuns.
+// Must be called with runs.mu held.
+func pruneFinishedRuns() {
+	finished := 0
+	for _, run := range runs.list {
+		if run.Status != RunStatusRunning {
+			finished++
+		}
+	}
+	kept := runs.list[:0]
+	for _, run := range runs.list {
+		if run.Status != RunStatusRunning && finished > maxFinishedRuns {
+			finished--
+			continue
+		}
+		kept = append(kept, run)
+	}
+	runs.list = kept
+}
+
+// GetRuns returns a snapshot of active and recently finished runs.
+func GetRuns() []Run {
+	runs.mu.Lock()
+	defer runs.mu.Unlock()
+
+	res := make([]Run, len(runs.list))
+	for i, run := range runs.list {
+		res[i] = *run
+	}
+	return res
+}
+
+// sessionRunning tells if a generation is running in the session.
+func sessionRunning(sessionID string) bool {
+	runs.mu.Lock()
+	defer runs.mu.Unlock()
+	return sessionRunningLocked(sessionID)
+}
+
+// sessionRunningLocked is sessionRunning for callers holding runs.mu.
+func sessionRunningLocked(sessionID string) bool {
+	for _, run := range runs.list {
+		if run.SessionID == sessionID && run.Status == RunStatusRunning {
+			return true
+		}
+	}
+	return false
+}
+
+// CancelRun cancels the context of a running generation, which stops the model
+// stream, any in-flight MCP call and rate limiter wait.
+func CancelRun(id string) error {
+	runs.mu.Lock()
+	defer runs.mu.Unlock()
+
+	for _, run := range runs.list {
+		if run.ID == id {
+			if run.Status != RunStatusRunning {
+				return errors.New("run is not running")
+			}
+			run.cancel(errRunCancelled)
+			return nil
+		}
+	}
+	log.E("trying to cancel non existing run", id)
+	return errors.New("run not found")
+}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"agentsmith/src/util"
+	"cmp"
+	"context"
+	"database/sql"
+	"html"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 100
+)
+
+// snippet markers are control characters that can't come from the HTML
+// escaped text, they are replaced by <mark> tags after escaping.
+const (
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+)
+
+// SessionSearch filters messages of all sessions. Origin and the range of
+// message dates are optional.
+type SessionSearch struct {
+	Query  string           `json:"query"`
+	Origin ai.MessageOrigin `json:"origin,omitempty"`
+	From   time.Time        `json:"from"`
+	To     time.Time        `json:"to"`
+	Limit  int              `json:"limit,omitempty"`
+}
+
+// SearchHit is a message matching the search. Snippet is HTML escaped text
+// around the match with matched words wrapped in <mark>.
+type SearchHit struct {
+	SessionID string           `json:"sessionId"`
+	MessageID string           `json:"messageId"`
+	Summary   string           `json:"summary"`
+	Origin    ai.MessageOrigin `json:"origin"`
+	Date      time.Time        `json:"date"`
+	Snippet   string           `json:"snippet"`
+}
+
+// SearchSessions finds messages of all sessions matching any word of the
+// query, best matches first.
+func SearchSessions(ctx context.Context, search SessionSearch) ([]*SearchHit, error) {
+	limit := search.Limit
+	if limit <= 0 {
+		limit = searchDefaultLimit
+	}
+	limit = min(limit, searchMaxLimit)
+
+	hits := make([]*SearchHit, 0, limit)
+	match := util.FTSQuery(search.Query)
+	if match == "" {
+		return hits, nil
+	}
+
+	db := storage.DB()
+
+	version, err := util.FTSVersion(ctx, db, "messages_fts")
+	if err != nil {
+		return nil, err
+	}
+
+	filter := ""
+	args := []any{match}
+	if search.Origin != "" {
+		filter += " AND m.origin = ?"
+		args = append(args, search.Origin)
+	}
+	if !search.From.IsZero() {
+		filter += " AND m.created_at >= ?"
+		args = append(args, search.From.UTC())
+	}
+	if !search.To.IsZero() {
+		filter += " AND m.created_at <= ?"
+		args = append(args, search.To.UTC())
+	}
+
+	if version == "fts5" {
+		query := `
+		SELECT m.session_id, m.id, s.summary, m.origin, m.created_at,
+			snippet(messages_fts, 0, char(2), char(3), '…', 16)
+		FROM messages_fts f
+		JOIN messages m ON m.num = f.rowid
+		JOIN sessions s ON s.session_id = m.session_id
+		WHERE messages_fts MATCH ?` + filter + `
+		ORDER BY bm25(messages_fts) LIMIT ?;
+		`
+		rows, err := db.QueryContext(ctx, query, append(args, limit)...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			hit, err := scanSearchHit(rows)
+			if err != nil {
+				return nil, err
+			}
+			hits = append(hits, hit)
+		}
+		return hits, rows.Err()
+	}
+
+	// FTS4 has no ranking function, BM25 is computed from matchinfo
+	query := `
+	SELECT m.session_id, m.id, s.summary, m.origin, m.created_at,
+		snippet(messages_fts, char(2), char(3), '…', 0, 16), matchinfo(messages_fts, 'pcnalx')
+	FROM messages_fts f
+	JOIN messages m ON m.num = f.rowid
+	JOIN sessions s ON s.session_id = m.session_id
+	WHERE messages_fts MATCH ?` + filter + `;
+	`
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := make(map[*SearchHit]float64)
+	for rows.Next() {
+		var info []byte
+		hit, err := scanSearchHit(rows, &info)
+		if err != nil {
+			return nil, err
+		}
+		scores[hit] = util.BM25(info)
+		hits = append(hits, hit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(hits, func(a, b *SearchHit) int {
+		return cmp.Compare(scores[b], scores[a])
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+func scanSearchHit(rows *sql.Rows, extra ...any) (*SearchHit, error) {
+	var hit SearchHit
+	var summary sql.NullString
+	var snippet string
+	err := rows.Scan(append([]any{&hit.SessionID, &hit.MessageID, &summary, &hit.Origin, &hit.Date, &snippet}, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	hit.Summary = summary.String
+	hit.Snippet = strings.NewReplacer(snippetStart, "<mark>", snippetEnd, "</mark>").Replace(html.EscapeString(snippet))
+	return &hit, nil
+}
@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSearchSessions(t *testing.T) {
+	setupTestDB(t)
+
+	january := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	june := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	old := &Session{ID: "old", Date: january, Summary: "Trip"}
+	old.Messages = []*ai.Message{
+		{ID: "u1", Origin: ai.MessageOriginUser, Text: "Plan a trip to <Lisbon>", CreatedAt: january},
+		{ID: "a1", Origin: ai.MessageOriginAI, Text: "<think>lisbon lisbon</think>Lisbon in spring is great", CreatedAt: january},
+	}
+	old.linkMessages()
+	recent := &Session{ID: "recent", Date: june, Summary: "Cooking"}
+	recent.Messages = []*ai.Message{{ID: "u2", Origin: ai.MessageOriginUser, Text: "Pastel de nata recipe from Lisbon", CreatedAt: june}}
+	recent.linkMessages()
+	for _, session := range []*Session{old, recent} {
+		if err := session.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hits, err := SearchSessions(context.Background(), SessionSearch{Query: "lisbon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 3 {
+		t.Fatalf("expected 3 hits, got %d", len(hits))
+	}
+	for _, hit := range hits {
+		if hit.MessageID == "u1" && hit.Snippet != "Plan a trip to &lt;<mark>Lisbon</mark>&gt;" {
+			t.Errorf("unexpected snippet %q", hit.Snippet)
+		}
+	}
+
+	hits, _ = SearchSessions(context.Background(), SessionSearch{Query: "lisbon", Origin: ai.MessageOriginAI})
+	if len(hits) != 1 || hits[0].MessageID != "a1" || hits[0].Summary != "Trip" {
+		t.Errorf("unexpected hits filtered by origin %+v", hits)
+	}
+	hits, _ = SearchSessions(context.Background(), SessionSearch{Query: "lisbon", From: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)})
+	if len(hits) != 1 || hits[0].SessionID != "recent" {
+		t.Errorf("unexpected hits filtered by date %+v", hits)
+	}
+
+	// thinking of the model is not indexed
+	if hits, _ = SearchSessions(context.Background(), SessionSearch{Query: "spring"}); len(hits) != 1 {
+		t.Errorf("expected the answer to be found, got %d hits", len(hits))
+	}
+
+	old.Delete()
+	if hits, _ = SearchSessions(context.Background(), SessionSearch{Query: "trip"}); len(hits) != 0 {
+		t.Errorf("deleted session must not be found, got %+v", hits)
+	}
+}
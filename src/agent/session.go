@@ -4,77 +4,60 @@ import (
 	"agentsmith/src/ai"
 	"agentsmith/src/logger"
 	"agentsmith/src/mcptools"
+	"agentsmith/src/store"
 	"agentsmith/src/util"
-	"database/sql"
-	"encoding/json"
-	"os"
+	"context"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type Session struct {
-	ID        string        `json:"id"`
-	Date      time.Time     `json:"date"`
-	Messages  []*ai.Message `json:"messages"`
-	Summary   string        `json:"summary"`
-	temporary bool          `json:"-"`
+	ID   string    `json:"id"`
+	Date time.Time `json:"date"`
+	// Messages of all branches of the conversation, linked by ParentID in
+	// the order they were added
+	Messages []*ai.Message `json:"messages"`
+	Summary  string        `json:"summary"`
+	// ActiveID is the last message of the active branch, new messages are
+	// added after it
+	ActiveID  string `json:"activeId"`
+	temporary bool   `json:"-"`
+
+	// changes not saved yet, see Save. Until the session is stored all of
+	// its messages are saved.
+	mu      sync.Mutex
+	stored  bool
+	changed map[string]bool
+	removed []string
 }
 
 func LoadSessions() []*Session {
-	log.D("Loading sessions from", os.Getenv("AS_AGENT_DB_FILE"))
+	log.D("Loading sessions")
 	defer logger.BreakOnError()
 	sessions := make([]*Session, 0, 32)
 
-	// Open a connection to the SQLite database
-	db, err := sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	log.CheckE(err, nil, "Failed to open session db")
-	defer db.Close()
+	messages, err := loadMessages()
+	log.CheckE(err, nil, "Failed to select messages from DB")
 
-	query := "SELECT session_id, date, summary, data FROM sessions ORDER BY date DESC;"
-	rows, err := db.Query(query)
+	records, err := storage.Sessions()
 	log.CheckE(err, nil, "Failed to select sessions from DB")
-	defer rows.Close()
 
-	for rows.Next() {
-		var session Session
-		var dataJSON string
-		var dateStr string
-		var summary sql.NullString
-
-		// Scan the row data into variables
-		err = rows.Scan(&session.ID, &dateStr, &summary, &dataJSON)
-		if err != nil {
-			log.W("Failed to scan session row:", err)
-			continue
-		}
-
-		session.Date, err = time.Parse(time.RFC3339, dateStr)
-		if err != nil {
-			log.W("Failed to parse session date: ", dateStr, err)
-			session.Date = time.Time{}
-		}
-
-		if summary.Valid {
-			session.Summary = summary.String
-		} else {
-			session.Summary = ""
+	for _, record := range records {
+		session := &Session{
+			ID:       record.ID,
+			Date:     record.Date,
+			Summary:  record.Summary,
+			ActiveID: record.ActiveID,
+			Messages: messages[record.ID],
+			stored:   true,
 		}
-
-		// Unmarshal the JSON data from the 'data' column into Messages
-		if dataJSON != "" {
-			err = json.Unmarshal([]byte(dataJSON), &session.Messages)
-			if err != nil {
-				log.W("Failed to unmarshal messages for session:", session.ID, err)
-				session.Messages = make([]*ai.Message, 0)
-			}
-		} else {
+		if session.Messages == nil {
 			session.Messages = make([]*ai.Message, 0)
 		}
-
-		// Append the successfully loaded session to the slice
-		sessions = append(sessions, &session)
+		sessions = append(sessions, session)
 	}
 
 	log.D("Loaded sessions from DB:", len(sessions))
@@ -82,95 +65,127 @@ func LoadSessions() []*Session {
 }
 
 func newSession() *Session {
-	session := &Session{uuid.NewString(), time.Now(), make([]*ai.Message, 0, 32), "New chat", false}
+	session := &Session{ID: uuid.NewString(), Date: time.Now(), Messages: make([]*ai.Message, 0, 32), Summary: "New chat"}
 	return session
 }
 
 func NewTempSession() *Session {
-	session := &Session{uuid.NewString(), time.Now(), make([]*ai.Message, 0, 32), "New chat", true}
+	session := &Session{ID: uuid.NewString(), Date: time.Now(), Messages: make([]*ai.Message, 0, 32), Summary: "New chat", temporary: true}
 	return session
 }
 
+// Save writes the session and the messages changed since the last save.
 func (s *Session) Save() (err error) {
-	// log.D("Saving session to ", os.Getenv("AS_AGENT_DB_FILE"))
 	defer logger.BreakOnError()
 
-	var db *sql.DB
-	db, err = sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	log.CheckE(err, nil, "Failed to open DB")
-	defer db.Close()
-
-	messagesJSON, err := json.Marshal(s.Messages)
-	log.CheckE(err, nil, "Failed to marshal messages for session ", s.ID)
-
-	// Use INSERT OR REPLACE (UPSERT) to handle both new and existing sessions
-	query := `
-	INSERT INTO sessions (session_id, date, summary, data)
-	VALUES (?, ?, ?, ?)
-	ON CONFLICT(session_id) DO UPDATE SET
-		date=excluded.date,
-		summary=excluded.summary,
-		data=excluded.data;
-	`
-	// Format date to a standard string format for SQLite
-	dateStr := s.Date.Format(time.RFC3339)
-
-	_, err = db.Exec(query, s.ID, dateStr, s.Summary, string(messagesJSON))
-	log.CheckW(err, "Failed to update session DB")
+	changed, removed, stored := s.takeChanges()
+	defer func() {
+		if err != nil {
+			s.restoreChanges(changed, removed)
+		}
+	}()
+
+	// in the order of messages, so that rows keep the order they were added in
+	records := make([]*store.Message, 0, len(changed))
+	for _, message := range s.Messages {
+		if changed[message.ID] || !stored {
+			var record *store.Message
+			record, err = messageRecord(s.ID, message)
+			log.CheckE(err, nil, "Failed to encode message", message.ID)
+			records = append(records, record)
+		}
+	}
+
+	session := &store.Session{ID: s.ID, Date: s.Date, Summary: s.Summary, ActiveID: s.ActiveID}
+	err = storage.SaveSession(session, records, removed)
+	log.CheckE(err, nil, "Failed to update session DB")
+	s.setStored()
 
 	log.D("Saved session", s.ID)
 	return
 }
 
 func (s *Session) Delete() {
-	log.D("Deleting session from ", os.Getenv("AS_AGENT_DB_FILE"))
+	log.D("Deleting session", s.ID)
 	defer logger.BreakOnError()
 
-	db, err := sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	log.CheckE(err, nil, "Failed to open DB")
-	defer db.Close()
-
-	query := "DELETE FROM sessions WHERE session_id=?"
-	db.Exec(query, s.ID)
+	err := storage.DeleteSession(s.ID)
+	log.CheckW(err, "Failed to delete session")
 }
+
 func (s *Session) AddMessage(origin ai.MessageOrigin, text string, toolRequests []*mcptools.ToolCallRequest) error {
-	message := &ai.Message{
+	return s.addMessage(&ai.Message{
 		ID:           uuid.NewString(),
 		Origin:       origin,
 		Text:         text,
 		ToolRequests: toolRequests,
-	}
+	})
+}
 
-	s.Messages = append(s.Messages, message)
-	s.Date = time.Now()
+// addReply appends an empty assistant message the model streams its answer
+// into. roleID is the role of the request, empty without one.
+func (s *Session) addReply(model *ai.Model, roleID string) error {
+	return s.addMessage(&ai.Message{
+		ID:      uuid.NewString(),
+		Origin:  ai.MessageOriginAI,
+		ModelID: model.ID,
+		RoleID:  roleID,
+	})
+}
 
-	sseCh <- &SSEMessage{Type: SSEMessageNewMessage, Data: map[string]any{"message": message, "sessionId": s.ID}}
-	sseCh <- &SSEMessage{Type: SSEMessageSessionUpdate, Data: s}
+// addMessage appends a fully built message to the active branch. Temporary
+// sessions are neither saved nor broadcast to clients.
+func (s *Session) addMessage(message *ai.Message) error {
+	message.ParentID = s.ActiveID
+	message.CreatedAt = time.Now()
+	s.Messages = append(s.Messages, message)
+	s.ActiveID = message.ID
+	s.Date = message.CreatedAt
 
-	var err error
-	if !s.temporary {
-		err = s.Save()
+	if s.temporary {
+		return nil
 	}
+	s.markChanged(message.ID)
 
-	return err
+	sseCh <- &SSEMessage{Type: SSEMessageNewMessage, Data: map[string]any{"message": message, "sessionId": s.ID, "branch": s.branchInfo(message)}}
+	sseCh <- &SSEMessage{Type: SSEMessageSessionUpdate, Data: s}
+
+	return s.Save()
 }
 
+// UpdateLastMessage appends text to the last message of the active branch.
 func (s *Session) UpdateLastMessage(newText string) {
-	if len(s.Messages) > 0 {
-		message := s.Messages[len(s.Messages)-1]
+	if message := s.lastMessage(); message != nil {
 		message.Text = message.Text + newText
+		if s.temporary {
+			return
+		}
+		s.markChanged(message.ID)
 		sseCh <- &SSEMessage{
 			Type: SSEMessageLastMessageUpdate,
 			Data: map[string]any{
 				"sessionId": s.ID,
 				"message":   message,
+				"branch":    s.branchInfo(message),
 			}}
 	}
 
 }
 
+// setUsage records token usage of the completion of the last message.
+func (s *Session) setUsage(usage *ai.OpenAIChatCompletionUsage) {
+	if message := s.lastMessage(); message != nil && usage != nil {
+		message.Usage = usage
+		s.markChanged(message.ID)
+	}
+}
+
 func (s *Session) ClearMessages() {
+	for _, message := range s.Messages {
+		s.markRemoved(message.ID)
+	}
 	s.Messages = make([]*ai.Message, 0, 32)
+	s.ActiveID = ""
 }
 
 const titleGenerationSysPrompt = `You are naming a chat conversation based on its content so far. ` +
@@ -262,8 +277,9 @@ func (s *Session) MaybeGenerateTitle(model *ai.Model) {
 		return
 	}
 
+	branch := s.Branch()
 	userMessageCount := 0
-	for _, message := range s.Messages {
+	for _, message := range branch {
 		if message.Origin == ai.MessageOriginUser {
 			userMessageCount++
 		}
@@ -276,7 +292,7 @@ func (s *Session) MaybeGenerateTitle(model *ai.Model) {
 		return
 	}
 
-	filtered := filterMessagesForTitle(s.Messages)
+	filtered := filterMessagesForTitle(branch)
 	if len(filtered) == 0 {
 		return
 	}
@@ -287,7 +303,7 @@ func (s *Session) MaybeGenerateTitle(model *ai.Model) {
 	go func() {
 		defer logger.BreakOnError()
 
-		model.Provider.WaitForAllowance()
+		model.Provider.WaitForAllowance(context.Background())
 		response, err := model.Provider.ChatCompletion(promptMessages, titleGenerationSysPrompt, model, nil)
 		if err != nil {
 			log.W("Failed to generate session title:", err)
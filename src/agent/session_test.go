@@ -2,42 +2,25 @@ package agent
 
 import (
 	"agentsmith/src/ai"
-	"database/sql"
+	"agentsmith/src/store"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"path/filepath"
 	"testing"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
-// setupTestDB points AS_AGENT_DB_FILE at a fresh temporary sqlite DB with the
-// sessions table created, mirroring the schema in src/server/debug.go.
+// setupTestDB makes the agent use a fresh in-memory store.
 func setupTestDB(t *testing.T) {
 	t.Helper()
 
-	dbFile := filepath.Join(t.TempDir(), "test.db")
-	t.Setenv("AS_AGENT_DB_FILE", dbFile)
-
-	db, err := sql.Open("sqlite3", dbFile)
+	db, err := store.OpenMemory()
 	if err != nil {
 		t.Fatalf("failed to open test db: %v", err)
 	}
-	defer db.Close()
-
-	_, err = db.Exec(`
-	CREATE TABLE IF NOT EXISTS sessions (
-		session_id TEXT PRIMARY KEY,
-		date DATETIME,
-		summary TEXT,
-		data TEXT
-	);`)
-	if err != nil {
-		t.Fatalf("failed to create sessions table: %v", err)
-	}
+	t.Cleanup(func() { db.Close() })
+	UseStore(db)
 }
 
 // newTestModel spins up an httptest server that answers /chat/completions
@@ -72,12 +55,13 @@ func newTestModel(t *testing.T, title string) *ai.Model {
 }
 
 // buildExchangeMessages builds a plausible message history containing
-// userCount user messages, each followed by an assistant reply.
+// userCount user messages, each followed by an assistant reply. Like
+// sessions saved before branching the messages are not linked yet.
 func buildExchangeMessages(userCount int) []*ai.Message {
 	messages := make([]*ai.Message, 0, userCount*2)
 	for i := 0; i < userCount; i++ {
-		messages = append(messages, &ai.Message{ID: "u", Origin: ai.MessageOriginUser, Text: "user message"})
-		messages = append(messages, &ai.Message{ID: "a", Origin: ai.MessageOriginAI, Text: "assistant reply"})
+		messages = append(messages, &ai.Message{ID: fmt.Sprint("u", i), Origin: ai.MessageOriginUser, Text: "user message"})
+		messages = append(messages, &ai.Message{ID: fmt.Sprint("a", i), Origin: ai.MessageOriginAI, Text: "assistant reply"})
 	}
 	return messages
 }
@@ -136,6 +120,7 @@ func TestMaybeGenerateTitle_ExchangeCountEligibility(t *testing.T) {
 				Date:     time.Now(),
 				Messages: buildExchangeMessages(tc.userCount),
 			}
+			session.linkMessages()
 
 			session.MaybeGenerateTitle(model)
 
@@ -252,6 +237,7 @@ func TestMaybeGenerateTitle_TemporarySessionsNeverTitled(t *testing.T) {
 		Messages:  buildExchangeMessages(1),
 		temporary: true,
 	}
+	session.linkMessages()
 
 	session.MaybeGenerateTitle(model)
 
@@ -270,6 +256,7 @@ func TestMaybeGenerateTitle_PersistsAndBroadcasts(t *testing.T) {
 		Date:     time.Now(),
 		Messages: buildExchangeMessages(1),
 	}
+	session.linkMessages()
 
 	session.MaybeGenerateTitle(model)
 
@@ -279,14 +266,8 @@ func TestMaybeGenerateTitle_PersistsAndBroadcasts(t *testing.T) {
 		t.Fatalf("expected session.Summary = %q, got %q", "Trip Planning", session.Summary)
 	}
 
-	db, err := sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	if err != nil {
-		t.Fatalf("failed to open test db: %v", err)
-	}
-	defer db.Close()
-
 	var summary string
-	err = db.QueryRow("SELECT summary FROM sessions WHERE session_id = ?", session.ID).Scan(&summary)
+	err := storage.DB().QueryRow("SELECT summary FROM sessions WHERE session_id = ?", session.ID).Scan(&summary)
 	if err != nil {
 		t.Fatalf("failed to query persisted summary: %v", err)
 	}
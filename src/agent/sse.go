@@ -3,12 +3,18 @@ package agent
 type SSEMessageType string
 
 const (
-	SSEMessageSessionUpdate      = "session_update"
-	SSEMessageNewMessage         = "new_message"
-	SSEMessageLastMessageUpdate  = "last_message_update"
-	SSEMessageProviderListUpdate = "provider_list_update"
-	SSEMessageMCPListUpdate      = "mcp_list_update"
-	SSEMessageRoleListUpdate     = "role_list_update"
+	SSEMessageSessionUpdate       = "session_update"
+	SSEMessageNewMessage          = "new_message"
+	SSEMessageLastMessageUpdate   = "last_message_update"
+	SSEMessageProviderListUpdate  = "provider_list_update"
+	SSEMessageMCPListUpdate       = "mcp_list_update"
+	SSEMessageRoleListUpdate      = "role_list_update"
+	SSEMessageRunStarted          = "run_started"
+	SSEMessageRunFinished         = "run_finished"
+	SSEMessageRunFailed           = "run_failed"
+	SSEMessageRunCancelled        = "run_cancelled"
+	SSEMessageRunLimitExceeded    = "run_limit_exceeded"
+	SSEMessageToolApprovalRequest = "tool_approval_request"
 )
 
 type SSEMessage struct {
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// MaxSchemaRepairs is how many times the model is asked to fix an answer that
+// does not match the response schema before the run fails.
+var MaxSchemaRepairs = 2
+
+var ErrSchemaMismatch = errors.New("response does not match the schema")
+
+const schemaPrompt = `
+## Response format:
+Your final answer must be only a JSON value matching the JSON Schema below, with no other text and no markdown.
+`
+
+const schemaRepairPrompt = `Your last response does not match the required JSON Schema:
+%s
+Respond again with only the corrected JSON value.`
+
+// CheckResponseSchema reports errors in the response schema of a request or
+// role, nil schema is valid.
+func CheckResponseSchema(schema map[string]any) error {
+	if schema == nil {
+		return nil
+	}
+	if err := ai.CheckSchema(schema); err != nil {
+		return fmt.Errorf("invalid response schema: %w", err)
+	}
+	return nil
+}
+
+// responseSchema returns the schema of the request, or of the role when the
+// request has none.
+func (self RoleConfig) responseSchema(schema map[string]any) map[string]any {
+	if schema != nil {
+		return schema
+	}
+	return self.ResponseSchema
+}
+
+// responseSchemaPrompt returns system prompt section with the schema for
+// providers that can't enforce it with response_format.
+func responseSchemaPrompt(model *ai.Model, schema map[string]any) string {
+	if schema == nil || model.Provider.SupportsResponseFormat() {
+		return ""
+	}
+	schemaJSON, _ := json.MarshalIndent(schema, "", "  ")
+	return schemaPrompt + string(schemaJSON) + "\n"
+}
+
+// enforceSchema validates the last message of the session against the schema
+// and stores the validated value in it. An invalid answer is sent back to the
+// model with the validation errors at most MaxSchemaRepairs times, and the
+// repaired answer replaces it. The repair requests are not kept in the
+// session.
+func enforceSchema(ctx context.Context, session *Session, model *ai.Model, sysPrompt string, schema map[string]any) (any, error) {
+	last := session.lastMessage()
+	// the model must see its invalid answers, not the repaired text in last
+	original := *last
+	messages := append(session.history(), &original)
+
+	for attempt := 0; ; attempt++ {
+		value, err := ai.ParseStructured(last.Text, schema)
+		if err == nil {
+			last.Structured = value
+			session.UpdateLastMessage("")
+			if !session.temporary {
+				session.Save()
+			}
+			return value, nil
+		}
+		if attempt == MaxSchemaRepairs {
+			return nil, fmt.Errorf("%w: %v", ErrSchemaMismatch, err)
+		}
+		log.D("Response does not match the schema, asking for repair:", err)
+
+		messages = append(messages, &ai.Message{
+			ID:     uuid.NewString(),
+			Origin: ai.MessageOriginUser,
+			Text:   fmt.Sprintf(schemaRepairPrompt, err),
+		})
+		if err = model.Provider.WaitForAllowance(ctx); err != nil {
+			return nil, err
+		}
+		text, err := completeText(ctx, model, messages, sysPrompt, schema)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, &ai.Message{ID: uuid.NewString(), Origin: ai.MessageOriginAI, Text: text})
+
+		last.Text = ""
+		session.UpdateLastMessage(text)
+	}
+}
+
+// completeText runs a completion without tools and returns the whole answer.
+func completeText(ctx context.Context, model *ai.Model, messages []*ai.Message, sysPrompt string, schema map[string]any) (string, error) {
+	writeCh := make(chan string)
+	doneCh := make(chan struct{})
+	var sb strings.Builder
+	go func() {
+		for chunk := range writeCh {
+			sb.WriteString(chunk)
+		}
+		close(doneCh)
+	}()
+
+	_, err := model.Provider.ChatCompletionStream(ctx, messages, sysPrompt, model, nil, schema, writeCh, nil)
+	close(writeCh)
+	<-doneCh
+	return sb.String(), err
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testResponseSchema = map[string]any{
+	"type":     "object",
+	"required": []any{"city"},
+	"properties": map[string]any{
+		"city": map[string]any{"type": "string"},
+	},
+}
+
+func TestDynamicAgentChat_RepairsStructuredResponse(t *testing.T) {
+	requests := 0
+	model := newStreamingTestModel(t, func(messages []map[string]any) string {
+		requests++
+		sysPrompt, _ := messages[0]["content"].(string)
+		if !strings.Contains(sysPrompt, "## Response format:") {
+			t.Errorf("schema is missing in the system prompt of a provider without response_format")
+		}
+		last, _ := messages[len(messages)-1]["content"].(string)
+		if strings.HasPrefix(last, "Your last response does not match") {
+			if !strings.Contains(last, `$.city: expected string, got number`) {
+				t.Errorf("repair request without validation errors: %q", last)
+			}
+			return "```json\n{\"city\": \"Paris\"}\n```"
+		}
+		return `{"city": 75}`
+	})
+	useTestAgent(t, model)
+
+	res, err := DynamicAgentChat(context.Background(), model.ID, "where is the Louvre", "", nil, testResponseSchema, DefaultRunLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("expected one repair, got %d requests", requests)
+	}
+	if city := res.Structured.(map[string]any)["city"]; city != "Paris" {
+		t.Errorf("unexpected structured response %v", res.Structured)
+	}
+	// repair requests are not part of the conversation
+	if len(res.Trace) != 2 || res.Trace[1].Text != "```json\n{\"city\": \"Paris\"}\n```" {
+		t.Errorf("unexpected trace %+v", res.Trace)
+	}
+}
+
+func TestDynamicAgentChat_StructuredResponseRepairsAreBounded(t *testing.T) {
+	requests := 0
+	model := newStreamingTestModel(t, func(messages []map[string]any) string {
+		requests++
+		return "Paris"
+	})
+	model.Provider.APIType = ai.APITypeOpenAI
+	useTestAgent(t, model)
+
+	res, err := DynamicAgentChat(context.Background(), model.ID, "where is the Louvre", "", nil, testResponseSchema, DefaultRunLimits)
+	if !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("expected schema mismatch, got %v", err)
+	}
+	if requests != 1+MaxSchemaRepairs {
+		t.Errorf("expected %d requests, got %d", 1+MaxSchemaRepairs, requests)
+	}
+	if res.Structured != nil {
+		t.Errorf("invalid response must not be returned as structured: %v", res.Structured)
+	}
+}
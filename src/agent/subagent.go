@@ -0,0 +1,207 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"agentsmith/src/mcptools"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const subAgentToolName = "builtin_dynamic_ai_agent"
+
+// MaxAgentDepth is how deep dynamic agents may be nested, counting the agent
+// created by the user as depth 0.
+const MaxAgentDepth = 3
+
+// SubAgentLimits are the circuit breakers of a sub-agent spawned by the model.
+// Token limit is further lowered to what is left of the parent's budget.
+var SubAgentLimits = RunLimits{
+	MaxToolSteps: 10,
+	MaxDuration:  5 * time.Minute,
+	MaxTokens:    100_000,
+	MaxRepeats:   3,
+}
+
+var subAgentTool = &mcptools.Tool{
+	Name:           subAgentToolName,
+	Description:    "Use this tool to dynamically create AI agent with custom system prompts and subset of the tools you have. Use the combination of the system prompt, query and available tools to obtain the result from AI model. For example you can instruct model to analyze query and create a task execution plan for you. Or you can instruct model to validate or classify something. Use your best judgement tone determine the purpose of this tool and if you can utilize it to achieve your objective.",
+	RequiredParams: []string{"sysPrompt", "query"},
+	Params: []*mcptools.ToolParam{
+		{
+			Name:        "sysPrompt",
+			Type:        "string",
+			Description: "System prompt that AI agent will set to model. Include here any context information you want AI model to be aware of. Model will not have any other context outside of this.",
+		},
+		{
+			Name:        "query",
+			Type:        "string",
+			Description: "Query text to be sent to the AI agent.",
+		},
+		{
+			Name:        "tools",
+			Type:        "string",
+			Description: "Comma separated names of the tools that can be used by this agent. Specify here any tools from the ones you have that you think the AI agent will need to use. Leave empty for no tools.",
+		},
+		{
+			Name:        "model",
+			Type:        "string",
+			Description: "Optional ID of the model the agent should use. By default the agent uses the same model as you.",
+		},
+	},
+}
+
+func init() {
+	mcptools.RegisterBuiltinTool(mcptools.NewBuiltinTool(subAgentTool, executeSubAgent))
+}
+
+var (
+	ErrModelNotFound = errors.New("model not found")
+	ErrAgentDepth    = errors.New("maximum agent recursion depth reached")
+)
+
+type agentDepthKey struct{}
+
+func agentDepth(ctx context.Context) int {
+	depth, _ := ctx.Value(agentDepthKey{}).(int)
+	return depth
+}
+
+// WithAgentDepth marks ctx as being used by an agent at the given depth. It
+// lets callers of DynamicAgentChat, which may themselves be agents calling the
+// API as a tool, pass their recursion depth along.
+func WithAgentDepth(ctx context.Context, depth int) context.Context {
+	return context.WithValue(ctx, agentDepthKey{}, depth)
+}
+
+// TraceStep is one message of a dynamic agent conversation. Steps of
+// sub-agents spawned by a tool call are nested under the tool result.
+type TraceStep struct {
+	Origin   ai.MessageOrigin          `json:"origin"`
+	Text     string                    `json:"text,omitempty"`
+	ToolCall *mcptools.ToolCallRequest `json:"toolCall,omitempty"`
+	Steps    []TraceStep               `json:"steps,omitempty"`
+}
+
+func buildTrace(messages []*ai.Message) []TraceStep {
+	trace := make([]TraceStep, 0, len(messages))
+	for _, message := range messages {
+		step := TraceStep{
+			Origin: message.Origin,
+			Text:   message.Text,
+			Steps:  buildTrace(message.Transcript),
+		}
+		if len(message.ToolRequests) > 0 {
+			step.ToolCall = message.ToolRequests[0]
+		}
+		if len(step.Steps) == 0 {
+			step.Steps = nil
+		}
+		trace = append(trace, step)
+	}
+	return trace
+}
+
+// selectTools picks tools by name. The sub-agent tool is dropped when an agent
+// created in ctx would not be allowed to go any deeper.
+func selectTools(ctx context.Context, available []*mcptools.Tool, names []string) []*mcptools.Tool {
+	res := make([]*mcptools.Tool, 0, len(names))
+	for _, name := range names {
+		if name == subAgentToolName && agentDepth(ctx)+1 >= MaxAgentDepth {
+			continue
+		}
+		for _, tool := range available {
+			if tool.Name == name {
+				res = append(res, tool)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// parseToolNames accepts tool names either as comma separated string or as
+// JSON array, since models are not consistent about it.
+func parseToolNames(param any) []string {
+	names := make([]string, 0, 8)
+	switch v := param.(type) {
+	case string:
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if name, ok := item.(string); ok && strings.TrimSpace(name) != "" {
+				names = append(names, strings.TrimSpace(name))
+			}
+		}
+	}
+	return names
+}
+
+// runDynamicAgent runs a non-persisting agent one level deeper than ctx and
+// returns its answer, transcript and the tokens it used. The answer is
+// validated against schema unless it is nil.
+func runDynamicAgent(ctx context.Context, model *ai.Model, query string, sysPrompt string, tools []*mcptools.Tool, schema map[string]any, limits RunLimits) (response string, transcript []*ai.Message, tokens int, err error) {
+	depth := agentDepth(ctx)
+	if depth >= MaxAgentDepth {
+		return "", nil, 0, ErrAgentDepth
+	}
+
+	loop := newToolLoop(NewTempSession(), model, sysPrompt, tools, limits).requireSchema(schema)
+	response, err = loop.runQuery(WithAgentDepth(ctx, depth+1), query)
+	return response, loop.session.Messages, loop.guard.tokens, err
+}
+
+type toolLoopKey struct{}
+
+// executeSubAgent runs builtin_dynamic_ai_agent on behalf of the tool loop
+// that called it.
+func executeSubAgent(ctx context.Context, params map[string]any) (string, error) {
+	loop, _ := ctx.Value(toolLoopKey{}).(*toolLoop)
+	if loop == nil {
+		return "", errors.New("sub-agent can only be started by an agent")
+	}
+	return loop.runSubAgent(ctx, params)
+}
+
+// runSubAgent executes builtin_dynamic_ai_agent tool call. The sub-agent can
+// only use tools available to the calling loop, and its tokens count towards
+// the caller's token limit. Transcript of the sub-agent is kept in the loop so
+// that it is attached to the tool message.
+func (self *toolLoop) runSubAgent(ctx context.Context, params map[string]any) (string, error) {
+	sysPrompt, _ := params["sysPrompt"].(string)
+	query, _ := params["query"].(string)
+	if strings.TrimSpace(query) == "" {
+		return "", errors.New("query parameter is required")
+	}
+
+	model := self.model
+	if modelID, _ := params["model"].(string); modelID != "" {
+		model = findModel(modelID)
+		if model == nil {
+			return "", fmt.Errorf("%w: %s", ErrModelNotFound, modelID)
+		}
+	}
+
+	limits := SubAgentLimits
+	if self.limits.MaxTokens > 0 {
+		remaining := self.limits.MaxTokens - self.guard.tokens
+		if remaining <= 0 {
+			return "", errors.New("no tokens left for a sub-agent")
+		}
+		if limits.MaxTokens == 0 || remaining < limits.MaxTokens {
+			limits.MaxTokens = remaining
+		}
+	}
+
+	tools := selectTools(ctx, self.tools, parseToolNames(params["tools"]))
+	response, transcript, tokens, err := runDynamicAgent(ctx, model, query, sysPrompt, tools, nil, limits)
+	self.guard.tokens += tokens
+	self.transcript = transcript
+	return response, err
+}
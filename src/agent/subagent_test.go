@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"agentsmith/src/ai"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newStreamingTestModel serves /chat/completions as an SSE stream whose
+// content is produced by reply from the decoded request messages.
+func newStreamingTestModel(t *testing.T, reply func(messages []map[string]any) string) *ai.Model {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []map[string]any `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+
+		chunk, _ := json.Marshal(map[string]any{
+			"choices": []map[string]any{{"delta": map[string]any{"content": reply(body.Messages)}}},
+		})
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", chunk)
+	}))
+	t.Cleanup(server.Close)
+
+	provider := &ai.APIProvider{ID: "stream-provider", APIURL: server.URL, APIType: ai.APITypeOpenAICompatible}
+	model := &ai.Model{ID: "stream-model", Name: "stream-model", Provider: provider}
+	provider.Models = []*ai.Model{model}
+	return model
+}
+
+func useTestAgent(t *testing.T, model *ai.Model) {
+	t.Helper()
+
+	saved := Agent
+	Agent.apiProviders = []*ai.APIProvider{model.Provider}
+	t.Cleanup(func() { Agent = saved })
+}
+
+func TestDynamicAgentChat_SpawnsSubAgent(t *testing.T) {
+	model := newStreamingTestModel(t, func(messages []map[string]any) string {
+		sysPrompt, _ := messages[0]["content"].(string)
+		last := messages[len(messages)-1]
+		switch {
+		case strings.HasPrefix(sysPrompt, "You are a calculator"):
+			return "42"
+		case last["role"] == "tool":
+			return "sub-agent said " + last["content"].(string)
+		default:
+			return `<tool_call>{"name": "builtin_dynamic_ai_agent", "params": {"sysPrompt": "You are a calculator", "query": "6*7"}}</tool_call>`
+		}
+	})
+	useTestAgent(t, model)
+
+	res, err := DynamicAgentChat(context.Background(), model.ID, "what is 6*7", "You are a planner", []string{subAgentToolName}, nil, DefaultRunLimits)
+	if err != nil {
+		t.Fatalf("DynamicAgentChat failed: %v", err)
+	}
+	if res.Response != "sub-agent said 42" {
+		t.Fatalf("response = %q, want %q", res.Response, "sub-agent said 42")
+	}
+
+	var toolStep *TraceStep
+	for i, step := range res.Trace {
+		if step.Origin == ai.MessageOriginTool {
+			toolStep = &res.Trace[i]
+		}
+	}
+	if toolStep == nil || toolStep.ToolCall == nil || toolStep.ToolCall.Name != subAgentToolName {
+		t.Fatalf("expected sub-agent tool step in trace, got %+v", res.Trace)
+	}
+	if len(toolStep.Steps) != 2 || toolStep.Steps[1].Text != "42" {
+		t.Fatalf("expected nested sub-agent trace answering 42, got %+v", toolStep.Steps)
+	}
+}
+
+func TestDynamicAgentChat_RecursionDepthIsLimited(t *testing.T) {
+	calls := 0
+	model := newStreamingTestModel(t, func(messages []map[string]any) string {
+		calls++
+		if messages[len(messages)-1]["role"] == "tool" {
+			return "done"
+		}
+		// every agent tries to spawn yet another agent
+		return `<tool_call>{"name": "builtin_dynamic_ai_agent", "params": {"sysPrompt": "again", "query": "again", "tools": "builtin_dynamic_ai_agent"}}</tool_call>`
+	})
+	useTestAgent(t, model)
+
+	_, err := DynamicAgentChat(context.Background(), model.ID, "go", "", []string{subAgentToolName}, nil, DefaultRunLimits)
+	if err != nil {
+		t.Fatalf("DynamicAgentChat failed: %v", err)
+	}
+
+	// agents at depth 1..MaxAgentDepth-1 spawn a sub-agent and answer after it
+	// returns; the deepest one has no sub-agent tool, so its tool call text is
+	// taken as the answer
+	if want := (MaxAgentDepth-1)*2 + 1; calls != want {
+		t.Fatalf("model was called %d times, want %d", calls, want)
+	}
+
+	_, err = DynamicAgentChat(WithAgentDepth(context.Background(), MaxAgentDepth), model.ID, "go", "", nil, nil, DefaultRunLimits)
+	if !errors.Is(err, ErrAgentDepth) {
+		t.Fatalf("expected ErrAgentDepth when starting agent beyond MaxAgentDepth, got %v", err)
+	}
+}
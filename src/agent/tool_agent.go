@@ -2,13 +2,14 @@ package agent
 
 import (
 	"agentsmith/src/ai"
-	"agentsmith/src/logger"
 	"agentsmith/src/mcptools"
 	"agentsmith/src/util"
 	"context"
 	"encoding/json"
 	"errors"
 	"strings"
+
+	"github.com/google/uuid"
 )
 
 type AgentAction string
@@ -19,7 +20,9 @@ const (
 	AgentActionError    = "error"
 )
 
-func inferNextAction(message string) (AgentAction, *mcptools.MCPServer, *mcptools.ToolCallRequest) {
+// inferNextAction parses a tool call written as text by models that don't use
+// native tool calling. Only tools from the given list are recognized.
+func inferNextAction(message string, tools []*mcptools.Tool) (AgentAction, *mcptools.MCPServer, *mcptools.ToolCallRequest) {
 	content := util.CutThinking(message)
 	if len(content) > 0 {
 		content = strings.TrimSpace(content)
@@ -49,7 +52,6 @@ func inferNextAction(message string) (AgentAction, *mcptools.MCPServer, *mcptool
 		err := json.Unmarshal([]byte(content), &callRequest)
 
 		if err == nil {
-			tools := append(GetTools(), GetBuiltinTools()...)
 			// exact match first to avoid false substring hits (e.g. "time" inside "datetime")
 			for _, tool := range tools {
 				if callRequest.Name == tool.Name {
@@ -91,174 +93,402 @@ Only use provided tool names and their defined parameters.
 If you can answer directly from your knowledge, do so without using a tool.
 `
 
-func ToolChatStreaming(ctx context.Context, sessionID string, modelID string, roleID string, query string, streamDoneCh chan bool) {
+// ToolChatStreaming runs the agent loop for one user query within the given
+// run: the model is called, any tool it asks for is executed and its result
+// is fed back until the model answers, the run is cancelled or a circuit
+// breaker from limits trips. When schema, or the response schema of the role,
+// is set, the answer must be JSON matching it.
+func ToolChatStreaming(run *Run, roleID string, query string, schema map[string]any, limits RunLimits, streamDoneCh chan bool) {
+	toolChat(run, roleID, query, false, schema, limits, streamDoneCh)
+}
+
+// ToolChatRegenerate runs the agent loop for the last message of the active
+// branch again, it has to be a user message, see RegenerateMessage.
+func ToolChatRegenerate(run *Run, roleID string, schema map[string]any, limits RunLimits, streamDoneCh chan bool) {
+	toolChat(run, roleID, "", true, schema, limits, streamDoneCh)
+}
+
+// toolChat runs the agent loop for the query, or with regenerate for the user
+// message the active branch ends with.
+func toolChat(run *Run, roleID string, query string, regenerate bool, schema map[string]any, limits RunLimits, streamDoneCh chan bool) {
 	log.D("Tool chat initiated")
-	model := findModel(modelID)
-	if model != nil {
-		var session *Session
 
-		for _, s := range Agent.sessions {
-			if s.ID == sessionID {
-				session = s
-				break
-			}
+	end := func(status RunStatus, reason string) {
+		run.finish(status, reason, "")
+		streamDoneCh <- status == RunStatusFinished
+	}
+
+	model := findModel(run.ModelID)
+	if model == nil {
+		log.E("Model not found")
+		end(RunStatusFailed, "model not found")
+		return
+	}
+
+	var session *Session
+	for _, s := range Agent.sessions {
+		if s.ID == run.SessionID {
+			session = s
+			break
 		}
-		if session == nil {
-			log.E("Session not found")
-			streamDoneCh <- false
+	}
+	if session == nil {
+		log.E("Session not found")
+		end(RunStatusFailed, "session not found")
+		return
+	}
+	if regenerate {
+		last := session.lastMessage()
+		if last == nil {
+			log.E("No message to regenerate")
+			end(RunStatusFailed, "no message to regenerate")
 			return
 		}
+		query = last.Text
+	}
 
-		sysPrompt := ""
-		// Find the role by ID
-		for _, role := range Agent.roles {
-			if role.ID == roleID {
-				sysPrompt = "## General instruction: \n" + role.Config.GeneralInstruction +
-					"## Role and personality: \n" + role.Config.Role +
-					"## Text style and tone: \n" + role.Config.Style
-				break
-			}
+	sysPrompt := ""
+	roleConfig := RoleConfig{}
+	// Find the role by ID
+	for _, role := range Agent.roles {
+		if role.ID == roleID {
+			sysPrompt = "## General instruction: \n" + role.Config.GeneralInstruction +
+				"## Role and personality: \n" + role.Config.Role +
+				"## Text style and tone: \n" + role.Config.Style
+			roleConfig = role.Config
+			break
 		}
+	}
+
+	sysPrompt += roleConfig.recallMemories(run.Context(), query)
+	sysPrompt += roleConfig.retrieveKnowledge(run.Context(), query)
 
-		modelResponseCh := make(chan string)
-		modelDoneCh := make(chan error)
-		toolCh := make(chan []*mcptools.ToolCallRequest)
+	selectedTools := make([]*mcptools.Tool, 0, len(Agent.mcps)*4)
+	selectedTools = append(selectedTools, GetTools()...)
+	selectedTools = append(selectedTools, roleConfig.filterCodeRunners(GetBuiltinTools())...)
 
+	if !regenerate {
 		session.AddMessage(ai.MessageOriginUser, query, nil)
-		session.AddMessage(ai.MessageOriginAI, "", nil)
+	}
 
-		var toolCalls []*mcptools.ToolCallRequest
-		selectedTools := make([]*mcptools.Tool, 0, len(Agent.mcps)*4)
-		for _, mcp := range Agent.mcps {
-			if mcp.Active {
-				selectedTools = append(selectedTools, mcp.Tools...)
-			}
+	loop := newToolLoop(session, model, sysPrompt, selectedTools, limits).requireSchema(roleConfig.responseSchema(schema))
+	loop.roleID = roleID
+	status, limitErr, err := loop.run(run.Context())
+
+	switch status {
+	case RunStatusFinished:
+		if loop.schema != nil {
+			run.setStructured(session.lastMessage().Structured)
+		}
+		session.MaybeGenerateTitle(model)
+		if roleConfig.ExtractMemories {
+			scheduleMemoryExtraction(session, model)
 		}
+		end(RunStatusFinished, "")
+	case RunStatusLimitExceeded:
+		run.finish(RunStatusLimitExceeded, limitErr.Reason, limitErr.Kind)
+		streamDoneCh <- false
+	case RunStatusCancelled:
+		run.finishCtxDone(limits)
+		streamDoneCh <- false
+	default:
+		end(RunStatusFailed, err.Error())
+	}
+}
+
+// toolLoop is the agent loop shared by tool chat and dynamic (sub) agents. It
+// works on any session - temporary sessions make it a non-persisting agent.
+type toolLoop struct {
+	session   *Session
+	model     *ai.Model
+	sysPrompt string
+	tools     []*mcptools.Tool
+	limits    RunLimits
+	guard     *runGuard
+	// roleID is recorded on the replies, empty for dynamic agents
+	roleID string
+	// schema the final answer is validated against, if any
+	schema map[string]any
+
+	// transcript of a sub-agent started by the tool call being executed
+	transcript []*ai.Message
+}
+
+func newToolLoop(session *Session, model *ai.Model, sysPrompt string, tools []*mcptools.Tool, limits RunLimits) *toolLoop {
+	if len(tools) > 0 {
+		sysPrompt = sysPrompt + toolUsePrompt
+	}
+	return &toolLoop{
+		session:   session,
+		model:     model,
+		sysPrompt: sysPrompt,
+		tools:     tools,
+		limits:    limits,
+		guard:     newRunGuard(limits),
+	}
+}
+
+// requireSchema makes the loop validate the final answer against the schema,
+// nil schema is ignored.
+func (self *toolLoop) requireSchema(schema map[string]any) *toolLoop {
+	self.schema = schema
+	self.sysPrompt += responseSchemaPrompt(self.model, schema)
+	return self
+}
 
-		selectedTools = append(selectedTools, GetBuiltinTools()...)
+// run generates the assistant reply to the messages already in the session,
+// executing tool calls until the model answers. When a circuit breaker trips
+// the returned LimitError describes it, and a notice is added to the session.
+func (self *toolLoop) run(ctx context.Context) (status RunStatus, limitErr *LimitError, err error) {
+	session := self.session
+
+	runCtx := ctx
+	if self.limits.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, self.limits.MaxDuration)
+		defer cancel()
+	}
+
+	// stop ends the loop because a circuit breaker tripped
+	stop := func(limitErr *LimitError) (RunStatus, *LimitError, error) {
+		log.W("Agent loop stopped by circuit breaker:", limitErr)
+		session.AddMessage(ai.MessageOriginAI, "Agent run stopped: "+limitErr.Reason+".", nil)
+		return RunStatusLimitExceeded, limitErr, nil
+	}
 
-		if len(selectedTools) > 0 {
-			sysPrompt = sysPrompt + toolUsePrompt
+	// ctxDone ends the loop after it was cancelled or ran out of time
+	ctxDone := func() (RunStatus, *LimitError, error) {
+		if errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			return stop(durationLimitError(self.limits))
 		}
+		return RunStatusCancelled, nil, ctx.Err()
+	}
+
+	modelResponseCh := make(chan string)
+	// buffered so that a completion finishing after the loop was stopped does not block forever
+	modelDoneCh := make(chan error, 1)
+	toolCh := make(chan []*mcptools.ToolCallRequest, 1)
+
+	session.addReply(self.model, self.roleID)
 
-		chatCompletion := func() {
-			model.Provider.WaitForAllowance()
-			err := model.Provider.ChatCompletionStream(
-				ctx,
-				session.Messages[:len(session.Messages)-1],
-				sysPrompt,
-				model,
-				selectedTools,
+	var toolCalls []*mcptools.ToolCallRequest
+	var usage *ai.OpenAIChatCompletionUsage
+
+	chatCompletion := func() {
+		err := self.model.Provider.WaitForAllowance(runCtx)
+		if err == nil {
+			usage, err = self.model.Provider.ChatCompletionStream(
+				runCtx,
+				session.history(),
+				self.sysPrompt,
+				self.model,
+				self.tools,
+				self.schema,
 				modelResponseCh,
 				toolCh,
 			)
-			modelDoneCh <- err
 		}
+		modelDoneCh <- err
+	}
 
-		go chatCompletion()
-
-		for {
-			select {
-			case msg := <-modelResponseCh:
-				session.UpdateLastMessage(msg)
-			case toolCalls = <-toolCh:
-			case err := <-modelDoneCh:
-				log.D("Model response done")
+	go chatCompletion()
+
+	for {
+		select {
+		case msg := <-modelResponseCh:
+			session.UpdateLastMessage(msg)
+		case toolCalls = <-toolCh:
+		case err := <-modelDoneCh:
+			log.D("Model response done")
+			session.setUsage(usage)
+			if !session.temporary {
 				session.Save()
+			}
 
-				var action AgentAction
-				var mcp *mcptools.MCPServer
-				var callRequest *mcptools.ToolCallRequest
+			if runCtx.Err() != nil {
+				return ctxDone()
+			}
 
-				if err != nil {
-					action = AgentActionError
+			var action AgentAction
+			var mcp *mcptools.MCPServer
+			var callRequest *mcptools.ToolCallRequest
+
+			if err != nil {
+				action = AgentActionError
+			} else {
+				lastMessage := session.lastMessage()
+				if limitErr := self.guard.addUsage(usage, session.history(), self.sysPrompt, lastMessage.Text); limitErr != nil {
+					return stop(limitErr)
+				}
+
+				if len(toolCalls) > 0 {
+					action = AgentActionToolCall
+					callRequest = toolCalls[0]
+					mcp = GetMCPForTool(callRequest.Name)
 				} else {
-					if len(toolCalls) > 0 {
-						action = AgentActionToolCall
-						callRequest = toolCalls[0]
-						mcp = GetMCPForTool(callRequest.Name)
-					} else {
-						action, mcp, callRequest = inferNextAction(session.Messages[len(session.Messages)-1].Text)
-					}
+					action, mcp, callRequest = inferNextAction(lastMessage.Text, self.tools)
 				}
+			}
 
-				switch action {
-				case AgentActionError:
-					log.E("Error during parse of model intention")
-					streamDoneCh <- false
-					return
-				case AgentActionAnswer:
-					log.D("Model will answer ")
-					session.MaybeGenerateTitle(model)
-					streamDoneCh <- true
-					return
-				case AgentActionToolCall:
-					log.D("Model will call tool")
-					if mcp != nil || callRequest.Name == "lua_code_runner" {
-						session.Messages[len(session.Messages)-1].ToolRequests = []*mcptools.ToolCallRequest{callRequest}
-						session.UpdateLastMessage("")
-
-						var toolResult string
-						if callRequest.Name == "lua_code_runner" {
-							toolResult = mcptools.RunLua(callRequest)
-						} else {
-							toolResult, err = mcp.CallTool(callRequest)
-						}
-						log.D("Tool execution result: ", toolResult)
-
-						if err != nil {
-							log.E("Error during tool call: ", err)
-							// Don't terminate the stream — let the AI handle the failure
-							session.AddMessage(ai.MessageOriginTool, "Tool call failed: "+err.Error(), []*mcptools.ToolCallRequest{callRequest})
-						} else {
-							session.AddMessage(ai.MessageOriginTool, util.CutThinking(toolResult), []*mcptools.ToolCallRequest{callRequest})
+			switch action {
+			case AgentActionError:
+				log.E("Error during parse of model intention")
+				if err == nil {
+					err = errors.New("model returned no response")
+				}
+				return RunStatusFailed, nil, err
+			case AgentActionAnswer:
+				log.D("Model will answer ")
+				if self.schema != nil {
+					if _, err := enforceSchema(runCtx, session, self.model, self.sysPrompt, self.schema); err != nil {
+						if runCtx.Err() != nil {
+							return ctxDone()
 						}
-						session.AddMessage(ai.MessageOriginAI, "", nil)
-
-						toolCalls = nil
-						go chatCompletion()
-					} else {
-						log.E("didnt find mcp to call")
-						streamDoneCh <- false
-						return
+						return RunStatusFailed, nil, err
 					}
 				}
-			case <-ctx.Done():
-				streamDoneCh <- false
-				return
+				return RunStatusFinished, nil, nil
+			case AgentActionToolCall:
+				log.D("Model will call tool")
+				tool := self.findTool(callRequest.Name)
+				if (mcp == nil && !mcptools.IsBuiltinTool(callRequest.Name)) || tool == nil {
+					log.E("didnt find mcp to call")
+					return RunStatusFailed, nil, errors.New("tool not found: " + callRequest.Name)
+				}
+
+				session.lastMessage().ToolRequests = []*mcptools.ToolCallRequest{callRequest}
+				session.UpdateLastMessage("")
+
+				if limitErr := self.guard.checkToolCall(callRequest); limitErr != nil {
+					return stop(limitErr)
+				}
+
+				toolResult, transcript, err := self.callTool(runCtx, mcp, tool, callRequest)
+				log.D("Tool execution result: ", toolResult)
+
+				if runCtx.Err() != nil {
+					return ctxDone()
+				}
+
+				if err != nil {
+					log.E("Error during tool call: ", err)
+					// Don't terminate the loop — let the AI handle the failure
+					toolResult = "Tool call failed: " + err.Error()
+				} else {
+					toolResult = util.CutThinking(toolResult)
+				}
+				session.addMessage(&ai.Message{
+					ID:           uuid.NewString(),
+					Origin:       ai.MessageOriginTool,
+					Text:         toolResult,
+					ToolRequests: []*mcptools.ToolCallRequest{callRequest},
+					Transcript:   transcript,
+				})
+
+				if limitErr := self.guard.checkToolOutput(toolResult); limitErr != nil {
+					return stop(limitErr)
+				}
+				if limitErr := self.guard.checkTokens(); limitErr != nil {
+					return stop(limitErr)
+				}
+				session.addReply(self.model, self.roleID)
+
+				toolCalls = nil
+				go chatCompletion()
 			}
+		case <-runCtx.Done():
+			return ctxDone()
 		}
-	} else {
-		log.E("Model not found")
-		streamDoneCh <- false
 	}
 }
 
-func DynamicAgentChat(modelID string, query string, sysPrompt string) (response string, err error) {
-	logger.BreakOnError()
-	response = ""
+// callTool executes a tool call with either a builtin or the MCP server that
+// owns the tool. Tools that require approval wait for the user decision first.
+// Sub-agent calls also return the transcript of the sub-agent.
+func (self *toolLoop) callTool(ctx context.Context, mcp *mcptools.MCPServer, tool *mcptools.Tool, callRequest *mcptools.ToolCallRequest) (result string, transcript []*ai.Message, err error) {
+	if tool.RequiresApproval {
+		approved, err := requestApproval(ctx, self.session.ID, callRequest)
+		if err != nil {
+			return "", nil, err
+		}
+		if !approved {
+			return "Tool call was denied by the user.", nil, nil
+		}
+	}
+
+	if mcptools.IsBuiltinTool(callRequest.Name) {
+		self.transcript = nil
+		result, err = mcptools.CallBuiltinTool(mcptools.WithSessionID(context.WithValue(ctx, toolLoopKey{}, self), self.session.ID), callRequest)
+		return result, self.transcript, err
+	}
+	if mcp != nil {
+		result, err = mcp.CallTool(ctx, callRequest)
+		return result, nil, err
+	}
+	return "", nil, errors.New("tool not found: " + callRequest.Name)
+}
+
+func (self *toolLoop) findTool(name string) *mcptools.Tool {
+	for _, tool := range self.tools {
+		if tool.Name == name {
+			return tool
+		}
+	}
+	return nil
+}
 
+// DynamicAgentResult is the outcome of DynamicAgentChat.
+type DynamicAgentResult struct {
+	Response string      `json:"response"`
+	Trace    []TraceStep `json:"trace"`
+	Tokens   int         `json:"tokens"`
+	// Structured is the response validated against the requested schema
+	Structured any `json:"structured,omitempty"`
+}
+
+// DynamicAgentChat runs a one-off agent configured only by the system prompt,
+// using the same tool loop as ToolChatStreaming. Nothing is saved: the
+// conversation lives in a temporary session and is returned as a trace along
+// with the final answer. Tools are looked up by name among the tools available
+// to the agent. The agent runs one level deeper than the depth carried by ctx,
+// see WithAgentDepth. The trace is returned even when the run fails. With a
+// schema the response must be JSON matching it.
+func DynamicAgentChat(ctx context.Context, modelID string, query string, sysPrompt string, toolNames []string, schema map[string]any, limits RunLimits) (*DynamicAgentResult, error) {
 	model := findModel(modelID)
-	if model != nil {
-		session := NewTempSession()
-		err = session.AddMessage(ai.MessageOriginUser, query, nil)
-
-		message, err := model.Provider.ChatCompletion(
-			session.Messages,
-			sysPrompt,
-			model,
-			[]*mcptools.Tool{},
-		)
-		log.CheckE(err, nil, "Failed to get completion for message")
-
-		err = session.AddMessage(ai.MessageOriginAI, message, nil)
-		log.CheckE(err, nil, "Failed to store new message in agent")
-
-		response = message
-	} else {
-		err = errors.New("model not selected")
+	if model == nil {
+		return nil, ErrModelNotFound
+	}
+
+	available := append(GetTools(), GetBuiltinTools()...)
+	response, transcript, tokens, err := runDynamicAgent(ctx, model, query, sysPrompt, selectTools(ctx, available, toolNames), schema, limits)
+	result := &DynamicAgentResult{
+		Response: response,
+		Trace:    buildTrace(transcript),
+		Tokens:   tokens,
+	}
+	if err == nil && schema != nil {
+		result.Structured = transcript[len(transcript)-1].Structured
+	}
+	return result, err
+}
+
+// runQuery adds the query to the loop session and runs the loop, returning
+// the text of the final answer.
+func (self *toolLoop) runQuery(ctx context.Context, query string) (string, error) {
+	err := self.session.AddMessage(ai.MessageOriginUser, query, nil)
+	if err != nil {
+		return "", err
+	}
+
+	status, limitErr, err := self.run(ctx)
+	switch status {
+	case RunStatusFinished:
+		return util.CutThinking(self.session.lastMessage().Text), nil
+	case RunStatusLimitExceeded:
+		return "", limitErr
+	default:
+		return "", err
 	}
-	return
 }
 
 func GetMCPForTool(name string) (mcp *mcptools.MCPServer) {
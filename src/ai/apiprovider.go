@@ -4,26 +4,27 @@ package ai
 import (
 	"agentsmith/src/logger"
 	"agentsmith/src/mcptools"
+	"agentsmith/src/store"
 	"agentsmith/src/util"
 	"bytes"
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/tmaxmax/go-sse"
 	"resty.dev/v3"
 )
 
 var log = logger.Logger("ai", 1, 1, 1)
 
+// storage keeps the providers, see UseStore
+var storage store.Store
+
 type APIType string
 
 const (
@@ -47,14 +48,24 @@ type APIProvider struct {
 	rateLimiter *rateLimiter `json:"-"`
 }
 
+// MarshalJSON masks the API key, it is write only in the API.
+func (self APIProvider) MarshalJSON() ([]byte, error) {
+	type provider APIProvider
+	masked := provider(self)
+	masked.APIKey = util.MaskSecret(self.APIKey)
+	return json.Marshal(masked)
+}
+
 type rateLimiter struct {
 	mu         sync.Mutex
 	timestamps []time.Time
 }
 
-func (self *APIProvider) WaitForAllowance() {
+// WaitForAllowance blocks until the provider rate limit allows one more
+// request. It returns early with the context error if ctx is done first.
+func (self *APIProvider) WaitForAllowance(ctx context.Context) error {
 	if self.RateLimit == 0 {
-		return
+		return nil
 	}
 	if self.rateLimiter == nil {
 		self.rateLimiter = &rateLimiter{}
@@ -75,7 +86,7 @@ func (self *APIProvider) WaitForAllowance() {
 		if len(rl.timestamps) < self.RateLimit {
 			rl.timestamps = append(rl.timestamps, now)
 			rl.mu.Unlock()
-			return
+			return nil
 		}
 		// Wait until the oldest timestamp is out of the window
 		wait := rl.timestamps[0].Add(time.Minute).Sub(now)
@@ -83,7 +94,11 @@ func (self *APIProvider) WaitForAllowance() {
 		if wait < time.Millisecond*100 {
 			wait = time.Millisecond * 100
 		}
-		time.Sleep(wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 }
 
@@ -93,34 +108,25 @@ func NewProvider(id string, apiType APIType, name string, url string, apiKey str
 	return
 }
 
+// UseStore sets the store providers are saved in.
+func UseStore(s store.Store) {
+	storage = s
+}
+
 func LoadProviders() []*APIProvider {
-	log.D("Loading providers from", os.Getenv("AS_AGENT_DB_FILE"))
+	log.D("Loading providers")
 	defer logger.BreakOnError()
 
 	providers := make([]*APIProvider, 0, 16)
 
-	db, err := sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	log.CheckE(err, nil, "Failed to open agent db for loading providers")
-	defer db.Close()
-
-	query := "SELECT id, name, api_url, api_key, provider, rate_limit FROM providers;"
-	rows, err := db.Query(query)
+	records, err := storage.Providers()
 	log.CheckE(err, nil, "Failed to select providers from DB")
-	defer rows.Close()
 
 	var signal sync.WaitGroup
+	var mu sync.Mutex
 
-	for rows.Next() {
-		var id, name, apiURL, apiKey, providerTypeStr sql.NullString
-		var rateLimit sql.NullInt16
-
-		err = rows.Scan(&id, &name, &apiURL, &apiKey, &providerTypeStr, &rateLimit)
-		if err != nil {
-			log.W("Failed to scan provider row:", err)
-			continue
-		}
-
-		if !id.Valid || !name.Valid || !providerTypeStr.Valid {
+	for _, record := range records {
+		if record.ID == "" || record.Name == "" || record.Type == "" {
 			log.W("Skipping provider row due to missing name or provider type")
 			continue
 		}
@@ -129,18 +135,20 @@ func LoadProviders() []*APIProvider {
 		go func() {
 			defer signal.Done()
 			provider, err := NewProvider(
-				id.String,
-				APIType(providerTypeStr.String),
-				name.String,
-				apiURL.String,
-				apiKey.String,
-				int(rateLimit.Int16),
+				record.ID,
+				APIType(record.Type),
+				record.Name,
+				record.APIURL,
+				record.APIKey,
+				record.RateLimit,
 			)
 			if err != nil {
-				log.W("Error creating provider '%s' from DB data: %v", name.String, err)
+				log.W("Error creating provider '%s' from DB data: %v", record.Name, err)
 				return
 			}
+			mu.Lock()
 			providers = append(providers, provider)
+			mu.Unlock()
 		}()
 	}
 
@@ -153,22 +161,14 @@ func LoadProviders() []*APIProvider {
 func (self *APIProvider) Save() (err error) {
 	defer logger.BreakOnError()
 
-	db, err := sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	log.CheckE(err, nil, "Failed to open DB")
-	defer db.Close()
-
-	query := `
-	INSERT INTO providers (id, name, api_url, api_key, provider, rate_limit)
-	VALUES (?, ?, ?, ?, ?, ?)
-	ON CONFLICT(id) DO UPDATE SET
-		name=excluded.name,
-		api_url=excluded.api_url,
-		api_key=excluded.api_key,
-		provider=excluded.provider,
-		rate_limit=excluded.rate_limit;
-	`
-
-	_, err = db.Exec(query, self.ID, self.Name, self.APIURL, self.APIKey, self.APIType, self.RateLimit)
+	err = storage.SaveProvider(&store.Provider{
+		ID:        self.ID,
+		Name:      self.Name,
+		APIURL:    self.APIURL,
+		APIKey:    self.APIKey,
+		Type:      string(self.APIType),
+		RateLimit: self.RateLimit,
+	})
 	log.CheckW(err, "Failed to update provider DB")
 
 	log.D("Saved provider", self.Name)
@@ -176,15 +176,10 @@ func (self *APIProvider) Save() (err error) {
 }
 
 func (self *APIProvider) Delete() (err error) {
-	log.D("Deleting provider from ", os.Getenv("AS_AGENT_DB_FILE"))
+	log.D("Deleting provider", self.ID)
 	defer logger.BreakOnError()
 
-	db, err := sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	log.CheckE(err, nil, "Failed to open DB")
-	defer db.Close()
-
-	query := "DELETE FROM providers WHERE id=?"
-	_, err = db.Exec(query, self.ID)
+	err = storage.DeleteProvider(self.ID)
 	log.CheckW(err, "Failed to delete provider from DB")
 
 	return
@@ -224,9 +219,10 @@ func (self *APIProvider) LoadModels() (err error) {
 	self.Models = make([]*Model, len(list.Data))
 	for i, config := range list.Data {
 		self.Models[i] = &Model{
-			ID:       config["id"].(string),
-			Name:     config["id"].(string),
-			Provider: self,
+			ID:        config["id"].(string),
+			Name:      config["id"].(string),
+			Provider:  self,
+			Embedding: isEmbeddingModel(config),
 		}
 	}
 
@@ -331,6 +327,14 @@ type OpenAIStreamChatResponse struct {
 	Model             string                           `json:"model"`
 	SystemFingerprint string                           `json:"system_fingerprint"`
 	Choices           []OpenAIStreamChatResponseChoice `json:"choices"`
+	Usage             *OpenAIChatCompletionUsage       `json:"usage,omitempty"`
+}
+
+// SupportsStreamUsage tells if the provider reports usage of a streamed
+// completion only when asked with stream_options. Mistral reports it unasked
+// and rejects unknown fields.
+func (self *APIProvider) SupportsStreamUsage() bool {
+	return self.APIType != APITypeMistral
 }
 
 func (self *APIProvider) ChatCompletionStream(
@@ -339,9 +343,10 @@ func (self *APIProvider) ChatCompletionStream(
 	sysPrompt string,
 	model *Model,
 	tools []*mcptools.Tool,
+	schema map[string]any,
 	writeCh chan string,
 	toolCh chan []*mcptools.ToolCallRequest,
-) (err error) {
+) (usage *OpenAIChatCompletionUsage, err error) {
 	defer logger.BreakOnError()
 	log.D("OpenAI chat completion streaming")
 
@@ -354,9 +359,15 @@ func (self *APIProvider) ChatCompletionStream(
 		"stream":   true,
 	}
 
+	if self.SupportsStreamUsage() {
+		body["stream_options"] = map[string]any{"include_usage": true}
+	}
 	if len(tools) > 0 {
 		body["tools"] = prepareTools(tools)
 	}
+	if schema != nil && self.SupportsResponseFormat() {
+		body["response_format"] = responseFormat(schema)
+	}
 
 	var bodyJSON []byte
 	bodyJSON, err = json.Marshal(body)
@@ -398,6 +409,11 @@ func (self *APIProvider) ChatCompletionStream(
 		err := json.Unmarshal([]byte(eventData), &response)
 		log.CheckE(err, nil, "failed to parse OpenAI JSON chunk")
 
+		// providers that report usage send it with the last chunk, often with empty choices
+		if response.Usage != nil {
+			usage = response.Usage
+		}
+
 		if len(response.Choices) == 0 {
 			log.D("Received chunk with no choices, skipping.")
 			return
@@ -506,6 +522,7 @@ func (self *APIProvider) ChatCompletionStream(
 }
 
 func prepareMessages(messages []*Message, sysPrompt string) *[]map[string]any {
+	messages = ActiveBranch(messages)
 	bodyMessages := make([]map[string]any, len(messages)+1)
 	bodyMessages[0] = map[string]any{
 		"role":    "system",
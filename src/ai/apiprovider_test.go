@@ -0,0 +1,65 @@
+package ai
+
+import (
+	"agentsmith/src/util"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProviderJSONMasksAPIKey(t *testing.T) {
+	key := "sk-0123456789abcdef"
+	provider := &APIProvider{ID: "p", Name: "Cloud", APIKey: key, Models: []*Model{}}
+
+	for _, value := range []any{provider, []*APIProvider{provider}, *provider} {
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "0123456789") || !strings.Contains(string(data), `"apiKey":"••••••••cdef"`) {
+			t.Errorf("API key must be masked: %s", data)
+		}
+	}
+	if provider.APIKey != key {
+		t.Error("masking must not change the provider")
+	}
+
+	if util.UnmaskSecret(util.MaskSecret(key), key) != key {
+		t.Error("masked key sent back must stand for the current one")
+	}
+	if util.UnmaskSecret("", key) != "" || util.UnmaskSecret("sk-new", key) != "sk-new" {
+		t.Error("a new or cleared key must replace the current one")
+	}
+}
+
+func TestChatCompletionStream_AsksForUsage(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\": [{\"delta\": {\"content\": \"Hi\"}}]}\n\n"))
+		w.Write([]byte("data: {\"choices\": [], \"usage\": {\"prompt_tokens\": 7, \"completion_tokens\": 1, \"total_tokens\": 8}}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	for _, apiType := range []APIType{APITypeOpenAI, APITypeMistral} {
+		body = nil
+		provider := &APIProvider{Name: "test", APIURL: server.URL, APIType: apiType}
+		writeCh := make(chan string, 10)
+		usage, err := provider.ChatCompletionStream(context.Background(), []*Message{}, "", &Model{ID: "m"}, nil, nil, writeCh, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if usage == nil || usage.TotalTokens != 8 {
+			t.Errorf("%s: expected reported usage, got %+v", apiType, usage)
+		}
+		options, asked := body["stream_options"].(map[string]any)
+		if asked != (apiType != APITypeMistral) || (asked && options["include_usage"] != true) {
+			t.Errorf("%s: unexpected stream_options %v", apiType, body["stream_options"])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"resty.dev/v3"
+)
+
+// embedBatchSize is the number of inputs sent in one embeddings request.
+// Providers limit it, OpenAI to 2048, local servers often much lower.
+const embedBatchSize = 64
+
+const embedTimeout = 60 * time.Second
+
+type OpenAIEmbeddingData struct {
+	Index     int       `json:"index"`
+	Embedding []float32 `json:"embedding"`
+}
+
+type OpenAIEmbeddingError struct {
+	Message string `json:"message"`
+}
+
+type OpenAIEmbeddingRes struct {
+	Data  []OpenAIEmbeddingData     `json:"data"`
+	Model string                    `json:"model"`
+	Usage OpenAIChatCompletionUsage `json:"usage"`
+	Error *OpenAIEmbeddingError     `json:"error"`
+}
+
+// embeddingModelHints are parts of IDs of known embedding models, used when
+// the provider does not report model type.
+var embeddingModelHints = []string{"embed", "bge-", "e5-", "gte-", "minilm", "nomic-bert"}
+
+// isEmbeddingModel reports whether the model from /models list produces
+// embeddings. LM Studio and some hosted providers report the type, otherwise
+// the ID is checked for names of known embedding models.
+func isEmbeddingModel(config map[string]any) bool {
+	if modelType, ok := config["type"].(string); ok {
+		if modelType == "embedding" || modelType == "embeddings" {
+			return true
+		}
+	}
+	id, _ := config["id"].(string)
+	id = strings.ToLower(id)
+	for _, hint := range embeddingModelHints {
+		if strings.Contains(id, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// Embed computes embeddings of the texts with the model using OpenAI
+// compatible /embeddings API, which Ollama, LM Studio, Mistral and Google
+// serve as well. Texts are sent in batches, each batch waits for the provider
+// rate limit. Embeddings are returned in the order of texts.
+func (self *APIProvider) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	log.D("OpenAI embeddings for", len(texts), "inputs")
+	res := make([][]float32, 0, len(texts))
+
+	c := resty.New()
+	defer c.Close()
+
+	for start := 0; start < len(texts); start += embedBatchSize {
+		batch := texts[start:min(start+embedBatchSize, len(texts))]
+		if err := self.WaitForAllowance(ctx); err != nil {
+			return nil, err
+		}
+		embeddings, err := self.embedBatch(ctx, c, model, batch)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, embeddings...)
+	}
+	return res, nil
+}
+
+func (self *APIProvider) embedBatch(ctx context.Context, c *resty.Client, model string, texts []string) ([][]float32, error) {
+	r := c.R()
+	r.SetContext(ctx)
+	r.SetTimeout(embedTimeout)
+	if self.APIKey != "" && self.APIType != APITypeOllama && self.APIType != APITypeLMStudio {
+		r.Header.Add("Authorization", "Bearer "+self.APIKey)
+	}
+	r.SetBody(map[string]any{
+		"model":           model,
+		"input":           texts,
+		"encoding_format": "float",
+	})
+	body := &OpenAIEmbeddingRes{}
+	r.SetResult(body)
+	r.SetError(body)
+
+	resp, err := r.Post(self.APIURL + "/embeddings")
+	if err != nil {
+		return nil, err
+	}
+	if body.Error != nil && body.Error.Message != "" {
+		return nil, errors.New(body.Error.Message)
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("embeddings request failed: %s", resp.Status())
+	}
+	if len(body.Data) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings for %d inputs", len(body.Data), len(texts))
+	}
+
+	sort.Slice(body.Data, func(i, j int) bool { return body.Data[i].Index < body.Data[j].Index })
+	res := make([][]float32, len(body.Data))
+	for i, data := range body.Data {
+		res[i] = data.Embedding
+	}
+	return res, nil
+}
@@ -0,0 +1,85 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newEmbeddingServer(t *testing.T, requests *int) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		*requests++
+		w.Header().Set("Content-Type", "application/json")
+
+		if body.Model != "test-embed" {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error": {"message": "model not found"}}`))
+			return
+		}
+		// reversed order, clients must sort by index
+		data := make([]map[string]any, 0, len(body.Input))
+		for i := len(body.Input) - 1; i >= 0; i-- {
+			data = append(data, map[string]any{"index": i, "embedding": []float32{float32(len(body.Input[i])), 1}})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data, "model": body.Model})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestEmbed_BatchesAndOrders(t *testing.T) {
+	requests := 0
+	server := newEmbeddingServer(t, &requests)
+	provider := &APIProvider{Name: "test", APIURL: server.URL, APIType: APITypeOpenAICompatible, RateLimit: 100}
+
+	texts := make([]string, embedBatchSize+6)
+	for i := range texts {
+		texts[i] = strings.Repeat("a", i)
+	}
+	embeddings, err := provider.Embed(context.Background(), "test-embed", texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 || len(provider.rateLimiter.timestamps) != 2 {
+		t.Errorf("expected 2 rate limited requests, got %d requests", requests)
+	}
+	if len(embeddings) != len(texts) {
+		t.Fatalf("expected %d embeddings, got %d", len(texts), len(embeddings))
+	}
+	for i, embedding := range embeddings {
+		if embedding[0] != float32(i) {
+			t.Fatalf("embedding %d is out of order: %v", i, embedding)
+		}
+	}
+
+	if _, err = provider.Embed(context.Background(), "missing", []string{"x"}); err == nil || err.Error() != "model not found" {
+		t.Errorf("expected API error, got %v", err)
+	}
+}
+
+func TestIsEmbeddingModel(t *testing.T) {
+	for id, want := range map[string]bool{
+		"text-embedding-3-small":  true,
+		"nomic-embed-text:latest": true,
+		"BAAI/bge-m3":             true,
+		"gpt-4o":                  false,
+		"llama3.1:8b":             false,
+	} {
+		if got := isEmbeddingModel(map[string]any{"id": id}); got != want {
+			t.Errorf("isEmbeddingModel(%q) = %v", id, got)
+		}
+	}
+	if !isEmbeddingModel(map[string]any{"id": "custom", "type": "embeddings"}) {
+		t.Error("type reported by the provider must be used")
+	}
+}
@@ -1,6 +1,10 @@
 package ai
 
-import "agentsmith/src/mcptools"
+import (
+	"agentsmith/src/mcptools"
+	"slices"
+	"time"
+)
 
 type MessageOrigin string
 
@@ -12,8 +16,47 @@ const (
 )
 
 type Message struct {
-	ID           string                      `json:"id"`
+	ID string `json:"id"`
+	// ParentID is the message this one replies to, messages with the same
+	// parent are alternative branches of the conversation
+	ParentID     string                      `json:"parentId,omitempty"`
 	Origin       MessageOrigin               `json:"origin"`
 	Text         string                      `json:"text"`
 	ToolRequests []*mcptools.ToolCallRequest `json:"toolRequests"`
+	// ModelID is the model that generated assistant message, answers of
+	// different models to the same question are alternative branches
+	ModelID string `json:"modelId,omitempty"`
+	// RoleID is the role assistant message was generated with, if any
+	RoleID string `json:"roleId,omitempty"`
+	// Transcript of the sub-agent conversation for messages holding its result
+	Transcript []*Message `json:"transcript,omitempty"`
+	// Structured is the JSON value of the answer validated against the
+	// response schema of the request
+	Structured any `json:"structured,omitempty"`
+	// Usage reported by the provider for the completion of assistant message
+	Usage     *OpenAIChatCompletionUsage `json:"usage,omitempty"`
+	CreatedAt time.Time                  `json:"createdAt"`
+}
+
+// ActiveBranch returns the branch that ends with the last message: the
+// message and its parents, from the first one. Messages of other branches
+// are left out. Messages without parent links are a plain conversation and
+// are returned as they are.
+func ActiveBranch(messages []*Message) []*Message {
+	byID := make(map[string]*Message, len(messages))
+	linked := false
+	for _, message := range messages {
+		byID[message.ID] = message
+		linked = linked || message.ParentID != ""
+	}
+	if !linked {
+		return messages
+	}
+
+	branch := make([]*Message, 0, len(messages))
+	for message := messages[len(messages)-1]; message != nil && len(branch) < len(messages); message = byID[message.ParentID] {
+		branch = append(branch, message)
+	}
+	slices.Reverse(branch)
+	return branch
 }
@@ -4,4 +4,6 @@ type Model struct {
 	ID       string       `json:"id"`
 	Name     string       `json:"name"`
 	Provider *APIProvider `json:"-"`
+	// Embedding models produce embeddings and can't chat
+	Embedding bool `json:"embedding"`
 }
@@ -0,0 +1,372 @@
+package ai
+
+import (
+	"agentsmith/src/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// schemaMaxErrors is how many validation errors are reported, enough for the
+// model to repair its response.
+const schemaMaxErrors = 10
+
+var schemaTypes = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+// SupportsResponseFormat tells if the provider enforces JSON Schema passed as
+// response_format. Schema of other providers has to be given in the prompt.
+func (self *APIProvider) SupportsResponseFormat() bool {
+	switch self.APIType {
+	case APITypeOpenAI, APITypeLMStudio, APITypeGoogle, APITypeMistral, APITypeOllama:
+		return true
+	}
+	return false
+}
+
+func responseFormat(schema map[string]any) map[string]any {
+	return map[string]any{
+		"type": "json_schema",
+		"json_schema": map[string]any{
+			"name":   "response",
+			"schema": schema,
+			// strict mode requires every property to be required, which arbitrary schemas are not
+			"strict": false,
+		},
+	}
+}
+
+// CheckSchema reports errors in the schema itself: unknown types, invalid
+// patterns and references that can't be resolved. Only the subset of JSON
+// Schema supported by ValidateSchema is checked.
+func CheckSchema(schema map[string]any) error {
+	if len(schema) == 0 {
+		return errors.New("schema is empty")
+	}
+	return checkSchema(schema, schema, "#")
+}
+
+func checkSchema(root map[string]any, schema map[string]any, path string) error {
+	if types, ok := schema["type"]; ok {
+		for _, t := range schemaTypeList(types) {
+			if !slices.Contains(schemaTypes, t) {
+				return fmt.Errorf("%s: unknown type %q", path, t)
+			}
+		}
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", path, err)
+		}
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		if _, err := resolveRef(root, ref); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for key, value := range schema {
+		switch key {
+		case "properties", "$defs", "definitions":
+			children, _ := value.(map[string]any)
+			for name, child := range children {
+				if child, ok := child.(map[string]any); ok {
+					if err := checkSchema(root, child, path+"/"+key+"/"+name); err != nil {
+						return err
+					}
+				}
+			}
+		case "items", "additionalProperties", "not":
+			if child, ok := value.(map[string]any); ok {
+				if err := checkSchema(root, child, path+"/"+key); err != nil {
+					return err
+				}
+			}
+		case "anyOf", "oneOf", "allOf":
+			children, _ := value.([]any)
+			for i, child := range children {
+				if child, ok := child.(map[string]any); ok {
+					if err := checkSchema(root, child, path+"/"+key+"/"+strconv.Itoa(i)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ParseStructured extracts JSON value from the model response and validates
+// it against the schema. The response may be wrapped in markdown or preceded
+// by reasoning.
+func ParseStructured(response string, schema map[string]any) (any, error) {
+	content := strings.TrimSpace(util.CutThinking(response))
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content[3:], "json")
+		content = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(content), "```"))
+	}
+	if content == "" {
+		return nil, errors.New("response is empty")
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return nil, fmt.Errorf("response is not valid JSON: %w", err)
+	}
+	if err := ValidateSchema(schema, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// ValidateSchema validates JSON value decoded into Go types against a JSON
+// Schema. Supported keywords are type, enum, const, properties, required,
+// additionalProperties, items, min/maxItems, min/maxLength, pattern,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, anyOf, oneOf, allOf,
+// not and local $ref. Other keywords, like format, are ignored.
+func ValidateSchema(schema map[string]any, value any) error {
+	v := &schemaValidator{root: schema}
+	v.validate(schema, value, "$")
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(v.errors, "\n"))
+}
+
+type schemaValidator struct {
+	root   map[string]any
+	errors []string
+}
+
+func (self *schemaValidator) fail(path string, format string, args ...any) {
+	if len(self.errors) < schemaMaxErrors {
+		self.errors = append(self.errors, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// matches tells if the value is valid against the schema without reporting
+// errors, for anyOf, oneOf and not.
+func (self *schemaValidator) matches(schema any, value any) bool {
+	v := &schemaValidator{root: self.root}
+	v.validate(schema, value, "$")
+	return len(v.errors) == 0
+}
+
+func (self *schemaValidator) validate(schemaValue any, value any, path string) {
+	// boolean schema allows anything or nothing
+	if allowed, ok := schemaValue.(bool); ok {
+		if !allowed {
+			self.fail(path, "no value is allowed here")
+		}
+		return
+	}
+	schema, ok := schemaValue.(map[string]any)
+	if !ok {
+		return
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, err := resolveRef(self.root, ref)
+		if err != nil {
+			self.fail(path, "%v", err)
+			return
+		}
+		self.validate(resolved, value, path)
+	}
+
+	if types, ok := schema["type"]; ok {
+		list := schemaTypeList(types)
+		if !slices.ContainsFunc(list, func(t string) bool { return isSchemaType(value, t) }) {
+			self.fail(path, "expected %s, got %s", strings.Join(list, " or "), jsonTypeName(value))
+			return
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		if !slices.ContainsFunc(enum, func(option any) bool { return reflect.DeepEqual(option, value) }) {
+			options, _ := json.Marshal(enum)
+			self.fail(path, "must be one of %s", options)
+		}
+	}
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		expected, _ := json.Marshal(constant)
+		self.fail(path, "must be %s", expected)
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		self.validateObject(schema, v, path)
+	case []any:
+		if items, ok := schema["items"]; ok {
+			for i, item := range v {
+				self.validate(items, item, path+"["+strconv.Itoa(i)+"]")
+			}
+		}
+		if n, ok := schemaNumber(schema, "minItems"); ok && float64(len(v)) < n {
+			self.fail(path, "must have at least %v items", n)
+		}
+		if n, ok := schemaNumber(schema, "maxItems"); ok && float64(len(v)) > n {
+			self.fail(path, "must have at most %v items", n)
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if n, ok := schemaNumber(schema, "minLength"); ok && length < n {
+			self.fail(path, "must be at least %v characters long", n)
+		}
+		if n, ok := schemaNumber(schema, "maxLength"); ok && length > n {
+			self.fail(path, "must be at most %v characters long", n)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				self.fail(path, "must match pattern %s", pattern)
+			}
+		}
+	case float64:
+		if n, ok := schemaNumber(schema, "minimum"); ok && v < n {
+			self.fail(path, "must be >= %v", n)
+		}
+		if n, ok := schemaNumber(schema, "maximum"); ok && v > n {
+			self.fail(path, "must be <= %v", n)
+		}
+		if n, ok := schemaNumber(schema, "exclusiveMinimum"); ok && v <= n {
+			self.fail(path, "must be > %v", n)
+		}
+		if n, ok := schemaNumber(schema, "exclusiveMaximum"); ok && v >= n {
+			self.fail(path, "must be < %v", n)
+		}
+	}
+
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, sub := range all {
+			self.validate(sub, value, path)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		if !slices.ContainsFunc(anyOf, func(sub any) bool { return self.matches(sub, value) }) {
+			self.fail(path, "does not match any of the allowed schemas")
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		count := 0
+		for _, sub := range oneOf {
+			if self.matches(sub, value) {
+				count++
+			}
+		}
+		if count != 1 {
+			self.fail(path, "must match exactly one of the allowed schemas, matches %d", count)
+		}
+	}
+	if not, ok := schema["not"]; ok && self.matches(not, value) {
+		self.fail(path, "matches a schema it must not match")
+	}
+}
+
+func (self *schemaValidator) validateObject(schema map[string]any, object map[string]any, path string) {
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, ok := object[name]; !ok {
+					self.fail(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	additional, hasAdditional := schema["additionalProperties"]
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	// sorted so that errors are reported in a stable order
+	slices.Sort(names)
+	for _, name := range names {
+		propertyPath := path + "." + name
+		if property, ok := properties[name]; ok {
+			self.validate(property, object[name], propertyPath)
+		} else if hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				self.fail(propertyPath, "property is not allowed")
+			} else {
+				self.validate(additional, object[name], propertyPath)
+			}
+		}
+	}
+}
+
+// resolveRef resolves local references like #/$defs/address.
+func resolveRef(root map[string]any, ref string) (any, error) {
+	if ref == "#" {
+		return root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported reference %q, only local references are supported", ref)
+	}
+	var current any = root
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("reference %q can't be resolved", ref)
+		}
+		if current, ok = object[part]; !ok {
+			return nil, fmt.Errorf("reference %q can't be resolved", ref)
+		}
+	}
+	return current, nil
+}
+
+func schemaTypeList(types any) []string {
+	switch t := types.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		list := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func schemaNumber(schema map[string]any, key string) (float64, bool) {
+	n, ok := schema[key].(float64)
+	return n, ok
+}
+
+func isSchemaType(value any, schemaType string) bool {
+	switch schemaType {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	}
+	return jsonTypeName(value) == schemaType
+}
+
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,126 @@
+package ai
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["name", "tags", "priority"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "pattern": "^[a-z-]+$"},
+		"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}, "maxItems": 2},
+		"priority": {"type": "integer", "minimum": 1, "maximum": 3},
+		"owner": {"anyOf": [{"type": "null"}, {"type": "string"}]}
+	},
+	"$defs": {
+		"tag": {"enum": ["bug", "feature"]}
+	}
+}`
+
+func parseTestSchema(t *testing.T, schema string) map[string]any {
+	t.Helper()
+
+	var res map[string]any
+	if err := json.Unmarshal([]byte(schema), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestValidateSchema(t *testing.T) {
+	schema := parseTestSchema(t, testSchema)
+	if err := CheckSchema(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		value  string
+		errors []string
+	}{
+		{`{"name": "fix-login", "tags": ["bug"], "priority": 1, "owner": null}`, nil},
+		{`{"name": "fix-login", "tags": [], "priority": 3, "owner": "ann"}`, nil},
+		{`{"name": "Fix login", "tags": ["bug"], "priority": 1}`, []string{"$.name: must match pattern"}},
+		{`{"name": "x", "tags": ["bug", "docs", "feature"], "priority": 1.5}`, []string{
+			"$.priority: expected integer, got number",
+			`$.tags[1]: must be one of ["bug","feature"]`,
+			"$.tags: must have at most 2 items",
+		}},
+		{`{"name": "x", "tags": [], "priority": 4, "extra": true}`, []string{
+			"$.extra: property is not allowed",
+			"$.priority: must be <= 3",
+		}},
+		{`{"tags": [], "owner": 1}`, []string{
+			`$: missing required property "name"`,
+			`$: missing required property "priority"`,
+			"$.owner: does not match any of the allowed schemas",
+		}},
+		{`["x"]`, []string{"$: expected object, got array"}},
+	}
+
+	for _, test := range tests {
+		var value any
+		if err := json.Unmarshal([]byte(test.value), &value); err != nil {
+			t.Fatal(err)
+		}
+		err := ValidateSchema(schema, value)
+		if len(test.errors) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.value, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected errors %v", test.value, test.errors)
+			continue
+		}
+		lines := strings.Split(err.Error(), "\n")
+		if len(lines) != len(test.errors) {
+			t.Errorf("%s: expected %d errors, got %q", test.value, len(test.errors), lines)
+			continue
+		}
+		for i, prefix := range test.errors {
+			if !strings.HasPrefix(lines[i], prefix) {
+				t.Errorf("%s: error %q, expected %q", test.value, lines[i], prefix)
+			}
+		}
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	tests := map[string]string{
+		`{"type": "text"}`: `#: unknown type "text"`,
+		`{"properties": {"id": {"type": "string", "pattern": "("}}}`: "#/properties/id: invalid pattern",
+		`{"items": {"$ref": "#/$defs/missing"}}`:                     `#/items: reference "#/$defs/missing" can't be resolved`,
+		`{"anyOf": [{"$ref": "https://example.com/schema.json"}]}`:   "#/anyOf/0: unsupported reference",
+		`{}`: "schema is empty",
+	}
+	for schema, expected := range tests {
+		err := CheckSchema(parseTestSchema(t, schema))
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("%s: error %v, expected %q", schema, err, expected)
+		}
+	}
+}
+
+func TestParseStructured(t *testing.T) {
+	schema := parseTestSchema(t, `{"type": "object", "required": ["answer"]}`)
+
+	value, err := ParseStructured("<think>json it is</think>\n```json\n{\"answer\": 42}\n```", schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer := value.(map[string]any)["answer"]; answer != 42.0 {
+		t.Errorf("unexpected answer %v", answer)
+	}
+
+	if _, err = ParseStructured("The answer is 42.", schema); err == nil || !strings.HasPrefix(err.Error(), "response is not valid JSON") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err = ParseStructured(`{"result": 42}`, schema); err == nil || err.Error() != `$: missing required property "answer"` {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package knowledge
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Chunking limits, in characters.
+const (
+	chunkMaxChars     = 1500
+	chunkOverlapChars = 300
+)
+
+// Chunk is a piece of a document indexed for search. Lines are 1-based and
+// inclusive, they are used for citations.
+type Chunk struct {
+	Seq       int    `json:"seq"`
+	Heading   string `json:"heading,omitempty"`
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+	Text      string `json:"text"`
+}
+
+// segment is a run of lines that should stay together when possible: a
+// paragraph of text or a block of code between blank lines.
+type segment struct {
+	heading   string
+	startLine int
+	endLine   int
+	text      string
+	// starts a new markdown section, so it must start a new chunk
+	section bool
+}
+
+var markdownHeadingRe = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+
+func isMarkdown(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown", ".mdx":
+		return true
+	}
+	return false
+}
+
+// ChunkDocument splits document text into chunks of at most chunkMaxChars.
+// Paragraphs are kept together when they fit, markdown sections always start
+// a new chunk and consecutive chunks of a section overlap a bit, so that
+// context around a boundary is searchable.
+func ChunkDocument(name string, text string) []*Chunk {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	segments := splitSegments(strings.Split(text, "\n"), isMarkdown(name))
+
+	chunks := make([]*Chunk, 0, len(text)/chunkMaxChars+1)
+	var cur []segment
+	curLen := 0
+
+	flush := func(overlap bool) {
+		if len(cur) == 0 {
+			return
+		}
+		parts := make([]string, len(cur))
+		for i, seg := range cur {
+			parts[i] = seg.text
+		}
+		chunks = append(chunks, &Chunk{
+			Seq:       len(chunks),
+			Heading:   cur[0].heading,
+			StartLine: cur[0].startLine,
+			EndLine:   cur[len(cur)-1].endLine,
+			Text:      strings.Join(parts, "\n\n"),
+		})
+
+		last := cur[len(cur)-1]
+		cur, curLen = cur[:0], 0
+		if overlap && len(chunks[len(chunks)-1].Text) > len(last.text) && utf8.RuneCountInString(last.text) <= chunkOverlapChars {
+			cur, curLen = append(cur, last), utf8.RuneCountInString(last.text)
+		}
+	}
+
+	for _, seg := range segments {
+		segLen := utf8.RuneCountInString(seg.text)
+		if seg.section {
+			flush(false)
+		} else if len(cur) > 0 && curLen+segLen+2 > chunkMaxChars {
+			flush(true)
+			if len(cur) > 0 && curLen+segLen+2 > chunkMaxChars {
+				cur, curLen = cur[:0], 0
+			}
+		}
+		if len(cur) > 0 {
+			curLen += 2
+		}
+		cur = append(cur, seg)
+		curLen += segLen
+	}
+	flush(false)
+	return chunks
+}
+
+// splitSegments groups lines into segments separated by blank lines and
+// markdown headings. Segments longer than chunkMaxChars are split by lines,
+// and lines that are too long are split by characters.
+func splitSegments(lines []string, markdown bool) []segment {
+	segments := make([]segment, 0, len(lines)/4+1)
+	heading := ""
+	inFence := false
+	var cur []string
+	start := 0
+	section := false
+
+	flush := func(end int) {
+		if len(cur) > 0 {
+			segments = append(segments, splitLongSegment(segment{
+				heading:   heading,
+				startLine: start,
+				endLine:   end,
+				text:      strings.Join(cur, "\n"),
+				section:   section,
+			})...)
+		}
+		cur = cur[:0]
+		section = false
+	}
+
+	for i, line := range lines {
+		lineNo := i + 1
+		if markdown && strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+		if markdown && !inFence {
+			if m := markdownHeadingRe.FindStringSubmatch(line); m != nil {
+				flush(lineNo - 1)
+				heading = m[2]
+				section = true
+				cur = append(cur, line)
+				start = lineNo
+				continue
+			}
+		}
+		if strings.TrimSpace(line) == "" && !inFence {
+			flush(lineNo - 1)
+			continue
+		}
+		if len(cur) == 0 {
+			start = lineNo
+		}
+		cur = append(cur, line)
+	}
+	flush(len(lines))
+	return segments
+}
+
+func splitLongSegment(seg segment) []segment {
+	if utf8.RuneCountInString(seg.text) <= chunkMaxChars {
+		return []segment{seg}
+	}
+
+	res := make([]segment, 0, 4)
+	var cur []string
+	curLen := 0
+	start := seg.startLine
+	flush := func(end int) {
+		if len(cur) > 0 {
+			res = append(res, segment{heading: seg.heading, startLine: start, endLine: end, text: strings.Join(cur, "\n"), section: seg.section && len(res) == 0})
+		}
+		cur, curLen = cur[:0], 0
+	}
+
+	for i, line := range strings.Split(seg.text, "\n") {
+		lineNo := seg.startLine + i
+		runes := []rune(line)
+		for len(runes) > chunkMaxChars {
+			flush(lineNo - 1)
+			res = append(res, segment{heading: seg.heading, startLine: lineNo, endLine: lineNo, text: string(runes[:chunkMaxChars]), section: seg.section && len(res) == 0})
+			runes = runes[chunkMaxChars:]
+		}
+		if curLen+len(runes)+1 > chunkMaxChars {
+			flush(lineNo - 1)
+		}
+		if len(cur) == 0 {
+			start = lineNo
+		}
+		cur = append(cur, string(runes))
+		curLen += len(runes) + 1
+	}
+	flush(seg.endLine)
+	return res
+}
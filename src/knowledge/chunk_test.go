@@ -0,0 +1,49 @@
+package knowledge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChunkDocument_MarkdownSections(t *testing.T) {
+	text := "# Setup\n\nInstall the app.\n\n## Config\n\nSet the key.\n\n```\n# not a heading\n\ncode\n```\n"
+	chunks := ChunkDocument("guide.md", text)
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d: %+v", len(chunks), chunks)
+	}
+	if chunks[0].Heading != "Setup" || chunks[0].StartLine != 1 || chunks[0].EndLine != 3 || chunks[0].Text != "# Setup\n\nInstall the app." {
+		t.Errorf("unexpected first chunk %+v", chunks[0])
+	}
+	if chunks[1].Heading != "Config" || chunks[1].StartLine != 5 || chunks[1].EndLine != 13 || !strings.Contains(chunks[1].Text, "# not a heading\n\ncode") {
+		t.Errorf("unexpected second chunk %+v", chunks[1])
+	}
+}
+
+func TestChunkDocument_SizeAndOverlap(t *testing.T) {
+	paragraph := strings.Repeat("word ", 50) // 250 chars
+	text := strings.Repeat(paragraph+"\n\n", 8) + "short tail"
+	chunks := ChunkDocument("notes.txt", text)
+	if len(chunks) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if len([]rune(chunk.Text)) > chunkMaxChars {
+			t.Errorf("chunk %d is %d chars long", i, len([]rune(chunk.Text)))
+		}
+		if chunk.Seq != i {
+			t.Errorf("chunk %d has seq %d", i, chunk.Seq)
+		}
+	}
+	if chunks[1].StartLine != chunks[0].EndLine {
+		t.Errorf("chunks must overlap by the last paragraph: %+v %+v", chunks[0], chunks[1])
+	}
+	if last := chunks[len(chunks)-1]; last.EndLine != 17 || !strings.HasSuffix(last.Text, "short tail") {
+		t.Errorf("unexpected last chunk %+v", last)
+	}
+
+	// a single line longer than a chunk is split by characters
+	chunks = ChunkDocument("min.js", strings.Repeat("x", chunkMaxChars*2+10))
+	if len(chunks) != 3 || chunks[2].StartLine != 1 || len(chunks[2].Text) != 10 {
+		t.Fatalf("unexpected chunks of long line %+v", chunks)
+	}
+}
@@ -0,0 +1,320 @@
+// Package knowledge implements local knowledge bases: text documents chunked
+// and indexed in the agent SQLite DB for full text and optional embedding
+// search.
+package knowledge
+
+import (
+	"agentsmith/src/logger"
+	"agentsmith/src/store"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+var log = logger.Logger("knowledge", 1, 1, 1)
+
+// storage keeps the knowledge bases, see UseStore
+var storage store.Store
+
+// UseStore sets the store the knowledge bases are saved in.
+func UseStore(s store.Store) {
+	storage = s
+}
+
+// Maximum size of a document added to a knowledge base.
+const documentMaxBytes = 5 << 20
+
+var (
+	ErrKnowledgeBaseNotFound = errors.New("knowledge base not found")
+	ErrDocumentNotFound      = errors.New("document not found")
+)
+
+// Embedder computes embeddings of texts with the named model. It is set by
+// the agent, when it is nil knowledge bases use full text search only.
+var Embedder func(ctx context.Context, model string, texts []string) ([][]float32, error)
+
+// KnowledgeBase is a named collection of documents.
+type KnowledgeBase struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// EmbeddingModel is the ID of the model chunks are embedded with, empty
+	// when the knowledge base uses full text search only.
+	EmbeddingModel string    `json:"embeddingModel"`
+	CreatedAt      time.Time `json:"createdAt"`
+	Documents      int       `json:"documents"`
+}
+
+// Document is a file added to a knowledge base. Its text is kept only in
+// chunks.
+type Document struct {
+	ID        string    `json:"id"`
+	KBID      string    `json:"kbId"`
+	Name      string    `json:"name"`
+	Size      int       `json:"size"`
+	Chunks    int       `json:"chunks"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+var kbNameRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_\-]{0,63}$`)
+
+func NewKnowledgeBase(name string, description string, embeddingModel string) (*KnowledgeBase, error) {
+	if !kbNameRe.MatchString(name) {
+		return nil, errors.New("knowledge base name must start with a letter and contain only letters, digits, _ and -")
+	}
+	return &KnowledgeBase{
+		ID:             uuid.NewString(),
+		Name:           name,
+		Description:    description,
+		EmbeddingModel: embeddingModel,
+		CreatedAt:      time.Now(),
+	}, nil
+}
+
+func LoadKnowledgeBases() (kbs []*KnowledgeBase) {
+	log.D("Loading knowledge bases")
+	defer logger.BreakOnError()
+	kbs = make([]*KnowledgeBase, 0, 8)
+
+	db := storage.DB()
+
+	query := `
+	SELECT kb.id, kb.name, kb.description, kb.embedding_model, kb.created_at, COUNT(d.id)
+	FROM knowledge_bases kb LEFT JOIN kb_documents d ON d.kb_id = kb.id
+	GROUP BY kb.id ORDER BY kb.name;
+	`
+	rows, err := db.Query(query)
+	log.CheckE(err, nil, "Failed to select knowledge bases from DB")
+	defer rows.Close()
+
+	for rows.Next() {
+		var kb KnowledgeBase
+		err = rows.Scan(&kb.ID, &kb.Name, &kb.Description, &kb.EmbeddingModel, &kb.CreatedAt, &kb.Documents)
+		if err != nil {
+			log.W("Failed to scan knowledge base row:", err)
+			continue
+		}
+		kbs = append(kbs, &kb)
+	}
+	return kbs
+}
+
+// FindKnowledgeBase looks the knowledge base up by name or ID.
+func FindKnowledgeBase(name string) (*KnowledgeBase, error) {
+	for _, kb := range LoadKnowledgeBases() {
+		if kb.Name == name || kb.ID == name {
+			return kb, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrKnowledgeBaseNotFound, name)
+}
+
+func (self *KnowledgeBase) Save() (err error) {
+	log.D("Saving knowledge base", self.Name)
+	defer logger.BreakOnError()
+
+	db := storage.DB()
+
+	query := `
+	INSERT INTO knowledge_bases (id, name, description, embedding_model, created_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+		name=excluded.name,
+		description=excluded.description,
+		embedding_model=excluded.embedding_model;
+	`
+	_, err = db.Exec(query, self.ID, self.Name, self.Description, self.EmbeddingModel, self.CreatedAt)
+	log.CheckW(err, "Failed to update knowledge base DB")
+	return
+}
+
+// Delete removes the knowledge base with all its documents and chunks.
+func (self *KnowledgeBase) Delete() (err error) {
+	log.D("Deleting knowledge base", self.Name)
+	defer logger.BreakOnError()
+
+	db := storage.DB()
+
+	tx, err := db.Begin()
+	log.CheckE(err, nil, "Failed to start transaction")
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM kb_chunks_fts WHERE rowid IN (SELECT id FROM kb_chunks WHERE kb_id=?);", self.ID)
+	log.CheckE(err, nil, "Failed to delete knowledge base index")
+	_, err = tx.Exec("DELETE FROM kb_chunks WHERE kb_id=?;", self.ID)
+	log.CheckE(err, nil, "Failed to delete knowledge base chunks")
+	_, err = tx.Exec("DELETE FROM kb_documents WHERE kb_id=?;", self.ID)
+	log.CheckE(err, nil, "Failed to delete knowledge base documents")
+	_, err = tx.Exec("DELETE FROM knowledge_bases WHERE id=?;", self.ID)
+	log.CheckE(err, nil, "Failed to delete knowledge base")
+
+	err = tx.Commit()
+	log.CheckW(err, "Failed to commit knowledge base deletion")
+	return
+}
+
+func (self *KnowledgeBase) LoadDocuments() (docs []*Document, err error) {
+	defer logger.BreakOnError()
+	docs = make([]*Document, 0, 16)
+
+	db := storage.DB()
+
+	query := `
+	SELECT d.id, d.kb_id, d.name, d.size, d.created_at, COUNT(c.id)
+	FROM kb_documents d LEFT JOIN kb_chunks c ON c.document_id = d.id
+	WHERE d.kb_id=? GROUP BY d.id ORDER BY d.name;
+	`
+	rows, err := db.Query(query, self.ID)
+	log.CheckE(err, nil, "Failed to select documents from DB")
+	defer rows.Close()
+
+	for rows.Next() {
+		var doc Document
+		if err = rows.Scan(&doc.ID, &doc.KBID, &doc.Name, &doc.Size, &doc.CreatedAt, &doc.Chunks); err != nil {
+			log.W("Failed to scan document row:", err)
+			continue
+		}
+		docs = append(docs, &doc)
+	}
+	return docs, nil
+}
+
+// AddDocument chunks and indexes the text file. A document with the same name
+// in the knowledge base is replaced. When the knowledge base has an embedding
+// model, chunks are embedded too; failure to embed them fails the whole
+// document, so the index never mixes embedded and plain chunks.
+func (self *KnowledgeBase) AddDocument(ctx context.Context, name string, data []byte) (*Document, error) {
+	if name == "" {
+		return nil, errors.New("document name is required")
+	}
+	if len(data) > documentMaxBytes {
+		return nil, fmt.Errorf("document is larger than %d bytes", documentMaxBytes)
+	}
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return nil, errors.New("only UTF-8 text documents are supported")
+	}
+
+	chunks := ChunkDocument(name, string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	embeddings, err := self.embedChunks(ctx, chunks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed document: %w", err)
+	}
+
+	doc := &Document{ID: uuid.NewString(), KBID: self.ID, Name: name, Size: len(data), Chunks: len(chunks), CreatedAt: time.Now()}
+	return doc, doc.save(chunks, embeddings)
+}
+
+func (self *KnowledgeBase) embedChunks(ctx context.Context, chunks []*Chunk) ([][]float32, error) {
+	if self.EmbeddingModel == "" || Embedder == nil || len(chunks) == 0 {
+		return nil, nil
+	}
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.indexText()
+	}
+	embeddings, err := Embedder(ctx, self.EmbeddingModel, texts)
+	if err == nil && len(embeddings) != len(texts) {
+		err = fmt.Errorf("got %d embeddings for %d chunks", len(embeddings), len(texts))
+	}
+	return embeddings, err
+}
+
+// indexText is what is indexed for the chunk: its text prefixed by the heading
+// of its section, when the heading is not part of the text already.
+func (self *Chunk) indexText() string {
+	if self.Heading == "" || (len(self.Text) > 0 && self.Text[0] == '#') {
+		return self.Text
+	}
+	return self.Heading + "\n" + self.Text
+}
+
+func (self *Document) save(chunks []*Chunk, embeddings [][]float32) (err error) {
+	defer logger.BreakOnError()
+
+	db := storage.DB()
+
+	tx, err := db.Begin()
+	log.CheckE(err, nil, "Failed to start transaction")
+	defer tx.Rollback()
+
+	err = deleteDocuments(tx, "kb_id=? AND name=?", self.KBID, self.Name)
+	log.CheckE(err, nil, "Failed to replace document")
+
+	_, err = tx.Exec("INSERT INTO kb_documents (id, kb_id, name, size, created_at) VALUES (?, ?, ?, ?, ?);",
+		self.ID, self.KBID, self.Name, self.Size, self.CreatedAt)
+	log.CheckE(err, nil, "Failed to insert document")
+
+	chunkStmt, err := tx.Prepare(`
+	INSERT INTO kb_chunks (kb_id, document_id, seq, heading, start_line, end_line, text, embedding)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?);`)
+	log.CheckE(err, nil, "Failed to prepare chunk insert")
+	defer chunkStmt.Close()
+	ftsStmt, err := tx.Prepare("INSERT INTO kb_chunks_fts (rowid, text) VALUES (?, ?);")
+	log.CheckE(err, nil, "Failed to prepare chunk index insert")
+	defer ftsStmt.Close()
+
+	for i, chunk := range chunks {
+		var embedding []byte
+		if embeddings != nil {
+			embedding = encodeEmbedding(embeddings[i])
+		}
+		var res sql.Result
+		res, err = chunkStmt.Exec(self.KBID, self.ID, chunk.Seq, chunk.Heading, chunk.StartLine, chunk.EndLine, chunk.Text, embedding)
+		log.CheckE(err, nil, "Failed to insert chunk")
+		var id int64
+		id, err = res.LastInsertId()
+		log.CheckE(err, nil, "Failed to get chunk id")
+		_, err = ftsStmt.Exec(id, chunk.indexText())
+		log.CheckE(err, nil, "Failed to index chunk")
+	}
+
+	err = tx.Commit()
+	log.CheckW(err, "Failed to commit document")
+	return
+}
+
+// DeleteDocument removes the document and its chunks from the knowledge base.
+func (self *KnowledgeBase) DeleteDocument(id string) (err error) {
+	defer logger.BreakOnError()
+
+	db := storage.DB()
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM kb_documents WHERE id=? AND kb_id=?;", id, self.ID).Scan(&count)
+	log.CheckE(err, nil, "Failed to find document")
+	if count == 0 {
+		return ErrDocumentNotFound
+	}
+
+	tx, err := db.Begin()
+	log.CheckE(err, nil, "Failed to start transaction")
+	defer tx.Rollback()
+
+	err = deleteDocuments(tx, "id=?", id)
+	log.CheckE(err, nil, "Failed to delete document")
+
+	err = tx.Commit()
+	log.CheckW(err, "Failed to commit document deletion")
+	return
+}
+
+// deleteDocuments deletes documents matching the where clause with their
+// chunks and index entries.
+func deleteDocuments(tx *sql.Tx, where string, args ...any) error {
+	docs := "SELECT id FROM kb_documents WHERE " + where
+	_, err := tx.Exec("DELETE FROM kb_chunks_fts WHERE rowid IN (SELECT id FROM kb_chunks WHERE document_id IN ("+docs+"));", args...)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM kb_chunks WHERE document_id IN ("+docs+");", args...)
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM kb_documents WHERE "+where+";", args...)
+	}
+	return err
+}
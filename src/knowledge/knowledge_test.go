@@ -0,0 +1,138 @@
+package knowledge
+
+import (
+	"agentsmith/src/store"
+	"context"
+	"strings"
+	"testing"
+)
+
+func useTestDB(t *testing.T) {
+	t.Helper()
+
+	db, err := store.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	UseStore(db)
+}
+
+func createTestKB(t *testing.T, name string, embeddingModel string) *KnowledgeBase {
+	t.Helper()
+
+	kb, err := NewKnowledgeBase(name, "", embeddingModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = kb.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return kb
+}
+
+func addTestDocument(t *testing.T, kb *KnowledgeBase, name string, text string) *Document {
+	t.Helper()
+
+	doc, err := kb.AddDocument(context.Background(), name, []byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestSearch_RanksAndCites(t *testing.T) {
+	useTestDB(t)
+	kb := createTestKB(t, "handbook", "")
+	addTestDocument(t, kb, "setup.md", "# Setup\n\nRun the installer.\n\n# Vacation\n\nVacation requests are approved by the team lead. Vacation days do not expire.\n")
+	addTestDocument(t, kb, "office.txt", "The office is open from nine to five.\nRequests for parking go to the front desk.\n")
+	other := createTestKB(t, "other", "")
+	addTestDocument(t, other, "vacation.txt", "vacation vacation vacation")
+
+	results, err := Search(context.Background(), []*KnowledgeBase{kb}, "How are vacation requests approved?", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results from the handbook only, got %+v", results)
+	}
+	if results[0].Citation() != "handbook/setup.md:5-7" || results[0].Heading != "Vacation" {
+		t.Errorf("unexpected best result %+v", results[0])
+	}
+	if results[1].Citation() != "handbook/office.txt:1-2" {
+		t.Errorf("unexpected second result %+v", results[1])
+	}
+
+	// FTS syntax in the query is treated as plain words
+	if _, err = Search(context.Background(), []*KnowledgeBase{kb}, `vacation" NEAR( AND -"`, 5); err != nil {
+		t.Fatalf("query with FTS syntax failed: %v", err)
+	}
+}
+
+func TestDocuments_ReplaceAndDelete(t *testing.T) {
+	useTestDB(t)
+	kb := createTestKB(t, "notes", "")
+	addTestDocument(t, kb, "a.txt", "old content about apples")
+	doc := addTestDocument(t, kb, "a.txt", "new content about pears")
+
+	docs, _ := kb.LoadDocuments()
+	if len(docs) != 1 || docs[0].ID != doc.ID || docs[0].Chunks != 1 {
+		t.Fatalf("document must be replaced, got %+v", docs)
+	}
+	if results, _ := Search(context.Background(), []*KnowledgeBase{kb}, "apples", 5); len(results) != 0 {
+		t.Fatalf("replaced content is still indexed: %+v", results)
+	}
+
+	if _, err := kb.AddDocument(context.Background(), "bin.dat", []byte{0x00, 0x01}); err == nil {
+		t.Error("binary document must be rejected")
+	}
+	if err := kb.DeleteDocument("missing"); err != ErrDocumentNotFound {
+		t.Errorf("DeleteDocument(missing) = %v", err)
+	}
+	if err := kb.DeleteDocument(doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if results, _ := Search(context.Background(), []*KnowledgeBase{kb}, "pears", 5); len(results) != 0 {
+		t.Fatalf("deleted content is still indexed: %+v", results)
+	}
+
+	addTestDocument(t, kb, "b.txt", "plums")
+	if err := kb.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindKnowledgeBase("notes"); err == nil {
+		t.Error("knowledge base must be deleted")
+	}
+}
+
+func TestSearch_Embeddings(t *testing.T) {
+	useTestDB(t)
+	// fake model that embeds texts by mentions of two topics
+	Embedder = func(ctx context.Context, model string, texts []string) ([][]float32, error) {
+		res := make([][]float32, len(texts))
+		for i, text := range texts {
+			text = strings.ToLower(text)
+			res[i] = []float32{float32(strings.Count(text, "cat") + strings.Count(text, "kitten")), float32(strings.Count(text, "dog"))}
+		}
+		return res, nil
+	}
+	t.Cleanup(func() { Embedder = nil })
+
+	kb := createTestKB(t, "pets", "test-embed")
+	addTestDocument(t, kb, "dogs.txt", "Dogs need walks every day.")
+	addTestDocument(t, kb, "cats.txt", "A kitten sleeps most of the day.")
+
+	// only the embedding ranking finds the kitten for the word cat
+	results, err := Search(context.Background(), []*KnowledgeBase{kb}, "cat", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 || results[0].Document != "cats.txt" {
+		t.Fatalf("expected cats.txt first, got %+v", results)
+	}
+
+	out, err := executeKBSearch(context.Background(), map[string]any{"query": "cat", "kb": "pets", "limit": float64(1)})
+	if err != nil || !strings.HasPrefix(out, "[1] Source: [pets/cats.txt:1]\nA kitten") || strings.Contains(out, "[2]") {
+		t.Fatalf("kb_search = %q, %v", out, err)
+	}
+}
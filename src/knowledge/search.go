@@ -0,0 +1,301 @@
+package knowledge
+
+import (
+	"agentsmith/src/util"
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Search limits.
+const (
+	searchDefaultLimit = 5
+	searchMaxLimit     = 20
+	// candidates taken from each ranking before they are fused
+	searchCandidates = 50
+	// k constant of reciprocal rank fusion
+	rrfK = 60
+)
+
+// SearchResult is a chunk found by Search.
+type SearchResult struct {
+	ChunkID   int64   `json:"chunkId"`
+	KBName    string  `json:"kb"`
+	Document  string  `json:"document"`
+	Heading   string  `json:"heading,omitempty"`
+	StartLine int     `json:"startLine"`
+	EndLine   int     `json:"endLine"`
+	Text      string  `json:"text"`
+	Score     float64 `json:"score"`
+}
+
+// Citation identifies the source of the result as kb/document:lines.
+func (self *SearchResult) Citation() string {
+	if self.StartLine == self.EndLine {
+		return fmt.Sprintf("%s/%s:%d", self.KBName, self.Document, self.StartLine)
+	}
+	return fmt.Sprintf("%s/%s:%d-%d", self.KBName, self.Document, self.StartLine, self.EndLine)
+}
+
+// Search finds chunks of the knowledge bases relevant to the query. Full text
+// search is ranked with BM25; knowledge bases with an embedding model are also
+// searched by cosine similarity and both rankings are merged with reciprocal
+// rank fusion.
+func Search(ctx context.Context, kbs []*KnowledgeBase, query string, limit int) ([]*SearchResult, error) {
+	if limit <= 0 {
+		limit = searchDefaultLimit
+	}
+	limit = min(limit, searchMaxLimit)
+	if len(kbs) == 0 || strings.TrimSpace(query) == "" {
+		return []*SearchResult{}, nil
+	}
+
+	db := storage.DB()
+
+	kbIDs := make([]any, len(kbs))
+	for i, kb := range kbs {
+		kbIDs[i] = kb.ID
+	}
+
+	rankings := make([][]int64, 0, 1+len(kbs))
+	textRanking, err := searchText(ctx, db, kbIDs, query)
+	if err != nil {
+		return nil, err
+	}
+	rankings = append(rankings, textRanking)
+
+	// chunks of one knowledge base share the model, so embed the query once per model
+	byModel := make(map[string][]any)
+	for _, kb := range kbs {
+		if kb.EmbeddingModel != "" && Embedder != nil {
+			byModel[kb.EmbeddingModel] = append(byModel[kb.EmbeddingModel], kb.ID)
+		}
+	}
+	for model, ids := range byModel {
+		ranking, err := searchEmbeddings(ctx, db, ids, model, query)
+		if err != nil {
+			// full text results are still useful when the provider is unavailable
+			log.W("Embedding search failed, using full text search only:", err)
+			continue
+		}
+		rankings = append(rankings, ranking)
+	}
+
+	scores := fuseRankings(rankings)
+	ids := make([]int64, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	sortByScore(ids, scores)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return loadResults(ctx, db, ids, scores)
+}
+
+// sortByScore sorts ids by descending score, keeping the order of ties.
+func sortByScore(ids []int64, scores map[int64]float64) {
+	slices.SortStableFunc(ids, func(a, b int64) int {
+		return cmp.Compare(scores[b], scores[a])
+	})
+}
+
+func fuseRankings(rankings [][]int64) map[int64]float64 {
+	scores := make(map[int64]float64)
+	for _, ranking := range rankings {
+		for rank, id := range ranking {
+			scores[id] += 1 / float64(rrfK+rank+1)
+		}
+	}
+	return scores
+}
+
+// searchText returns IDs of the best matching chunks, best first.
+func searchText(ctx context.Context, db *sql.DB, kbIDs []any, query string) ([]int64, error) {
+	match := util.FTSQuery(query)
+	if match == "" {
+		return nil, nil
+	}
+	version, err := util.FTSVersion(ctx, db, "kb_chunks_fts")
+	if err != nil {
+		return nil, err
+	}
+
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(kbIDs)), ", ")
+	args := append([]any{match}, kbIDs...)
+	if version == "fts5" {
+		query := `
+		SELECT c.id FROM kb_chunks_fts f JOIN kb_chunks c ON c.id = f.rowid
+		WHERE kb_chunks_fts MATCH ? AND c.kb_id IN (` + in + `)
+		ORDER BY bm25(kb_chunks_fts) LIMIT ` + fmt.Sprint(searchCandidates) + `;`
+		return queryIDs(ctx, db, query, args...)
+	}
+
+	// FTS4 has no ranking function, BM25 is computed from matchinfo
+	query = `
+	SELECT c.id, matchinfo(kb_chunks_fts, 'pcnalx') FROM kb_chunks_fts f JOIN kb_chunks c ON c.id = f.rowid
+	WHERE kb_chunks_fts MATCH ? AND c.kb_id IN (` + in + `);`
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := make(map[int64]float64)
+	ids := make([]int64, 0, searchCandidates)
+	for rows.Next() {
+		var id int64
+		var info []byte
+		if err = rows.Scan(&id, &info); err != nil {
+			return nil, err
+		}
+		scores[id] = util.BM25(info)
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	sortByScore(ids, scores)
+	if len(ids) > searchCandidates {
+		ids = ids[:searchCandidates]
+	}
+	return ids, nil
+}
+
+// searchEmbeddings returns IDs of chunks most similar to the query, best
+// first. Chunks are compared in memory, which is fine for local collections.
+func searchEmbeddings(ctx context.Context, db *sql.DB, kbIDs []any, model string, query string) ([]int64, error) {
+	embeddings, err := Embedder(ctx, model, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) != 1 {
+		return nil, errors.New("no embedding returned for the query")
+	}
+	queryEmbedding := embeddings[0]
+
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(kbIDs)), ", ")
+	rows, err := db.QueryContext(ctx, "SELECT id, embedding FROM kb_chunks WHERE embedding IS NOT NULL AND kb_id IN ("+in+");", kbIDs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := make(map[int64]float64)
+	ids := make([]int64, 0, 256)
+	for rows.Next() {
+		var id int64
+		var data []byte
+		if err = rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		embedding := decodeEmbedding(data)
+		if len(embedding) != len(queryEmbedding) {
+			continue
+		}
+		scores[id] = cosine(queryEmbedding, embedding)
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortByScore(ids, scores)
+	if len(ids) > searchCandidates {
+		ids = ids[:searchCandidates]
+	}
+	return ids, nil
+}
+
+func cosine(a []float32, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+func encodeEmbedding(embedding []float32) []byte {
+	data := make([]byte, len(embedding)*4)
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+	return data
+}
+
+func decodeEmbedding(data []byte) []float32 {
+	embedding := make([]float32, len(data)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return embedding
+}
+
+func queryIDs(ctx context.Context, db *sql.DB, query string, args ...any) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, searchCandidates)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// loadResults loads chunks in the order of ids.
+func loadResults(ctx context.Context, db *sql.DB, ids []int64, scores map[int64]float64) ([]*SearchResult, error) {
+	res := make([]*SearchResult, 0, len(ids))
+	for _, id := range ids {
+		query := `
+		SELECT c.id, kb.name, d.name, c.heading, c.start_line, c.end_line, c.text
+		FROM kb_chunks c
+		JOIN kb_documents d ON d.id = c.document_id
+		JOIN knowledge_bases kb ON kb.id = c.kb_id
+		WHERE c.id=?;
+		`
+		var r SearchResult
+		err := db.QueryRowContext(ctx, query, id).Scan(&r.ChunkID, &r.KBName, &r.Document, &r.Heading, &r.StartLine, &r.EndLine, &r.Text)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.Score = scores[id]
+		res = append(res, &r)
+	}
+	return res, nil
+}
+
+// FormatResults renders results for the model, each one headed by the
+// citation the model should use to refer to it.
+func FormatResults(results []*SearchResult) string {
+	var sb strings.Builder
+	for i, r := range results {
+		sb.WriteString(fmt.Sprintf("[%d] Source: [%s]", i+1, r.Citation()))
+		if r.Heading != "" {
+			sb.WriteString(" - " + r.Heading)
+		}
+		sb.WriteString("\n" + r.Text + "\n\n")
+	}
+	return strings.TrimSpace(sb.String())
+}
@@ -0,0 +1,49 @@
+package knowledge
+
+import (
+	"agentsmith/src/mcptools"
+	"context"
+	"strings"
+)
+
+var kbSearchTool = &mcptools.Tool{
+	Name:           "kb_search",
+	Description:    "Search the user's knowledge bases of uploaded documents and get the most relevant excerpts. Every excerpt has a source, cite it in square brackets, e.g. [handbook/setup.md:10-24], when you use the excerpt in your answer.",
+	RequiredParams: []string{"query"},
+	Params: []*mcptools.ToolParam{
+		{Name: "query", Type: "string", Description: "What to search for, a question or keywords."},
+		{Name: "kb", Type: "string", Description: "Name of the knowledge base to search. All knowledge bases are searched when empty."},
+		{Name: "limit", Type: "integer", Description: "Maximum number of excerpts, 5 by default and at most 20."},
+	},
+}
+
+func init() {
+	mcptools.RegisterBuiltinTool(mcptools.NewBuiltinTool(kbSearchTool, executeKBSearch))
+}
+
+func executeKBSearch(ctx context.Context, params map[string]any) (string, error) {
+	query, _ := params["query"].(string)
+	name, _ := params["kb"].(string)
+	limit, _ := params["limit"].(float64)
+
+	kbs := LoadKnowledgeBases()
+	if name = strings.TrimSpace(name); name != "" {
+		kb, err := FindKnowledgeBase(name)
+		if err != nil {
+			return "", err
+		}
+		kbs = []*KnowledgeBase{kb}
+	}
+	if len(kbs) == 0 {
+		return "No knowledge bases exist. Ask the user to create one and upload documents.", nil
+	}
+
+	results, err := Search(ctx, kbs, query, int(limit))
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return "Nothing relevant was found.", nil
+	}
+	return FormatResults(results), nil
+}
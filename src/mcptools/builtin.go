@@ -2,110 +2,226 @@ package mcptools
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 
 	lua "github.com/yuin/gopher-lua"
 )
 
-func GetBuiltinTools() []*Tool {
-	res := make([]*Tool, 0, 4)
-
-	// tool, err := NewToolFromJSON(`{
-	// 	"Name": "builtin_dynamic_ai_agent",
-	// 	"Description": "Use this tool to dynamically create AI agent with custom system prompts and subset of the tools you have. Use the combination of the system prompt, query and available tools to obtain the result from AI model. For example you can instruct model to analyze query and create a task execution plan for you. Or you can instruct model to validate or classify something. Use your best judgement tone determine the purpose of this tool and if you can utilize it to achieve your objective.",
-	// 	"Required": ["sysPrompt", "query"],
-	// 	"Params": [
-	// 		{
-	// 			"Name": "sysPrompt",
-	// 			"Type": "string",
-	// 			"Description": "System prompt that AI agent will set to model. Include here any context information you want AI model to be aware of. Model will not have any other context outside of this."
-	// 		},
-	// 		{
-	// 			"Name": "query",
-	// 			"Type": "string",
-	// 			"Description": "Query text to be sent to the AI agent."
-	// 		},
-	// 		{
-	// 			"Name": "tools",
-	// 			"Type": "array",
-	// 			"Description": "Array of tool names that can be used by this agent. Specify here any tools from the ones you have that you think the AI agent will need to use.",
-	// 		},
-	// 	],
-	// }`)
-
-	tool, err := NewToolFromJSON(`{
-		"Name": "lua_code_runner",
-		"Description": "Execute lua code and get the result. Use this tool when you need to perform any math or calculations. Any print with print() will be accumulated and returned as result. Last value on stack will be appended to the result. Don't write complex functions, write direct code and finish it with return statement to get result.",
-		"Required": ["code"],
-		"Params": [
-			{
-				"Name": "code",
-				"Type": "string",
-				"Description": "Lua5.1 code to be executed."
-			}
-		]
-	}`)
+var luaCodeRunnerTool = &Tool{
+	Name:           "lua_code_runner",
+	Description:    "Execute lua code and get the result. Use this tool when you need to perform any math or calculations. Any print with print() will be accumulated and returned as output. Last value on stack will be returned as result. Don't write complex functions, write direct code and finish it with return statement to get result. Code runs in a sandbox: only base, string, table, math and os.time/os.clock/os.date are available, no file or process access, execution time and memory are limited.",
+	RequiredParams: []string{"code"},
+	Params: []*ToolParam{
+		{Name: "code", Type: "string", Description: "Lua5.1 code to be executed."},
+	},
+}
+
+func init() {
+	RegisterBuiltinTool(NewBuiltinTool(luaCodeRunnerTool, func(ctx context.Context, params map[string]any) (string, error) {
+		return RunLua(ctx, &ToolCallRequest{Name: luaCodeRunnerTool.Name, Params: params}), nil
+	}))
+}
+
+// Limits of the sandbox lua_code_runner executes code in, besides codeLimits.
+const (
+	luaMaxInstructions = 50_000_000
+	luaMaxOutputBytes  = 64 << 10
+	luaMaxStringBytes  = 1 << 20
+	luaCallStackSize   = 256
+	luaRegistrySize    = 1024 * 16
+	luaRegistryMaxSize = 1024 * 256
+)
+
+var errLuaInstructionLimit = errors.New("instruction limit exceeded")
+
+// CodeRunResult is the structured result of code runner tools, returned to
+// the model as JSON.
+type CodeRunResult struct {
+	Output    string `json:"output,omitempty"`
+	Result    any    `json:"result"`
+	Error     string `json:"error,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
 
-	if err == nil {
-		res = append(res, tool)
+func (self *CodeRunResult) String() string {
+	jsonOutput, err := json.MarshalIndent(self, "", "  ")
+	log.CheckW(err, "error marshalling tool result to json")
+	if len(jsonOutput) > luaMaxOutputBytes {
+		// result value itself is too big, drop it and keep the rest
+		self.Result = nil
+		self.Truncated = true
+		jsonOutput, _ = json.MarshalIndent(self, "", "  ")
 	}
+	return string(jsonOutput)
+}
 
-	return res
+// luaBudget is the context given to the Lua VM. gopher-lua checks Done() before
+// every instruction, which is used to count instructions.
+type luaBudget struct {
+	context.Context
+	instructions int
+	err          error
 }
 
-func RunLua(callRequest *ToolCallRequest) (toolResult string) {
-	L := lua.NewState()
+var closedCh = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+func (self *luaBudget) Done() <-chan struct{} {
+	if self.err != nil {
+		return closedCh
+	}
+	self.instructions++
+	if self.instructions > luaMaxInstructions {
+		self.err = errLuaInstructionLimit
+		return closedCh
+	}
+	return self.Context.Done()
+}
+
+func (self *luaBudget) Err() error {
+	if self.err != nil {
+		return self.err
+	}
+	if self.Context.Err() != nil {
+		return context.Cause(self.Context)
+	}
+	return nil
+}
+
+// newLuaSandbox creates Lua state with only base, table, string, math and a
+// time-only subset of os libraries. Everything that can touch files, load
+// code from disk or spawn processes is left out.
+func newLuaSandbox() *lua.LState {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:        true,
+		CallStackSize:       luaCallStackSize,
+		RegistrySize:        luaRegistrySize,
+		RegistryMaxSize:     luaRegistryMaxSize,
+		MinimizeStackMemory: true,
+	})
+
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+		{lua.OsLibName, lua.OpenOs},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	for _, name := range []string{"dofile", "loadfile", "require", "module", "_printregs"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	fullOs := L.GetGlobal(lua.OsLibName).(*lua.LTable)
+	safeOs := L.NewTable()
+	for _, name := range []string{"clock", "date", "difftime", "time"} {
+		safeOs.RawSetString(name, fullOs.RawGetString(name))
+	}
+	L.SetGlobal(lua.OsLibName, safeOs)
+
+	// string.rep is the easiest way to allocate huge memory in a single
+	// instruction. It takes the separator of Lua 5.2, which gopher-lua ignores.
+	stringLib := L.GetGlobal(lua.StringLibName).(*lua.LTable)
+	stringLib.RawSetString("rep", L.NewFunction(func(L *lua.LState) int {
+		str, n, sep := L.CheckString(1), L.CheckInt(2), L.OptString(3, "")
+		if n <= 0 {
+			L.Push(lua.LString(""))
+			return 1
+		}
+		if len(str)+len(sep) > (luaMaxStringBytes+len(sep))/n {
+			L.RaiseError("string.rep result is too large")
+		}
+		L.Push(lua.LString(strings.Repeat(str+sep, n-1) + str))
+		return 1
+	}))
+
+	return L
+}
+
+// RunLua executes code from the call request in a sandbox in a child process.
+// Execution is bound by ctx, a timeout and instruction/memory budgets. Print
+// output is captured, and both the last returned value and any error are
+// reported in the structured result.
+func RunLua(ctx context.Context, callRequest *ToolCallRequest) string {
+	codeStr, ok := callRequest.Params["code"].(string)
+	if !ok || strings.TrimSpace(codeStr) == "" {
+		res := &CodeRunResult{Error: "missing code parameter"}
+		return res.String()
+	}
+	return runInSandbox(ctx, sandboxLua, codeStr).String()
+}
+
+// runLuaSandbox runs the code in this process. It must only be called in the
+// child process, as it exits the process when the memory limit is exceeded.
+func runLuaSandbox(code string, limits sandboxLimits) *CodeRunResult {
+	res := &CodeRunResult{}
+
+	L := newLuaSandbox()
 	defer L.Close() // Ensure Lua state is closed even on error
 
 	var stdoutBuf bytes.Buffer // Buffer to capture print output
 
+	write := func(s string) {
+		if stdoutBuf.Len()+len(s) > luaMaxOutputBytes {
+			stdoutBuf.WriteString(s[:max(0, luaMaxOutputBytes-stdoutBuf.Len())])
+			res.Truncated = true
+			return
+		}
+		stdoutBuf.WriteString(s)
+	}
+
 	// Override the Lua print function to write to our buffer
 	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
 		top := L.GetTop()
 		for i := 1; i <= top; i++ {
-			stdoutBuf.WriteString(L.ToStringMeta(L.Get(i)).String())
+			write(L.ToStringMeta(L.Get(i)).String())
 			if i < top {
-				stdoutBuf.WriteString("\t") // Separate arguments with tabs
+				write("\t") // Separate arguments with tabs
 			}
 		}
-		stdoutBuf.WriteString("\n") // Add newline at the end
-		return 0                    // No return values
+		write("\n") // Add newline at the end
+		return 0    // No return values
 	}))
 
-	codeStr := callRequest.Params["code"].(string)
-
-	err := L.DoString(codeStr)
-	if err == nil {
-		// Capture print output
-		capturedOutput := stdoutBuf.String()
-
-		// Capture the last returned value
-		lv := L.Get(-1)
-		// returnedValue := ""
-		// if str, ok := lv.(lua.LString); ok && str != "" {
-		// 	returnedValue = string(str)
-		// }
-		goValue := luaValueToGoInterface(lv)
-		resultMap := map[string]interface{}{
-			"result": goValue,
-		}
-		jsonOutput, err := json.MarshalIndent(resultMap, "", "  ") // Using MarshalIndent for pretty print
-		log.CheckW(err, "error marshalling tool result to json")
-		returnedValue := string(jsonOutput)
-
-		// Combine results: prioritize print output, then add returned value if available
-		if capturedOutput != "" {
-			toolResult = capturedOutput
-			if returnedValue != "" {
-				toolResult += "\nLast returned value: " + returnedValue
-			}
-		} else if returnedValue != "" {
-			toolResult = returnedValue
+	// the budget sees the timeout itself, memory is checked by watchHeap
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), limits.Timeout)
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	watchHeap(timeoutCtx, limits.MaxAllocBytes, nil, done)
+	budget := &luaBudget{Context: timeoutCtx}
+	L.SetContext(budget)
+
+	err := L.DoString(code)
+	res.Output = stdoutBuf.String()
+	if err != nil {
+		if budget.Err() != nil {
+			res.Error = sandboxStoppedPrefix + budget.Err().Error()
+		} else {
+			res.Error = err.Error()
 		}
-		// If neither print output nor returned value, toolResult remains an empty string.
+		return res
 	}
-	return
+
+	// Capture the last returned value
+	if L.GetTop() > 0 {
+		res.Result = luaValueToGoInterface(L.Get(-1))
+	}
+	return res
 }
 
 func luaValueToGoInterface(lv lua.LValue) interface{} {
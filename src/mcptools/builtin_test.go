@@ -0,0 +1,110 @@
+package mcptools
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runLuaCode(t *testing.T, code string) CodeRunResult {
+	t.Helper()
+
+	raw := RunLua(context.Background(), &ToolCallRequest{Name: "lua_code_runner", Params: map[string]any{"code": code}})
+	var res CodeRunResult
+	if err := json.Unmarshal([]byte(raw), &res); err != nil {
+		t.Fatalf("RunLua returned non JSON result %q: %v", raw, err)
+	}
+	return res
+}
+
+func TestRunLua_OutputAndResult(t *testing.T) {
+	res := runLuaCode(t, `print("sum", 1 + 2) return {a = 2 ^ 10}`)
+
+	if res.Error != "" {
+		t.Fatalf("unexpected error: %s", res.Error)
+	}
+	if res.Output != "sum\t3\n" {
+		t.Errorf("Output = %q, want %q", res.Output, "sum\t3\n")
+	}
+	result, ok := res.Result.(map[string]any)
+	if !ok || result["a"] != float64(1024) {
+		t.Errorf("Result = %#v, want map with a=1024", res.Result)
+	}
+}
+
+func TestRunLua_SyntaxErrorIsReported(t *testing.T) {
+	res := runLuaCode(t, `return 1 +`)
+
+	if res.Error == "" {
+		t.Fatal("expected syntax error to be reported")
+	}
+}
+
+func TestRunLua_RestrictedStdlib(t *testing.T) {
+	cases := map[string]string{
+		"io":          `return io.open("/etc/passwd")`,
+		"os.remove":   `return os.remove("some-file")`,
+		"os.execute":  `return os.execute("ls")`,
+		"dofile":      `return dofile("script.lua")`,
+		"require":     `return require("os")`,
+		"huge string": `return string.rep("x", 1e9)`,
+	}
+	for name, code := range cases {
+		t.Run(name, func(t *testing.T) {
+			if res := runLuaCode(t, code); res.Error == "" {
+				t.Fatalf("expected %q to fail in sandbox, got result %#v", code, res.Result)
+			}
+		})
+	}
+
+	if res := runLuaCode(t, `return os.time() > 0`); res.Error != "" || res.Result != true {
+		t.Fatalf("os.time should be available, got %#v / %q", res.Result, res.Error)
+	}
+}
+
+func TestRunLua_InfiniteLoopIsStopped(t *testing.T) {
+	withCodeLimits(t, sandboxLimits{Timeout: 500 * time.Millisecond, MaxAllocBytes: codeLimits.MaxAllocBytes})
+
+	res := runLuaCode(t, `while true do end`)
+
+	if !strings.Contains(res.Error, "execution stopped") {
+		t.Fatalf("expected execution to be stopped, got error %q", res.Error)
+	}
+}
+
+func TestRunLua_OutputIsCapped(t *testing.T) {
+	res := runLuaCode(t, `for i = 1, 100000 do print("0123456789") end`)
+
+	if !res.Truncated || len(res.Output) > luaMaxOutputBytes {
+		t.Fatalf("expected truncated output, got %d bytes, truncated=%v", len(res.Output), res.Truncated)
+	}
+}
+
+func TestRunLua_MemoryIsCapped(t *testing.T) {
+	res := runLuaCode(t, `local s = "0123456789abcdef" for i = 1, 40 do s = s .. s end return #s`)
+
+	if !strings.Contains(res.Error, errMemoryLimit.Error()) {
+		t.Fatalf("expected memory limit error, got error %q, result %#v", res.Error, res.Result)
+	}
+}
+
+func TestRunLua_GarbageIsNotCharged(t *testing.T) {
+	withCodeLimits(t, sandboxLimits{Timeout: codeLimits.Timeout, MaxAllocBytes: 16 << 20})
+
+	// allocates far more than the memory limit in total, but keeps little of it
+	res := runLuaCode(t, `local n = 0 for i = 1, 50000 do local s = string.rep("x", 1000) .. i n = n + #s end return n`)
+
+	if res.Error != "" {
+		t.Fatalf("unexpected error: %s", res.Error)
+	}
+}
+
+func TestRunLua_StringRepSeparator(t *testing.T) {
+	res := runLuaCode(t, `return string.rep("a", 3, ",") .. string.rep("b", 2) .. string.rep("c", 0, ",")`)
+
+	if res.Error != "" || res.Result != "a,a,abb" {
+		t.Fatalf("Result = %#v, error %q, want %q", res.Result, res.Error, "a,a,abb")
+	}
+}
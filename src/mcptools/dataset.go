@@ -0,0 +1,359 @@
+package mcptools
+
+import (
+	"agentsmith/src/logger"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DatasetType string
+
+const (
+	DatasetTypeSQLite DatasetType = "sqlite"
+	DatasetTypeCSV    DatasetType = "csv"
+)
+
+// Limits of CSV files loaded into memory.
+const (
+	csvMaxBytes = 64 << 20
+	csvMaxRows  = 500_000
+)
+
+// Dataset is a local SQLite or CSV file attached by the user for the SQL
+// tools. Files are never modified.
+type Dataset struct {
+	ID        string      `json:"id"`
+	Name      string      `json:"name"`
+	Type      DatasetType `json:"type"`
+	Path      string      `json:"path"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+var ErrDatasetNotFound = errors.New("dataset not found")
+
+var datasetNameRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_\-]{0,63}$`)
+
+// NewDataset validates the file and creates dataset for it. Type is detected
+// from the file extension.
+func NewDataset(name string, path string) (*Dataset, error) {
+	if !datasetNameRe.MatchString(name) {
+		return nil, errors.New("dataset name must start with a letter and contain only letters, digits, _ and -")
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errors.New("dataset path is a directory")
+	}
+
+	dataset := &Dataset{ID: uuid.NewString(), Name: name, Path: path, CreatedAt: time.Now()}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		dataset.Type = DatasetTypeCSV
+	case ".db", ".sqlite", ".sqlite3":
+		dataset.Type = DatasetTypeSQLite
+	default:
+		return nil, errors.New("unsupported dataset file, expected .csv, .db, .sqlite or .sqlite3")
+	}
+	return dataset, nil
+}
+
+func LoadDatasets() (datasets []*Dataset) {
+	log.D("Loading datasets")
+	defer logger.BreakOnError()
+	datasets = make([]*Dataset, 0, 8)
+
+	db := storage.DB()
+
+	rows, err := db.Query("SELECT id, name, type, path, created_at FROM datasets ORDER BY name;")
+	log.CheckE(err, nil, "Failed to select datasets from DB")
+	defer rows.Close()
+
+	for rows.Next() {
+		var dataset Dataset
+		if err = rows.Scan(&dataset.ID, &dataset.Name, &dataset.Type, &dataset.Path, &dataset.CreatedAt); err != nil {
+			log.W("Failed to scan dataset row:", err)
+			continue
+		}
+		datasets = append(datasets, &dataset)
+	}
+	return datasets
+}
+
+func FindDataset(name string) (*Dataset, error) {
+	for _, dataset := range LoadDatasets() {
+		if dataset.Name == name || dataset.ID == name {
+			return dataset, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrDatasetNotFound, name)
+}
+
+func (self *Dataset) Save() (err error) {
+	log.D("Saving dataset", self.Name)
+	defer logger.BreakOnError()
+
+	db := storage.DB()
+
+	query := `
+	INSERT INTO datasets (id, name, type, path, created_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+		name=excluded.name,
+		type=excluded.type,
+		path=excluded.path;
+	`
+	_, err = db.Exec(query, self.ID, self.Name, self.Type, self.Path, self.CreatedAt)
+	log.CheckW(err, "Failed to update dataset DB")
+	return
+}
+
+func (self *Dataset) Delete() (err error) {
+	log.D("Deleting dataset", self.Name)
+	defer logger.BreakOnError()
+
+	db := storage.DB()
+
+	_, err = db.Exec("DELETE FROM datasets WHERE id=?", self.ID)
+	log.CheckW(err, "Failed to delete dataset")
+	dropCSVCache(self.ID)
+	return
+}
+
+// open returns read-only connection to the dataset. For CSV datasets it is a
+// shared in-memory database the file was loaded into, so it must not be
+// closed by the caller. The returned close function must always be called.
+func (self *Dataset) open(ctx context.Context) (db *sql.DB, closeFn func(), err error) {
+	switch self.Type {
+	case DatasetTypeSQLite:
+		if _, err = os.Stat(self.Path); err != nil {
+			return nil, nil, err
+		}
+		dsn := "file:" + (&url.URL{Path: self.Path}).EscapedPath() + "?mode=ro&_query_only=true"
+		db, err = sql.Open("sqlite3", dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = db.PingContext(ctx); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return db, func() { db.Close() }, nil
+	case DatasetTypeCSV:
+		db, err = loadCSV(ctx, self)
+		return db, func() {}, err
+	}
+	return nil, nil, errors.New("unknown dataset type: " + string(self.Type))
+}
+
+type csvCacheEntry struct {
+	db      *sql.DB
+	modTime time.Time
+	size    int64
+}
+
+var csvCache = struct {
+	mu      sync.Mutex
+	entries map[string]*csvCacheEntry
+}{entries: make(map[string]*csvCacheEntry)}
+
+func dropCSVCache(id string) {
+	csvCache.mu.Lock()
+	defer csvCache.mu.Unlock()
+
+	if entry, ok := csvCache.entries[id]; ok {
+		entry.db.Close()
+		delete(csvCache.entries, id)
+	}
+}
+
+// loadCSV loads CSV file into a table of an in-memory SQLite database. The
+// database is cached until the file changes. The table is named after the
+// file and column types are inferred from the values.
+func loadCSV(ctx context.Context, dataset *Dataset) (*sql.DB, error) {
+	info, err := os.Stat(dataset.Path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > csvMaxBytes {
+		return nil, fmt.Errorf("CSV file is larger than %d bytes", csvMaxBytes)
+	}
+
+	csvCache.mu.Lock()
+	defer csvCache.mu.Unlock()
+	if entry, ok := csvCache.entries[dataset.ID]; ok {
+		if entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+			return entry.db, nil
+		}
+		entry.db.Close()
+		delete(csvCache.entries, dataset.ID)
+	}
+
+	f, err := os.Open(dataset.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = false
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := csvColumnNames(header)
+
+	records := make([][]string, 0, 1024)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		if len(records) >= csvMaxRows {
+			return nil, fmt.Errorf("CSV file has more than %d rows", csvMaxRows)
+		}
+		records = append(records, record)
+	}
+
+	// every connection to ":memory:" is a separate database, so keep exactly one
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	if err = fillCSVTable(ctx, db, CSVTableName(dataset.Path), columns, records); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err = db.ExecContext(ctx, "PRAGMA query_only = true;"); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	csvCache.entries[dataset.ID] = &csvCacheEntry{db: db, modTime: info.ModTime(), size: info.Size()}
+	return db, nil
+}
+
+func fillCSVTable(ctx context.Context, db *sql.DB, table string, columns []string, records [][]string) error {
+	defs := make([]string, len(columns))
+	for i, column := range columns {
+		defs[i] = quoteIdent(column) + " " + inferCSVColumnType(records, i)
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (%s);", quoteIdent(table), strings.Join(defs, ", ")))
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s VALUES (%s);", quoteIdent(table), placeholders))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	values := make([]any, len(columns))
+	for _, record := range records {
+		for i := range columns {
+			if i < len(record) && record[i] != "" {
+				values[i] = record[i]
+			} else {
+				values[i] = nil
+			}
+		}
+		if _, err = stmt.ExecContext(ctx, values...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// inferCSVColumnType returns INTEGER or REAL when all non empty values of the
+// column are numbers. SQLite then stores the text values as numbers.
+func inferCSVColumnType(records [][]string, column int) string {
+	colType := "INTEGER"
+	seen := false
+	for _, record := range records {
+		if column >= len(record) || record[column] == "" {
+			continue
+		}
+		seen = true
+		value := strings.TrimSpace(record[column])
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			continue
+		}
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			colType = "REAL"
+			continue
+		}
+		return "TEXT"
+	}
+	if !seen {
+		return "TEXT"
+	}
+	return colType
+}
+
+var identCleanRe = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// CSVTableName is the name of the table a CSV file is loaded into.
+func CSVTableName(path string) string {
+	name := strings.Trim(identCleanRe.ReplaceAllString(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), "_"), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "t_" + name
+	}
+	return strings.ToLower(name)
+}
+
+func csvColumnNames(header []string) []string {
+	columns := make([]string, len(header))
+	seen := make(map[string]int)
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			name = fmt.Sprintf("column%d", i+1)
+		}
+		lower := strings.ToLower(name)
+		seen[lower]++
+		if seen[lower] > 1 {
+			name = fmt.Sprintf("%s_%d", name, seen[lower])
+		}
+		columns[i] = name
+	}
+	return columns
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,388 @@
+package mcptools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Limits of the workspace file tools.
+const (
+	fsMaxReadBytes   = 256 << 10
+	fsMaxWriteBytes  = 1 << 20
+	fsMaxSearchBytes = 1 << 20 // bigger files are skipped by fs_search
+	fsMaxListEntries = 500
+	fsMaxMatches     = 100
+)
+
+// defaultWorkspaceDir is the workspace of the profile, see UseWorkspaceDir.
+var defaultWorkspaceDir string
+
+var (
+	errPathEscapes = errors.New("path escapes the workspace")
+	errSymlink     = errors.New("symlinks are not allowed in the workspace")
+	errNoWorkspace = errors.New("workspace directory is not set")
+)
+
+// UseWorkspaceDir sets the workspace used when AS_AGENT_WORKSPACE_DIR is not
+// set, the app uses the workspace in the data directory of the profile.
+func UseWorkspaceDir(dir string) {
+	defaultWorkspaceDir = dir
+}
+
+// WorkspaceDir is the only directory the builtin file tools can access. It is
+// configured with AS_AGENT_WORKSPACE_DIR.
+func WorkspaceDir() (string, error) {
+	if dir := os.Getenv("AS_AGENT_WORKSPACE_DIR"); dir != "" {
+		return dir, nil
+	}
+	if defaultWorkspaceDir == "" {
+		return "", errNoWorkspace
+	}
+	return defaultWorkspaceDir, nil
+}
+
+func openWorkspace() (*os.Root, error) {
+	dir, err := WorkspaceDir()
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return os.OpenRoot(dir)
+}
+
+// workspacePath converts a path given by the model into a path relative to the
+// workspace root. Leading slash means the workspace root, ".." may not be used
+// to leave it and no existing component of the path may be a symlink.
+func workspacePath(root *os.Root, path string) (string, error) {
+	path = strings.TrimLeft(filepath.ToSlash(strings.TrimSpace(path)), "/")
+	if path == "" {
+		return ".", nil
+	}
+	path = filepath.FromSlash(path)
+	if !filepath.IsLocal(path) {
+		return "", errPathEscapes
+	}
+	path = filepath.Clean(path)
+
+	current := ""
+	for _, part := range strings.Split(path, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := root.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", errSymlink
+		}
+	}
+	return path, nil
+}
+
+func mkdirAllInRoot(root *os.Root, dir string) error {
+	current := ""
+	for _, part := range strings.Split(dir, string(filepath.Separator)) {
+		if part == "." || part == "" {
+			continue
+		}
+		current = filepath.Join(current, part)
+		err := root.Mkdir(current, 0o755)
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+var fsTools = []*Tool{
+	{
+		Name:           "fs_read",
+		Description:    "Read a text file from the workspace directory. Paths are relative to the workspace root.",
+		RequiredParams: []string{"path"},
+		Params: []*ToolParam{
+			{Name: "path", Type: "string", Description: "Path of the file to read."},
+		},
+	},
+	{
+		Name:             "fs_write",
+		Description:      "Write a text file in the workspace directory, creating parent directories as needed. Overwrites the file unless append is true. Paths are relative to the workspace root.",
+		RequiredParams:   []string{"path", "content"},
+		RequiresApproval: true,
+		Params: []*ToolParam{
+			{Name: "path", Type: "string", Description: "Path of the file to write."},
+			{Name: "content", Type: "string", Description: "Text content to write."},
+			{Name: "append", Type: "boolean", Description: "Append content to the end of the file instead of overwriting it."},
+		},
+	},
+	{
+		Name:           "fs_list",
+		Description:    "List files and directories in the workspace directory. Directories are listed with trailing slash. Paths are relative to the workspace root.",
+		RequiredParams: []string{},
+		Params: []*ToolParam{
+			{Name: "path", Type: "string", Description: "Directory to list, workspace root by default."},
+			{Name: "recursive", Type: "boolean", Description: "List all nested directories as well."},
+		},
+	},
+	{
+		Name:           "fs_search",
+		Description:    "Search text in files of the workspace directory. Returns matching lines as path:line: text. Paths are relative to the workspace root.",
+		RequiredParams: []string{"query"},
+		Params: []*ToolParam{
+			{Name: "query", Type: "string", Description: "Text to search for."},
+			{Name: "regex", Type: "boolean", Description: "Treat query as a regular expression."},
+			{Name: "path", Type: "string", Description: "Directory to search in, workspace root by default."},
+			{Name: "glob", Type: "string", Description: "Only search files whose name matches this pattern, e.g. *.go"},
+		},
+	},
+	{
+		Name:             "fs_patch",
+		Description:      "Edit a text file in the workspace directory by replacing exact text. oldText must match exactly once unless replaceAll is true. Paths are relative to the workspace root.",
+		RequiredParams:   []string{"path", "oldText", "newText"},
+		RequiresApproval: true,
+		Params: []*ToolParam{
+			{Name: "path", Type: "string", Description: "Path of the file to edit."},
+			{Name: "oldText", Type: "string", Description: "Exact text to be replaced."},
+			{Name: "newText", Type: "string", Description: "Replacement text."},
+			{Name: "replaceAll", Type: "boolean", Description: "Replace every occurrence of oldText."},
+		},
+	},
+}
+
+func init() {
+	for _, tool := range fsTools {
+		RegisterBuiltinTool(NewBuiltinTool(tool, func(ctx context.Context, params map[string]any) (string, error) {
+			return RunFSTool(ctx, &ToolCallRequest{Name: tool.Name, Params: params})
+		}))
+	}
+}
+
+// RunFSTool executes one of the workspace file tools.
+func RunFSTool(ctx context.Context, callRequest *ToolCallRequest) (string, error) {
+	root, err := openWorkspace()
+	if err != nil {
+		return "", err
+	}
+	defer root.Close()
+
+	path, err := workspacePath(root, stringParam(callRequest.Params, "path"))
+	if err != nil {
+		return "", err
+	}
+
+	switch callRequest.Name {
+	case "fs_read":
+		return fsRead(root, path)
+	case "fs_write":
+		return fsWrite(root, path, stringParam(callRequest.Params, "content"), boolParam(callRequest.Params, "append"))
+	case "fs_list":
+		return fsList(ctx, root, path, boolParam(callRequest.Params, "recursive"))
+	case "fs_search":
+		return fsSearch(ctx, root, path, callRequest.Params)
+	case "fs_patch":
+		return fsPatch(root, path, callRequest.Params)
+	}
+	return "", errors.New("unknown file tool: " + callRequest.Name)
+}
+
+func fsRead(root *os.Root, path string) (string, error) {
+	f, err := root.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, fsMaxReadBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > fsMaxReadBytes {
+		return string(data[:fsMaxReadBytes]) + fmt.Sprintf("\n[truncated: file is larger than %d bytes]", fsMaxReadBytes), nil
+	}
+	return string(data), nil
+}
+
+func fsWrite(root *os.Root, path string, content string, appendMode bool) (string, error) {
+	if path == "." {
+		return "", errors.New("path of the file is required")
+	}
+	if len(content) > fsMaxWriteBytes {
+		return "", fmt.Errorf("content is larger than %d bytes", fsMaxWriteBytes)
+	}
+	if err := mkdirAllInRoot(root, filepath.Dir(path)); err != nil {
+		return "", err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendMode {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		if info, err := root.Stat(path); err == nil && info.Size()+int64(len(content)) > fsMaxWriteBytes {
+			return "", fmt.Errorf("file would grow larger than %d bytes", fsMaxWriteBytes)
+		}
+	}
+	f, err := root.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err = f.WriteString(content); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Wrote %d bytes to %s", len(content), filepath.ToSlash(path)), nil
+}
+
+func fsList(ctx context.Context, root *os.Root, path string, recursive bool) (string, error) {
+	var sb strings.Builder
+	count := 0
+	err := fs.WalkDir(root.FS(), filepath.ToSlash(path), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if p == filepath.ToSlash(path) {
+			if !d.IsDir() {
+				return errors.New("not a directory: " + p)
+			}
+			return nil
+		}
+		if count >= fsMaxListEntries {
+			sb.WriteString(fmt.Sprintf("[truncated: more than %d entries]\n", fsMaxListEntries))
+			return fs.SkipAll
+		}
+		count++
+
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			sb.WriteString(p + " (symlink, not accessible)\n")
+		case d.IsDir():
+			sb.WriteString(p + "/\n")
+			if !recursive {
+				return fs.SkipDir
+			}
+		default:
+			size := int64(0)
+			if info, err := d.Info(); err == nil {
+				size = info.Size()
+			}
+			sb.WriteString(fmt.Sprintf("%s (%d bytes)\n", p, size))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "Directory is empty", nil
+	}
+	return sb.String(), nil
+}
+
+func fsSearch(ctx context.Context, root *os.Root, path string, params map[string]any) (string, error) {
+	query := stringParam(params, "query")
+	if query == "" {
+		return "", errors.New("query is required")
+	}
+	pattern := regexp.QuoteMeta(query)
+	if boolParam(params, "regex") {
+		pattern = query
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	glob := stringParam(params, "glob")
+
+	var sb strings.Builder
+	matches := 0
+	err = fs.WalkDir(root.FS(), filepath.ToSlash(path), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		if glob != "" {
+			if ok, _ := filepath.Match(glob, d.Name()); !ok {
+				return nil
+			}
+		}
+		if info, err := d.Info(); err != nil || info.Size() > fsMaxSearchBytes {
+			return nil
+		}
+
+		data, err := fs.ReadFile(root.FS(), p)
+		if err != nil {
+			return nil
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			if re.MatchString(line) {
+				if matches >= fsMaxMatches {
+					sb.WriteString(fmt.Sprintf("[truncated: more than %d matches]\n", fsMaxMatches))
+					return fs.SkipAll
+				}
+				matches++
+				sb.WriteString(fmt.Sprintf("%s:%d: %s\n", p, i+1, strings.TrimSpace(line)))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if matches == 0 {
+		return "No matches found", nil
+	}
+	return sb.String(), nil
+}
+
+func fsPatch(root *os.Root, path string, params map[string]any) (string, error) {
+	oldText := stringParam(params, "oldText")
+	newText := stringParam(params, "newText")
+	if oldText == "" {
+		return "", errors.New("oldText is required")
+	}
+
+	f, err := root.Open(path)
+	if err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, fsMaxWriteBytes+1))
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	if len(data) > fsMaxWriteBytes {
+		return "", fmt.Errorf("file is larger than %d bytes", fsMaxWriteBytes)
+	}
+
+	content := string(data)
+	count := strings.Count(content, oldText)
+	if count == 0 {
+		return "", errors.New("oldText not found in file")
+	}
+	if count > 1 && !boolParam(params, "replaceAll") {
+		return "", fmt.Errorf("oldText found %d times, make it unique or set replaceAll", count)
+	}
+
+	content = strings.ReplaceAll(content, oldText, newText)
+	if _, err = fsWrite(root, path, content, false); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Replaced %d occurrence(s) in %s", count, filepath.ToSlash(path)), nil
+}
@@ -0,0 +1,116 @@
+package mcptools
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func useTestWorkspace(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("AS_AGENT_WORKSPACE_DIR", dir)
+	return dir
+}
+
+func runFSTool(name string, params map[string]any) (string, error) {
+	return RunFSTool(context.Background(), &ToolCallRequest{Name: name, Params: params})
+}
+
+func TestFSTools_WriteReadPatch(t *testing.T) {
+	useTestWorkspace(t)
+
+	if _, err := runFSTool("fs_write", map[string]any{"path": "notes/todo.txt", "content": "buy milk\nbuy bread\n"}); err != nil {
+		t.Fatalf("fs_write failed: %v", err)
+	}
+	if _, err := runFSTool("fs_patch", map[string]any{"path": "/notes/todo.txt", "oldText": "milk", "newText": "tea"}); err != nil {
+		t.Fatalf("fs_patch failed: %v", err)
+	}
+	if _, err := runFSTool("fs_patch", map[string]any{"path": "notes/todo.txt", "oldText": "buy", "newText": "get"}); err == nil {
+		t.Error("expected ambiguous patch to fail")
+	}
+
+	out, err := runFSTool("fs_read", map[string]any{"path": "notes/todo.txt"})
+	if err != nil || out != "buy tea\nbuy bread\n" {
+		t.Fatalf("fs_read = %q, %v", out, err)
+	}
+
+	out, err = runFSTool("fs_search", map[string]any{"query": "bread"})
+	if err != nil || !strings.Contains(out, "notes/todo.txt:2: buy bread") {
+		t.Errorf("fs_search = %q, %v", out, err)
+	}
+
+	out, err = runFSTool("fs_list", map[string]any{"recursive": true})
+	if err != nil || !strings.Contains(out, "notes/\n") || !strings.Contains(out, "notes/todo.txt") {
+		t.Errorf("fs_list = %q, %v", out, err)
+	}
+}
+
+func TestFSTools_TraversalIsBlocked(t *testing.T) {
+	dir := useTestWorkspace(t)
+	outside := filepath.Join(filepath.Dir(dir), "outside.txt")
+
+	for _, path := range []string{"../outside.txt", "a/../../outside.txt"} {
+		if _, err := runFSTool("fs_write", map[string]any{"path": path, "content": "x"}); err == nil {
+			t.Errorf("write to %q must fail", path)
+		}
+	}
+	if _, err := os.Stat(outside); err == nil {
+		t.Fatal("file was written outside of the workspace")
+	}
+}
+
+func TestFSTools_SymlinksAreBlocked(t *testing.T) {
+	dir := useTestWorkspace(t)
+	secret := filepath.Join(t.TempDir(), "secret.txt")
+	os.WriteFile(secret, []byte("secret"), 0o644)
+	if err := os.Symlink(filepath.Dir(secret), filepath.Join(dir, "link")); err != nil {
+		t.Skip("symlinks are not supported: ", err)
+	}
+
+	if out, err := runFSTool("fs_read", map[string]any{"path": "link/secret.txt"}); err == nil {
+		t.Fatalf("read through symlink must fail, got %q", out)
+	}
+	if _, err := runFSTool("fs_write", map[string]any{"path": "link/new.txt", "content": "x"}); err == nil {
+		t.Fatal("write through symlink must fail")
+	}
+}
+
+func TestFSTools_SizeLimits(t *testing.T) {
+	useTestWorkspace(t)
+
+	big := strings.Repeat("a", fsMaxWriteBytes+1)
+	if _, err := runFSTool("fs_write", map[string]any{"path": "big.txt", "content": big}); err == nil {
+		t.Error("oversized write must fail")
+	}
+
+	runFSTool("fs_write", map[string]any{"path": "big.txt", "content": big[:fsMaxReadBytes+10]})
+	out, err := runFSTool("fs_read", map[string]any{"path": "big.txt"})
+	if err != nil || !strings.Contains(out, "[truncated") {
+		t.Errorf("expected truncated read, got err %v", err)
+	}
+}
+
+func TestWorkspaceDir(t *testing.T) {
+	t.Setenv("AS_AGENT_WORKSPACE_DIR", "")
+	saved := defaultWorkspaceDir
+	t.Cleanup(func() { UseWorkspaceDir(saved) })
+
+	UseWorkspaceDir("")
+	if _, err := runFSTool("fs_list", map[string]any{}); !errors.Is(err, errNoWorkspace) {
+		t.Errorf("expected errNoWorkspace, got %v", err)
+	}
+
+	dir := t.TempDir()
+	UseWorkspaceDir(dir)
+	if _, err := runFSTool("fs_write", map[string]any{"path": "a.txt", "content": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); err != nil {
+		t.Errorf("file must be written to the workspace of the profile: %v", err)
+	}
+}
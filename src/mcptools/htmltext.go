@@ -0,0 +1,194 @@
+package mcptools
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// htmlToText converts HTML page to markdown-like plain text that is much
+// cheaper for the model than the raw markup. Scripts, styles and other non
+// content elements are dropped.
+func htmlToText(r io.Reader) (string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", err
+	}
+	conv := &htmlConverter{}
+	conv.walk(doc)
+	return conv.result(), nil
+}
+
+type htmlConverter struct {
+	sb  strings.Builder
+	pre int
+}
+
+var htmlSkipTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true,
+	"svg": true, "iframe": true, "head": true, "nav": true, "form": true,
+}
+
+var htmlBlockTags = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true,
+	"header": true, "footer": true, "aside": true, "table": true, "tr": true,
+	"ul": true, "ol": true, "blockquote": true, "figure": true, "dl": true,
+}
+
+func (self *htmlConverter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		self.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			self.walk(c)
+		}
+		return
+	}
+
+	tag := n.Data
+	if htmlSkipTags[tag] {
+		return
+	}
+
+	switch tag {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		self.newBlock()
+		self.sb.WriteString(strings.Repeat("#", int(tag[1]-'0')) + " ")
+		self.children(n)
+		self.newBlock()
+	case "br":
+		self.sb.WriteString("\n")
+	case "hr":
+		self.newBlock()
+		self.sb.WriteString("---")
+		self.newBlock()
+	case "li":
+		self.newLine()
+		self.sb.WriteString("- ")
+		self.children(n)
+		self.newLine()
+	case "td", "th":
+		self.children(n)
+		self.sb.WriteString(" | ")
+	case "pre":
+		self.newBlock()
+		self.sb.WriteString("```\n")
+		self.pre++
+		self.children(n)
+		self.pre--
+		self.newLine()
+		self.sb.WriteString("```")
+		self.newBlock()
+	case "code":
+		if self.pre > 0 {
+			self.children(n)
+		} else {
+			self.sb.WriteString("`")
+			self.children(n)
+			self.sb.WriteString("`")
+		}
+	case "a":
+		href := htmlAttr(n, "href")
+		if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "javascript:") {
+			self.children(n)
+			return
+		}
+		self.sb.WriteString("[")
+		self.children(n)
+		self.sb.WriteString("](" + href + ")")
+	case "img":
+		if alt := htmlAttr(n, "alt"); alt != "" {
+			self.sb.WriteString("[image: " + alt + "]")
+		}
+	default:
+		if htmlBlockTags[tag] {
+			self.newBlock()
+			self.children(n)
+			self.newBlock()
+		} else {
+			self.children(n)
+		}
+	}
+}
+
+func (self *htmlConverter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		self.walk(c)
+	}
+}
+
+func (self *htmlConverter) text(data string) {
+	if self.pre > 0 {
+		self.sb.WriteString(data)
+		return
+	}
+	words := strings.Fields(data)
+	if len(words) == 0 {
+		if data != "" && !self.endsWithSpace() {
+			self.sb.WriteString(" ")
+		}
+		return
+	}
+	if isSpace(data[0]) && !self.endsWithSpace() {
+		self.sb.WriteString(" ")
+	}
+	self.sb.WriteString(strings.Join(words, " "))
+	if isSpace(data[len(data)-1]) {
+		self.sb.WriteString(" ")
+	}
+}
+
+func (self *htmlConverter) endsWithSpace() bool {
+	s := self.sb.String()
+	return s == "" || isSpace(s[len(s)-1])
+}
+
+func (self *htmlConverter) newLine() {
+	if !strings.HasSuffix(self.sb.String(), "\n") && self.sb.Len() > 0 {
+		self.sb.WriteString("\n")
+	}
+}
+
+func (self *htmlConverter) newBlock() {
+	self.newLine()
+	if !strings.HasSuffix(self.sb.String(), "\n\n") && self.sb.Len() > 0 {
+		self.sb.WriteString("\n")
+	}
+}
+
+// result trims trailing spaces of every line and collapses blank lines.
+func (self *htmlConverter) result() string {
+	lines := strings.Split(self.sb.String(), "\n")
+	res := make([]string, 0, len(lines))
+	blank := false
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if strings.TrimSpace(line) == "" {
+			if !blank && len(res) > 0 {
+				res = append(res, "")
+			}
+			blank = true
+			continue
+		}
+		blank = false
+		res = append(res, line)
+	}
+	return strings.TrimSpace(strings.Join(res, "\n"))
+}
+
+func htmlAttr(n *html.Node, name string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\t' || b == '\r'
+}
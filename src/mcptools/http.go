@@ -0,0 +1,279 @@
+package mcptools
+
+import (
+	"agentsmith/src/logger"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits of http_fetch.
+const (
+	fetchTimeout      = 20 * time.Second
+	fetchMaxBodyBytes = 2 << 20   // response bytes read from the server
+	fetchMaxTextBytes = 100 << 10 // text returned to the model
+	fetchMaxRedirects = 5
+)
+
+// fetchAllowAll in the allowlist allows any public host.
+const fetchAllowAll = "*"
+
+var httpFetchTool = &Tool{
+	Name:           "http_fetch",
+	Description:    "Fetch a web page or API endpoint over HTTP(S). HTML pages are converted to readable text with markdown-like formatting. Only allowed domains can be accessed.",
+	RequiredParams: []string{"url"},
+	Params: []*ToolParam{
+		{Name: "url", Type: "string", Description: "Absolute http or https URL."},
+		{Name: "method", Type: "string", Description: "GET (default) or POST."},
+		{Name: "body", Type: "string", Description: "Request body for POST."},
+		{Name: "contentType", Type: "string", Description: "Content type of the POST body, application/json by default."},
+	},
+}
+
+func init() {
+	RegisterBuiltinTool(NewBuiltinTool(httpFetchTool, func(ctx context.Context, params map[string]any) (string, error) {
+		return HTTPFetch(ctx, params)
+	}))
+}
+
+// FetchAllowlist returns domains http_fetch may access, configured as comma
+// separated AS_AGENT_FETCH_ALLOWLIST. A domain also allows its subdomains.
+// Empty allowlist denies every host, fetchAllowAll allows any public host.
+// Hosts resolving to private, loopback or link-local addresses are allowed
+// only when listed explicitly.
+func FetchAllowlist() []string {
+	res := make([]string, 0, 8)
+	for _, domain := range strings.Split(os.Getenv("AS_AGENT_FETCH_ALLOWLIST"), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			res = append(res, domain)
+		}
+	}
+	return res
+}
+
+func domainAllowed(host string, allowlist []string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range allowlist {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// checkFetchURL validates URL against the allowlist before any connection is
+// made. Addresses are checked once more on dial, see newFetchClient.
+func checkFetchURL(u *url.URL, allowlist []string) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("only http and https URLs are supported")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("URL has no host")
+	}
+	if !slices.Contains(allowlist, fetchAllowAll) && !domainAllowed(host, allowlist) {
+		return fmt.Errorf("domain %s is not in the fetch allowlist", host)
+	}
+	return nil
+}
+
+// newFetchClient creates client that refuses to connect to non public
+// addresses of hosts that are not explicitly allowlisted. The check is done
+// on the resolved address, so DNS names pointing to internal hosts and
+// redirects to them are blocked too.
+func newFetchClient(allowlist []string) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	transport := &http.Transport{
+		Proxy:               nil,
+		TLSHandshakeTimeout: 10 * time.Second,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			if domainAllowed(host, allowlist) {
+				return dialer.DialContext(ctx, network, addr)
+			}
+			d := *dialer
+			d.Control = func(network, address string, _ syscall.RawConn) error {
+				ipStr, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(ipStr); ip == nil || !isPublicIP(ip) {
+					return fmt.Errorf("access to non public address %s of %s is not allowed", ipStr, host)
+				}
+				return nil
+			}
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   fetchTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= fetchMaxRedirects {
+				return errors.New("too many redirects")
+			}
+			return checkFetchURL(req.URL, allowlist)
+		},
+	}
+}
+
+// HTTPFetch executes http_fetch call and records it in the fetch audit of the
+// session carried by ctx.
+func HTTPFetch(ctx context.Context, params map[string]any) (result string, err error) {
+	rawURL := strings.TrimSpace(stringParam(params, "url"))
+	method := strings.ToUpper(strings.TrimSpace(stringParam(params, "method")))
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	entry := &FetchAuditEntry{SessionID: SessionID(ctx), Date: time.Now(), Method: method, URL: rawURL}
+	defer func() {
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		entry.save()
+	}()
+
+	if method != http.MethodGet && method != http.MethodPost {
+		return "", errors.New("only GET and POST methods are supported")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	allowlist := FetchAllowlist()
+	if err = checkFetchURL(u, allowlist); err != nil {
+		return "", err
+	}
+
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(stringParam(params, "body"))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "AgentSmith/1.0")
+	req.Header.Set("Accept", "text/html,application/json,text/plain;q=0.9,*/*;q=0.5")
+	if method == http.MethodPost {
+		contentType := stringParam(params, "contentType")
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := newFetchClient(allowlist).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	entry.Status = resp.StatusCode
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, fetchMaxBodyBytes+1))
+	if err != nil {
+		return "", err
+	}
+	truncated := len(data) > fetchMaxBodyBytes
+	if truncated {
+		data = data[:fetchMaxBodyBytes]
+	}
+	entry.Bytes = len(data)
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	text := string(data)
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		text, err = htmlToText(strings.NewReader(text))
+		if err != nil {
+			return "", err
+		}
+	case strings.HasPrefix(mediaType, "text/") || strings.Contains(mediaType, "json") || strings.Contains(mediaType, "xml") || mediaType == "":
+	default:
+		text = fmt.Sprintf("[binary content of %d bytes is not shown]", len(data))
+	}
+	if len(text) > fetchMaxTextBytes {
+		// cut before the character the limit falls in, not in the middle of it
+		cut := fetchMaxTextBytes
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+		truncated = true
+	}
+	if truncated {
+		text += "\n[truncated]"
+	}
+
+	return fmt.Sprintf("Status: %s\nURL: %s\nContent-Type: %s\n\n%s", resp.Status, resp.Request.URL, contentType, text), nil
+}
+
+// FetchAuditEntry is a record of one http_fetch call.
+type FetchAuditEntry struct {
+	SessionID string    `json:"sessionId"`
+	Date      time.Time `json:"date"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func (self *FetchAuditEntry) save() {
+	defer logger.BreakOnError()
+
+	db := storage.DB()
+
+	query := `
+	INSERT INTO fetch_audit (session_id, date, method, url, status, bytes, error)
+	VALUES (?, ?, ?, ?, ?, ?, ?);
+	`
+	_, err := db.Exec(query, self.SessionID, self.Date, self.Method, self.URL, self.Status, self.Bytes, self.Error)
+	log.CheckW(err, "Failed to save fetch audit entry")
+}
+
+// GetFetchAudit returns URLs fetched by http_fetch in the session, oldest
+// first.
+func GetFetchAudit(sessionID string) (res []FetchAuditEntry, err error) {
+	defer logger.BreakOnError()
+	res = make([]FetchAuditEntry, 0, 16)
+
+	db := storage.DB()
+
+	query := "SELECT session_id, date, method, url, status, bytes, error FROM fetch_audit WHERE session_id=? ORDER BY date;"
+	rows, err := db.Query(query, sessionID)
+	log.CheckE(err, nil, "Failed to select fetch audit from DB")
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry FetchAuditEntry
+		err = rows.Scan(&entry.SessionID, &entry.Date, &entry.Method, &entry.URL, &entry.Status, &entry.Bytes, &entry.Error)
+		if err != nil {
+			log.W("Failed to scan fetch audit row:", err)
+			continue
+		}
+		res = append(res, entry)
+	}
+	return res, nil
+}
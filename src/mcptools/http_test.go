@@ -0,0 +1,120 @@
+package mcptools
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+const testPage = `<html><head><title>T</title><script>alert(1)</script></head>
+<body><nav>menu</nav><h1>Hello</h1><p>Some <b>bold</b>
+text with <a href="https://example.com/x">link</a>.</p>
+<ul><li>one</li><li>two</li></ul><pre>a  b</pre></body></html>`
+
+func TestHTMLToText(t *testing.T) {
+	text, err := htmlToText(strings.NewReader(testPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Hello\n\nSome bold text with [link](https://example.com/x).\n\n- one\n- two\n\n```\na  b\n```"
+	if text != want {
+		t.Fatalf("htmlToText =\n%q\nwant\n%q", text, want)
+	}
+}
+
+func newFetchTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, testPage)
+		case "/echo":
+			w.Header().Set("Content-Type", "text/plain")
+			body := new(strings.Builder)
+			fmt.Fprint(body, r.Method, " ", r.Header.Get("Content-Type"), " ")
+			buf := make([]byte, 64)
+			n, _ := r.Body.Read(buf)
+			body.Write(buf[:n])
+			fmt.Fprint(w, body.String())
+		case "/big":
+			w.Header().Set("Content-Type", "text/plain")
+			// the limit falls in the middle of a two byte character
+			fmt.Fprint(w, "x"+strings.Repeat("é", fetchMaxTextBytes/2))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPFetch(t *testing.T) {
+	useTestDB(t)
+	server := newFetchTestServer(t)
+	t.Setenv("AS_AGENT_FETCH_ALLOWLIST", "127.0.0.1")
+	ctx := WithSessionID(context.Background(), "session-1")
+
+	out, err := HTTPFetch(ctx, map[string]any{"url": server.URL + "/page"})
+	if err != nil || !strings.Contains(out, "Status: 200 OK") || !strings.Contains(out, "# Hello") || strings.Contains(out, "alert") {
+		t.Fatalf("HTTPFetch page = %q, %v", out, err)
+	}
+
+	out, err = HTTPFetch(ctx, map[string]any{"url": server.URL + "/echo", "method": "post", "body": `{"a":1}`})
+	if err != nil || !strings.HasSuffix(out, `POST application/json {"a":1}`) {
+		t.Fatalf("HTTPFetch post = %q, %v", out, err)
+	}
+
+	out, err = HTTPFetch(ctx, map[string]any{"url": server.URL + "/big"})
+	if err != nil || !strings.HasSuffix(out, "[truncated]") || !utf8.ValidString(out) {
+		t.Fatalf("expected truncated valid UTF-8 output, got err %v", err)
+	}
+
+	audit, err := GetFetchAudit("session-1")
+	if err != nil || len(audit) != 3 || audit[1].Method != "POST" || audit[0].Status != 200 {
+		t.Fatalf("GetFetchAudit = %+v, %v", audit, err)
+	}
+}
+
+func TestHTTPFetch_Blocked(t *testing.T) {
+	useTestDB(t)
+	server := newFetchTestServer(t)
+	ctx := WithSessionID(context.Background(), "session-2")
+
+	// empty allowlist denies every host
+	if _, err := HTTPFetch(ctx, map[string]any{"url": server.URL + "/page"}); err == nil || !strings.Contains(err.Error(), "allowlist") {
+		t.Errorf("expected empty allowlist to block, got %v", err)
+	}
+
+	// loopback is not public and not allowlisted
+	t.Setenv("AS_AGENT_FETCH_ALLOWLIST", "*")
+	if _, err := HTTPFetch(ctx, map[string]any{"url": server.URL + "/page"}); err == nil || !strings.Contains(err.Error(), "non public address") {
+		t.Errorf("expected private address to be blocked, got %v", err)
+	}
+
+	t.Setenv("AS_AGENT_FETCH_ALLOWLIST", "example.com")
+	if _, err := HTTPFetch(ctx, map[string]any{"url": server.URL + "/page"}); err == nil || !strings.Contains(err.Error(), "allowlist") {
+		t.Errorf("expected domain outside of allowlist to be blocked, got %v", err)
+	}
+	if _, err := HTTPFetch(ctx, map[string]any{"url": "file:///etc/passwd"}); err == nil {
+		t.Error("expected non http scheme to be blocked")
+	}
+
+	// redirect to a host outside of allowlist
+	u, _ := url.Parse(server.URL)
+	t.Setenv("AS_AGENT_FETCH_ALLOWLIST", u.Hostname())
+	redirect := httptest.NewServer(http.RedirectHandler("http://localhost:1/", http.StatusFound))
+	defer redirect.Close()
+	if _, err := HTTPFetch(ctx, map[string]any{"url": redirect.URL}); err == nil || !strings.Contains(err.Error(), "allowlist") {
+		t.Errorf("expected redirect outside of allowlist to be blocked, got %v", err)
+	}
+
+	audit, _ := GetFetchAudit("session-2")
+	if len(audit) != 5 || audit[0].Error == "" {
+		t.Fatalf("blocked fetches must be audited with error, got %+v", audit)
+	}
+}
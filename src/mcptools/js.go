@@ -0,0 +1,203 @@
+package mcptools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dop251/goja"
+)
+
+// Limits of the sandbox js_code_runner executes code in, besides codeLimits.
+// They match the Lua sandbox, except that goja has no instruction hook, so
+// there is no instruction limit.
+const (
+	jsMaxOutputBytes = luaMaxOutputBytes
+	jsMaxStringBytes = luaMaxStringBytes
+	jsCallStackSize  = luaCallStackSize
+	jsMaxFillLength  = 1 << 22
+)
+
+var jsCodeRunnerTool = &Tool{
+	Name:           "js_code_runner",
+	Description:    "Execute JavaScript (ES2015+) code and get the result. Use this tool when you need to perform any math or calculations. Anything logged with console.log() will be accumulated and returned as output. Value of the last expression statement will be returned as result, so end the code with the expression you need, e.g. `const x = 2 ** 10; x`. Code runs in a sandbox: only standard JavaScript objects are available, no modules, network, file or process access, execution time and memory are limited.",
+	RequiredParams: []string{"code"},
+	Params: []*ToolParam{
+		{Name: "code", Type: "string", Description: "JavaScript code to be executed."},
+	},
+}
+
+func init() {
+	RegisterBuiltinTool(NewBuiltinTool(jsCodeRunnerTool, func(ctx context.Context, params map[string]any) (string, error) {
+		return RunJS(ctx, &ToolCallRequest{Name: jsCodeRunnerTool.Name, Params: params}), nil
+	}))
+}
+
+// RunJS executes code from the call request in a goja sandbox in a child
+// process, with the same contract as RunLua: console output is captured, the
+// completion value of the script is returned as result and errors are
+// reported in the result.
+func RunJS(ctx context.Context, callRequest *ToolCallRequest) string {
+	codeStr, ok := callRequest.Params["code"].(string)
+	if !ok || strings.TrimSpace(codeStr) == "" {
+		res := &CodeRunResult{Error: "missing code parameter"}
+		return res.String()
+	}
+	return runInSandbox(ctx, sandboxJS, codeStr).String()
+}
+
+// runJSSandbox runs the code in this process. It must only be called in the
+// child process, as it exits the process when the memory limit is exceeded.
+func runJSSandbox(code string, limits sandboxLimits) *CodeRunResult {
+	res := &CodeRunResult{}
+
+	vm := goja.New()
+	vm.SetMaxCallStackSize(jsCallStackSize)
+
+	var stdoutBuf bytes.Buffer
+	write := func(s string) {
+		if stdoutBuf.Len()+len(s) > jsMaxOutputBytes {
+			stdoutBuf.WriteString(s[:max(0, jsMaxOutputBytes-stdoutBuf.Len())])
+			res.Truncated = true
+			return
+		}
+		stdoutBuf.WriteString(s)
+	}
+	if err := setupJSSandbox(vm, write); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), limits.Timeout)
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	watchHeap(timeoutCtx, limits.MaxAllocBytes, func(err error) { vm.Interrupt(err) }, done)
+
+	value, err := vm.RunString(code)
+	res.Output = stdoutBuf.String()
+	if err != nil {
+		var interrupted *goja.InterruptedError
+		var exception *goja.Exception
+		var overflow *goja.StackOverflowError
+		switch {
+		case errors.As(err, &overflow):
+			res.Error = "RangeError: maximum call stack size exceeded"
+		case errors.As(err, &interrupted):
+			reason, ok := interrupted.Value().(error)
+			if !ok {
+				reason = fmt.Errorf("%v", interrupted.Value())
+			}
+			res.Error = sandboxStoppedPrefix + reason.Error()
+		case errors.As(err, &exception):
+			res.Error = exception.Error()
+		default:
+			res.Error = err.Error()
+		}
+		return res
+	}
+
+	// toString and toJSON of the value run here, they may throw as well
+	if exception := vm.Try(func() { res.Result = exportJSValue(value) }); exception != nil {
+		res.Error = exception.Error()
+	}
+	return res
+}
+
+// setupJSSandbox adds console and caps functions that can allocate huge
+// strings or arrays in a single call, which the memory watcher could not
+// interrupt.
+func setupJSSandbox(vm *goja.Runtime, write func(string)) error {
+	console := vm.NewObject()
+	logFn := func(call goja.FunctionCall) goja.Value {
+		parts := make([]string, len(call.Arguments))
+		for i, arg := range call.Arguments {
+			parts[i] = formatJSValue(arg)
+		}
+		write(strings.Join(parts, " ") + "\n")
+		return goja.Undefined()
+	}
+	for _, name := range []string{"log", "info", "warn", "error", "debug"} {
+		if err := console.Set(name, logFn); err != nil {
+			return err
+		}
+	}
+	if err := vm.Set("console", console); err != nil {
+		return err
+	}
+
+	stringProto := vm.Get("String").ToObject(vm).Get("prototype").ToObject(vm)
+	for _, name := range []string{"repeat", "padStart", "padEnd"} {
+		original, ok := goja.AssertFunction(stringProto.Get(name))
+		if !ok {
+			continue
+		}
+		err := stringProto.Set(name, func(call goja.FunctionCall) goja.Value {
+			size := call.Argument(0).ToFloat()
+			if name == "repeat" {
+				size *= float64(len(call.This.String()))
+			}
+			if size > jsMaxStringBytes {
+				panic(vm.NewTypeError("String.prototype." + name + " result is too large"))
+			}
+			res, err := original(call.This, call.Arguments...)
+			if err != nil {
+				panic(err)
+			}
+			return res
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	arrayProto := vm.Get("Array").ToObject(vm).Get("prototype").ToObject(vm)
+	fill, _ := goja.AssertFunction(arrayProto.Get("fill"))
+	err := arrayProto.Set("fill", func(call goja.FunctionCall) goja.Value {
+		if call.This.ToObject(vm).Get("length").ToFloat() > jsMaxFillLength {
+			panic(vm.NewTypeError("Array.prototype.fill array is too large"))
+		}
+		res, err := fill(call.This, call.Arguments...)
+		if err != nil {
+			panic(err)
+		}
+		return res
+	})
+	return err
+}
+
+// formatJSValue prints objects as JSON the way console.log shows them, and
+// other values as their string representation.
+func formatJSValue(value goja.Value) string {
+	if obj, ok := value.(*goja.Object); ok {
+		if _, isFn := goja.AssertFunction(obj); !isFn && obj.ClassName() != "Error" {
+			if data, err := json.Marshal(obj); err == nil {
+				return string(data)
+			}
+		}
+	}
+	return value.String()
+}
+
+// exportJSValue converts completion value of the script to a JSON compatible
+// Go value.
+func exportJSValue(value goja.Value) any {
+	if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+		return nil
+	}
+	if _, isFn := goja.AssertFunction(value); isFn {
+		return value.String()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value.String()
+	}
+	var res any
+	if err = json.Unmarshal(data, &res); err != nil {
+		return value.String()
+	}
+	return res
+}
@@ -0,0 +1,94 @@
+package mcptools
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runJSCode(t *testing.T, code string) CodeRunResult {
+	t.Helper()
+
+	raw := RunJS(context.Background(), &ToolCallRequest{Name: "js_code_runner", Params: map[string]any{"code": code}})
+	var res CodeRunResult
+	if err := json.Unmarshal([]byte(raw), &res); err != nil {
+		t.Fatalf("RunJS returned non JSON result %q: %v", raw, err)
+	}
+	return res
+}
+
+func TestRunJS_OutputAndResult(t *testing.T) {
+	res := runJSCode(t, `console.log("sum", 1 + 2, {a: [1]}); const x = 2 ** 10; ({x})`)
+
+	if res.Error != "" {
+		t.Fatalf("unexpected error: %s", res.Error)
+	}
+	if res.Output != "sum 3 {\"a\":[1]}\n" {
+		t.Errorf("Output = %q", res.Output)
+	}
+	result, ok := res.Result.(map[string]any)
+	if !ok || result["x"] != float64(1024) {
+		t.Errorf("Result = %#v, want map with x=1024", res.Result)
+	}
+}
+
+func TestRunJS_ErrorsAreReported(t *testing.T) {
+	for code, want := range map[string]string{
+		`return 1 +`:                "SyntaxError",
+		`throw new Error("boom")`:   "boom",
+		`function f() { f() }; f()`: "call stack",
+		`"x".repeat(1e9)`:           "too large",
+		`new Array(1e9).fill(0)`:    "too large",
+		`undefinedFunction()`:       "ReferenceError",
+		`require("fs")`:             "ReferenceError",
+	} {
+		if res := runJSCode(t, code); !strings.Contains(res.Error, want) {
+			t.Errorf("%s: error = %q, want %q", code, res.Error, want)
+		}
+	}
+}
+
+func TestRunJS_InfiniteLoopIsStopped(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	raw := RunJS(ctx, &ToolCallRequest{Name: "js_code_runner", Params: map[string]any{"code": `while (true) {}`}})
+	if !strings.Contains(raw, "execution stopped") {
+		t.Fatalf("expected execution to be stopped, got %s", raw)
+	}
+}
+
+func TestRunJS_MemoryLimit(t *testing.T) {
+	// a low limit is hit long before the timeout, also with the race detector
+	withCodeLimits(t, sandboxLimits{Timeout: codeLimits.Timeout, MaxAllocBytes: 16 << 20})
+
+	res := runJSCode(t, `const a = []; while (true) a.push(new Array(1000).fill("x"))`)
+	if !strings.Contains(res.Error, "memory limit exceeded") {
+		t.Fatalf("expected memory limit error, got %+v", res)
+	}
+}
+
+func TestRunJS_OutputIsCapped(t *testing.T) {
+	res := runJSCode(t, `for (let i = 0; i < 100000; i++) console.log("0123456789")`)
+	if !res.Truncated || len(res.Output) != jsMaxOutputBytes {
+		t.Fatalf("expected output capped at %d bytes, got %d (truncated %v)", jsMaxOutputBytes, len(res.Output), res.Truncated)
+	}
+}
+
+func TestRunJS_NativeCallsAreStopped(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// a single native call that goja can't interrupt, it ran for a minute
+	start := time.Now()
+	raw := RunJS(ctx, &ToolCallRequest{Name: "js_code_runner", Params: map[string]any{"code": `new Array(3e8).join("x")`}})
+	if !strings.Contains(raw, "execution stopped") || time.Since(start) > sandboxKillDelay {
+		t.Fatalf("expected execution to be stopped in time, got %s after %v", raw, time.Since(start))
+	}
+
+	if res := runJSCode(t, `new Float64Array(4e8).fill(1).length`); !strings.Contains(res.Error, "memory limit exceeded") {
+		t.Fatalf("expected memory limit error, got %+v", res)
+	}
+}
@@ -3,12 +3,11 @@ package mcptools
 
 import (
 	"agentsmith/src/logger"
+	"agentsmith/src/store"
 	"agentsmith/src/util"
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
-	"os"
 	"strings"
 	"time"
 
@@ -21,6 +20,14 @@ import (
 
 var log = logger.Logger("tools", 1, 1, 1)
 
+// storage keeps MCP servers, datasets and settings of the tools, see UseStore
+var storage store.Store
+
+// UseStore sets the store the tools save their data in.
+func UseStore(s store.Store) {
+	storage = s
+}
+
 type MCPTransport string
 
 const (
@@ -58,33 +65,15 @@ func NewMCP(id string, name string, transport MCPTransport, url string, command
 }
 
 func LoadMCPServers(updateCb MCPUpdateCb) []*MCPServer {
-	log.D("Loading MCP servers from", os.Getenv("AS_AGENT_DB_FILE"))
+	log.D("Loading MCP servers")
 	defer logger.BreakOnError()
 	mcpServers := make([]*MCPServer, 0, 8)
 
-	// Open a connection to the SQLite database
-	db, err := sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	log.CheckE(err, nil, "Failed to open MCP server db")
-	defer db.Close()
-
-	query := "SELECT id, name, transport, url, command, active FROM mcp;"
-	rows, err := db.Query(query)
+	records, err := storage.MCPServers()
 	log.CheckE(err, nil, "Failed to select MCP servers from DB")
-	defer rows.Close()
-
-	for rows.Next() {
-		var url, command sql.NullString
-		var id, name, transport string
-		var active bool
 
-		err = rows.Scan(&id, &name, &transport, &url, &command, &active)
-		if err != nil {
-			log.W("Failed to scan MCP server row:", err)
-			continue
-		}
-
-		mcpServer := NewMCP(id, name, MCPTransport(transport), url.String, command.String, active)
-		mcpServer.Active = active // Set the Active field
+	for _, record := range records {
+		mcpServer := NewMCP(record.ID, record.Name, MCPTransport(record.Transport), record.URL, record.Command, record.Active)
 		mcpServers = append(mcpServers, mcpServer)
 
 		go func() {
@@ -101,27 +90,17 @@ func LoadMCPServers(updateCb MCPUpdateCb) []*MCPServer {
 }
 
 func (self *MCPServer) Save() (err error) {
-	log.D("Saving MCP server to ", os.Getenv("AS_AGENT_DB_FILE"))
+	log.D("Saving MCP server", self.ID)
 	defer logger.BreakOnError()
 
-	var db *sql.DB
-	db, err = sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	log.CheckE(err, nil, "Failed to open DB")
-	defer db.Close()
-
-	// Use INSERT OR REPLACE (UPSERT) to handle both new and existing MCP servers
-	query := `
-	INSERT INTO mcp (id, name, transport, url, command, active)
-	VALUES (?, ?, ?, ?, ?, ?)
-	ON CONFLICT(id) DO UPDATE SET
-		name=excluded.name,
-		transport=excluded.transport,
-		url=excluded.url,
-		command=excluded.command,
-		active=excluded.active;
-	`
-
-	_, err = db.Exec(query, self.ID, self.Name, self.Transport, self.URL, self.Command, self.Active)
+	err = storage.SaveMCPServer(&store.MCPServer{
+		ID:        self.ID,
+		Name:      self.Name,
+		Transport: string(self.Transport),
+		URL:       self.URL,
+		Command:   self.Command,
+		Active:    self.Active,
+	})
 	log.CheckW(err, "Failed to update MCP server DB")
 
 	log.D("Saved MCP server", self.ID)
@@ -129,21 +108,17 @@ func (self *MCPServer) Save() (err error) {
 }
 
 func (self *MCPServer) Delete() {
-	log.D("Deleting MCP server from ", os.Getenv("AS_AGENT_DB_FILE"))
+	log.D("Deleting MCP server", self.ID)
 	defer logger.BreakOnError()
 
-	db, err := sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	log.CheckE(err, nil, "Failed to open DB")
-	defer db.Close()
-
-	query := "DELETE FROM mcp WHERE id=?"
-	db.Exec(query, self.ID)
+	err := storage.DeleteMCPServer(self.ID)
+	log.CheckW(err, "Failed to delete MCP server from DB")
 }
 
-func (self *MCPServer) connect() (ctx context.Context, cancel context.CancelFunc, c *client.Client, err error) {
+func (self *MCPServer) connect(parent context.Context) (ctx context.Context, cancel context.CancelFunc, c *client.Client, err error) {
 	defer logger.BreakOnError()
 
-	ctx, cancel = context.WithCancel(context.Background())
+	ctx, cancel = context.WithCancel(parent)
 	if self.Transport == MCPTransportSSE {
 		var sseTransport *transport.SSE
 		sseTransport, err = transport.NewSSE(self.URL)
@@ -185,7 +160,7 @@ func (self *MCPServer) connect() (ctx context.Context, cancel context.CancelFunc
 		Version: "1.0.0",
 	}
 
-	initDoneCh := make(chan bool)
+	initDoneCh := make(chan bool, 1)
 	go func() {
 		_, err = c.Initialize(ctx, initRequest)
 		log.CheckW(err, "Failed to initialize MCP request")
@@ -203,6 +178,10 @@ func (self *MCPServer) connect() (ctx context.Context, cancel context.CancelFunc
 		err = errors.New("MCP init timeout")
 		c.Close()
 		cancel()
+	case <-ctx.Done():
+		err = ctx.Err()
+		c.Close()
+		cancel()
 	}
 	return
 }
@@ -210,7 +189,7 @@ func (self *MCPServer) connect() (ctx context.Context, cancel context.CancelFunc
 func (self *MCPServer) LoadTools() (err error) {
 	defer logger.BreakOnError()
 
-	ctx, cancel, c, err := self.connect()
+	ctx, cancel, c, err := self.connect(context.Background())
 	log.CheckE(err, nil, "failed to connect to MCP")
 	defer cancel()
 
@@ -277,13 +256,15 @@ func (self *MCPServer) LoadTools() (err error) {
 	return
 }
 
-func (self *MCPServer) CallTool(callRequest *ToolCallRequest) (result string, err error) {
+// CallTool executes the tool on the MCP server. Cancelling ctx aborts both the
+// connection setup and the in-flight call.
+func (self *MCPServer) CallTool(parent context.Context, callRequest *ToolCallRequest) (result string, err error) {
 	defer logger.BreakOnError()
 
 	var ctx context.Context
 	var cancel context.CancelFunc
 	var c *client.Client
-	ctx, cancel, c, err = self.connect()
+	ctx, cancel, c, err = self.connect(parent)
 	defer cancel()
 	log.CheckE(err, nil, "failed to connect to MCP")
 
@@ -298,7 +279,7 @@ func (self *MCPServer) CallTool(callRequest *ToolCallRequest) (result string, er
 
 	var callResult *mcp.CallToolResult
 	var rawErr error
-	callDoneCh := make(chan bool)
+	callDoneCh := make(chan bool, 1)
 	go func() {
 		callResult, rawErr = c.CallTool(ctx, toolRequest)
 		log.CheckW(rawErr, nil, "Failed to call MCP tool: ", callRequest.Name)
@@ -332,6 +313,9 @@ func (self *MCPServer) CallTool(callRequest *ToolCallRequest) (result string, er
 	case <-time.After(time.Hour):
 		err = errors.New("timeout on calling a tool")
 		return
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
 	}
 
 	var sb strings.Builder
@@ -0,0 +1,223 @@
+package mcptools
+
+import (
+	"agentsmith/src/logger"
+	"context"
+	"errors"
+	"sync"
+)
+
+// BuiltinTool is a tool executed in process instead of on an MCP server. New
+// builtins only need to implement it and call RegisterBuiltinTool, the agent
+// loop dispatches to them by name.
+type BuiltinTool interface {
+	// Definition is the tool as it is shown to the model.
+	Definition() *Tool
+	// Schema is the JSON schema of the tool parameters.
+	Schema() map[string]any
+	Execute(ctx context.Context, params map[string]any) (string, error)
+}
+
+// BuiltinToolInfo describes a registered builtin for listing in the UI.
+type BuiltinToolInfo struct {
+	*Tool
+	Schema  map[string]any `json:"schema"`
+	Enabled bool           `json:"enabled"`
+}
+
+var builtins = struct {
+	mu       sync.RWMutex
+	tools    []BuiltinTool
+	disabled map[string]bool
+}{disabled: make(map[string]bool)}
+
+var ErrBuiltinToolNotFound = errors.New("builtin tool not found")
+
+// RegisterBuiltinTool adds a builtin to the registry, replacing any builtin
+// with the same name. Builtins are enabled unless disabled by the user.
+func RegisterBuiltinTool(tool BuiltinTool) {
+	builtins.mu.Lock()
+	defer builtins.mu.Unlock()
+
+	name := tool.Definition().Name
+	for i, t := range builtins.tools {
+		if t.Definition().Name == name {
+			builtins.tools[i] = tool
+			return
+		}
+	}
+	builtins.tools = append(builtins.tools, tool)
+}
+
+func findBuiltinTool(name string) BuiltinTool {
+	for _, tool := range builtins.tools {
+		if tool.Definition().Name == name {
+			return tool
+		}
+	}
+	return nil
+}
+
+// GetBuiltinTools returns definitions of the enabled builtins.
+func GetBuiltinTools() []*Tool {
+	builtins.mu.RLock()
+	defer builtins.mu.RUnlock()
+
+	res := make([]*Tool, 0, len(builtins.tools))
+	for _, tool := range builtins.tools {
+		if def := tool.Definition(); !builtins.disabled[def.Name] {
+			res = append(res, def)
+		}
+	}
+	return res
+}
+
+// ListBuiltinTools returns all registered builtins, including disabled ones.
+func ListBuiltinTools() []BuiltinToolInfo {
+	builtins.mu.RLock()
+	defer builtins.mu.RUnlock()
+
+	res := make([]BuiltinToolInfo, 0, len(builtins.tools))
+	for _, tool := range builtins.tools {
+		def := tool.Definition()
+		res = append(res, BuiltinToolInfo{Tool: def, Schema: tool.Schema(), Enabled: !builtins.disabled[def.Name]})
+	}
+	return res
+}
+
+// IsBuiltinTool reports whether name is an enabled builtin.
+func IsBuiltinTool(name string) bool {
+	builtins.mu.RLock()
+	defer builtins.mu.RUnlock()
+
+	return findBuiltinTool(name) != nil && !builtins.disabled[name]
+}
+
+// CallBuiltinTool executes an enabled builtin.
+func CallBuiltinTool(ctx context.Context, callRequest *ToolCallRequest) (string, error) {
+	builtins.mu.RLock()
+	tool := findBuiltinTool(callRequest.Name)
+	disabled := builtins.disabled[callRequest.Name]
+	builtins.mu.RUnlock()
+
+	if tool == nil {
+		return "", ErrBuiltinToolNotFound
+	}
+	if disabled {
+		return "", errors.New("builtin tool is disabled: " + callRequest.Name)
+	}
+	params := callRequest.Params
+	if params == nil {
+		params = map[string]any{}
+	}
+	return tool.Execute(ctx, params)
+}
+
+// SetBuiltinToolEnabled enables or disables a builtin and saves the setting.
+func SetBuiltinToolEnabled(name string, enabled bool) (err error) {
+	defer logger.BreakOnError()
+
+	builtins.mu.Lock()
+	if findBuiltinTool(name) == nil {
+		builtins.mu.Unlock()
+		return ErrBuiltinToolNotFound
+	}
+	builtins.disabled[name] = !enabled
+	builtins.mu.Unlock()
+
+	db := storage.DB()
+
+	query := `
+	INSERT INTO builtin_tools (name, enabled)
+	VALUES (?, ?)
+	ON CONFLICT(name) DO UPDATE SET
+		enabled=excluded.enabled;
+	`
+	_, err = db.Exec(query, name, enabled)
+	log.CheckW(err, "Failed to update builtin tool DB")
+	return
+}
+
+// LoadBuiltinToolSettings restores which builtins were disabled by the user.
+func LoadBuiltinToolSettings() {
+	log.D("Loading builtin tool settings")
+	defer logger.BreakOnError()
+
+	db := storage.DB()
+
+	rows, err := db.Query("SELECT name, enabled FROM builtin_tools;")
+	log.CheckE(err, nil, "Failed to select builtin tools from DB")
+	defer rows.Close()
+
+	builtins.mu.Lock()
+	defer builtins.mu.Unlock()
+	for rows.Next() {
+		var name string
+		var enabled bool
+		if err = rows.Scan(&name, &enabled); err != nil {
+			log.W("Failed to scan builtin tool row:", err)
+			continue
+		}
+		builtins.disabled[name] = !enabled
+	}
+}
+
+// ToolSchema converts tool parameters to JSON schema.
+func ToolSchema(tool *Tool) map[string]any {
+	properties := make(map[string]any, len(tool.Params))
+	for _, param := range tool.Params {
+		properties[param.Name] = map[string]any{
+			"type":        param.Type,
+			"description": param.Description,
+		}
+	}
+	required := tool.RequiredParams
+	if required == nil {
+		required = []string{}
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// NewBuiltinTool creates a builtin from a static definition and a function
+// executing it.
+func NewBuiltinTool(tool *Tool, execute func(ctx context.Context, params map[string]any) (string, error)) BuiltinTool {
+	if tool.RequiredParams == nil {
+		tool.RequiredParams = make([]string, 0)
+	}
+	return &funcBuiltinTool{tool: tool, execute: execute}
+}
+
+type funcBuiltinTool struct {
+	tool    *Tool
+	execute func(ctx context.Context, params map[string]any) (string, error)
+}
+
+func (self *funcBuiltinTool) Definition() *Tool {
+	return self.tool
+}
+
+func (self *funcBuiltinTool) Schema() map[string]any {
+	return ToolSchema(self.tool)
+}
+
+func (self *funcBuiltinTool) Execute(ctx context.Context, params map[string]any) (string, error) {
+	return self.execute(ctx, params)
+}
+
+type sessionIDKey struct{}
+
+// WithSessionID marks ctx with the chat session a tool is executed for, so
+// that builtins can keep per-session state.
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey{}, sessionID)
+}
+
+// SessionID returns the session set by WithSessionID or an empty string.
+func SessionID(ctx context.Context) string {
+	sessionID, _ := ctx.Value(sessionIDKey{}).(string)
+	return sessionID
+}
@@ -0,0 +1,78 @@
+package mcptools
+
+import (
+	"agentsmith/src/store"
+	"context"
+	"slices"
+	"testing"
+)
+
+func useTestDB(t *testing.T) {
+	t.Helper()
+
+	db, err := store.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	UseStore(db)
+}
+
+func registerTestBuiltin(t *testing.T) *Tool {
+	t.Helper()
+
+	tool := &Tool{Name: "test_echo", Params: []*ToolParam{{Name: "text", Type: "string"}}, RequiredParams: []string{"text"}}
+	RegisterBuiltinTool(NewBuiltinTool(tool, func(ctx context.Context, params map[string]any) (string, error) {
+		return stringParam(params, "text"), nil
+	}))
+	t.Cleanup(func() {
+		builtins.mu.Lock()
+		defer builtins.mu.Unlock()
+		builtins.tools = slices.DeleteFunc(builtins.tools, func(b BuiltinTool) bool { return b.Definition() == tool })
+		delete(builtins.disabled, tool.Name)
+	})
+	return tool
+}
+
+func TestBuiltinRegistry_CallAndToggle(t *testing.T) {
+	useTestDB(t)
+	tool := registerTestBuiltin(t)
+
+	res, err := CallBuiltinTool(context.Background(), &ToolCallRequest{Name: tool.Name, Params: map[string]any{"text": "hi"}})
+	if err != nil || res != "hi" {
+		t.Fatalf("CallBuiltinTool = %q, %v", res, err)
+	}
+
+	if err = SetBuiltinToolEnabled(tool.Name, false); err != nil {
+		t.Fatal(err)
+	}
+	if IsBuiltinTool(tool.Name) || slices.Contains(GetBuiltinTools(), tool) {
+		t.Error("disabled builtin must not be offered")
+	}
+	if _, err = CallBuiltinTool(context.Background(), &ToolCallRequest{Name: tool.Name}); err == nil {
+		t.Error("disabled builtin must not be callable")
+	}
+
+	// setting is restored from DB
+	builtins.mu.Lock()
+	delete(builtins.disabled, tool.Name)
+	builtins.mu.Unlock()
+	LoadBuiltinToolSettings()
+	if IsBuiltinTool(tool.Name) {
+		t.Error("disabled setting was not loaded from DB")
+	}
+}
+
+func TestBuiltinRegistry_Schema(t *testing.T) {
+	for _, info := range ListBuiltinTools() {
+		if info.Name != "lua_code_runner" {
+			continue
+		}
+		required, _ := info.Schema["required"].([]string)
+		if !slices.Equal(required, []string{"code"}) {
+			t.Fatalf("lua_code_runner required params = %v, want [code]", required)
+		}
+		return
+	}
+	t.Fatal("lua_code_runner is not registered")
+}
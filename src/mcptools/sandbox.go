@@ -0,0 +1,191 @@
+package mcptools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"runtime/metrics"
+	"strings"
+	"time"
+)
+
+// Code runners execute code in a child process started from the app binary
+// with sandboxEnv set, main serves it before the app starts. The VMs check for
+// interrupts only between instructions, a native call such as join of a huge
+// array runs to its end whatever the time and memory it takes. The child has a
+// heap of its own, it exits when the code uses too much memory and is killed
+// when it is still running sandboxKillDelay after the timeout.
+const (
+	sandboxEnv            = "AS_AGENT_CODE_SANDBOX"
+	sandboxKillDelay      = time.Second
+	sandboxMemCheckPeriod = 5 * time.Millisecond
+	sandboxMemoryExitCode = 3
+	sandboxMaxResultBytes = 4 * luaMaxOutputBytes
+	sandboxMaxStderrBytes = 4 << 10
+	sandboxStoppedPrefix  = "execution stopped: "
+)
+
+type sandboxLanguage string
+
+const (
+	sandboxLua sandboxLanguage = "lua"
+	sandboxJS  sandboxLanguage = "js"
+)
+
+var errMemoryLimit = errors.New("memory limit exceeded")
+
+// sandboxLimits are the time and memory code may use.
+type sandboxLimits struct {
+	Timeout       time.Duration `json:"timeout"`
+	MaxAllocBytes uint64        `json:"maxAllocBytes"`
+}
+
+// codeLimits are sent to the child with the code, so tests can change them.
+var codeLimits = sandboxLimits{Timeout: 10 * time.Second, MaxAllocBytes: 128 << 20}
+
+type sandboxRequest struct {
+	Language sandboxLanguage `json:"language"`
+	Code     string          `json:"code"`
+	Limits   sandboxLimits   `json:"limits"`
+}
+
+// IsSandbox tells if the process was started by a code runner. main checks it
+// first and calls ServeSandbox instead of starting the app.
+func IsSandbox() bool {
+	return os.Getenv(sandboxEnv) != ""
+}
+
+// ServeSandbox runs the code read from stdin and writes the result to stdout.
+// It returns the exit code of the process.
+func ServeSandbox() int {
+	return serveSandbox(os.Stdin, os.Stdout)
+}
+
+func serveSandbox(in io.Reader, out io.Writer) int {
+	var req sandboxRequest
+	if err := json.NewDecoder(in).Decode(&req); err != nil {
+		return 1
+	}
+	// garbage is collected before the heap gets to the limit, so the heap in
+	// use over the limit is live memory
+	debug.SetMemoryLimit(int64(req.Limits.MaxAllocBytes))
+
+	var res *CodeRunResult
+	switch req.Language {
+	case sandboxLua:
+		res = runLuaSandbox(req.Code, req.Limits)
+	case sandboxJS:
+		res = runJSSandbox(req.Code, req.Limits)
+	default:
+		return 1
+	}
+	if _, err := io.WriteString(out, res.String()); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// runInSandbox runs the code in a child process and returns its result.
+// Errors of the child are reported in the result.
+func runInSandbox(ctx context.Context, language sandboxLanguage, code string) *CodeRunResult {
+	res := &CodeRunResult{}
+
+	if IsSandbox() {
+		// the binary doesn't serve the sandbox, it would start itself again
+		res.Error = "sandbox is not served by this binary"
+		return res
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		res.Error = "failed to start the sandbox: " + err.Error()
+		return res
+	}
+	limits := codeLimits
+	request, err := json.Marshal(&sandboxRequest{Language: language, Code: code, Limits: limits})
+	if err != nil {
+		res.Error = "failed to start the sandbox: " + err.Error()
+		return res
+	}
+	runCtx, cancel := context.WithTimeout(ctx, limits.Timeout+sandboxKillDelay)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(runCtx, exe)
+	// nothing of the app environment, Windows needs SYSTEMROOT to start
+	cmd.Env = []string{sandboxEnv + "=1", "SYSTEMROOT=" + os.Getenv("SYSTEMROOT")}
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = &limitedWriter{&stdout, sandboxMaxResultBytes}
+	cmd.Stderr = &limitedWriter{&stderr, sandboxMaxStderrBytes}
+	cmd.WaitDelay = sandboxKillDelay
+	err = cmd.Run()
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		res.Error = sandboxStoppedPrefix + ctx.Err().Error()
+	case runCtx.Err() != nil:
+		res.Error = sandboxStoppedPrefix + runCtx.Err().Error()
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == sandboxMemoryExitCode:
+		res.Error = sandboxStoppedPrefix + errMemoryLimit.Error()
+	case err != nil:
+		res.Error = "sandbox failed: " + err.Error() + " " + strings.TrimSpace(stderr.String())
+	case json.Unmarshal(stdout.Bytes(), res) != nil:
+		res.Error = "sandbox returned an invalid result"
+	}
+	return res
+}
+
+// limitedWriter keeps the first max bytes and drops the rest, so a child
+// process can't fill the memory of the app.
+type limitedWriter struct {
+	buf *bytes.Buffer
+	max int
+}
+
+func (self *limitedWriter) Write(p []byte) (int, error) {
+	if room := self.max - self.buf.Len(); room > 0 {
+		self.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// watchHeap starts to watch the code running in the child, it calls stop, if
+// set, with the error of ctx when it is done. When the heap grew by more than limit
+// since watchHeap was called, it exits the process, as the VM may be in a
+// native call that doesn't see interrupts.
+func watchHeap(ctx context.Context, limit uint64, stop func(err error), done <-chan struct{}) {
+	start := sandboxHeap()
+	go func() {
+		ticker := time.NewTicker(sandboxMemCheckPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				if stop != nil {
+					stop(ctx.Err())
+				}
+				return
+			case <-ticker.C:
+				if sandboxHeap() > start+limit {
+					os.Exit(sandboxMemoryExitCode)
+				}
+			}
+		}
+	}()
+}
+
+// sandboxHeap is the heap in use of the child process. It sees a large
+// allocation right away, the collector keeps garbage under the memory limit
+// set for the child so it is not counted.
+func sandboxHeap() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
@@ -0,0 +1,31 @@
+package mcptools
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// the test binary serves the sandbox of the code runners it tests, as main
+	// does for the app
+	if IsSandbox() {
+		os.Exit(ServeSandbox())
+	}
+	os.Exit(m.Run())
+}
+
+// withCodeLimits changes the limits of code runners for the test.
+func withCodeLimits(t *testing.T, limits sandboxLimits) {
+	saved := codeLimits
+	codeLimits = limits
+	t.Cleanup(func() { codeLimits = saved })
+}
+
+func TestRunInSandbox_NotFromSandbox(t *testing.T) {
+	t.Setenv(sandboxEnv, "1")
+
+	if res := runInSandbox(context.Background(), sandboxJS, "1"); res.Error != "sandbox is not served by this binary" {
+		t.Fatalf("sandbox must not start itself again, got %+v", res)
+	}
+}
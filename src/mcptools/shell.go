@@ -0,0 +1,270 @@
+package mcptools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Limits of shell_exec.
+const (
+	shellDefaultTimeout  = 30 * time.Second
+	shellMaxTimeout      = 5 * time.Minute
+	shellMaxOutputBytes  = 64 << 10 // per stream
+	shellMaxCommandBytes = 8 << 10
+)
+
+// shellDefaultDenylist are programs shell_exec never runs, in addition to
+// AS_AGENT_SHELL_DENYLIST. Shells, interpreters and programs that run other
+// programs would get around the lists, versions such as python3.12 are
+// denied with their program.
+var shellDefaultDenylist = []string{
+	"sudo", "su", "doas", "pkexec", "shutdown", "reboot", "halt", "poweroff",
+	"mkfs", "dd", "fdisk", "mount", "umount", "chown", "passwd", "crontab",
+	"sh", "bash", "zsh", "fish", "dash", "ksh", "csh", "tcsh", "cmd", "powershell", "pwsh",
+	"python", "node", "nodejs", "deno", "bun", "perl", "ruby", "php", "lua", "luajit",
+	"tclsh", "wish", "osascript", "wscript", "cscript", "awk", "gawk", "mawk", "nawk",
+	"env", "xargs", "nohup", "nice", "timeout", "setsid", "chroot",
+}
+
+// shellDeniedArgs are arguments that make a program run other programs.
+var shellDeniedArgs = map[string][]string{
+	"find": {"-exec", "-execdir", "-ok", "-okdir"},
+}
+
+var shellExecTool = &Tool{
+	Name:             "shell_exec",
+	Description:      "Run a command in the workspace directory and get its exit code, stdout and stderr as JSON. The command is executed directly, without a shell: pipes, redirects, globs and variables are not supported. Only allowed programs can be run and the user has to approve every command.",
+	RequiredParams:   []string{"command"},
+	RequiresApproval: true,
+	Params: []*ToolParam{
+		{Name: "command", Type: "string", Description: "Command line, e.g. go test ./... Arguments can be quoted with single or double quotes."},
+		{Name: "timeout", Type: "integer", Description: "Timeout in seconds, 30 by default and at most 300."},
+	},
+}
+
+func init() {
+	RegisterBuiltinTool(NewBuiltinTool(shellExecTool, func(ctx context.Context, params map[string]any) (string, error) {
+		return RunShell(ctx, params).String(), nil
+	}))
+}
+
+// ShellResult is the result of shell_exec returned to the model as JSON.
+type ShellResult struct {
+	ExitCode  int    `json:"exitCode"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Truncated bool   `json:"truncated,omitempty"`
+	TimedOut  bool   `json:"timedOut,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (self *ShellResult) String() string {
+	data, _ := json.Marshal(self)
+	return string(data)
+}
+
+// ShellAllowlist returns programs shell_exec may run, configured as comma
+// separated AS_AGENT_SHELL_ALLOWLIST. Empty allowlist allows any program that
+// is not denied.
+func ShellAllowlist() []string {
+	return splitEnvList("AS_AGENT_SHELL_ALLOWLIST")
+}
+
+// ShellDenylist returns programs shell_exec refuses to run. It always
+// includes shellDefaultDenylist.
+func ShellDenylist() []string {
+	return append(splitEnvList("AS_AGENT_SHELL_DENYLIST"), shellDefaultDenylist...)
+}
+
+func splitEnvList(name string) []string {
+	res := make([]string, 0, 8)
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+func checkShellCommand(args []string) error {
+	name := strings.TrimSuffix(strings.ToLower(filepath.Base(args[0])), ".exe")
+	unversioned := strings.TrimRight(name, "0123456789.")
+	for _, denied := range ShellDenylist() {
+		if strings.EqualFold(name, denied) || strings.EqualFold(unversioned, denied) {
+			return fmt.Errorf("program %s is not allowed", name)
+		}
+	}
+	for _, arg := range args[1:] {
+		if slices.Contains(shellDeniedArgs[name], arg) {
+			return fmt.Errorf("argument %s of %s is not allowed", arg, name)
+		}
+	}
+	allowlist := ShellAllowlist()
+	if len(allowlist) == 0 {
+		return nil
+	}
+	for _, allowed := range allowlist {
+		if strings.EqualFold(name, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("program %s is not in the shell allowlist", name)
+}
+
+// splitCommand splits command line into arguments the way POSIX shell does
+// for quoting and escaping, without any expansion.
+func splitCommand(command string) ([]string, error) {
+	args := make([]string, 0, 8)
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range command {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inArg = true, true
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in command")
+	}
+	if escaped {
+		return nil, errors.New("command ends with escape character")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// limitedBuffer keeps at most limit bytes and remembers if more were written.
+type limitedBuffer struct {
+	buf       []byte
+	limit     int
+	truncated bool
+}
+
+func (self *limitedBuffer) Write(p []byte) (int, error) {
+	if room := self.limit - len(self.buf); room < len(p) {
+		self.buf = append(self.buf, p[:max(0, room)]...)
+		self.truncated = true
+	} else {
+		self.buf = append(self.buf, p...)
+	}
+	return len(p), nil
+}
+
+// RunShell executes the shell_exec call in the workspace directory.
+func RunShell(ctx context.Context, params map[string]any) *ShellResult {
+	res := &ShellResult{ExitCode: -1}
+
+	command := stringParam(params, "command")
+	if strings.TrimSpace(command) == "" {
+		res.Error = "missing command parameter"
+		return res
+	}
+	if len(command) > shellMaxCommandBytes {
+		res.Error = "command is too long"
+		return res
+	}
+	args, err := splitCommand(command)
+	if err == nil && len(args) == 0 {
+		err = errors.New("missing command parameter")
+	}
+	if err == nil {
+		err = checkShellCommand(args)
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	timeout := shellDefaultTimeout
+	if seconds := intParam(params, "timeout"); seconds > 0 {
+		timeout = min(time.Duration(seconds)*time.Second, shellMaxTimeout)
+	}
+
+	dir, err := WorkspaceDir()
+	if err == nil {
+		dir, err = filepath.Abs(dir)
+	}
+	if err == nil {
+		err = os.MkdirAll(dir, 0o755)
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: shellMaxOutputBytes}
+	stderr := &limitedBuffer{limit: shellMaxOutputBytes}
+	cmd := exec.CommandContext(timeoutCtx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = 2 * time.Second
+	configureShellCmd(cmd)
+
+	err = cmd.Run()
+	res.Stdout = string(stdout.buf)
+	res.Stderr = string(stderr.buf)
+	res.Truncated = stdout.truncated || stderr.truncated
+	if cmd.ProcessState != nil {
+		res.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	switch {
+	case errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+		res.TimedOut = true
+		res.Error = fmt.Sprintf("command timed out after %s", timeout)
+	case ctx.Err() != nil:
+		res.Error = ctx.Err().Error()
+	case err != nil:
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			res.Error = err.Error()
+		}
+	}
+	return res
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package mcptools
+
+import "os/exec"
+
+func configureShellCmd(cmd *exec.Cmd) {}
@@ -0,0 +1,91 @@
+//go:build unix
+
+package mcptools
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitCommand(t *testing.T) {
+	args, err := splitCommand(`grep -rn "hello world" 'a b' c\ d "q\"uote" ''`)
+	want := []string{"grep", "-rn", "hello world", "a b", "c d", `q"uote`, ""}
+	if err != nil || !slices.Equal(args, want) {
+		t.Fatalf("splitCommand = %q, %v, want %q", args, err, want)
+	}
+	if _, err = splitCommand(`echo "unterminated`); err == nil {
+		t.Error("expected unterminated quote error")
+	}
+}
+
+func TestRunShell(t *testing.T) {
+	dir := useTestWorkspace(t)
+
+	res := RunShell(context.Background(), map[string]any{"command": "pwd"})
+	if res.Error != "" || res.ExitCode != 0 || !strings.HasSuffix(strings.TrimSpace(res.Stdout), dir) {
+		t.Fatalf("pwd = %+v, want workspace %s", res, dir)
+	}
+
+	res = RunShell(context.Background(), map[string]any{"command": "ls no-such-file"})
+	if res.Error != "" || res.ExitCode == 0 || res.Stderr == "" {
+		t.Fatalf("expected non zero exit code with stderr, got %+v", res)
+	}
+
+	res = RunShell(context.Background(), map[string]any{"command": "echo 'a | b' > out"})
+	if res.Stdout != "a | b > out\n" {
+		t.Fatalf("shell syntax must not be interpreted, got %+v", res)
+	}
+}
+
+func TestRunShell_Limits(t *testing.T) {
+	useTestWorkspace(t)
+
+	start := time.Now()
+	res := RunShell(context.Background(), map[string]any{"command": "sleep 10", "timeout": 1})
+	if !res.TimedOut || time.Since(start) > 5*time.Second {
+		t.Fatalf("expected timeout after 1s, got %+v in %s", res, time.Since(start))
+	}
+
+	res = RunShell(context.Background(), map[string]any{"command": "head -c 100000 /dev/zero"})
+	if !res.Truncated || len(res.Stdout) != shellMaxOutputBytes {
+		t.Fatalf("expected truncated stdout, got %d bytes, truncated %v", len(res.Stdout), res.Truncated)
+	}
+}
+
+func TestRunShell_AllowDenyLists(t *testing.T) {
+	useTestWorkspace(t)
+
+	for _, command := range []string{"sudo ls", "/bin/sh -c ls", "env sudo ls", "python3.12 -c 1", "node -e 1", "perl -e 1", "find . -exec rm {} ;"} {
+		if res := RunShell(context.Background(), map[string]any{"command": command}); !strings.Contains(res.Error, "not allowed") {
+			t.Errorf("%q must be denied, got %+v", command, res)
+		}
+	}
+
+	t.Setenv("AS_AGENT_SHELL_ALLOWLIST", "echo")
+	if res := RunShell(context.Background(), map[string]any{"command": "ls"}); !strings.Contains(res.Error, "allowlist") {
+		t.Errorf("ls must be rejected by allowlist, got %+v", res)
+	}
+	if res := RunShell(context.Background(), map[string]any{"command": "echo ok"}); res.Error != "" || res.Stdout != "ok\n" {
+		t.Errorf("echo must be allowed, got %+v", res)
+	}
+
+	t.Setenv("AS_AGENT_SHELL_DENYLIST", "echo")
+	if res := RunShell(context.Background(), map[string]any{"command": "echo ok"}); !strings.Contains(res.Error, "not allowed") {
+		t.Errorf("denylist must win over allowlist, got %+v", res)
+	}
+}
+
+func TestShellExec_RequiresApproval(t *testing.T) {
+	for _, tool := range GetBuiltinTools() {
+		if tool.Name == "shell_exec" {
+			if !tool.RequiresApproval {
+				t.Fatal("shell_exec must require approval")
+			}
+			return
+		}
+	}
+	t.Fatal("shell_exec is not registered")
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package mcptools
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// configureShellCmd runs the command in its own process group, so that
+// cancellation kills the programs it started as well.
+func configureShellCmd(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
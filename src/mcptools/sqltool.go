@@ -0,0 +1,295 @@
+package mcptools
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits of the SQL tools.
+const (
+	sqlDefaultRowLimit = 100
+	sqlMaxRowLimit     = 1000
+	sqlMaxCellChars    = 200
+	sqlQueryTimeout    = 30 * time.Second
+)
+
+var sqlSchemaTool = &Tool{
+	Name:           "sql_schema",
+	Description:    "List datasets attached by the user for SQL queries, with their tables, columns and row counts. Call it before writing queries with sql_query.",
+	RequiredParams: []string{},
+	Params: []*ToolParam{
+		{Name: "dataset", Type: "string", Description: "Name of the dataset to describe. All datasets are described when empty."},
+	},
+}
+
+var sqlQueryTool = &Tool{
+	Name:           "sql_query",
+	Description:    "Run read-only SQLite SQL query (SELECT or WITH) against a dataset attached by the user and get the result as a markdown table. CSV datasets are loaded into a single table, see sql_schema for its name and columns.",
+	RequiredParams: []string{"dataset", "query"},
+	Params: []*ToolParam{
+		{Name: "dataset", Type: "string", Description: "Name of the dataset."},
+		{Name: "query", Type: "string", Description: "Single SELECT statement in SQLite dialect."},
+		{Name: "limit", Type: "integer", Description: "Maximum number of rows returned, 100 by default and at most 1000."},
+	},
+}
+
+func init() {
+	RegisterBuiltinTool(NewBuiltinTool(sqlSchemaTool, func(ctx context.Context, params map[string]any) (string, error) {
+		return DescribeDatasets(ctx, stringParam(params, "dataset"))
+	}))
+	RegisterBuiltinTool(NewBuiltinTool(sqlQueryTool, func(ctx context.Context, params map[string]any) (string, error) {
+		return QueryDataset(ctx, stringParam(params, "dataset"), stringParam(params, "query"), intParam(params, "limit"))
+	}))
+}
+
+// DescribeDatasets returns schema of the named dataset, or of all datasets
+// when name is empty.
+func DescribeDatasets(ctx context.Context, name string) (string, error) {
+	datasets := LoadDatasets()
+	if name != "" {
+		dataset, err := FindDataset(name)
+		if err != nil {
+			return "", err
+		}
+		datasets = []*Dataset{dataset}
+	}
+	if len(datasets) == 0 {
+		return "No datasets are attached. Ask the user to attach a SQLite or CSV file.", nil
+	}
+
+	var sb strings.Builder
+	for _, dataset := range datasets {
+		schema, err := describeDataset(ctx, dataset)
+		if err != nil {
+			schema = "Error: " + err.Error() + "\n"
+		}
+		sb.WriteString(fmt.Sprintf("## Dataset %s (%s)\n%s\n", dataset.Name, dataset.Type, schema))
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+func describeDataset(ctx context.Context, dataset *Dataset) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, sqlQueryTimeout)
+	defer cancel()
+
+	db, closeDB, err := dataset.open(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer closeDB()
+
+	rows, err := db.QueryContext(ctx, "SELECT name, type FROM sqlite_master WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%' ORDER BY name;")
+	if err != nil {
+		return "", err
+	}
+	type table struct{ name, kind string }
+	tables := make([]table, 0, 8)
+	for rows.Next() {
+		var t table
+		if err = rows.Scan(&t.name, &t.kind); err != nil {
+			rows.Close()
+			return "", err
+		}
+		tables = append(tables, t)
+	}
+	rows.Close()
+
+	var sb strings.Builder
+	for _, t := range tables {
+		var count int64
+		err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+quoteIdent(t.name)+";").Scan(&count)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(fmt.Sprintf("%s %s (%d rows):\n", t.kind, t.name, count))
+
+		cols, err := db.QueryContext(ctx, "SELECT name, type, pk FROM pragma_table_info(?);", t.name)
+		if err != nil {
+			return "", err
+		}
+		for cols.Next() {
+			var name, colType string
+			var pk int
+			if err = cols.Scan(&name, &colType, &pk); err != nil {
+				cols.Close()
+				return "", err
+			}
+			line := "- " + name + " " + colType
+			if pk > 0 {
+				line += " PRIMARY KEY"
+			}
+			sb.WriteString(line + "\n")
+		}
+		cols.Close()
+	}
+	if len(tables) == 0 {
+		sb.WriteString("No tables\n")
+	}
+	return sb.String(), nil
+}
+
+var sqlReadOnlyRe = regexp.MustCompile(`(?is)^\s*(select|with|values|explain)\b`)
+
+// QueryDataset runs read-only query against the dataset and formats the rows
+// as a markdown table. At most limit rows are returned.
+func QueryDataset(ctx context.Context, name string, query string, limit int) (string, error) {
+	if strings.TrimSpace(query) == "" {
+		return "", errors.New("query is required")
+	}
+	if !sqlReadOnlyRe.MatchString(query) {
+		return "", errors.New("only SELECT queries are allowed")
+	}
+	query, multiple := splitStatement(query)
+	if multiple {
+		return "", errors.New("only a single statement is allowed")
+	}
+	if limit <= 0 {
+		limit = sqlDefaultRowLimit
+	}
+	limit = min(limit, sqlMaxRowLimit)
+
+	dataset, err := FindDataset(name)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sqlQueryTimeout)
+	defer cancel()
+
+	db, closeDB, err := dataset.open(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer closeDB()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	return formatRows(rows, limit)
+}
+
+// splitStatement returns the first statement of the query without the
+// terminating semicolon, and reports whether there is anything but whitespace
+// and comments after it. Semicolons in string literals, identifiers and
+// comments are ignored.
+func splitStatement(query string) (string, bool) {
+	var quote byte
+	end := -1
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(query)
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(query)
+			}
+		case end < 0 && c == ';':
+			end = i
+		case end >= 0 && c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ';':
+			return query[:end], true
+		}
+	}
+	if end >= 0 {
+		return query[:end], false
+	}
+	return query, false
+}
+
+// formatRows renders up to limit rows as markdown table.
+func formatRows(rows *sql.Rows, limit int) (string, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| " + strings.Join(escapeCells(columns), " | ") + " |\n")
+	sb.WriteString("|" + strings.Repeat(" --- |", len(columns)) + "\n")
+
+	values := make([]any, len(columns))
+	ptrs := make([]any, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	count := 0
+	truncated := false
+	for rows.Next() {
+		if count >= limit {
+			truncated = true
+			break
+		}
+		if err = rows.Scan(ptrs...); err != nil {
+			return "", err
+		}
+		cells := make([]string, len(values))
+		for i, value := range values {
+			cells[i] = formatCell(value)
+		}
+		sb.WriteString("| " + strings.Join(escapeCells(cells), " | ") + " |\n")
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+
+	if truncated {
+		sb.WriteString(fmt.Sprintf("\n%d rows shown, more rows were truncated by the limit.", count))
+	} else {
+		sb.WriteString(fmt.Sprintf("\n%d rows.", count))
+	}
+	return sb.String(), nil
+}
+
+func formatCell(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		if utf8.Valid(v) {
+			return string(v)
+		}
+		return fmt.Sprintf("<blob %d bytes>", len(v))
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func escapeCells(cells []string) []string {
+	res := make([]string, len(cells))
+	for i, cell := range cells {
+		if utf8.RuneCountInString(cell) > sqlMaxCellChars {
+			cell = string([]rune(cell)[:sqlMaxCellChars]) + "…"
+		}
+		cell = strings.ReplaceAll(cell, "|", `\|`)
+		cell = strings.ReplaceAll(cell, "\r", "")
+		res[i] = strings.ReplaceAll(cell, "\n", " ")
+	}
+	return res
+}
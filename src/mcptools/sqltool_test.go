@@ -0,0 +1,95 @@
+package mcptools
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func attachTestDataset(t *testing.T, name string, path string) *Dataset {
+	t.Helper()
+
+	dataset, err := NewDataset(name, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dataset.Save(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dropCSVCache(dataset.ID) })
+	return dataset
+}
+
+func TestQueryDataset_CSV(t *testing.T) {
+	useTestDB(t)
+	path := filepath.Join(t.TempDir(), "Sales 2024.csv")
+	os.WriteFile(path, []byte("region,amount,price\nnorth,10,1.5\nsouth,20,2\nnorth,5,\"3,5\"\n"), 0o644)
+	attachTestDataset(t, "sales", path)
+
+	schema, err := DescribeDatasets(context.Background(), "sales")
+	if err != nil || !strings.Contains(schema, "table sales_2024 (3 rows)") || !strings.Contains(schema, "- amount INTEGER") || !strings.Contains(schema, "- price TEXT") {
+		t.Fatalf("schema = %q, %v", schema, err)
+	}
+
+	out, err := QueryDataset(context.Background(), "sales", "SELECT region, SUM(amount) AS total FROM sales_2024 GROUP BY region ORDER BY region", 0)
+	want := "| region | total |\n| --- | --- |\n| north | 15 |\n| south | 20 |\n\n2 rows."
+	if err != nil || out != want {
+		t.Fatalf("QueryDataset =\n%s\n%v\nwant\n%s", out, err, want)
+	}
+
+	out, err = QueryDataset(context.Background(), "sales", "SELECT * FROM sales_2024", 1)
+	if err != nil || !strings.Contains(out, "1 rows shown, more rows were truncated") {
+		t.Fatalf("expected truncated result, got %q, %v", out, err)
+	}
+}
+
+func TestQueryDataset_SQLiteIsReadOnly(t *testing.T) {
+	useTestDB(t)
+	path := filepath.Join(t.TempDir(), "data.db")
+	db, _ := sql.Open("sqlite3", path)
+	_, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO items (name) VALUES ('a|b'), ('c');")
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachTestDataset(t, "items", path)
+
+	out, err := QueryDataset(context.Background(), "items", "select name from items order by id; -- done", 0)
+	if err != nil || !strings.Contains(out, `| a\|b |`) {
+		t.Fatalf("QueryDataset = %q, %v", out, err)
+	}
+
+	for _, query := range []string{
+		"DELETE FROM items",
+		"SELECT 1; DELETE FROM items",
+		"WITH x AS (SELECT 1) DELETE FROM items",
+		"ATTACH DATABASE 'other.db' AS other",
+	} {
+		if _, err = QueryDataset(context.Background(), "items", query, 0); err == nil {
+			t.Errorf("%q must fail", query)
+		}
+	}
+
+	out, _ = QueryDataset(context.Background(), "items", "SELECT COUNT(*) AS n FROM items", 0)
+	if !strings.Contains(out, "| 2 |") {
+		t.Fatalf("dataset was modified: %q", out)
+	}
+}
+
+func TestSplitStatement(t *testing.T) {
+	for query, want := range map[string]bool{
+		"SELECT 1;":                     false,
+		"SELECT ';' AS x;  \n":          false,
+		"SELECT 1 -- ; DROP TABLE x":    false,
+		"SELECT 1 /* ; */":              false,
+		"SELECT 1; SELECT 2":            true,
+		"SELECT \"a;b\" FROM t; DELETE": true,
+	} {
+		if _, got := splitStatement(query); got != want {
+			t.Errorf("splitStatement(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
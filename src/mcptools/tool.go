@@ -2,6 +2,8 @@ package mcptools
 
 import (
 	"encoding/json"
+	"strconv"
+	"strings"
 )
 
 type ToolParam struct {
@@ -17,6 +19,8 @@ type Tool struct {
 	Description    string       `json:"description"`
 	Server         *MCPServer   `json:"-"`
 	RequiredParams []string     `json:"requiredParams"`
+	// RequiresApproval tools are executed only after the user approves the call.
+	RequiresApproval bool `json:"requiresApproval"`
 }
 
 func NewToolFromJSON(jsonStr string) (*Tool, error) {
@@ -28,3 +32,36 @@ func NewToolFromJSON(jsonStr string) (*Tool, error) {
 
 	return &tool, err
 }
+
+// stringParam returns a string parameter of a tool call or an empty string.
+func stringParam(params map[string]any, name string) string {
+	value, _ := params[name].(string)
+	return value
+}
+
+// boolParam returns a boolean parameter of a tool call. Models sometimes send
+// booleans as strings, so "true" is accepted as well.
+func boolParam(params map[string]any, name string) bool {
+	switch value := params[name].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+// intParam returns an integer parameter of a tool call or 0. JSON numbers are
+// decoded as float64, and models sometimes send numbers as strings.
+func intParam(params map[string]any, name string) int {
+	switch value := params[name].(type) {
+	case float64:
+		return int(value)
+	case int:
+		return value
+	case string:
+		n, _ := strconv.Atoi(strings.TrimSpace(value))
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,153 @@
+package memory
+
+import (
+	"agentsmith/src/ai"
+	"agentsmith/src/util"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Limits of automatic extraction.
+const (
+	extractMaxTranscriptChars = 20_000
+	extractMaxFacts           = 10
+	extractKnownMemories      = 20
+)
+
+const extractionSysPrompt = `You maintain long-term memory of an AI assistant.
+Read the conversation between the user and the assistant and list facts worth remembering for future conversations:
+preferences of the user, facts about the user, their projects, environment and tools, and decisions they made.
+Each fact must be a short self-contained sentence that makes sense without the conversation, e.g. "The user deploys services to Kubernetes."
+Do not list facts that matter only for the current task, facts about the assistant, general knowledge, secrets, passwords or keys.
+Do not repeat facts that are already remembered.
+Respond only with a JSON array of strings, or [] when there is nothing worth remembering.`
+
+// Extract asks the model for facts worth remembering in the conversation and
+// saves them. Facts that are already remembered are not duplicated.
+func Extract(ctx context.Context, model *ai.Model, messages []*ai.Message, sessionID string) ([]*Memory, error) {
+	transcript := buildTranscript(messages)
+	if transcript == "" {
+		return nil, nil
+	}
+
+	prompt := "Conversation:\n" + transcript
+	if known, err := Search(ctx, transcript, extractKnownMemories); err == nil && len(known) > 0 {
+		facts := make([]string, len(known))
+		for i, memory := range known {
+			facts[i] = "- " + memory.Content
+		}
+		prompt += "\nAlready remembered:\n" + strings.Join(facts, "\n") + "\n"
+	}
+
+	if err := model.Provider.WaitForAllowance(ctx); err != nil {
+		return nil, err
+	}
+	response, err := model.Provider.ChatCompletion(
+		[]*ai.Message{{Origin: ai.MessageOriginUser, Text: prompt}},
+		extractionSysPrompt,
+		model,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	facts, err := parseFacts(response)
+	if err != nil {
+		return nil, err
+	}
+
+	memories := make([]*Memory, 0, len(facts))
+	for _, fact := range facts {
+		memory, err := Save(fact, MemorySourceExtraction, sessionID)
+		if err != nil {
+			log.W("Failed to save extracted memory:", err)
+			continue
+		}
+		memories = append(memories, memory)
+	}
+	log.D("Extracted memories:", len(memories))
+	return memories, nil
+}
+
+// LastExtracted returns the ID of the last message of the session facts were
+// extracted from, or an empty string when none were.
+func LastExtracted(sessionID string) (string, error) {
+	var messageID string
+	err := storage.DB().QueryRow("SELECT message_id FROM memory_extractions WHERE session_id=?;", sessionID).Scan(&messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return messageID, err
+}
+
+// SetLastExtracted records the last message of the session facts were
+// extracted from, an empty ID forgets the session.
+func SetLastExtracted(sessionID string, messageID string) error {
+	db := storage.DB()
+	if messageID == "" {
+		_, err := db.Exec("DELETE FROM memory_extractions WHERE session_id=?;", sessionID)
+		return err
+	}
+	_, err := db.Exec(`
+	INSERT INTO memory_extractions (session_id, message_id, extracted_at) VALUES (?, ?, ?)
+	ON CONFLICT(session_id) DO UPDATE SET message_id=excluded.message_id, extracted_at=excluded.extracted_at;`,
+		sessionID, messageID, time.Now())
+	return err
+}
+
+// buildTranscript flattens user and assistant messages into text, keeping the
+// end of the conversation when it is too long.
+func buildTranscript(messages []*ai.Message) string {
+	var sb strings.Builder
+	for _, message := range messages {
+		role := ""
+		switch message.Origin {
+		case ai.MessageOriginUser:
+			role = "User"
+		case ai.MessageOriginAI:
+			role = "Assistant"
+		default:
+			continue
+		}
+		text := strings.TrimSpace(util.CutThinking(message.Text))
+		if text == "" {
+			continue
+		}
+		sb.WriteString(role + ": " + text + "\n")
+	}
+	transcript := sb.String()
+	if len(transcript) > extractMaxTranscriptChars {
+		transcript = transcript[len(transcript)-extractMaxTranscriptChars:]
+		if i := strings.IndexByte(transcript, '\n'); i >= 0 {
+			transcript = transcript[i+1:]
+		}
+	}
+	return transcript
+}
+
+// parseFacts reads the JSON array of facts from the model response, which
+// may be wrapped in markdown or preceded by reasoning.
+func parseFacts(response string) ([]string, error) {
+	content := util.CutThinking(response)
+	start := strings.Index(content, "[")
+	end := strings.LastIndex(content, "]")
+	if start == -1 || end < start {
+		return nil, errors.New("model did not return a JSON array of facts")
+	}
+
+	var facts []string
+	if err := json.Unmarshal([]byte(content[start:end+1]), &facts); err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(facts))
+	for _, fact := range facts {
+		if fact = strings.TrimSpace(fact); fact != "" && len(res) < extractMaxFacts {
+			res = append(res, fact)
+		}
+	}
+	return res, nil
+}
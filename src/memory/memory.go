@@ -0,0 +1,246 @@
+// Package memory implements long-term memory of the agent: short facts about
+// the user and their projects that are kept across sessions.
+package memory
+
+import (
+	"agentsmith/src/logger"
+	"agentsmith/src/store"
+	"agentsmith/src/util"
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+var log = logger.Logger("memory", 1, 1, 1)
+
+// storage keeps the memories, see UseStore
+var storage store.Store
+
+// UseStore sets the store the memories are saved in.
+func UseStore(s store.Store) {
+	storage = s
+}
+
+// Limits of the memory store.
+const (
+	memoryMaxChars     = 1000
+	searchDefaultLimit = 5
+	searchMaxLimit     = 50
+)
+
+type MemorySource string
+
+const (
+	MemorySourceTool       MemorySource = "tool"
+	MemorySourceExtraction MemorySource = "extraction"
+	MemorySourceUser       MemorySource = "user"
+)
+
+var ErrMemoryNotFound = errors.New("memory not found")
+
+// Memory is a single remembered fact.
+type Memory struct {
+	ID      string       `json:"id"`
+	Content string       `json:"content"`
+	Source  MemorySource `json:"source"`
+	// SessionID is the session the memory was saved or extracted in
+	SessionID string    `json:"sessionId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Save remembers the fact. Saving a fact that is already remembered only
+// refreshes its UpdatedAt, so repeated extraction does not pile up duplicates.
+func Save(content string, source MemorySource, sessionID string) (memory *Memory, err error) {
+	defer logger.BreakOnError()
+
+	content = strings.Join(strings.Fields(content), " ")
+	if content == "" {
+		return nil, errors.New("memory content is empty")
+	}
+	if utf8.RuneCountInString(content) > memoryMaxChars {
+		return nil, fmt.Errorf("memory is longer than %d characters, save a shorter fact", memoryMaxChars)
+	}
+
+	db := storage.DB()
+
+	now := time.Now()
+	memory = &Memory{}
+	query := "SELECT id, content, source, session_id, created_at FROM memories WHERE lower(content)=lower(?);"
+	err = db.QueryRow(query, content).Scan(&memory.ID, &memory.Content, &memory.Source, &memory.SessionID, &memory.CreatedAt)
+	if err == nil {
+		memory.UpdatedAt = now
+		_, err = db.Exec("UPDATE memories SET updated_at=? WHERE id=?;", now, memory.ID)
+		log.CheckW(err, "Failed to refresh memory")
+		return memory, err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.CheckE(err, nil, "Failed to look up memory")
+	}
+
+	memory = &Memory{ID: uuid.NewString(), Content: content, Source: source, SessionID: sessionID, CreatedAt: now, UpdatedAt: now}
+
+	tx, err := db.Begin()
+	log.CheckE(err, nil, "Failed to start transaction")
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO memories (id, content, source, session_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?);",
+		memory.ID, memory.Content, memory.Source, memory.SessionID, memory.CreatedAt, memory.UpdatedAt)
+	log.CheckE(err, nil, "Failed to insert memory")
+	rowID, err := res.LastInsertId()
+	log.CheckE(err, nil, "Failed to get memory row id")
+	_, err = tx.Exec("INSERT INTO memories_fts (rowid, content) VALUES (?, ?);", rowID, memory.Content)
+	log.CheckE(err, nil, "Failed to index memory")
+
+	err = tx.Commit()
+	log.CheckW(err, "Failed to commit memory")
+	return memory, err
+}
+
+// LoadMemories returns all memories, most recently updated first.
+func LoadMemories() (memories []*Memory) {
+	log.D("Loading memories")
+	defer logger.BreakOnError()
+	memories = make([]*Memory, 0, 32)
+
+	db := storage.DB()
+
+	rows, err := db.Query("SELECT id, content, source, session_id, created_at, updated_at FROM memories ORDER BY updated_at DESC;")
+	log.CheckE(err, nil, "Failed to select memories from DB")
+	defer rows.Close()
+
+	return scanMemories(rows, memories)
+}
+
+func scanMemories(rows *sql.Rows, memories []*Memory) []*Memory {
+	for rows.Next() {
+		var memory Memory
+		err := rows.Scan(&memory.ID, &memory.Content, &memory.Source, &memory.SessionID, &memory.CreatedAt, &memory.UpdatedAt)
+		if err != nil {
+			log.W("Failed to scan memory row:", err)
+			continue
+		}
+		memories = append(memories, &memory)
+	}
+	return memories
+}
+
+// Forget deletes the memory.
+func Forget(id string) (err error) {
+	log.D("Deleting memory", id)
+	defer logger.BreakOnError()
+
+	db := storage.DB()
+
+	tx, err := db.Begin()
+	log.CheckE(err, nil, "Failed to start transaction")
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM memories_fts WHERE rowid IN (SELECT rowid FROM memories WHERE id=?);", id)
+	log.CheckE(err, nil, "Failed to delete memory index")
+	res, err := tx.Exec("DELETE FROM memories WHERE id=?;", id)
+	log.CheckE(err, nil, "Failed to delete memory")
+	if count, _ := res.RowsAffected(); count == 0 {
+		return ErrMemoryNotFound
+	}
+
+	err = tx.Commit()
+	log.CheckW(err, "Failed to commit memory deletion")
+	return
+}
+
+// Search returns memories relevant to the query ranked by BM25, or the most
+// recent memories when the query has no words.
+func Search(ctx context.Context, query string, limit int) ([]*Memory, error) {
+	if limit <= 0 {
+		limit = searchDefaultLimit
+	}
+	limit = min(limit, searchMaxLimit)
+
+	db := storage.DB()
+
+	memories := make([]*Memory, 0, limit)
+	match := util.FTSQuery(query)
+	if match == "" {
+		rows, err := db.QueryContext(ctx, "SELECT id, content, source, session_id, created_at, updated_at FROM memories ORDER BY updated_at DESC LIMIT ?;", limit)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		return scanMemories(rows, memories), rows.Err()
+	}
+
+	version, err := util.FTSVersion(ctx, db, "memories_fts")
+	if err != nil {
+		return nil, err
+	}
+	if version == "fts5" {
+		query := `
+		SELECT m.id, m.content, m.source, m.session_id, m.created_at, m.updated_at
+		FROM memories_fts f JOIN memories m ON m.rowid = f.rowid
+		WHERE memories_fts MATCH ? ORDER BY bm25(memories_fts) LIMIT ?;
+		`
+		rows, err := db.QueryContext(ctx, query, match, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		return scanMemories(rows, memories), rows.Err()
+	}
+
+	// FTS4 has no ranking function, BM25 is computed from matchinfo
+	query = `
+	SELECT m.id, m.content, m.source, m.session_id, m.created_at, m.updated_at, matchinfo(memories_fts, 'pcnalx')
+	FROM memories_fts f JOIN memories m ON m.rowid = f.rowid
+	WHERE memories_fts MATCH ?;
+	`
+	rows, err := db.QueryContext(ctx, query, match)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := make(map[*Memory]float64)
+	for rows.Next() {
+		var memory Memory
+		var info []byte
+		err = rows.Scan(&memory.ID, &memory.Content, &memory.Source, &memory.SessionID, &memory.CreatedAt, &memory.UpdatedAt, &info)
+		if err != nil {
+			return nil, err
+		}
+		scores[&memory] = util.BM25(info)
+		memories = append(memories, &memory)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	sortByScore(memories, scores)
+	if len(memories) > limit {
+		memories = memories[:limit]
+	}
+	return memories, nil
+}
+
+// Format renders memories for the model, one per line with its ID, so the
+// model can forget them.
+func Format(memories []*Memory) string {
+	var sb strings.Builder
+	for _, memory := range memories {
+		sb.WriteString(fmt.Sprintf("- [%s] %s (%s)\n", memory.ID, memory.Content, memory.UpdatedAt.Format("2006-01-02")))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func sortByScore(memories []*Memory, scores map[*Memory]float64) {
+	slices.SortStableFunc(memories, func(a, b *Memory) int {
+		return cmp.Compare(scores[b], scores[a])
+	})
+}
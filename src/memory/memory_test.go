@@ -0,0 +1,153 @@
+package memory
+
+import (
+	"agentsmith/src/ai"
+	"agentsmith/src/mcptools"
+	"agentsmith/src/store"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func useTestDB(t *testing.T) {
+	t.Helper()
+
+	db, err := store.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	UseStore(db)
+}
+
+func TestMemory_SaveSearchForget(t *testing.T) {
+	useTestDB(t)
+	ctx := context.Background()
+
+	golang, err := Save("The user prefers  Go for backend services.", MemorySourceUser, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Save("The user's cat is called Tom.", MemorySourceUser, "")
+	again, err := Save("the user prefers go for backend services.", MemorySourceTool, "s1")
+	if err != nil || again.ID != golang.ID || again.Content != "The user prefers Go for backend services." {
+		t.Fatalf("duplicate must refresh the existing memory, got %+v, %v", again, err)
+	}
+	if memories := LoadMemories(); len(memories) != 2 || memories[0].ID != golang.ID {
+		t.Fatalf("expected 2 memories, refreshed first, got %+v", memories)
+	}
+
+	found, err := Search(ctx, "Which language for the backend?", 5)
+	if err != nil || len(found) != 2 || found[0].ID != golang.ID {
+		t.Fatalf("Search = %+v, %v", found, err)
+	}
+	if recent, _ := Search(ctx, "?", 1); len(recent) != 1 || recent[0].ID != golang.ID {
+		t.Fatalf("query without words must return recent memories, got %+v", recent)
+	}
+
+	if err = Forget(golang.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = Forget(golang.ID); err != ErrMemoryNotFound {
+		t.Errorf("second Forget = %v", err)
+	}
+	if found, _ = Search(ctx, "backend services", 5); len(found) != 0 {
+		t.Fatalf("forgotten memory is still found: %+v", found)
+	}
+}
+
+func TestMemory_Tools(t *testing.T) {
+	useTestDB(t)
+	ctx := mcptools.WithSessionID(context.Background(), "session-1")
+
+	res, err := executeMemorySave(ctx, map[string]any{"content": "The project uses PostgreSQL 16."})
+	if err != nil || !strings.HasPrefix(res, "Saved memory ") {
+		t.Fatalf("memory_save = %q, %v", res, err)
+	}
+	id := strings.TrimPrefix(res, "Saved memory ")
+	if memories := LoadMemories(); memories[0].SessionID != "session-1" || memories[0].Source != MemorySourceTool {
+		t.Errorf("unexpected saved memory %+v", memories[0])
+	}
+
+	res, err = executeMemorySearch(ctx, map[string]any{"query": "postgresql"})
+	if err != nil || !strings.HasPrefix(res, "- ["+id+"] The project uses PostgreSQL 16.") {
+		t.Fatalf("memory_search = %q, %v", res, err)
+	}
+
+	if res, err = executeMemoryForget(ctx, map[string]any{"id": id}); err != nil {
+		t.Fatalf("memory_forget = %q, %v", res, err)
+	}
+	if res, _ = executeMemorySearch(ctx, map[string]any{}); res != "No memories found." {
+		t.Fatalf("memory_search after forget = %q", res)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	useTestDB(t)
+	Save("The user lives in Berlin.", MemorySourceUser, "")
+
+	var prompt string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []map[string]string `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		prompt = body.Messages[1]["content"]
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"choices": []map[string]any{{"message": map[string]string{
+			"role":    "assistant",
+			"content": "<think>hmm</think>```json\n[\"The user lives in Berlin.\", \"The user's team uses GitLab CI.\"]\n```",
+		}}}})
+	}))
+	defer server.Close()
+	model := &ai.Model{ID: "test"}
+	model.Provider = &ai.APIProvider{APIURL: server.URL, APIType: ai.APITypeOpenAICompatible}
+
+	messages := []*ai.Message{
+		{Origin: ai.MessageOriginUser, Text: "Our team in Berlin moved CI to GitLab."},
+		{Origin: ai.MessageOriginTool, Text: "tool output"},
+		{Origin: ai.MessageOriginAI, Text: "Noted."},
+	}
+	memories, err := Extract(context.Background(), model, messages, "s1")
+	if err != nil || len(memories) != 2 {
+		t.Fatalf("Extract = %+v, %v", memories, err)
+	}
+	if !strings.Contains(prompt, "User: Our team in Berlin moved CI to GitLab.\nAssistant: Noted.\n") || strings.Contains(prompt, "tool output") {
+		t.Errorf("unexpected transcript in prompt %q", prompt)
+	}
+	if !strings.Contains(prompt, "Already remembered:\n- The user lives in Berlin.") {
+		t.Errorf("known memories must be in the prompt %q", prompt)
+	}
+	if all := LoadMemories(); len(all) != 2 {
+		t.Fatalf("known fact must not be duplicated, got %+v", all)
+	}
+}
+
+func TestLastExtracted(t *testing.T) {
+	useTestDB(t)
+
+	if id, err := LastExtracted("s1"); err != nil || id != "" {
+		t.Fatalf("expected no extraction, got %q %v", id, err)
+	}
+	SetLastExtracted("s1", "m1")
+	SetLastExtracted("s1", "m2")
+	if id, _ := LastExtracted("s1"); id != "m2" {
+		t.Errorf("expected m2, got %q", id)
+	}
+	SetLastExtracted("s1", "")
+	if id, _ := LastExtracted("s1"); id != "" {
+		t.Errorf("expected forgotten extraction, got %q", id)
+	}
+}
+
+func TestParseFacts(t *testing.T) {
+	if facts, err := parseFacts("[]"); err != nil || len(facts) != 0 {
+		t.Errorf("parseFacts([]) = %v, %v", facts, err)
+	}
+	if _, err := parseFacts("Nothing to remember."); err == nil {
+		t.Error("response without array must fail")
+	}
+}
@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"agentsmith/src/mcptools"
+	"context"
+	"errors"
+)
+
+var memorySaveTool = &mcptools.Tool{
+	Name:           "memory_save",
+	Description:    "Remember a fact for future conversations: a preference of the user, a fact about their projects or environment, a decision. Save short self-contained facts, e.g. 'The user prefers Go for backend services.' Do not save secrets or things relevant only to the current conversation.",
+	RequiredParams: []string{"content"},
+	Params: []*mcptools.ToolParam{
+		{Name: "content", Type: "string", Description: "The fact to remember, one sentence."},
+	},
+}
+
+var memorySearchTool = &mcptools.Tool{
+	Name:           "memory_search",
+	Description:    "Search facts remembered from previous conversations with the user. Returns matching memories with their IDs.",
+	RequiredParams: []string{},
+	Params: []*mcptools.ToolParam{
+		{Name: "query", Type: "string", Description: "Keywords to search for. The most recent memories are returned when empty."},
+		{Name: "limit", Type: "integer", Description: "Maximum number of memories, 5 by default."},
+	},
+}
+
+var memoryForgetTool = &mcptools.Tool{
+	Name:           "memory_forget",
+	Description:    "Forget a remembered fact that is wrong or outdated, or that the user asked to forget.",
+	RequiredParams: []string{"id"},
+	Params: []*mcptools.ToolParam{
+		{Name: "id", Type: "string", Description: "ID of the memory, as returned by memory_search."},
+	},
+}
+
+func init() {
+	mcptools.RegisterBuiltinTool(mcptools.NewBuiltinTool(memorySaveTool, executeMemorySave))
+	mcptools.RegisterBuiltinTool(mcptools.NewBuiltinTool(memorySearchTool, executeMemorySearch))
+	mcptools.RegisterBuiltinTool(mcptools.NewBuiltinTool(memoryForgetTool, executeMemoryForget))
+}
+
+func executeMemorySave(ctx context.Context, params map[string]any) (string, error) {
+	content, _ := params["content"].(string)
+	memory, err := Save(content, MemorySourceTool, mcptools.SessionID(ctx))
+	if err != nil {
+		return "", err
+	}
+	return "Saved memory " + memory.ID, nil
+}
+
+func executeMemorySearch(ctx context.Context, params map[string]any) (string, error) {
+	query, _ := params["query"].(string)
+	limit, _ := params["limit"].(float64)
+	memories, err := Search(ctx, query, int(limit))
+	if err != nil {
+		return "", err
+	}
+	if len(memories) == 0 {
+		return "No memories found.", nil
+	}
+	return Format(memories), nil
+}
+
+func executeMemoryForget(ctx context.Context, params map[string]any) (string, error) {
+	id, _ := params["id"].(string)
+	if id == "" {
+		return "", errors.New("missing id parameter")
+	}
+	if err := Forget(id); err != nil {
+		return "", err
+	}
+	return "Forgot memory " + id, nil
+}
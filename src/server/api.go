@@ -4,7 +4,12 @@ import (
 	"agentsmith/src/agent"
 	"agentsmith/src/logger"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -108,6 +113,206 @@ func deleteMessageHandler(c *gin.Context) {
 
 }
 
+/*
+Full text search in messages of all sessions, on all branches. Optional origin (user, assistant, tool) and from/to
+dates (RFC 3339, compared with the session date) filter the hits. Hits are returned best first with session and
+message IDs and an HTML escaped snippet with matched words in <mark> tags.
+*/
+var searchSessionsURI = "/sessions/search"
+
+func searchSessionsHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req agent.SessionSearch
+	err := c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	hits, err := agent.SearchSessions(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(500, map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"hits": hits})
+	}
+}
+
+/*
+Get URLs fetched by http_fetch tool in the session
+*/
+var sessionFetchesURI = "/sessions/:sessionId/fetches"
+
+type sessionFetchesReq struct {
+	SessionID string `uri:"sessionId" binding:"required"`
+}
+
+func sessionFetchesHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req sessionFetchesReq
+	err := c.BindUri(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	fetches, err := agent.GetFetchAudit(req.SessionID)
+	if err != nil {
+		c.JSON(500, map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"fetches": fetches})
+	}
+}
+
+/*
+Export the session as a file: md (default) and html render the active branch with tool calls and results collapsed,
+html is a single self-contained page, json has messages of all branches and can be imported. Reasoning of the models
+is stripped unless thinking=keep.
+*/
+var exportSessionURI = "/sessions/:sessionId/export"
+
+type exportSessionReq struct {
+	SessionID string `uri:"sessionId" binding:"required"`
+	agent.ExportOptions
+}
+
+func exportSessionHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req exportSessionReq
+	err := c.BindUri(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+	err = c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	export, err := agent.ExportSession(req.SessionID, req.ExportOptions)
+	writeExport(c, export, err, 404)
+}
+
+/*
+Export all sessions as a zip file with one file per session, takes the same format and thinking parameters as the
+export of one session
+*/
+var exportSessionsURI = "/sessions/export"
+
+func exportSessionsHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req agent.ExportOptions
+	err := c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	export, err := agent.ExportSessions(req)
+	writeExport(c, export, err, 500)
+}
+
+// writeExport sends the exported file as an attachment, errors other than bad
+// options are sent with errStatus.
+func writeExport(c *gin.Context, export *agent.Export, err error, errStatus int) {
+	switch {
+	case errors.Is(err, agent.ErrUnknownExportFormat), errors.Is(err, agent.ErrUnknownThinkingOption):
+		c.JSON(400, map[string]any{"error": err.Error()})
+	case err != nil:
+		c.JSON(errStatus, map[string]any{"error": err.Error()})
+	default:
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.Name))
+		c.Data(200, export.ContentType, export.Data)
+	}
+}
+
+/*
+Import sessions from files uploaded as multipart form with one or more "files": JSON exports of sessions (one session,
+an array of them or a zip of JSON files) or conversations.json of a ChatGPT data export (or the export zip). Sessions
+imported before are skipped, the report lists imported and skipped sessions and messages left out of imported ones.
+*/
+var importSessionsURI = "/sessions/import"
+
+func importSessionsHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	form, err := c.MultipartForm()
+	log.CheckE(err, func() { c.JSON(400, map[string]any{"error": err.Error()}) }, "Failed to read uploaded files")
+	files := form.File["files"]
+	if len(files) == 0 {
+		c.JSON(400, map[string]any{"error": "no files uploaded"})
+		return
+	}
+
+	report := &agent.ImportReport{Imported: make([]*agent.ImportedSession, 0), Skipped: make([]*agent.SkippedImport, 0)}
+	errs := make(map[string]string)
+	for _, file := range files {
+		data, err := readUploadedFile(file)
+		if err != nil {
+			errs[file.Filename] = err.Error()
+			continue
+		}
+		fileReport, err := agent.ImportSessions(file.Filename, data)
+		if err != nil {
+			errs[file.Filename] = err.Error()
+			continue
+		}
+		report.Imported = append(report.Imported, fileReport.Imported...)
+		report.Skipped = append(report.Skipped, fileReport.Skipped...)
+	}
+
+	status := 200
+	if len(errs) == len(files) {
+		status = 400
+	}
+	c.JSON(status, map[string]any{"imported": report.Imported, "skipped": report.Skipped, "errors": errs})
+}
+
+/*
+Start a new branch at the message, the next message sent to the session becomes an alternative to it
+*/
+var forkSessionURI = "/sessions/:sessionId/fork/:messageId"
+
+type BranchReq struct {
+	SessionID string `uri:"sessionId" binding:"required"`
+	MessageID string `uri:"messageId" binding:"required"`
+}
+
+func forkSessionHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req BranchReq
+	err := c.BindUri(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	session, err := agent.ForkSession(req.SessionID, req.MessageID)
+	writeBranchResponse(c, session, err)
+}
+
+/*
+Switch session to the branch containing the message, continuing with the latest replies to it
+*/
+var switchBranchURI = "/sessions/:sessionId/branch/:messageId"
+
+func switchBranchHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req BranchReq
+	err := c.BindUri(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	session, err := agent.SwitchBranch(req.SessionID, req.MessageID)
+	writeBranchResponse(c, session, err)
+}
+
+func writeBranchResponse(c *gin.Context, session *agent.Session, err error) {
+	if err != nil {
+		c.JSON(branchErrorStatus(err), map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"session": session})
+	}
+}
+
+func branchErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, agent.ErrSessionRunning):
+		return 409
+	case errors.Is(err, agent.ErrNotUserMessage), errors.Is(err, agent.ErrNoUserMessage):
+		return 400
+	default:
+		return 404
+	}
+}
+
 /*
 Get list of available models
 */
@@ -117,6 +322,31 @@ func listModelsHandler(c *gin.Context) {
 	c.JSON(200, map[string]any{"models": agent.GetModels()})
 }
 
+/*
+Compute embeddings of input texts with embedding model. Embeddings are returned in the order of input.
+*/
+var embeddingsURI = "/embeddings"
+
+type embeddingsReq struct {
+	Model string   `json:"model" binding:"required"`
+	Input []string `json:"input" binding:"required,min=1"`
+}
+
+func embeddingsHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req embeddingsReq
+	err := c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	embeddings, err := agent.Embed(c.Request.Context(), req.Model, req.Input)
+	if err != nil {
+		c.JSON(500, map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"model": req.Model, "embeddings": embeddings})
+	}
+}
+
 /*
 Get list of available AI providers
 */
@@ -132,6 +362,9 @@ Test AI provider connectivity
 var testProviderURI = "/provider/test"
 
 type testProviderReq struct {
+	// ID is of the provider being edited, its API key is used when the
+	// masked one is sent back
+	ID        string `json:"id,omitempty"`
 	Name      string `json:"name" binding:"required"`
 	APIURL    string `json:"url" binding:"required"`
 	APIKey    string `json:"apiKey,omitempty"`
@@ -145,7 +378,7 @@ func testProviderHandler(c *gin.Context) {
 	err := c.Bind(&req)
 	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
 
-	c.JSON(200, map[string]any{"response": agent.TesProvider(req.Name, req.APIURL, req.APIKey, req.RateLimit)})
+	c.JSON(200, map[string]any{"response": agent.TesProvider(req.ID, req.Name, req.APIURL, req.APIKey, req.RateLimit)})
 }
 
 /*
@@ -231,14 +464,19 @@ func deleteProviderHandler(c *gin.Context) {
 /*
 API for sending message to AI directly and get response via system SSE connection. This call will return when generation ends.
 No tools will be called in response.
+ID of the registered run is returned in X-Run-ID header.
+Optional responseSchema (JSON Schema, overrides the schema of the role) makes the answer structured: the model
+is asked to fix an answer that does not match it, and the validated JSON is written to the response body when
+generation ends. It's also stored in the structured field of the message.
 */
 var directChatStreamURI = "/directchat/stream"
 
 type directChatStreamReq struct {
-	SessionID string `json:"sessionID" binding:"required"`
-	ModelID   string `json:"modelID" binding:"required"`
-	RoleID    string `json:"roleID"`
-	Message   string `json:"message" binding:"required"`
+	SessionID      string         `json:"sessionID" binding:"required"`
+	ModelID        string         `json:"modelID" binding:"required"`
+	RoleID         string         `json:"roleID"`
+	Message        string         `json:"message" binding:"required"`
+	ResponseSchema map[string]any `json:"responseSchema,omitempty"`
 }
 
 func directChatStreamHandler(c *gin.Context) {
@@ -247,17 +485,32 @@ func directChatStreamHandler(c *gin.Context) {
 	var req directChatStreamReq
 	err := c.Bind(&req)
 	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+	if err = agent.CheckResponseSchema(req.ResponseSchema); err != nil {
+		c.JSON(400, map[string]any{"error": err.Error()})
+		return
+	}
 
-	streamDoneCh := make(chan bool)
+	// buffered so the agent can report completion after the client has gone away
+	streamDoneCh := make(chan bool, 1)
 
-	go agent.DirectChatStreaming(c.Request.Context(), req.SessionID, req.ModelID, req.RoleID, strings.TrimSpace(req.Message), streamDoneCh)
+	run, err := agent.StartRun(c.Request.Context(), agent.RunKindDirectChat, req.SessionID, req.ModelID)
+	if err != nil {
+		c.JSON(branchErrorStatus(err), map[string]any{"error": err.Error()})
+		return
+	}
+	c.Header("X-Run-ID", run.ID)
+
+	go agent.DirectChatStreaming(run, req.RoleID, strings.TrimSpace(req.Message), req.ResponseSchema, streamDoneCh)
 
 	// blocking call
 	c.Stream(func(w io.Writer) bool {
 		for {
 			select {
-			case <-streamDoneCh:
+			case finished := <-streamDoneCh:
 				log.D("Stream finalized")
+				if finished {
+					writeStructured(w, run)
+				}
 				c.Status(200)
 				return false
 			case <-c.Request.Context().Done():
@@ -277,15 +530,28 @@ calling this as a tool.
 Internal behavior is same as tool streaming chat, meaning it can call tools and even recursively call this API.
 The difference to regular tool chat API is that output returned as complete message instead of streaming.
 In this mode agent also considers a depth of recursion and may decide to break it if deemed too deep to prevent infinite loops.
+Callers that are agents themselves pass their depth in X-Agent-Depth header, the agent runs one level deeper.
 No messages or sessions are saved during this call.
-Agent configured only via system prompt
+Agent configured only via system prompt and names of the tools it may use.
+Response contains the final answer and a trace of the whole conversation, including sub-agents.
+With optional responseSchema (JSON Schema) the answer must be JSON matching it, the validated value is returned
+in the structured field.
 */
 var dynamicAgentChatURI = "/dynamicagentchat"
 
+const agentDepthHeader = "X-Agent-Depth"
+
 type dynamicAgentChatReq struct {
-	ModelID   string `json:"modelID" binding:"required"`
-	Message   string `json:"message" binding:"required"`
-	SysPrompt string `json:"sysPrompt" binding:"required"`
+	ModelID     string   `json:"modelID" binding:"required"`
+	Message     string   `json:"message" binding:"required"`
+	SysPrompt   string   `json:"sysPrompt" binding:"required"`
+	Tools       []string `json:"tools,omitempty"`
+	MaxSteps    int      `json:"maxSteps,omitempty"`
+	MaxDuration int      `json:"maxDuration,omitempty"`
+	MaxTokens   int      `json:"maxTokens,omitempty"`
+	MaxRepeats  int      `json:"maxRepeats,omitempty"`
+
+	ResponseSchema map[string]any `json:"responseSchema,omitempty"`
 }
 
 func dynamicAgentChatHandler(c *gin.Context) {
@@ -294,12 +560,33 @@ func dynamicAgentChatHandler(c *gin.Context) {
 	var req dynamicAgentChatReq
 	err := c.Bind(&req)
 	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+	if err = agent.CheckResponseSchema(req.ResponseSchema); err != nil {
+		c.JSON(400, map[string]any{"error": err.Error()})
+		return
+	}
 
-	response, err := agent.DynamicAgentChat(req.ModelID, req.Message, req.SysPrompt)
-	if err != nil {
-		c.JSON(500, map[string]string{"error": "Unknown error"})
-	} else {
-		c.JSON(200, map[string]string{"response": response, "error": ""})
+	depth := 0
+	if header := c.GetHeader(agentDepthHeader); header != "" {
+		depth, err = strconv.Atoi(header)
+		if err != nil || depth < 0 {
+			c.JSON(400, map[string]any{"error": "invalid " + agentDepthHeader + " header"})
+			return
+		}
+	}
+
+	ctx := agent.WithAgentDepth(c.Request.Context(), depth)
+	limits := agent.DefaultRunLimits.WithOverrides(req.MaxSteps, time.Duration(req.MaxDuration)*time.Second, req.MaxTokens, req.MaxRepeats)
+
+	result, err := agent.DynamicAgentChat(ctx, req.ModelID, strings.TrimSpace(req.Message), req.SysPrompt, req.Tools, req.ResponseSchema, limits)
+	switch {
+	case errors.Is(err, agent.ErrModelNotFound):
+		c.JSON(404, map[string]any{"error": err.Error()})
+	case errors.Is(err, agent.ErrAgentDepth):
+		c.JSON(http.StatusLoopDetected, map[string]any{"error": err.Error()})
+	case err != nil:
+		c.JSON(500, map[string]any{"response": result.Response, "trace": result.Trace, "tokens": result.Tokens, "error": err.Error()})
+	default:
+		c.JSON(200, map[string]any{"response": result.Response, "structured": result.Structured, "trace": result.Trace, "tokens": result.Tokens, "error": ""})
 	}
 }
 
@@ -307,15 +594,25 @@ func dynamicAgentChatHandler(c *gin.Context) {
 API for sending message to AI through an agent. Internally agent and LLM can exchange multiple messages
 and evet include user in this loop. Loop can be broken by LLM, user prompt or if agent detects recursion
 LLM can call tools or dynamic agents.
+Optional limits override the default circuit breakers of the run (max tool steps, duration in seconds,
+total tokens, identical repeats). When a limit trips the run ends with run_limit_exceeded SSE event.
+ID of the registered run is returned in X-Run-ID header.
+Optional responseSchema works as in direct chat, the validated JSON is the last line of the response body.
 Response is SSE stream
 */
 var toolChatStreamURI = "/toolchat/stream"
 
 type toolChatStreamReq struct {
-	SessionID string `json:"sessionID"`
-	ModelID   string `json:"modelID" binding:"required"`
-	RoleID    string `json:"roleID"`
-	Message   string `json:"message" binding:"required"`
+	SessionID   string `json:"sessionID"`
+	ModelID     string `json:"modelID" binding:"required"`
+	RoleID      string `json:"roleID"`
+	Message     string `json:"message" binding:"required"`
+	MaxSteps    int    `json:"maxSteps,omitempty"`
+	MaxDuration int    `json:"maxDuration,omitempty"`
+	MaxTokens   int    `json:"maxTokens,omitempty"`
+	MaxRepeats  int    `json:"maxRepeats,omitempty"`
+
+	ResponseSchema map[string]any `json:"responseSchema,omitempty"`
 }
 
 func toolChatStreamHandler(c *gin.Context) {
@@ -324,17 +621,34 @@ func toolChatStreamHandler(c *gin.Context) {
 	var req toolChatStreamReq
 	err := c.Bind(&req)
 	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+	if err = agent.CheckResponseSchema(req.ResponseSchema); err != nil {
+		c.JSON(400, map[string]any{"error": err.Error()})
+		return
+	}
+
+	// buffered so the agent can report completion after the client has gone away
+	streamDoneCh := make(chan bool, 1)
 
-	streamDoneCh := make(chan bool)
+	limits := agent.DefaultRunLimits.WithOverrides(req.MaxSteps, time.Duration(req.MaxDuration)*time.Second, req.MaxTokens, req.MaxRepeats)
+
+	run, err := agent.StartRun(c.Request.Context(), agent.RunKindToolChat, req.SessionID, req.ModelID)
+	if err != nil {
+		c.JSON(branchErrorStatus(err), map[string]any{"error": err.Error()})
+		return
+	}
+	c.Header("X-Run-ID", run.ID)
 
-	go agent.ToolChatStreaming(c.Request.Context(), req.SessionID, req.ModelID, req.RoleID, strings.TrimSpace(req.Message), streamDoneCh)
+	go agent.ToolChatStreaming(run, req.RoleID, strings.TrimSpace(req.Message), req.ResponseSchema, limits, streamDoneCh)
 
 	// blocking call
 	c.Stream(func(w io.Writer) bool {
 		for {
 			select {
-			case <-streamDoneCh:
+			case finished := <-streamDoneCh:
 				log.D("Stream finalized")
+				if finished {
+					writeStructured(w, run)
+				}
 				c.Status(200)
 				return false
 			case <-c.Request.Context().Done():
@@ -351,6 +665,215 @@ func toolChatStreamHandler(c *gin.Context) {
 	})
 }
 
+// writeStructured writes the validated answer of a run with a response schema
+// as the last line of the stream.
+func writeStructured(w io.Writer, run *agent.Run) {
+	if run.Structured == nil {
+		return
+	}
+	data, err := json.Marshal(run.Structured)
+	if err != nil {
+		log.W("Failed to marshal structured response:", err)
+		return
+	}
+	w.Write(append([]byte("\n"), data...))
+}
+
+/*
+Edit text of the user message and run the agent from it again. The edited message becomes an alternative to the
+original one, which is kept along with its answers and can be switched back to with the branch API.
+With tools set the agent may call tools as in tool chat, otherwise the model answers directly. Other parameters
+and the response are the same as in the chat APIs.
+*/
+var editMessageURI = "/sessions/:sessionId/messages/edit/:messageId"
+
+type rerunReq struct {
+	ModelID     string `json:"modelID" binding:"required"`
+	RoleID      string `json:"roleID"`
+	Message     string `json:"message"`
+	Tools       bool   `json:"tools"`
+	MaxSteps    int    `json:"maxSteps,omitempty"`
+	MaxDuration int    `json:"maxDuration,omitempty"`
+	MaxTokens   int    `json:"maxTokens,omitempty"`
+	MaxRepeats  int    `json:"maxRepeats,omitempty"`
+
+	ResponseSchema map[string]any `json:"responseSchema,omitempty"`
+}
+
+func editMessageHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var target BranchReq
+	err := c.BindUri(&target)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+	var req rerunReq
+	err = c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	message := strings.TrimSpace(req.Message)
+	if message == "" {
+		c.JSON(400, map[string]any{"error": "message is empty"})
+		return
+	}
+	if err = agent.CheckResponseSchema(req.ResponseSchema); err != nil {
+		c.JSON(400, map[string]any{"error": err.Error()})
+		return
+	}
+	if _, err = agent.EditMessage(target.SessionID, target.MessageID); err != nil {
+		c.JSON(branchErrorStatus(err), map[string]any{"error": err.Error()})
+		return
+	}
+	streamRerun(c, target.SessionID, req, message, false)
+}
+
+/*
+Generate a new answer to the user message, or to the user message the given answer belongs to, with the model
+and role of the request, which may differ from the ones that answered before. Previous answers are kept as
+alternatives, each assistant message records the model that generated it in modelId.
+Parameters other than message and the response are the same as in the edit API.
+*/
+var regenerateMessageURI = "/sessions/:sessionId/messages/regenerate/:messageId"
+
+func regenerateMessageHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var target BranchReq
+	err := c.BindUri(&target)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+	var req rerunReq
+	err = c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	if err = agent.CheckResponseSchema(req.ResponseSchema); err != nil {
+		c.JSON(400, map[string]any{"error": err.Error()})
+		return
+	}
+	if _, err = agent.RegenerateMessage(target.SessionID, target.MessageID); err != nil {
+		c.JSON(branchErrorStatus(err), map[string]any{"error": err.Error()})
+		return
+	}
+	streamRerun(c, target.SessionID, req, "", true)
+}
+
+// streamRerun starts a chat run for the edited query, or for regeneration of
+// the answer the session branch was prepared for, and streams it as the chat
+// APIs do.
+func streamRerun(c *gin.Context, sessionID string, req rerunReq, query string, regenerate bool) {
+	// buffered so the agent can report completion after the client has gone away
+	streamDoneCh := make(chan bool, 1)
+
+	limits := agent.DefaultRunLimits.WithOverrides(req.MaxSteps, time.Duration(req.MaxDuration)*time.Second, req.MaxTokens, req.MaxRepeats)
+
+	var kind agent.RunKind = agent.RunKindDirectChat
+	if req.Tools {
+		kind = agent.RunKindToolChat
+	}
+	run, err := agent.StartRun(c.Request.Context(), kind, sessionID, req.ModelID)
+	if err != nil {
+		c.JSON(branchErrorStatus(err), map[string]any{"error": err.Error()})
+		return
+	}
+	c.Header("X-Run-ID", run.ID)
+
+	switch {
+	case req.Tools && regenerate:
+		go agent.ToolChatRegenerate(run, req.RoleID, req.ResponseSchema, limits, streamDoneCh)
+	case req.Tools:
+		go agent.ToolChatStreaming(run, req.RoleID, query, req.ResponseSchema, limits, streamDoneCh)
+	case regenerate:
+		go agent.DirectChatRegenerate(run, req.RoleID, req.ResponseSchema, streamDoneCh)
+	default:
+		go agent.DirectChatStreaming(run, req.RoleID, query, req.ResponseSchema, streamDoneCh)
+	}
+
+	// blocking call
+	c.Stream(func(w io.Writer) bool {
+		for {
+			select {
+			case finished := <-streamDoneCh:
+				log.D("Stream finalized")
+				if finished {
+					writeStructured(w, run)
+				}
+				c.Status(200)
+				return false
+			case <-c.Request.Context().Done():
+				return false
+			case <-time.After(30 * time.Second):
+				w.Write([]byte("."))
+				c.Writer.Flush()
+			}
+		}
+	})
+}
+
+/*
+Get list of running and recently finished chat runs
+*/
+var listRunsURI = "/runs"
+
+func listRunsHandler(c *gin.Context) {
+	c.JSON(200, map[string]any{"runs": agent.GetRuns()})
+}
+
+/*
+Cancel a running chat run. This stops model generation, in-flight tool calls and rate limit waits.
+*/
+var cancelRunURI = "/runs/:id/cancel"
+
+type cancelRunReq struct {
+	ID string `uri:"id" binding:"required"`
+}
+
+func cancelRunHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req cancelRunReq
+	err := c.BindUri(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	err = agent.CancelRun(req.ID)
+	if err != nil {
+		c.JSON(404, map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"error": nil})
+	}
+}
+
+/*
+Get list of tool calls waiting for user approval
+*/
+var listApprovalsURI = "/approvals"
+
+func listApprovalsHandler(c *gin.Context) {
+	c.JSON(200, map[string]any{"approvals": agent.GetPendingApprovals()})
+}
+
+/*
+Approve or deny a pending tool call. Decision is either "approve" or "deny".
+*/
+var resolveApprovalURI = "/approvals/:id/:decision"
+
+type resolveApprovalReq struct {
+	ID       string `uri:"id" binding:"required"`
+	Decision string `uri:"decision" binding:"required,oneof=approve deny"`
+}
+
+func resolveApprovalHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req resolveApprovalReq
+	err := c.BindUri(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	err = agent.ResolveApproval(req.ID, req.Decision == "approve")
+	if err != nil {
+		c.JSON(404, map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"error": nil})
+	}
+}
+
 /*
 Get list of available roles
 */
@@ -362,11 +885,17 @@ func listRolesHandler(c *gin.Context) {
 }
 
 type roleReq struct {
-	ID                 string `json:"id,omitempty"`
-	Name               string `json:"name" binding:"required"`
-	GeneralInstruction string `json:"generalInstruction"`
-	Role               string `json:"role"`
-	Style              string `json:"style"`
+	ID                 string         `json:"id,omitempty"`
+	Name               string         `json:"name" binding:"required"`
+	GeneralInstruction string         `json:"generalInstruction"`
+	Role               string         `json:"role"`
+	Style              string         `json:"style"`
+	CodeRunner         string         `json:"codeRunner" binding:"omitempty,oneof=js lua both none"`
+	KnowledgeBases     []string       `json:"knowledgeBases"`
+	RetrievalTopK      int            `json:"retrievalTopK" binding:"min=0,max=20"`
+	Memory             bool           `json:"memory"`
+	ExtractMemories    bool           `json:"extractMemories"`
+	ResponseSchema     map[string]any `json:"responseSchema,omitempty"`
 }
 
 /*
@@ -384,6 +913,12 @@ func createRoleHandler(c *gin.Context) {
 		GeneralInstruction: req.GeneralInstruction,
 		Role:               req.Role,
 		Style:              req.Style,
+		CodeRunner:         agent.CodeRunner(req.CodeRunner),
+		KnowledgeBases:     req.KnowledgeBases,
+		RetrievalTopK:      req.RetrievalTopK,
+		Memory:             req.Memory,
+		ExtractMemories:    req.ExtractMemories,
+		ResponseSchema:     req.ResponseSchema,
 	})
 	if err == nil {
 		c.JSON(200, map[string]any{"role": role})
@@ -407,6 +942,12 @@ func updateRoleHandler(c *gin.Context) {
 		GeneralInstruction: req.GeneralInstruction,
 		Role:               req.Role,
 		Style:              req.Style,
+		CodeRunner:         agent.CodeRunner(req.CodeRunner),
+		KnowledgeBases:     req.KnowledgeBases,
+		RetrievalTopK:      req.RetrievalTopK,
+		Memory:             req.Memory,
+		ExtractMemories:    req.ExtractMemories,
+		ResponseSchema:     req.ResponseSchema,
 	})
 	if err == nil {
 		c.JSON(200, map[string]any{"role": role})
@@ -587,6 +1128,454 @@ func reloadAllMCPServersHandler(c *gin.Context) {
 	c.JSON(200, map[string]any{"error": nil})
 }
 
+/*
+Get list of builtin tools, including disabled ones
+*/
+var listBuiltinToolsURI = "/builtins/list"
+
+func listBuiltinToolsHandler(c *gin.Context) {
+	c.JSON(200, map[string]any{"builtinTools": agent.ListBuiltinTools()})
+}
+
+/*
+Enable or disable builtin tool. Disabled tools are not offered to models.
+*/
+var updateBuiltinToolURI = "/builtins/update"
+
+type updateBuiltinToolReq struct {
+	Name    string `json:"name" binding:"required"`
+	Enabled bool   `json:"enabled"`
+}
+
+func updateBuiltinToolHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req updateBuiltinToolReq
+	err := c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	err = agent.SetBuiltinToolEnabled(req.Name, req.Enabled)
+	if err == nil {
+		c.JSON(200, map[string]any{"error": nil})
+	} else {
+		c.JSON(500, map[string]any{"error": err.Error()})
+	}
+}
+
+/*
+Execute builtin tool with given parameters and return its result. Tools that require approval wait for
+/approvals/:id/approve first, a denied call returns 403.
+*/
+var testBuiltinToolURI = "/builtins/test"
+
+type testBuiltinToolReq struct {
+	Name   string         `json:"name" binding:"required"`
+	Params map[string]any `json:"params"`
+}
+
+func testBuiltinToolHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req testBuiltinToolReq
+	err := c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	response, err := agent.TestBuiltinTool(c.Request.Context(), req.Name, req.Params)
+	if err == nil {
+		c.JSON(200, map[string]any{"response": response, "error": nil})
+	} else if errors.Is(err, agent.ErrToolCallDenied) {
+		c.JSON(403, map[string]any{"response": response, "error": err.Error()})
+	} else {
+		c.JSON(500, map[string]any{"response": response, "error": err.Error()})
+	}
+}
+
+/*
+Get list of datasets attached for the SQL tools
+*/
+var listDatasetsURI = "/datasets/list"
+
+func listDatasetsHandler(c *gin.Context) {
+	c.JSON(200, map[string]any{"datasets": agent.GetDatasets()})
+}
+
+/*
+Attach local SQLite (.db, .sqlite, .sqlite3) or CSV file as a dataset. Files are opened read-only.
+*/
+var attachDatasetURI = "/datasets/attach"
+
+type attachDatasetReq struct {
+	Name string `json:"name" binding:"required"`
+	Path string `json:"path" binding:"required"`
+}
+
+func attachDatasetHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req attachDatasetReq
+	err := c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	dataset, err := agent.AttachDataset(req.Name, req.Path)
+	if err == nil {
+		c.JSON(200, map[string]any{"dataset": dataset})
+	} else {
+		c.JSON(400, map[string]any{"error": err.Error()})
+	}
+}
+
+/*
+Detach dataset by id. The file itself is not deleted.
+*/
+var deleteDatasetURI = "/datasets/delete/:id"
+
+type deleteDatasetReq struct {
+	ID string `uri:"id" binding:"required"`
+}
+
+func deleteDatasetHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req deleteDatasetReq
+	err := c.BindUri(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	err = agent.DeleteDataset(req.ID)
+	if err != nil {
+		c.JSON(404, map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"error": nil})
+	}
+}
+
+/*
+Get list of knowledge bases
+*/
+var listKnowledgeBasesURI = "/kb/list"
+
+func listKnowledgeBasesHandler(c *gin.Context) {
+	c.JSON(200, map[string]any{"knowledgeBases": agent.GetKnowledgeBases()})
+}
+
+/*
+Create knowledge base. Optional embeddingModel is the ID of the model used to embed chunks
+in addition to full text search.
+*/
+var createKnowledgeBaseURI = "/kb/create"
+
+type createKnowledgeBaseReq struct {
+	Name           string `json:"name" binding:"required"`
+	Description    string `json:"description"`
+	EmbeddingModel string `json:"embeddingModel"`
+}
+
+func createKnowledgeBaseHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req createKnowledgeBaseReq
+	err := c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	kb, err := agent.CreateKnowledgeBase(req.Name, req.Description, req.EmbeddingModel)
+	if err == nil {
+		c.JSON(200, map[string]any{"knowledgeBase": kb})
+	} else {
+		c.JSON(400, map[string]any{"error": err.Error()})
+	}
+}
+
+/*
+Update description and embedding model of knowledge base
+*/
+var updateKnowledgeBaseURI = "/kb/update"
+
+type updateKnowledgeBaseReq struct {
+	ID             string `json:"id" binding:"required"`
+	Description    string `json:"description"`
+	EmbeddingModel string `json:"embeddingModel"`
+}
+
+func updateKnowledgeBaseHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req updateKnowledgeBaseReq
+	err := c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	kb, err := agent.UpdateKnowledgeBase(req.ID, req.Description, req.EmbeddingModel)
+	if err == nil {
+		c.JSON(200, map[string]any{"knowledgeBase": kb})
+	} else {
+		c.JSON(404, map[string]any{"error": err.Error()})
+	}
+}
+
+/*
+Delete knowledge base with all its documents
+*/
+var deleteKnowledgeBaseURI = "/kb/delete/:id"
+
+type knowledgeBaseReq struct {
+	ID string `uri:"id" binding:"required"`
+}
+
+func deleteKnowledgeBaseHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req knowledgeBaseReq
+	err := c.BindUri(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	err = agent.DeleteKnowledgeBase(req.ID)
+	if err != nil {
+		c.JSON(404, map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"error": nil})
+	}
+}
+
+/*
+Get documents of knowledge base
+*/
+var listKnowledgeDocumentsURI = "/kb/:id/documents"
+
+func listKnowledgeDocumentsHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req knowledgeBaseReq
+	err := c.BindUri(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	docs, err := agent.GetKnowledgeDocuments(req.ID)
+	if err != nil {
+		c.JSON(404, map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"documents": docs})
+	}
+}
+
+/*
+Upload text, markdown or code files to knowledge base as multipart form with one or more "files".
+Files are chunked and indexed, a file with the same name replaces the previous document.
+*/
+var uploadKnowledgeDocumentsURI = "/kb/:id/documents/upload"
+
+func uploadKnowledgeDocumentsHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req knowledgeBaseReq
+	err := c.BindUri(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	form, err := c.MultipartForm()
+	log.CheckE(err, func() { c.JSON(400, map[string]any{"error": err.Error()}) }, "Failed to read uploaded files")
+	files := form.File["files"]
+	if len(files) == 0 {
+		c.JSON(400, map[string]any{"error": "no files uploaded"})
+		return
+	}
+
+	docs := make([]any, 0, len(files))
+	errs := make(map[string]string)
+	for _, file := range files {
+		data, err := readUploadedFile(file)
+		if err != nil {
+			errs[file.Filename] = err.Error()
+			continue
+		}
+		doc, err := agent.AddKnowledgeDocument(c.Request.Context(), req.ID, file.Filename, data)
+		if err != nil {
+			errs[file.Filename] = err.Error()
+			continue
+		}
+		docs = append(docs, doc)
+	}
+
+	if len(docs) == 0 {
+		c.JSON(400, map[string]any{"documents": docs, "errors": errs})
+	} else {
+		c.JSON(200, map[string]any{"documents": docs, "errors": errs})
+	}
+}
+
+// uploadMaxFileBytes is the size of the largest file that can be uploaded to
+// import sessions or add knowledge base documents.
+const uploadMaxFileBytes = 64 << 20
+
+func readUploadedFile(file *multipart.FileHeader) ([]byte, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, uploadMaxFileBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > uploadMaxFileBytes {
+		return nil, fmt.Errorf("file is larger than %d bytes", uploadMaxFileBytes)
+	}
+	return data, nil
+}
+
+/*
+Delete document from knowledge base
+*/
+var deleteKnowledgeDocumentURI = "/kb/:id/documents/delete/:docId"
+
+type deleteKnowledgeDocumentReq struct {
+	ID    string `uri:"id" binding:"required"`
+	DocID string `uri:"docId" binding:"required"`
+}
+
+func deleteKnowledgeDocumentHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req deleteKnowledgeDocumentReq
+	err := c.BindUri(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	err = agent.DeleteKnowledgeDocument(req.ID, req.DocID)
+	if err != nil {
+		c.JSON(404, map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"error": nil})
+	}
+}
+
+/*
+Search knowledge bases by names, all knowledge bases are searched when kbs is empty
+*/
+var searchKnowledgeURI = "/kb/search"
+
+type searchKnowledgeReq struct {
+	KBs   []string `json:"kbs"`
+	Query string   `json:"query" binding:"required"`
+	Limit int      `json:"limit"`
+}
+
+func searchKnowledgeHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req searchKnowledgeReq
+	err := c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	results, err := agent.SearchKnowledge(c.Request.Context(), req.KBs, req.Query, req.Limit)
+	if err != nil {
+		c.JSON(500, map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"results": results})
+	}
+}
+
+/*
+Get all long-term memories, most recently updated first
+*/
+var listMemoriesURI = "/memories/list"
+
+func listMemoriesHandler(c *gin.Context) {
+	c.JSON(200, map[string]any{"memories": agent.GetMemories()})
+}
+
+/*
+Search memories by keywords
+*/
+var searchMemoriesURI = "/memories/search"
+
+type searchMemoriesReq struct {
+	Query string `json:"query"`
+	Limit int    `json:"limit"`
+}
+
+func searchMemoriesHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req searchMemoriesReq
+	err := c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	memories, err := agent.SearchMemories(c.Request.Context(), req.Query, req.Limit)
+	if err != nil {
+		c.JSON(500, map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"memories": memories})
+	}
+}
+
+/*
+Add memory written by the user
+*/
+var createMemoryURI = "/memories/create"
+
+type createMemoryReq struct {
+	Content string `json:"content" binding:"required"`
+}
+
+func createMemoryHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req createMemoryReq
+	err := c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	memory, err := agent.SaveMemory(req.Content)
+	if err == nil {
+		c.JSON(200, map[string]any{"memory": memory})
+	} else {
+		c.JSON(400, map[string]any{"error": err.Error()})
+	}
+}
+
+/*
+Delete memory by id
+*/
+var deleteMemoryURI = "/memories/delete/:id"
+
+type deleteMemoryReq struct {
+	ID string `uri:"id" binding:"required"`
+}
+
+func deleteMemoryHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req deleteMemoryReq
+	err := c.BindUri(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	err = agent.ForgetMemory(req.ID)
+	if err != nil {
+		c.JSON(404, map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"error": nil})
+	}
+}
+
+/*
+Extract memories from the session with given model now, instead of waiting until the session is idle
+*/
+var extractMemoriesURI = "/memories/extract"
+
+type extractMemoriesReq struct {
+	SessionID string `json:"sessionID" binding:"required"`
+	ModelID   string `json:"modelID" binding:"required"`
+}
+
+func extractMemoriesHandler(c *gin.Context) {
+	defer logger.BreakOnError()
+
+	var req extractMemoriesReq
+	err := c.Bind(&req)
+	log.CheckE(err, func() { c.Status(400) }, "Failed to unpack API parameters")
+
+	memories, err := agent.ExtractSessionMemories(c.Request.Context(), req.SessionID, req.ModelID)
+	if err != nil {
+		c.JSON(500, map[string]any{"error": err.Error()})
+	} else {
+		c.JSON(200, map[string]any{"memories": memories})
+	}
+}
+
 /*
 Open URL in default browser
 */
@@ -620,6 +1609,12 @@ SSE connection for receiving server updates. It implements following events:
 - mcp_list_update:[{mcp}]
 - provider_list_update:[{provider}]
 - role_list_update:[{role}]
+- run_started:{run}
+- run_finished:{run}
+- run_failed:{run}
+- run_cancelled:{run}
+- run_limit_exceeded:{run}
+- tool_approval_request:{approval} - tool call waits for /approvals/:id/approve or /approvals/:id/deny
 */
 var sseURI = "/sse"
 
@@ -655,8 +1650,18 @@ func InitAgentRoutes(router *gin.Engine) {
 		group.GET(deleteSessionURI, deleteSessionHandler)
 		group.GET(truncateSessionURI, truncateSessionHandler)
 		group.GET(deleteMessageURI, deleteMessageHandler)
+		group.GET(sessionFetchesURI, sessionFetchesHandler)
+		group.GET(exportSessionURI, exportSessionHandler)
+		group.GET(exportSessionsURI, exportSessionsHandler)
+		group.POST(importSessionsURI, importSessionsHandler)
+		group.POST(searchSessionsURI, searchSessionsHandler)
+		group.GET(forkSessionURI, forkSessionHandler)
+		group.GET(switchBranchURI, switchBranchHandler)
+		group.POST(editMessageURI, editMessageHandler)
+		group.POST(regenerateMessageURI, regenerateMessageHandler)
 
 		group.GET(listModelsURI, listModelsHandler)
+		group.POST(embeddingsURI, embeddingsHandler)
 		group.GET(listProvidersURI, listProvidersHandler)
 		group.POST(testProviderURI, testProviderHandler)
 		group.POST(updateProviderURI, updateProviderHandler)
@@ -667,6 +1672,11 @@ func InitAgentRoutes(router *gin.Engine) {
 		group.POST(dynamicAgentChatURI, dynamicAgentChatHandler)
 		group.POST(toolChatStreamURI, toolChatStreamHandler)
 
+		group.GET(listRunsURI, listRunsHandler)
+		group.GET(cancelRunURI, cancelRunHandler)
+		group.GET(listApprovalsURI, listApprovalsHandler)
+		group.GET(resolveApprovalURI, resolveApprovalHandler)
+
 		group.GET(listRolesURI, listRolesHandler)
 		group.POST(createRoleURI, createRoleHandler)
 		group.POST(updateRoleURI, updateRoleHandler)
@@ -680,6 +1690,29 @@ func InitAgentRoutes(router *gin.Engine) {
 		group.GET(reloadMCPServerURI, reloadMCPServerHandler)
 		group.GET(reloadAllMCPServersURI, reloadAllMCPServersHandler)
 
+		group.GET(listBuiltinToolsURI, listBuiltinToolsHandler)
+		group.POST(updateBuiltinToolURI, updateBuiltinToolHandler)
+		group.POST(testBuiltinToolURI, testBuiltinToolHandler)
+
+		group.GET(listDatasetsURI, listDatasetsHandler)
+		group.POST(attachDatasetURI, attachDatasetHandler)
+		group.GET(deleteDatasetURI, deleteDatasetHandler)
+
+		group.GET(listKnowledgeBasesURI, listKnowledgeBasesHandler)
+		group.POST(createKnowledgeBaseURI, createKnowledgeBaseHandler)
+		group.POST(updateKnowledgeBaseURI, updateKnowledgeBaseHandler)
+		group.GET(deleteKnowledgeBaseURI, deleteKnowledgeBaseHandler)
+		group.GET(listKnowledgeDocumentsURI, listKnowledgeDocumentsHandler)
+		group.POST(uploadKnowledgeDocumentsURI, uploadKnowledgeDocumentsHandler)
+		group.GET(deleteKnowledgeDocumentURI, deleteKnowledgeDocumentHandler)
+		group.POST(searchKnowledgeURI, searchKnowledgeHandler)
+
+		group.GET(listMemoriesURI, listMemoriesHandler)
+		group.POST(searchMemoriesURI, searchMemoriesHandler)
+		group.POST(createMemoryURI, createMemoryHandler)
+		group.GET(deleteMemoryURI, deleteMemoryHandler)
+		group.POST(extractMemoriesURI, extractMemoriesHandler)
+
 		group.POST(openLinkURI, openLinkHandler)
 
 		group.GET(sseURI, sseHandler)
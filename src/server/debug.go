@@ -1,15 +1,13 @@
 package server
 
 import (
+	"agentsmith/src/agent"
 	"agentsmith/src/logger"
 	"context"
-	"database/sql"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 func InitDebugRoutes(router *gin.Engine, server *http.Server) {
@@ -20,7 +18,7 @@ func InitDebugRoutes(router *gin.Engine, server *http.Server) {
 }
 
 func initDB(c *gin.Context) {
-	err := InitDB()
+	err := agent.MigrateDB()
 	if err == nil {
 		c.Status(200)
 	} else {
@@ -28,72 +26,6 @@ func initDB(c *gin.Context) {
 	}
 }
 
-func InitDB() (err error) {
-	log.D("Initializing sqlite DB")
-	defer logger.BreakOnError()
-
-	// Open a connection to the SQLite database
-	db, err := sql.Open("sqlite3", os.Getenv("AS_AGENT_DB_FILE"))
-	log.CheckE(err, nil, "Cant open DB")
-
-	defer db.Close()
-
-	// Create the tables
-	// Create the sessions table
-	createSessionsTableSQL := `
-	CREATE TABLE IF NOT EXISTS sessions (
-		session_id TEXT PRIMARY KEY,
-		date DATETIME,
-		summary TEXT,
-		data TEXT
-	);`
-
-	// Create the AI providers table
-	createAIProvidersTableSQL := `
-	CREATE TABLE IF NOT EXISTS providers (
-		id TEXT PRIMARY KEY,
-		name TEXT,
-		api_url TEXT,
-		api_key TEXT,
-		provider TEXT,
-		rate_limit INTEGER
-	);`
-
-	// Create the roles table
-	createRolesTableSQL := `
-	CREATE TABLE IF NOT EXISTS roles (
-		id TEXT PRIMARY KEY,
-		data TEXT
-	);`
-
-	// Create the mcp table
-	createMCPTableSQL := `
-	CREATE TABLE IF NOT EXISTS mcp (
-		id TEXT PRIMARY KEY,
-		name TEXT,
-		transport TEXT,
-		url TEXT,
-		command TEXT,
-		active BOOLEAN DEFAULT FALSE
-	);`
-
-	// Execute the SQL statements to create the tables
-	_, err = db.Exec(createSessionsTableSQL)
-	log.CheckW(err, "Failed to create sessions table")
-
-	_, err = db.Exec(createAIProvidersTableSQL)
-	log.CheckW(err, "Failed to create AI providers table")
-
-	_, err = db.Exec(createRolesTableSQL)
-	log.CheckW(err, "Failed to create roles table")
-
-	_, err = db.Exec(createMCPTableSQL)
-	log.CheckW(err, "Failed to create mcp table")
-
-	log.D("SQLite DB initialized")
-	return
-}
-
 func stopServer(server *http.Server) {
 	log.D("Stopping server")
 	defer logger.BreakOnError()
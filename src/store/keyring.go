@@ -0,0 +1,119 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	keyringService = "agentsmith"
+	// keyringTimeout leaves time to unlock the keyring when it asks the user
+	keyringTimeout = time.Minute
+)
+
+// Keyring keeps keys in the credential store of the OS.
+type Keyring interface {
+	Get(id string) ([]byte, error)
+	Set(id string, key []byte) error
+	Delete(id string) error
+}
+
+// keyring is the keyring of the OS, nil when there is none
+var keyring Keyring = systemKeyring()
+
+// systemKeyring returns the keyring used through the command line tool of
+// the OS, security on macOS and secret-tool of libsecret on Linux.
+func systemKeyring() Keyring {
+	var tool string
+	switch runtime.GOOS {
+	case "darwin":
+		tool = "security"
+	case "linux", "freebsd", "openbsd", "netbsd":
+		tool = "secret-tool"
+	default:
+		return nil
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		return nil
+	}
+	return &commandKeyring{tool}
+}
+
+type commandKeyring struct {
+	tool string
+}
+
+func (self *commandKeyring) Get(id string) ([]byte, error) {
+	var out []byte
+	var err error
+	if self.tool == "security" {
+		out, err = self.run("", "find-generic-password", "-s", keyringService, "-a", id, "-w")
+	} else {
+		out, err = self.run("", "lookup", "service", keyringService, "account", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New("key not found in keyring")
+	}
+	return hex.DecodeString(strings.TrimSpace(string(out)))
+}
+
+func (self *commandKeyring) Set(id string, key []byte) error {
+	// the secret is passed on stdin, in the arguments anyone could see it in
+	// the process list
+	secret := hex.EncodeToString(key)
+	if self.tool == "security" {
+		// -w as the last option would prompt for the password on the terminal,
+		// in interactive mode security reads the whole command from stdin.
+		// IDs and hex secrets need no quoting.
+		command := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", keyringService, id, secret)
+		if _, err := self.run(command, "-i"); err != nil {
+			return err
+		}
+		// interactive mode doesn't report failed commands in its exit code
+		saved, err := self.Get(id)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(saved, key) {
+			return errors.New("key read back from the keyring differs")
+		}
+		return nil
+	}
+	_, err := self.run(secret, "store", "--label=Agent Smith secrets", "service", keyringService, "account", id)
+	return err
+}
+
+func (self *commandKeyring) Delete(id string) error {
+	var err error
+	if self.tool == "security" {
+		_, err = self.run("", "delete-generic-password", "-s", keyringService, "-a", id)
+	} else {
+		_, err = self.run("", "clear", "service", keyringService, "account", id)
+	}
+	return err
+}
+
+func (self *commandKeyring) run(stdin string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keyringTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, self.tool, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w %s", self.tool, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+)
+
+const (
+	appDirName   = "agentsmith"
+	dbFileName   = "app.db"
+	profilesDir  = "profiles"
+	workspaceDir = "workspace"
+	// legacyDBFile is where versions before the data directory kept the DB,
+	// relative to the working directory
+	legacyDBFile = "app.db"
+)
+
+var ErrInvalidProfile = errors.New("profile name may only contain letters, digits, '-' and '_'")
+
+var profileName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Location is where the DB is. DBFile overrides the others, otherwise the DB
+// of the profile is in DataDir, the user data directory of the OS when
+// empty. Profiles keep separate DBs, the default one has no name.
+type Location struct {
+	DataDir string
+	Profile string
+	DBFile  string
+}
+
+// Path returns the DB file of the location.
+func (self Location) Path() (string, error) {
+	if self.DBFile != "" {
+		return self.DBFile, nil
+	}
+	dir := self.DataDir
+	if dir == "" {
+		var err error
+		if dir, err = DefaultDataDir(); err != nil {
+			return "", err
+		}
+	}
+	if self.Profile == "" {
+		return filepath.Join(dir, dbFileName), nil
+	}
+	if !profileName.MatchString(self.Profile) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidProfile, self.Profile)
+	}
+	return filepath.Join(dir, profilesDir, self.Profile, dbFileName), nil
+}
+
+// WorkspaceDir returns the directory of the builtin file and shell tools, next
+// to the DB of the location.
+func (self Location) WorkspaceDir() (string, error) {
+	path, err := self.Path()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), workspaceDir), nil
+}
+
+// DefaultDataDir returns the app directory in the user data directory:
+// $XDG_DATA_HOME or ~/.local/share on Linux, Application Support on macOS
+// and %AppData% on Windows.
+func DefaultDataDir() (string, error) {
+	var dir string
+	switch runtime.GOOS {
+	case "darwin", "windows", "ios", "plan9":
+		var err error
+		if dir, err = os.UserConfigDir(); err != nil {
+			return "", err
+		}
+	default:
+		if dir = os.Getenv("XDG_DATA_HOME"); dir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			dir = filepath.Join(home, ".local", "share")
+		}
+	}
+	return filepath.Join(dir, appDirName), nil
+}
+
+// OpenLocation creates the directory of the DB and opens it. The default
+// profile gets the DB earlier versions kept in the working directory, if it
+// has none yet.
+func OpenLocation(location Location) (*SQLite, error) {
+	path, err := location.Path()
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if location.DBFile == "" && location.Profile == "" {
+		if err = moveLegacyDB(legacyDBFile, path); err != nil {
+			return nil, fmt.Errorf("failed to move %s to %s: %w", legacyDBFile, path, err)
+		}
+	}
+	return Open(path)
+}
+
+// moveLegacyDB copies the legacy DB to path unless there is a DB already, and
+// renames the legacy one to <legacy>.moved so it's not copied again.
+func moveLegacyDB(legacy string, path string) error {
+	if _, err := os.Stat(legacy); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	legacyAbs, err := filepath.Abs(legacy)
+	if err != nil {
+		return err
+	}
+	pathAbs, err := filepath.Abs(path)
+	if err != nil || legacyAbs == pathAbs {
+		return err
+	}
+
+	// VACUUM INTO writes a consistent copy, including changes still in
+	// the WAL file
+	db, err := sql.Open("sqlite3", legacy)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("VACUUM INTO ?;", path)
+	db.Close()
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	log.D("Moved DB from", legacyAbs, "to", pathAbs)
+	return os.Rename(legacy, legacy+".moved")
+}
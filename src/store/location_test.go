@@ -0,0 +1,78 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestLocationPath(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		location Location
+		path     string
+	}{
+		{Location{DataDir: dir}, filepath.Join(dir, "app.db")},
+		{Location{DataDir: dir, Profile: "work"}, filepath.Join(dir, "profiles", "work", "app.db")},
+		{Location{DataDir: dir, Profile: "work", DBFile: "other.db"}, "other.db"},
+	}
+	for _, test := range tests {
+		if path, err := test.location.Path(); err != nil || path != test.path {
+			t.Errorf("%+v: expected %s, got %s %v", test.location, test.path, path, err)
+		}
+	}
+
+	if workspace, _ := (Location{DataDir: dir, Profile: "work"}).WorkspaceDir(); workspace != filepath.Join(dir, "profiles", "work", "workspace") {
+		t.Errorf("expected workspace of the profile, got %s", workspace)
+	}
+
+	if _, err := (Location{DataDir: dir, Profile: "../work"}).Path(); !errors.Is(err, ErrInvalidProfile) {
+		t.Errorf("expected ErrInvalidProfile, got %v", err)
+	}
+
+	if runtime.GOOS == "linux" {
+		t.Setenv("XDG_DATA_HOME", dir)
+		if path, _ := (Location{}).Path(); path != filepath.Join(dir, "agentsmith", "app.db") {
+			t.Errorf("expected DB in XDG_DATA_HOME, got %s", path)
+		}
+	}
+}
+
+func TestOpenLocationMovesLegacyDB(t *testing.T) {
+	t.Chdir(t.TempDir())
+	legacy, err := Open("app.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.SaveRole(&Role{ID: "kept", Config: []byte(`{}`)})
+	legacy.Close()
+
+	// profiles start empty
+	dataDir := t.TempDir()
+	work, err := OpenLocation(Location{DataDir: dataDir, Profile: "work"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles, _ := work.Roles(); len(roles) != 0 {
+		t.Errorf("profile must have its own DB, got %d roles", len(roles))
+	}
+	work.Close()
+
+	db, err := OpenLocation(Location{DataDir: dataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if roles, _ := db.Roles(); len(roles) != 1 || roles[0].ID != "kept" {
+		t.Errorf("expected roles of the legacy DB, got %+v", roles)
+	}
+	if _, err = os.Stat("app.db"); !os.IsNotExist(err) {
+		t.Errorf("legacy DB must be renamed, got %v", err)
+	}
+	if _, err = os.Stat("app.db.moved"); err != nil {
+		t.Errorf("legacy DB must be kept as app.db.moved: %v", err)
+	}
+}
@@ -0,0 +1,339 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migrations change the schema of the DB in order. The applied ones are
+// recorded in schema_migrations, each runs in its own transaction together
+// with its record. DB files created before migrations have no record and get
+// every migration, so migrations must accept tables that already exist.
+// Destructive migrations drop or rewrite data, the DB is backed up before the
+// first of them runs.
+
+type migration struct {
+	version     int
+	name        string
+	destructive bool
+	up          func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{version: 1, name: "initial schema", up: func(tx *sql.Tx) error {
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS sessions (
+			session_id TEXT PRIMARY KEY,
+			date DATETIME,
+			summary TEXT,
+			data TEXT
+		);
+		CREATE TABLE IF NOT EXISTS providers (
+			id TEXT PRIMARY KEY,
+			name TEXT,
+			api_url TEXT,
+			api_key TEXT,
+			provider TEXT,
+			rate_limit INTEGER
+		);
+		CREATE TABLE IF NOT EXISTS roles (
+			id TEXT PRIMARY KEY,
+			data TEXT
+		);
+		CREATE TABLE IF NOT EXISTS mcp (
+			id TEXT PRIMARY KEY,
+			name TEXT,
+			transport TEXT,
+			url TEXT,
+			command TEXT,
+			active BOOLEAN DEFAULT FALSE
+		);`)
+	}},
+	{version: 2, name: "builtin tools and fetch audit", up: func(tx *sql.Tx) error {
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS builtin_tools (
+			name TEXT PRIMARY KEY,
+			enabled BOOLEAN DEFAULT TRUE
+		);
+		CREATE TABLE IF NOT EXISTS fetch_audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id TEXT,
+			date DATETIME,
+			method TEXT,
+			url TEXT,
+			status INTEGER,
+			bytes INTEGER,
+			error TEXT
+		);
+		CREATE INDEX IF NOT EXISTS fetch_audit_session ON fetch_audit(session_id);`)
+	}},
+	{version: 3, name: "datasets", up: func(tx *sql.Tx) error {
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS datasets (
+			id TEXT PRIMARY KEY,
+			name TEXT UNIQUE,
+			type TEXT,
+			path TEXT,
+			created_at DATETIME
+		);`)
+	}},
+	{version: 4, name: "knowledge bases", up: func(tx *sql.Tx) error {
+		err := execAll(tx, `
+		CREATE TABLE IF NOT EXISTS knowledge_bases (
+			id TEXT PRIMARY KEY,
+			name TEXT UNIQUE,
+			description TEXT,
+			embedding_model TEXT,
+			created_at DATETIME
+		);
+		CREATE TABLE IF NOT EXISTS kb_documents (
+			id TEXT PRIMARY KEY,
+			kb_id TEXT,
+			name TEXT,
+			size INTEGER,
+			created_at DATETIME
+		);
+		CREATE INDEX IF NOT EXISTS kb_documents_kb ON kb_documents(kb_id);
+		CREATE TABLE IF NOT EXISTS kb_chunks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kb_id TEXT,
+			document_id TEXT,
+			seq INTEGER,
+			heading TEXT,
+			start_line INTEGER,
+			end_line INTEGER,
+			text TEXT,
+			embedding BLOB
+		);
+		CREATE INDEX IF NOT EXISTS kb_chunks_kb ON kb_chunks(kb_id);
+		CREATE INDEX IF NOT EXISTS kb_chunks_document ON kb_chunks(document_id);`)
+		if err != nil {
+			return err
+		}
+		// rowid of the index is the chunk id
+		return createFTS(tx, "kb_chunks_fts", "text")
+	}},
+	{version: 5, name: "memories", up: func(tx *sql.Tx) error {
+		// num is a stable rowid the full text index refers to, a TEXT
+		// primary key alone does not guarantee one
+		err := execAll(tx, `
+		CREATE TABLE IF NOT EXISTS memories (
+			num INTEGER PRIMARY KEY AUTOINCREMENT,
+			id TEXT UNIQUE,
+			content TEXT,
+			source TEXT,
+			session_id TEXT,
+			created_at DATETIME,
+			updated_at DATETIME
+		);`)
+		if err != nil {
+			return err
+		}
+		return createFTS(tx, "memories_fts", "content")
+	}},
+	{version: 6, name: "active branch of sessions", up: func(tx *sql.Tx) error {
+		return addColumn(tx, "sessions", "active_id", "TEXT")
+	}},
+	// the message index used to refer to a separate search table, it is
+	// dropped and rebuilt from the session data by the next migration
+	{version: 7, name: "messages table", destructive: true, up: func(tx *sql.Tx) error {
+		exists, err := tableExists(tx, "message_search")
+		if err != nil {
+			return err
+		}
+		if exists {
+			err = execAll(tx, `
+			DROP TABLE IF EXISTS messages_fts;
+			DROP TABLE message_search;`)
+			if err != nil {
+				return err
+			}
+		}
+		// num is the rowid of the message text in the full text index
+		err = execAll(tx, `
+		CREATE TABLE IF NOT EXISTS messages (
+			num INTEGER PRIMARY KEY AUTOINCREMENT,
+			id TEXT UNIQUE,
+			session_id TEXT,
+			parent_id TEXT,
+			origin TEXT,
+			text TEXT,
+			tool_requests TEXT,
+			transcript TEXT,
+			structured TEXT,
+			model_id TEXT,
+			usage TEXT,
+			created_at DATETIME
+		);
+		CREATE INDEX IF NOT EXISTS messages_session ON messages(session_id);`)
+		if err != nil {
+			return err
+		}
+		return createFTS(tx, "messages_fts", "text")
+	}},
+	{version: 8, name: "session data to messages", destructive: true, up: migrateSessionData},
+	{version: 9, name: "settings", up: func(tx *sql.Tx) error {
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT
+		);`)
+	}},
+	{version: 10, name: "role of messages", up: func(tx *sql.Tx) error {
+		return addColumn(tx, "messages", "role_id", "TEXT")
+	}},
+	{version: 11, name: "session imports", up: func(tx *sql.Tx) error {
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS session_imports (
+			source TEXT,
+			source_id TEXT,
+			session_id TEXT,
+			imported_at DATETIME,
+			PRIMARY KEY (source, source_id)
+		);
+		CREATE INDEX IF NOT EXISTS session_imports_session ON session_imports(session_id);`)
+	}},
+	{version: 12, name: "memory extraction progress", up: func(tx *sql.Tx) error {
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS memory_extractions (
+			session_id TEXT PRIMARY KEY,
+			message_id TEXT,
+			extracted_at DATETIME
+		);`)
+	}},
+}
+
+// migrate runs the migrations newer than the version of the DB. dbFile is
+// where backups are written next to, in memory DBs are not backed up.
+func migrate(db *sql.DB, dbFile string) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT,
+		applied_at DATETIME
+	);`)
+	if err != nil {
+		return err
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := migrations[len(migrations)-1].version
+	if version > latest {
+		return fmt.Errorf("DB schema version %d is newer than supported version %d", version, latest)
+	}
+
+	// a new DB has nothing to back up
+	var tables int
+	err = db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table' AND name != 'schema_migrations';").Scan(&tables)
+	if err != nil {
+		return err
+	}
+
+	backedUp := tables == 0
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		if m.destructive && !backedUp {
+			if err = backupDB(db, dbFile, version); err != nil {
+				return fmt.Errorf("backup before migration %d failed: %w", m.version, err)
+			}
+			backedUp = true
+		}
+
+		log.D("Migrating DB to version", m.version, m.name)
+		if err = runMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+func runMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = m.up(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?);",
+		m.version, m.name, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRow("SELECT MAX(version) FROM schema_migrations;").Scan(&version)
+	return int(version.Int64), err
+}
+
+// backupDB copies the DB to <dbFile>.v<version>-<time>.bak.
+func backupDB(db *sql.DB, dbFile string, version int) error {
+	if dbFile == "" || dbFile == ":memory:" {
+		return nil
+	}
+	backup := fmt.Sprintf("%s.v%d-%s.bak", dbFile, version, time.Now().Format("20060102-150405"))
+	if _, err := db.Exec("VACUUM INTO ?;", backup); err != nil {
+		return err
+	}
+	log.D("DB backed up to", backup)
+	return nil
+}
+
+// execAll runs statements separated by semicolons.
+func execAll(tx *sql.Tx, statements string) error {
+	_, err := tx.Exec(statements)
+	return err
+}
+
+// createFTS creates a full text index of one column. FTS5 is compiled in only
+// with the sqlite_fts5 build tag, the migration fails without it. Indexes that
+// builds without the tag created with FTS4 are still searched.
+func createFTS(tx *sql.Tx, table, column string) error {
+	_, err := tx.Exec(fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(%s, tokenize='unicode61');", table, column))
+	if err != nil {
+		return fmt.Errorf("FTS5 is not available, build with -tags sqlite_fts5: %w", err)
+	}
+	return nil
+}
+
+func tableExists(tx *sql.Tx, table string) (bool, error) {
+	var count int
+	err := tx.QueryRow("SELECT count(*) FROM sqlite_master WHERE name=?;", table).Scan(&count)
+	return count > 0, err
+}
+
+// addColumn adds the column unless the table already has it.
+func addColumn(tx *sql.Tx, table, column, columnType string) error {
+	rows, err := tx.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s');", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, columnType))
+	return err
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openFixtureDB creates a DB file from the SQL fixture in testdata, an empty
+// fixture name creates an empty DB.
+func openFixtureDB(t *testing.T, fixture string) (*sql.DB, string) {
+	t.Helper()
+
+	dbFile := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite3", dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if fixture != "" {
+		script, err := os.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.Exec(string(script)); err != nil {
+			t.Fatalf("failed to load fixture %s: %v", fixture, err)
+		}
+	}
+	return db, dbFile
+}
+
+func backups(t *testing.T, dbFile string) []string {
+	t.Helper()
+	files, err := filepath.Glob(dbFile + ".*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestMigrateNewDB(t *testing.T) {
+	db, dbFile := openFixtureDB(t, "")
+
+	if err := migrate(db, dbFile); err != nil {
+		t.Fatal(err)
+	}
+	version, _ := schemaVersion(db)
+	if latest := migrations[len(migrations)-1].version; version != latest {
+		t.Errorf("expected version %d, got %d", latest, version)
+	}
+	for _, table := range []string{"sessions", "messages", "messages_fts", "providers", "memories_fts", "kb_chunks_fts"} {
+		var count int
+		db.QueryRow("SELECT count(*) FROM sqlite_master WHERE name=?;", table).Scan(&count)
+		if count != 1 {
+			t.Errorf("table %s was not created", table)
+		}
+	}
+	if files := backups(t, dbFile); len(files) != 0 {
+		t.Errorf("new DB must not be backed up, got %v", files)
+	}
+
+	// nothing to do on the next start
+	if err := migrate(db, dbFile); err != nil {
+		t.Fatal(err)
+	}
+	var applied int
+	db.QueryRow("SELECT count(*) FROM schema_migrations;").Scan(&applied)
+	if applied != len(migrations) {
+		t.Errorf("expected %d applied migrations, got %d", len(migrations), applied)
+	}
+}
+
+func TestMigrateFixtures(t *testing.T) {
+	for _, fixture := range []string{"baseline.sql", "unversioned.sql"} {
+		t.Run(fixture, func(t *testing.T) {
+			db, dbFile := openFixtureDB(t, fixture)
+
+			if err := migrate(db, dbFile); err != nil {
+				t.Fatal(err)
+			}
+
+			var apiKey string
+			db.QueryRow("SELECT api_key FROM providers WHERE id='openai';").Scan(&apiKey)
+			if apiKey != "key" {
+				t.Errorf("providers must be kept, got key %q", apiKey)
+			}
+
+			var activeID string
+			var data sql.NullString
+			db.QueryRow("SELECT active_id, data FROM sessions WHERE session_id='trip';").Scan(&activeID, &data)
+			if activeID != "a1" || data.Valid {
+				t.Errorf("expected active a1 and no data, got %q %v", activeID, data)
+			}
+			var parentID string
+			db.QueryRow("SELECT parent_id FROM messages WHERE id='a1';").Scan(&parentID)
+			if parentID != "u1" {
+				t.Errorf("expected answer to follow the question, got parent %q", parentID)
+			}
+
+			var found string
+			err := db.QueryRow(`SELECT m.id FROM messages_fts f JOIN messages m ON m.num = f.rowid
+				WHERE messages_fts MATCH 'spring';`).Scan(&found)
+			if err != nil || found != "a1" {
+				t.Errorf("messages must be searchable, got %q %v", found, err)
+			}
+
+			// the backup has the data before the destructive migrations
+			files := backups(t, dbFile)
+			if len(files) != 1 {
+				t.Fatalf("expected one backup, got %v", files)
+			}
+			backup, err := sql.Open("sqlite3", files[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer backup.Close()
+			var sessions int
+			backup.QueryRow("SELECT count(*) FROM sessions;").Scan(&sessions)
+			if sessions != 1 {
+				t.Errorf("expected the session in the backup, got %d", sessions)
+			}
+		})
+	}
+}
+
+func TestMigrateNewerDB(t *testing.T) {
+	db, dbFile := openFixtureDB(t, "")
+	if err := migrate(db, dbFile); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("INSERT INTO schema_migrations (version, name) VALUES (1000, 'from the future');")
+
+	if err := migrate(db, dbFile); err == nil {
+		t.Error("expected DB of a newer version to be refused")
+	}
+}
+
+func TestFailedMigrationIsRolledBack(t *testing.T) {
+	db, dbFile := openFixtureDB(t, "")
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+	migrations = append(saved[:len(saved):len(saved)], migration{
+		version: saved[len(saved)-1].version + 1,
+		name:    "broken",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("CREATE TABLE half_done (id TEXT); INSERT INTO missing VALUES (1);")
+			return err
+		},
+	})
+
+	if err := migrate(db, dbFile); err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+	version, _ := schemaVersion(db)
+	if version != saved[len(saved)-1].version {
+		t.Errorf("expected migrations before the broken one to be applied, got version %d", version)
+	}
+	var count int
+	db.QueryRow("SELECT count(*) FROM sqlite_master WHERE name='half_done';").Scan(&count)
+	if count != 0 {
+		t.Error("changes of the failed migration must be rolled back")
+	}
+}
@@ -0,0 +1,409 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Secrets such as API keys are encrypted in the DB with AES-256-GCM. The key
+// is derived from a passphrase, or is a random key kept in the keyring of the
+// OS or, when there is none, in a key file next to the DB only the user can
+// read. The secrets setting records where the key is and a value encrypted
+// with it, so a wrong key is found when the DB is opened and not when a
+// secret is used.
+
+const (
+	KeySourcePassphrase = "passphrase"
+	KeySourceKeyring    = "keyring"
+	KeySourceFile       = "file"
+
+	secretPrefix = "enc:v1:"
+	secretCheck  = "agentsmith"
+	keySize      = 32
+	saltSize     = 16
+	secretsKey   = "secrets"
+)
+
+var (
+	ErrSecretsLocked      = errors.New("secrets are locked, the key was not loaded")
+	ErrWrongSecretsKey    = errors.New("the key does not decrypt the secrets of the DB")
+	ErrPassphraseRequired = errors.New("secrets of the DB are encrypted with a passphrase and none was given")
+)
+
+// passphraseIterations is the PBKDF2 work factor, tests lower it
+var passphraseIterations = 600_000
+
+// secretColumns are the columns holding secrets, as table, key column and
+// secret column. They are encrypted when saved and re-encrypted on rotation.
+// MCP servers keep no env or header secrets yet, columns for them must be
+// added here.
+var secretColumns = []struct{ table, id, column string }{
+	{"providers", "id", "api_key"},
+}
+
+// keyConfig is where the key of the secrets is, saved as JSON in settings.
+type keyConfig struct {
+	Source string `json:"source"`
+	// ID names the keyring entry or the key file
+	ID   string `json:"id,omitempty"`
+	Salt []byte `json:"salt,omitempty"`
+	// Check is secretCheck encrypted with the key
+	Check string `json:"check"`
+}
+
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+func newSecretBox(key []byte) (*secretBox, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCMWithRandomNonce(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretBox{aead}, nil
+}
+
+func (self *secretBox) encrypt(plain string) string {
+	if plain == "" {
+		return ""
+	}
+	return secretPrefix + base64.StdEncoding.EncodeToString(self.aead.Seal(nil, nil, []byte(plain), nil))
+}
+
+// decrypt returns values without the prefix as they are, they were saved
+// before secrets were encrypted.
+func (self *secretBox) decrypt(value string) (string, error) {
+	data, encrypted := strings.CutPrefix(value, secretPrefix)
+	if !encrypted {
+		return value, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+	plain, err := self.aead.Open(nil, nil, sealed, nil)
+	if err != nil {
+		return "", ErrWrongSecretsKey
+	}
+	return string(plain), nil
+}
+
+// UnlockSecrets loads the key of the secrets. A DB without one gets a key
+// derived from the passphrase or, when the passphrase is empty, a random key
+// kept in the keyring of the OS or in a key file. Secrets saved in plaintext
+// by earlier versions are encrypted once unlocked.
+func (self *SQLite) UnlockSecrets(passphrase string) error {
+	config, err := self.keyConfig()
+	if err != nil {
+		return err
+	}
+
+	var box *secretBox
+	if config == nil {
+		if config, box, err = self.newKey(passphrase); err != nil {
+			return err
+		}
+		log.D("Created key of secrets in", config.Source)
+	} else {
+		if box, err = self.loadKey(config, passphrase); err != nil {
+			return err
+		}
+		if check, err := box.decrypt(config.Check); err != nil || check != secretCheck {
+			return ErrWrongSecretsKey
+		}
+	}
+
+	tx, err := self.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = setKeyConfig(tx, config); err != nil {
+		return err
+	}
+	encrypted, err := rewriteSecrets(tx, func(value string) (string, error) {
+		if strings.HasPrefix(value, secretPrefix) {
+			return value, nil
+		}
+		return box.encrypt(value), nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	if encrypted > 0 {
+		log.D("Encrypted secrets saved in plaintext:", encrypted)
+	}
+
+	self.mu.Lock()
+	self.secrets = box
+	self.mu.Unlock()
+	return nil
+}
+
+// RotateKey re-encrypts the secrets with a new key, derived from the
+// passphrase or a random one kept like the first key when it is empty. The
+// old key is removed from the keyring or its file once the DB is updated.
+func (self *SQLite) RotateKey(passphrase string) error {
+	old := self.secretBox()
+	if old == nil {
+		return ErrSecretsLocked
+	}
+	oldConfig, err := self.keyConfig()
+	if err != nil {
+		return err
+	}
+
+	config, box, err := self.newKey(passphrase)
+	if err != nil {
+		return err
+	}
+	err = self.rotate(config, old, box)
+	if err != nil {
+		self.removeKey(config)
+		return err
+	}
+
+	self.mu.Lock()
+	self.secrets = box
+	self.mu.Unlock()
+
+	if oldConfig != nil {
+		if err = self.removeKey(oldConfig); err != nil {
+			log.W("Failed to remove the old key of secrets:", err)
+		}
+	}
+	log.D("Rotated key of secrets, the key is in", config.Source)
+	return nil
+}
+
+func (self *SQLite) rotate(config *keyConfig, old *secretBox, box *secretBox) error {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = rewriteSecrets(tx, func(value string) (string, error) {
+		plain, err := old.decrypt(value)
+		return box.encrypt(plain), err
+	})
+	if err != nil {
+		return err
+	}
+	if err = setKeyConfig(tx, config); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (self *SQLite) secretBox() *secretBox {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	return self.secrets
+}
+
+func (self *SQLite) encryptSecret(plain string) (string, error) {
+	box := self.secretBox()
+	if box == nil {
+		if plain == "" {
+			return "", nil
+		}
+		return "", ErrSecretsLocked
+	}
+	return box.encrypt(plain), nil
+}
+
+func (self *SQLite) decryptSecret(value string) (string, error) {
+	box := self.secretBox()
+	if box == nil {
+		if strings.HasPrefix(value, secretPrefix) {
+			return "", ErrSecretsLocked
+		}
+		return value, nil
+	}
+	return box.decrypt(value)
+}
+
+// newKey creates a key and saves it in the keyring or a key file unless it is
+// derived from the passphrase.
+func (self *SQLite) newKey(passphrase string) (*keyConfig, *secretBox, error) {
+	config := &keyConfig{}
+	var key []byte
+	var err error
+	if passphrase != "" {
+		config.Source = KeySourcePassphrase
+		config.Salt = make([]byte, saltSize)
+		rand.Read(config.Salt)
+		if key, err = deriveKey(passphrase, config.Salt); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		key = make([]byte, keySize)
+		rand.Read(key)
+		config.ID = rand.Text()
+		if keyring != nil {
+			if err = keyring.Set(config.ID, key); err == nil {
+				config.Source = KeySourceKeyring
+			} else {
+				log.W("Failed to save key of secrets in the keyring, using a key file:", err)
+			}
+		}
+		if config.Source == "" {
+			if self.path == "" {
+				return nil, nil, errors.New("a DB in memory has no directory for the key file")
+			}
+			config.Source = KeySourceFile
+			err = os.WriteFile(self.keyFile(config.ID), []byte(hex.EncodeToString(key)), 0o600)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	box, err := newSecretBox(key)
+	if err != nil {
+		self.removeKey(config)
+		return nil, nil, err
+	}
+	config.Check = box.encrypt(secretCheck)
+	return config, box, nil
+}
+
+func (self *SQLite) loadKey(config *keyConfig, passphrase string) (*secretBox, error) {
+	var key []byte
+	var err error
+	switch config.Source {
+	case KeySourcePassphrase:
+		if passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
+		key, err = deriveKey(passphrase, config.Salt)
+	case KeySourceKeyring:
+		if keyring == nil {
+			return nil, errors.New("the key of secrets is in a keyring, but there is none")
+		}
+		key, err = keyring.Get(config.ID)
+	case KeySourceFile:
+		var data []byte
+		if data, err = os.ReadFile(self.keyFile(config.ID)); err == nil {
+			key, err = hex.DecodeString(strings.TrimSpace(string(data)))
+		}
+	default:
+		return nil, fmt.Errorf("unknown source of the key of secrets: %q", config.Source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the key of secrets from %s: %w", config.Source, err)
+	}
+	if passphrase != "" && config.Source != KeySourcePassphrase {
+		log.W("The passphrase is ignored, the key of secrets is in", config.Source)
+	}
+	return newSecretBox(key)
+}
+
+func (self *SQLite) removeKey(config *keyConfig) error {
+	switch config.Source {
+	case KeySourceKeyring:
+		return keyring.Delete(config.ID)
+	case KeySourceFile:
+		return os.Remove(self.keyFile(config.ID))
+	}
+	return nil
+}
+
+// keyFile is next to the DB and named by the key ID, a new key gets a new
+// file so the old one is there until the DB uses the new key.
+func (self *SQLite) keyFile(id string) string {
+	return self.path + "." + id + ".key"
+}
+
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, passphraseIterations, keySize)
+}
+
+// keyConfig returns nil when the DB has no key yet.
+func (self *SQLite) keyConfig() (*keyConfig, error) {
+	var value string
+	err := self.db.QueryRow("SELECT value FROM settings WHERE key=?;", secretsKey).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var config keyConfig
+	if err = json.Unmarshal([]byte(value), &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func setKeyConfig(tx *sql.Tx, config *keyConfig) error {
+	value, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value=excluded.value;",
+		secretsKey, string(value))
+	return err
+}
+
+// rewriteSecrets replaces every secret that is set with the result of
+// rewrite and returns how many changed.
+func rewriteSecrets(tx *sql.Tx, rewrite func(value string) (string, error)) (int, error) {
+	changed := 0
+	for _, secret := range secretColumns {
+		rows, err := tx.Query(fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s IS NOT NULL AND %s != '';",
+			secret.id, secret.column, secret.table, secret.column, secret.column))
+		if err != nil {
+			return changed, err
+		}
+		values := make(map[string]string)
+		for rows.Next() {
+			var id, value string
+			if err = rows.Scan(&id, &value); err != nil {
+				rows.Close()
+				return changed, err
+			}
+			values[id] = value
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return changed, err
+		}
+
+		for id, value := range values {
+			rewritten, err := rewrite(value)
+			if err != nil {
+				return changed, fmt.Errorf("%s %s of %s: %w", secret.table, secret.column, id, err)
+			}
+			if rewritten == value {
+				continue
+			}
+			_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s=? WHERE %s=?;", secret.table, secret.column, secret.id), rewritten, id)
+			if err != nil {
+				return changed, err
+			}
+			changed++
+		}
+	}
+	return changed, nil
+}
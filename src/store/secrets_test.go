@@ -0,0 +1,173 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeKeyring map[string][]byte
+
+func (self fakeKeyring) Get(id string) ([]byte, error) {
+	if key, ok := self[id]; ok {
+		return key, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (self fakeKeyring) Set(id string, key []byte) error {
+	self[id] = key
+	return nil
+}
+
+func (self fakeKeyring) Delete(id string) error {
+	delete(self, id)
+	return nil
+}
+
+func useKeyring(t *testing.T, k Keyring) {
+	t.Helper()
+	saved, savedIterations := keyring, passphraseIterations
+	keyring, passphraseIterations = k, 1000
+	t.Cleanup(func() { keyring, passphraseIterations = saved, savedIterations })
+}
+
+func openSecretsDB(t *testing.T, path string, passphrase string) (*SQLite, error) {
+	t.Helper()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, db.UnlockSecrets(passphrase)
+}
+
+func storedAPIKey(t *testing.T, db *SQLite, id string) string {
+	t.Helper()
+	var key string
+	if err := db.DB().QueryRow("SELECT api_key FROM providers WHERE id=?;", id).Scan(&key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func checkAPIKey(t *testing.T, db *SQLite, key string) {
+	t.Helper()
+	providers, err := db.Providers()
+	if err != nil || len(providers) != 1 || providers[0].APIKey != key {
+		t.Fatalf("expected provider with key %q, got %+v %v", key, providers, err)
+	}
+}
+
+func TestSecretsKeyFile(t *testing.T) {
+	useKeyring(t, nil)
+	path := filepath.Join(t.TempDir(), "app.db")
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SaveProvider(&Provider{ID: "p", Name: "Cloud", APIKey: "sk-secret"}); !errors.Is(err, ErrSecretsLocked) {
+		t.Errorf("expected ErrSecretsLocked before unlock, got %v", err)
+	}
+	// saved by a version without encryption
+	db.DB().Exec("INSERT INTO providers (id, name, api_key, provider) VALUES ('p', 'Cloud', 'sk-secret', 'openai');")
+	if err = db.UnlockSecrets(""); err != nil {
+		t.Fatal(err)
+	}
+	if stored := storedAPIKey(t, db, "p"); !strings.HasPrefix(stored, secretPrefix) || strings.Contains(stored, "sk-secret") {
+		t.Errorf("plaintext key was not encrypted: %q", stored)
+	}
+	checkAPIKey(t, db, "sk-secret")
+	db.Close()
+
+	files, _ := filepath.Glob(path + ".*.key")
+	if len(files) != 1 {
+		t.Fatalf("expected one key file, got %v", files)
+	}
+	if info, err := os.Stat(files[0]); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("key file must be readable by the user only: %v %v", info.Mode(), err)
+	}
+
+	db, err = openSecretsDB(t, path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAPIKey(t, db, "sk-secret")
+}
+
+func TestSecretsPassphrase(t *testing.T) {
+	useKeyring(t, fakeKeyring{})
+	path := filepath.Join(t.TempDir(), "app.db")
+
+	db, err := openSecretsDB(t, path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SaveProvider(&Provider{ID: "p", Name: "Cloud", APIKey: "sk-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if _, err = openSecretsDB(t, path, ""); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("expected ErrPassphraseRequired, got %v", err)
+	}
+	if _, err = openSecretsDB(t, path, "wrong horse"); !errors.Is(err, ErrWrongSecretsKey) {
+		t.Errorf("expected ErrWrongSecretsKey, got %v", err)
+	}
+	db, err = openSecretsDB(t, path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAPIKey(t, db, "sk-secret")
+}
+
+func TestRotateKey(t *testing.T) {
+	k := fakeKeyring{}
+	useKeyring(t, k)
+	path := filepath.Join(t.TempDir(), "app.db")
+
+	db, err := openSecretsDB(t, path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k) != 1 {
+		t.Fatalf("expected key in the keyring, got %d", len(k))
+	}
+	if err = db.SaveProvider(&Provider{ID: "p", Name: "Cloud", APIKey: "sk-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	stored := storedAPIKey(t, db, "p")
+
+	if err = db.RotateKey("new passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if len(k) != 0 {
+		t.Errorf("old key must be removed from the keyring, got %d", len(k))
+	}
+	if storedAPIKey(t, db, "p") == stored {
+		t.Error("key was not re-encrypted")
+	}
+	checkAPIKey(t, db, "sk-secret")
+	db.Close()
+
+	db, err = openSecretsDB(t, path, "new passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAPIKey(t, db, "sk-secret")
+
+	if err = db.RotateKey(""); err != nil {
+		t.Fatal(err)
+	}
+	if len(k) != 1 {
+		t.Errorf("expected new key in the keyring, got %d", len(k))
+	}
+	db.Close()
+	if db, err = openSecretsDB(t, path, ""); err != nil {
+		t.Fatal(err)
+	}
+	checkAPIKey(t, db, "sk-secret")
+}
@@ -0,0 +1,322 @@
+package store
+
+import (
+	"agentsmith/src/util"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const messageColumns = "id, session_id, parent_id, origin, text, tool_requests, transcript, structured, model_id, role_id, usage, created_at"
+
+func (self *SQLite) Sessions() ([]*Session, error) {
+	rows, err := self.db.Query("SELECT session_id, date, summary, active_id FROM sessions ORDER BY date DESC;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]*Session, 0, 32)
+	for rows.Next() {
+		var session Session
+		var dateStr string
+		var summary, activeID sql.NullString
+		if err = rows.Scan(&session.ID, &dateStr, &summary, &activeID); err != nil {
+			log.W("Failed to scan session row:", err)
+			continue
+		}
+		session.Date, err = time.Parse(time.RFC3339, dateStr)
+		if err != nil {
+			log.W("Failed to parse session date: ", dateStr, err)
+		}
+		session.Summary, session.ActiveID = summary.String, activeID.String
+		sessions = append(sessions, &session)
+	}
+	return sessions, rows.Err()
+}
+
+func (self *SQLite) Messages() ([]*Message, error) {
+	rows, err := self.db.Query("SELECT " + messageColumns + " FROM messages ORDER BY num;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]*Message, 0, 256)
+	for rows.Next() {
+		var message Message
+		var parentID, toolRequests, transcript, structured, modelID, roleID, usage sql.NullString
+		err = rows.Scan(&message.ID, &message.SessionID, &parentID, &message.Origin, &message.Text,
+			&toolRequests, &transcript, &structured, &modelID, &roleID, &usage, &message.CreatedAt)
+		if err != nil {
+			log.W("Failed to scan message row:", err)
+			continue
+		}
+		message.ParentID, message.ModelID, message.RoleID = parentID.String, modelID.String, roleID.String
+		message.ToolRequests = rawColumn(toolRequests)
+		message.Transcript = rawColumn(transcript)
+		message.Structured = rawColumn(structured)
+		message.Usage = rawColumn(usage)
+		messages = append(messages, &message)
+	}
+	return messages, rows.Err()
+}
+
+func (self *SQLite) SaveSession(session *Session, changed []*Message, removed []string) error {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO sessions (session_id, date, summary, active_id)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(session_id) DO UPDATE SET
+		date=excluded.date,
+		summary=excluded.summary,
+		active_id=excluded.active_id;
+	`
+	_, err = tx.Exec(query, session.ID, session.Date.Format(time.RFC3339), session.Summary, session.ActiveID)
+	if err != nil {
+		return err
+	}
+	for _, id := range removed {
+		if err = deleteMessage(tx, id); err != nil {
+			return err
+		}
+	}
+	for _, message := range changed {
+		if err = saveMessage(tx, message); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (self *SQLite) ImportSession(source string, sourceID string, session *Session, messages []*Message) error {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRow("SELECT count(*) FROM session_imports WHERE source=? AND source_id=?;", source, sourceID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAlreadyImported
+	}
+	_, err = tx.Exec("INSERT INTO session_imports (source, source_id, session_id, imported_at) VALUES (?, ?, ?, ?);",
+		source, sourceID, session.ID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO sessions (session_id, date, summary, active_id) VALUES (?, ?, ?, ?);",
+		session.ID, session.Date.Format(time.RFC3339), session.Summary, session.ActiveID)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		if err = saveMessage(tx, message); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteSession deletes the session with its messages. A deleted session that
+// was imported can be imported again.
+func (self *SQLite) DeleteSession(id string) error {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("DELETE FROM sessions WHERE session_id=?;", id); err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM messages_fts WHERE rowid IN (SELECT num FROM messages WHERE session_id=?);", id)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM messages WHERE session_id=?;", id); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM session_imports WHERE session_id=?;", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// saveMessage inserts or updates the message and its search index entry.
+// Thinking of the model is not indexed.
+func saveMessage(tx *sql.Tx, message *Message) error {
+	query := `
+	INSERT INTO messages (` + messageColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+		parent_id=excluded.parent_id,
+		text=excluded.text,
+		tool_requests=excluded.tool_requests,
+		transcript=excluded.transcript,
+		structured=excluded.structured,
+		model_id=excluded.model_id,
+		role_id=excluded.role_id,
+		usage=excluded.usage;
+	`
+	_, err := tx.Exec(query, message.ID, message.SessionID, message.ParentID, message.Origin, message.Text,
+		nullColumn(message.ToolRequests), nullColumn(message.Transcript), nullColumn(message.Structured),
+		message.ModelID, message.RoleID, nullColumn(message.Usage), message.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM messages_fts WHERE rowid IN (SELECT num FROM messages WHERE id=?);", message.ID)
+	if err != nil {
+		return err
+	}
+	if text := strings.TrimSpace(util.CutThinking(message.Text)); text != "" {
+		_, err = tx.Exec("INSERT INTO messages_fts (rowid, text) SELECT num, ? FROM messages WHERE id=?;", text, message.ID)
+	}
+	return err
+}
+
+func deleteMessage(tx *sql.Tx, id string) error {
+	_, err := tx.Exec("DELETE FROM messages_fts WHERE rowid IN (SELECT num FROM messages WHERE id=?);", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM messages WHERE id=?;", id)
+	return err
+}
+
+func nullColumn(data json.RawMessage) sql.NullString {
+	return sql.NullString{String: string(data), Valid: len(data) > 0}
+}
+
+func rawColumn(data sql.NullString) json.RawMessage {
+	if !data.Valid || data.String == "" {
+		return nil
+	}
+	return json.RawMessage(data.String)
+}
+
+// legacyMessage is a message of the JSON blob sessions were saved as before
+// the messages table, nested data is copied as is.
+type legacyMessage struct {
+	ID           string          `json:"id"`
+	ParentID     string          `json:"parentId"`
+	Origin       string          `json:"origin"`
+	Text         string          `json:"text"`
+	ToolRequests json.RawMessage `json:"toolRequests"`
+	Transcript   json.RawMessage `json:"transcript"`
+	Structured   json.RawMessage `json:"structured"`
+	ModelID      string          `json:"modelId"`
+	Usage        json.RawMessage `json:"usage"`
+	CreatedAt    time.Time       `json:"createdAt"`
+}
+
+// migrateSessionData moves messages of sessions saved as one JSON blob in the
+// data column into the messages table. Sessions saved before branching become
+// a single branch.
+func migrateSessionData(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT session_id, date, data, active_id FROM sessions WHERE data IS NOT NULL AND data != '';")
+	if err != nil {
+		return err
+	}
+	type legacySession struct {
+		session  Session
+		messages []*legacyMessage
+	}
+	legacy := make([]*legacySession, 0)
+	for rows.Next() {
+		var s legacySession
+		var dateStr, dataJSON string
+		var activeID sql.NullString
+		if err = rows.Scan(&s.session.ID, &dateStr, &dataJSON, &activeID); err != nil {
+			rows.Close()
+			return err
+		}
+		if err = json.Unmarshal([]byte(dataJSON), &s.messages); err != nil {
+			log.W("Failed to unmarshal messages for session:", s.session.ID, err)
+		}
+		s.session.Date, _ = time.Parse(time.RFC3339, dateStr)
+		s.session.ActiveID = activeID.String
+		if !activeID.Valid {
+			for i, message := range s.messages {
+				if i > 0 {
+					message.ParentID = s.messages[i-1].ID
+				}
+				s.session.ActiveID = message.ID
+			}
+		}
+		legacy = append(legacy, &s)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range legacy {
+		for _, m := range s.messages {
+			message := &Message{
+				ID:           m.ID,
+				SessionID:    s.session.ID,
+				ParentID:     m.ParentID,
+				Origin:       m.Origin,
+				Text:         m.Text,
+				ToolRequests: nullJSON(m.ToolRequests),
+				Transcript:   nullJSON(m.Transcript),
+				Structured:   nullJSON(m.Structured),
+				ModelID:      m.ModelID,
+				Usage:        nullJSON(m.Usage),
+				CreatedAt:    m.CreatedAt,
+			}
+			if message.CreatedAt.IsZero() {
+				message.CreatedAt = s.session.Date
+			}
+			if err = insertMigratedMessage(tx, message); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec("UPDATE sessions SET data=NULL, active_id=? WHERE session_id=?;", s.session.ActiveID, s.session.ID)
+		if err != nil {
+			return err
+		}
+	}
+	log.D("Migrated messages of sessions to messages table:", len(legacy))
+	return nil
+}
+
+// insertMigratedMessage writes a message with the columns messages had in
+// migration 8. saveMessage writes the columns of the current schema, which
+// migration 8 must not depend on.
+func insertMigratedMessage(tx *sql.Tx, message *Message) error {
+	_, err := tx.Exec(`
+	INSERT INTO messages (id, session_id, parent_id, origin, text, tool_requests, transcript, structured, model_id, usage, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		message.ID, message.SessionID, message.ParentID, message.Origin, message.Text,
+		nullColumn(message.ToolRequests), nullColumn(message.Transcript), nullColumn(message.Structured),
+		message.ModelID, nullColumn(message.Usage), message.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	if text := strings.TrimSpace(util.CutThinking(message.Text)); text != "" {
+		_, err = tx.Exec("INSERT INTO messages_fts (rowid, text) SELECT num, ? FROM messages WHERE id=?;", text, message.ID)
+	}
+	return err
+}
+
+// nullJSON drops JSON null, the column of a missing value is NULL.
+func nullJSON(data json.RawMessage) json.RawMessage {
+	if string(data) == "null" {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,234 @@
+package store
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// busyTimeout is how long a write waits for another one to finish, in
+	// milliseconds
+	busyTimeout  = 5000
+	maxOpenConns = 8
+)
+
+// SQLite is the store in an SQLite database. Connections are pooled, the
+// journal is in WAL mode so reads don't wait for writes, and transactions
+// take the write lock when they begin so concurrent ones wait for each other
+// instead of failing. Secrets are encrypted, they can't be read or saved
+// until UnlockSecrets loads the key.
+type SQLite struct {
+	db      *sql.DB
+	path    string
+	mu      sync.RWMutex
+	secrets *secretBox
+}
+
+var memoryDBs atomic.Int64
+
+// Open opens the database file, creating it if missing, and migrates its
+// schema.
+func Open(path string) (*SQLite, error) {
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate",
+		(&url.URL{Path: path}).EscapedPath(), busyTimeout)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	return open(db, path)
+}
+
+// OpenMemory opens a new empty database that lives in memory until closed,
+// for tests. It has one connection, the database is gone when it closes.
+// Secrets are encrypted with a random key that is not kept anywhere. It is
+// the SQLite store and not a separate implementation of Store, as the tables
+// other packages use through DB need SQLite, and tests run the same queries.
+func OpenMemory() (*SQLite, error) {
+	dsn := fmt.Sprintf("file:memory%d?mode=memory", memoryDBs.Add(1))
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	self, err := open(db, "")
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, keySize)
+	rand.Read(key)
+	if self.secrets, err = newSecretBox(key); err != nil {
+		self.Close()
+		return nil, err
+	}
+	return self, nil
+}
+
+func open(db *sql.DB, path string) (*SQLite, error) {
+	self := &SQLite{db: db, path: path}
+	if err := self.Migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.D("Opened DB", path)
+	return self, nil
+}
+
+func (self *SQLite) DB() *sql.DB {
+	return self.db
+}
+
+func (self *SQLite) Migrate() error {
+	return migrate(self.db, self.path)
+}
+
+func (self *SQLite) Close() error {
+	return self.db.Close()
+}
+
+func (self *SQLite) Providers() ([]*Provider, error) {
+	rows, err := self.db.Query("SELECT id, name, api_url, api_key, provider, rate_limit FROM providers;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	providers := make([]*Provider, 0, 16)
+	for rows.Next() {
+		var id, name, apiURL, apiKey, providerType sql.NullString
+		var rateLimit sql.NullInt64
+		err = rows.Scan(&id, &name, &apiURL, &apiKey, &providerType, &rateLimit)
+		if err != nil {
+			log.W("Failed to scan provider row:", err)
+			continue
+		}
+		key, err := self.decryptSecret(apiKey.String)
+		if errors.Is(err, ErrSecretsLocked) {
+			return nil, err
+		}
+		if err != nil {
+			log.W("Failed to decrypt API key of provider", name.String, err)
+		}
+		providers = append(providers, &Provider{
+			ID:        id.String,
+			Name:      name.String,
+			APIURL:    apiURL.String,
+			APIKey:    key,
+			Type:      providerType.String,
+			RateLimit: int(rateLimit.Int64),
+		})
+	}
+	return providers, rows.Err()
+}
+
+func (self *SQLite) SaveProvider(provider *Provider) error {
+	query := `
+	INSERT INTO providers (id, name, api_url, api_key, provider, rate_limit)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+		name=excluded.name,
+		api_url=excluded.api_url,
+		api_key=excluded.api_key,
+		provider=excluded.provider,
+		rate_limit=excluded.rate_limit;
+	`
+	key, err := self.encryptSecret(provider.APIKey)
+	if err != nil {
+		return err
+	}
+	_, err = self.db.Exec(query, provider.ID, provider.Name, provider.APIURL, key, provider.Type, provider.RateLimit)
+	return err
+}
+
+func (self *SQLite) DeleteProvider(id string) error {
+	_, err := self.db.Exec("DELETE FROM providers WHERE id=?;", id)
+	return err
+}
+
+func (self *SQLite) Roles() ([]*Role, error) {
+	rows, err := self.db.Query("SELECT id, data FROM roles;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]*Role, 0, 32)
+	for rows.Next() {
+		var role Role
+		var data sql.NullString
+		if err = rows.Scan(&role.ID, &data); err != nil {
+			log.W("Failed to scan role row:", err)
+			continue
+		}
+		if data.String != "" {
+			role.Config = []byte(data.String)
+		}
+		roles = append(roles, &role)
+	}
+	return roles, rows.Err()
+}
+
+func (self *SQLite) SaveRole(role *Role) error {
+	query := `
+	INSERT INTO roles (id, data)
+	VALUES (?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+		data=excluded.data;
+	`
+	_, err := self.db.Exec(query, role.ID, string(role.Config))
+	return err
+}
+
+func (self *SQLite) DeleteRole(id string) error {
+	_, err := self.db.Exec("DELETE FROM roles WHERE id=?;", id)
+	return err
+}
+
+func (self *SQLite) MCPServers() ([]*MCPServer, error) {
+	rows, err := self.db.Query("SELECT id, name, transport, url, command, active FROM mcp;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	servers := make([]*MCPServer, 0, 8)
+	for rows.Next() {
+		var server MCPServer
+		var url, command sql.NullString
+		err = rows.Scan(&server.ID, &server.Name, &server.Transport, &url, &command, &server.Active)
+		if err != nil {
+			log.W("Failed to scan MCP server row:", err)
+			continue
+		}
+		server.URL, server.Command = url.String, command.String
+		servers = append(servers, &server)
+	}
+	return servers, rows.Err()
+}
+
+func (self *SQLite) SaveMCPServer(server *MCPServer) error {
+	query := `
+	INSERT INTO mcp (id, name, transport, url, command, active)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+		name=excluded.name,
+		transport=excluded.transport,
+		url=excluded.url,
+		command=excluded.command,
+		active=excluded.active;
+	`
+	_, err := self.db.Exec(query, server.ID, server.Name, server.Transport, server.URL, server.Command, server.Active)
+	return err
+}
+
+func (self *SQLite) DeleteMCPServer(id string) error {
+	_, err := self.db.Exec("DELETE FROM mcp WHERE id=?;", id)
+	return err
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *SQLite {
+	t.Helper()
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data dir", "app #1.db")
+	db, err := Open(path)
+	if err == nil {
+		db.Close()
+		t.Fatal("expected missing directory to fail")
+	}
+
+	path = filepath.Join(t.TempDir(), "app #1.db")
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var mode string
+	db.DB().QueryRow("PRAGMA journal_mode;").Scan(&mode)
+	if mode != "wal" {
+		t.Errorf("expected WAL journal, got %q", mode)
+	}
+
+	// concurrent writers wait for each other
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- db.SaveRole(&Role{ID: string(rune('a' + i)), Config: json.RawMessage(`{}`)})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if roles, _ := db.Roles(); len(roles) != 20 {
+		t.Errorf("expected 20 roles, got %d", len(roles))
+	}
+}
+
+func TestRepositories(t *testing.T) {
+	db := openTestStore(t)
+
+	db.SaveProvider(&Provider{ID: "p", Name: "Local", APIURL: "http://localhost", Type: "openai", RateLimit: 5})
+	db.SaveProvider(&Provider{ID: "p", Name: "Renamed", APIURL: "http://localhost", Type: "openai", RateLimit: 5})
+	providers, err := db.Providers()
+	if err != nil || len(providers) != 1 || providers[0].Name != "Renamed" || providers[0].RateLimit != 5 {
+		t.Errorf("unexpected providers %+v %v", providers, err)
+	}
+	db.DeleteProvider("p")
+	if providers, _ = db.Providers(); len(providers) != 0 {
+		t.Errorf("provider was not deleted")
+	}
+
+	db.SaveMCPServer(&MCPServer{ID: "m", Name: "files", Transport: "stdio", Command: "mcp-files", Active: true})
+	servers, err := db.MCPServers()
+	if err != nil || len(servers) != 1 || !servers[0].Active || servers[0].Command != "mcp-files" {
+		t.Errorf("unexpected MCP servers %+v %v", servers, err)
+	}
+
+	date := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	session := &Session{ID: "s", Date: date, Summary: "Trip", ActiveID: "a"}
+	messages := []*Message{
+		{ID: "u", SessionID: "s", Origin: "user", Text: "Plan a trip", CreatedAt: date},
+		{ID: "a", SessionID: "s", ParentID: "u", Origin: "assistant", Text: "<think>hmm</think>Lisbon",
+			ToolRequests: json.RawMessage(`[{"name":"search"}]`), Usage: json.RawMessage(`{"total_tokens":3}`), CreatedAt: date},
+	}
+	if err = db.SaveSession(session, messages, nil); err != nil {
+		t.Fatal(err)
+	}
+	messages[1].Text = "Porto"
+	if err = db.SaveSession(session, messages[1:], []string{"u"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, _ := db.Sessions()
+	if len(sessions) != 1 || !sessions[0].Date.Equal(date) || sessions[0].ActiveID != "a" {
+		t.Errorf("unexpected sessions %+v", sessions)
+	}
+	stored, _ := db.Messages()
+	if len(stored) != 1 || stored[0].Text != "Porto" || string(stored[0].ToolRequests) != `[{"name":"search"}]` ||
+		stored[0].Transcript != nil || !stored[0].CreatedAt.Equal(date) {
+		t.Errorf("unexpected messages %+v", stored)
+	}
+	var indexed int
+	db.DB().QueryRow("SELECT count(*) FROM messages_fts WHERE messages_fts MATCH 'porto OR lisbon OR plan';").Scan(&indexed)
+	if indexed != 1 {
+		t.Errorf("expected only the updated text to be indexed, got %d rows", indexed)
+	}
+
+	if err = db.DeleteSession("s"); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ = db.Messages(); len(stored) != 0 {
+		t.Errorf("messages of deleted session must be deleted, got %d", len(stored))
+	}
+}
+
+func TestMigrateSessionData(t *testing.T) {
+	db, dbFile := openFixtureDB(t, "")
+	_, err := db.Exec(`
+	CREATE TABLE sessions (session_id TEXT PRIMARY KEY, date DATETIME, summary TEXT, data TEXT);
+	INSERT INTO sessions VALUES ('legacy', '2025-01-01T00:00:00Z', 'Legacy',
+		'[{"id":"u","origin":"user","text":"question","toolRequests":null},
+		{"id":"a","origin":"assistant","text":"answer","toolRequests":[{"id":"call","name":"search","params":{}}]}]');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = migrate(db, dbFile); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, _ := (&SQLite{db: db}).Messages()
+	if len(messages) != 2 || messages[1].ParentID != "u" || messages[0].ToolRequests != nil {
+		t.Fatalf("unexpected migrated messages %+v", messages)
+	}
+	var call []map[string]any
+	json.Unmarshal(messages[1].ToolRequests, &call)
+	if len(call) != 1 || call[0]["name"] != "search" {
+		t.Errorf("tool requests must be migrated, got %s", messages[1].ToolRequests)
+	}
+	if !messages[1].CreatedAt.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("messages must get the session date, got %v", messages[1].CreatedAt)
+	}
+}
@@ -0,0 +1,99 @@
+// Package store implements persistence of the app data in SQLite
+package store
+
+import (
+	"agentsmith/src/logger"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var log = logger.Logger("store", 1, 1, 1)
+
+var ErrAlreadyImported = errors.New("session was imported before")
+
+// Store keeps providers, roles, MCP servers, sessions and their messages.
+// Other tables are used through the shared handle returned by DB. The store
+// is opened once and handed to the packages that persist data.
+type Store interface {
+	Providers() ([]*Provider, error)
+	SaveProvider(provider *Provider) error
+	DeleteProvider(id string) error
+
+	Roles() ([]*Role, error)
+	SaveRole(role *Role) error
+	DeleteRole(id string) error
+
+	MCPServers() ([]*MCPServer, error)
+	SaveMCPServer(server *MCPServer) error
+	DeleteMCPServer(id string) error
+
+	// Sessions returns all sessions, the latest first.
+	Sessions() ([]*Session, error)
+	// Messages returns messages of all sessions in the order they were
+	// added.
+	Messages() ([]*Message, error)
+	// SaveSession writes the session, deletes the removed messages and
+	// inserts or updates the changed ones in one transaction.
+	SaveSession(session *Session, changed []*Message, removed []string) error
+	// ImportSession saves a session imported from another app with its
+	// messages, unless the source ID was imported before and the session is
+	// still there, see ErrAlreadyImported.
+	ImportSession(source string, sourceID string, session *Session, messages []*Message) error
+	DeleteSession(id string) error
+
+	// DB is the shared handle of the database, it must not be closed.
+	DB() *sql.DB
+	// Migrate upgrades the schema to the version of the app.
+	Migrate() error
+	Close() error
+}
+
+type Provider struct {
+	ID        string
+	Name      string
+	APIURL    string
+	APIKey    string
+	Type      string
+	RateLimit int
+}
+
+// Role is stored as its ID and JSON config.
+type Role struct {
+	ID     string
+	Config json.RawMessage
+}
+
+type MCPServer struct {
+	ID        string
+	Name      string
+	Transport string
+	URL       string
+	Command   string
+	Active    bool
+}
+
+type Session struct {
+	ID       string
+	Date     time.Time
+	Summary  string
+	ActiveID string
+}
+
+// Message is one message of a session. Tool requests, transcript, structured
+// output and usage are JSON, nil when the message has none.
+type Message struct {
+	ID           string
+	SessionID    string
+	ParentID     string
+	Origin       string
+	Text         string
+	ToolRequests json.RawMessage
+	Transcript   json.RawMessage
+	Structured   json.RawMessage
+	ModelID      string
+	RoleID       string
+	Usage        json.RawMessage
+	CreatedAt    time.Time
+}
@@ -0,0 +1,32 @@
+-- DB as created by the first release: messages of sessions are one JSON blob
+CREATE TABLE sessions (
+	session_id TEXT PRIMARY KEY,
+	date DATETIME,
+	summary TEXT,
+	data TEXT
+);
+CREATE TABLE providers (
+	id TEXT PRIMARY KEY,
+	name TEXT,
+	api_url TEXT,
+	api_key TEXT,
+	provider TEXT,
+	rate_limit INTEGER
+);
+CREATE TABLE roles (
+	id TEXT PRIMARY KEY,
+	data TEXT
+);
+CREATE TABLE mcp (
+	id TEXT PRIMARY KEY,
+	name TEXT,
+	transport TEXT,
+	url TEXT,
+	command TEXT,
+	active BOOLEAN DEFAULT FALSE
+);
+
+INSERT INTO providers VALUES ('openai', 'OpenAI', 'https://api.openai.com/v1', 'key', 'openai', 0);
+INSERT INTO roles VALUES ('helper', '{"id":"helper","name":"Helper","prompt":"Be helpful"}');
+INSERT INTO sessions VALUES ('trip', '2025-01-10T12:00:00Z', 'Trip',
+	'[{"id":"u1","origin":"user","text":"Plan a trip to Lisbon"},{"id":"a1","origin":"assistant","text":"Lisbon in spring is great","toolRequests":null}]');
@@ -0,0 +1,116 @@
+-- DB as created by the last release before schema migrations: all tables
+-- exist, messages are stored one per row and indexed with FTS4
+CREATE TABLE sessions (
+	session_id TEXT PRIMARY KEY,
+	date DATETIME,
+	summary TEXT,
+	data TEXT,
+	active_id TEXT
+);
+CREATE TABLE messages (
+	num INTEGER PRIMARY KEY AUTOINCREMENT,
+	id TEXT UNIQUE,
+	session_id TEXT,
+	parent_id TEXT,
+	origin TEXT,
+	text TEXT,
+	tool_requests TEXT,
+	transcript TEXT,
+	structured TEXT,
+	model_id TEXT,
+	usage TEXT,
+	created_at DATETIME
+);
+CREATE INDEX messages_session ON messages(session_id);
+CREATE VIRTUAL TABLE messages_fts USING fts4(text, tokenize=unicode61);
+CREATE TABLE providers (
+	id TEXT PRIMARY KEY,
+	name TEXT,
+	api_url TEXT,
+	api_key TEXT,
+	provider TEXT,
+	rate_limit INTEGER
+);
+CREATE TABLE roles (
+	id TEXT PRIMARY KEY,
+	data TEXT
+);
+CREATE TABLE mcp (
+	id TEXT PRIMARY KEY,
+	name TEXT,
+	transport TEXT,
+	url TEXT,
+	command TEXT,
+	active BOOLEAN DEFAULT FALSE
+);
+CREATE TABLE builtin_tools (
+	name TEXT PRIMARY KEY,
+	enabled BOOLEAN DEFAULT TRUE
+);
+CREATE TABLE fetch_audit (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id TEXT,
+	date DATETIME,
+	method TEXT,
+	url TEXT,
+	status INTEGER,
+	bytes INTEGER,
+	error TEXT
+);
+CREATE INDEX fetch_audit_session ON fetch_audit(session_id);
+CREATE TABLE datasets (
+	id TEXT PRIMARY KEY,
+	name TEXT UNIQUE,
+	type TEXT,
+	path TEXT,
+	created_at DATETIME
+);
+CREATE TABLE knowledge_bases (
+	id TEXT PRIMARY KEY,
+	name TEXT UNIQUE,
+	description TEXT,
+	embedding_model TEXT,
+	created_at DATETIME
+);
+CREATE TABLE kb_documents (
+	id TEXT PRIMARY KEY,
+	kb_id TEXT,
+	name TEXT,
+	size INTEGER,
+	created_at DATETIME
+);
+CREATE INDEX kb_documents_kb ON kb_documents(kb_id);
+CREATE TABLE kb_chunks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kb_id TEXT,
+	document_id TEXT,
+	seq INTEGER,
+	heading TEXT,
+	start_line INTEGER,
+	end_line INTEGER,
+	text TEXT,
+	embedding BLOB
+);
+CREATE INDEX kb_chunks_kb ON kb_chunks(kb_id);
+CREATE INDEX kb_chunks_document ON kb_chunks(document_id);
+CREATE VIRTUAL TABLE kb_chunks_fts USING fts4(text, tokenize=unicode61);
+CREATE TABLE memories (
+	num INTEGER PRIMARY KEY AUTOINCREMENT,
+	id TEXT UNIQUE,
+	content TEXT,
+	source TEXT,
+	session_id TEXT,
+	created_at DATETIME,
+	updated_at DATETIME
+);
+CREATE VIRTUAL TABLE memories_fts USING fts4(content, tokenize=unicode61);
+
+INSERT INTO providers VALUES ('openai', 'OpenAI', 'https://api.openai.com/v1', 'key', 'openai', 0);
+INSERT INTO roles VALUES ('helper', '{"id":"helper","name":"Helper","prompt":"Be helpful"}');
+INSERT INTO sessions VALUES ('trip', '2025-01-10T12:00:00Z', 'Trip', NULL, 'a1');
+INSERT INTO messages (id, session_id, parent_id, origin, text, model_id, created_at) VALUES
+	('u1', 'trip', '', 'user', 'Plan a trip to Lisbon', '', '2025-01-10 12:00:00+00:00'),
+	('a1', 'trip', 'u1', 'assistant', 'Lisbon in spring is great', 'gpt', '2025-01-10 12:00:05+00:00');
+INSERT INTO messages_fts (rowid, text) SELECT num, text FROM messages;
+INSERT INTO memories (id, content, source, created_at, updated_at) VALUES ('m1', 'Likes Lisbon', 'user', '2025-01-10 12:00:00+00:00', '2025-01-10 12:00:00+00:00');
+INSERT INTO memories_fts (rowid, content) SELECT num, content FROM memories;
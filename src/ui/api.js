@@ -50,6 +50,48 @@ async function apiListSessions() {
     }
 }
 
+async function apiImportSessions(files) {
+    try {
+        const form = new FormData();
+        for (const file of files) {
+            form.append('files', file);
+        }
+        const response = await fetch('/agent/sessions/import', {
+            method: 'POST',
+            body: form
+        });
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(JSON.stringify(data.errors || data.error));
+        }
+        return data
+    } catch (error) {
+        console.error("Failed to import sessions:", error);
+        return null
+    }
+}
+
+async function apiSearchSessions(query) {
+    try {
+        const response = await fetch('/agent/sessions/search', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({ "query": query })
+        })
+        if (!response.ok) {
+            throw new Error(`HTTP error! Status: ${response.status}`);
+        }
+        const data = await response.json();
+
+        return data.hits || []
+    } catch (error) {
+        console.error("Failed to search sessions:", error);
+        return []
+    }
+}
+
 async function apiTruncateSession(sessionId, messageId) {
     try {
         const response = await fetch(`/agent/sessions/${sessionId}/truncate/${messageId}`)
@@ -65,6 +107,36 @@ async function apiTruncateSession(sessionId, messageId) {
     }
 }
 
+async function apiForkSession(sessionId, messageId) {
+    try {
+        const response = await fetch(`/agent/sessions/${sessionId}/fork/${messageId}`)
+        if (!response.ok) {
+            throw new Error(`HTTP error! Status: ${response.status}`);
+        }
+        const data = await response.json();
+
+        return data.session
+    } catch (error) {
+        console.error("Failed to fork session:", error);
+        return null
+    }
+}
+
+async function apiSwitchBranch(sessionId, messageId) {
+    try {
+        const response = await fetch(`/agent/sessions/${sessionId}/branch/${messageId}`)
+        if (!response.ok) {
+            throw new Error(`HTTP error! Status: ${response.status}`);
+        }
+        const data = await response.json();
+
+        return data.session
+    } catch (error) {
+        console.error("Failed to switch branch:", error);
+        return null
+    }
+}
+
 async function apiDeleteMessage(sessionId, messageId) {
     try {
         const response = await fetch(`/agent/sessions/${sessionId}/messages/delete/${messageId}`);
@@ -161,6 +233,46 @@ async function apiToolChatStreaming(sessionId, message) {
     sendEvent('loading:generation-stopped', { sessionId: sessionId })
 }
 
+// Streams a new run for the message: with text the user message is edited,
+// without it the answer to the message is generated again.
+async function apiRerunMessage(sessionId, messageId, tools, text) {
+    let controller = new AbortController()
+    sendEvent('loading:generation-started', { sessionId: sessionId, controller: controller })
+
+    const action = text === undefined ? 'regenerate' : 'edit'
+    try {
+        const response = await fetch(`/agent/sessions/${sessionId}/messages/${action}/${messageId}`, {
+            signal: controller.signal,
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({
+                "modelID": getSelectedModelId(),
+                "roleID": getSelectedRoleId(),
+                "tools": tools,
+                "message": text
+            })
+        })
+        const reader = response.body.pipeThrough(new TextDecoderStream()).getReader()
+        while (true) {
+            const { value, done } = await reader.read();
+            if (done) break;
+        }
+    } catch (error) {
+        console.error("Failed to initiate streaming:", error);
+    }
+    sendEvent('loading:generation-stopped', { sessionId: sessionId })
+}
+
+async function apiRegenerateMessage(sessionId, messageId, tools) {
+    return apiRerunMessage(sessionId, messageId, tools)
+}
+
+async function apiEditMessage(sessionId, messageId, text, tools) {
+    return apiRerunMessage(sessionId, messageId, tools, text)
+}
+
 async function apiListModels() {
     try {
         const response = await fetch('/agent/models/list');
@@ -509,15 +621,12 @@ async function apiAgentConnect() {
             for (let i in Storage.sessions) {
                 const session = Storage.sessions[i]
                 if (session.id == parsedData.sessionId) {
-                    session.messages.push({
-                        text: parsedData.message.text,
-                        origin: parsedData.message.origin,
-                        toolRequests: parsedData.message.toolRequests
-                    })
+                    session.messages.push(parsedData.message)
+                    session.activeId = parsedData.message.id
                     break
                 }
             }
-            sendEvent('chat:new-message', { text: parsedData.message.text, origin: parsedData.message.origin, toolRequests: parsedData.message.toolRequests, sessionId: parsedData.sessionId })
+            sendEvent('chat:new-message', { ...parsedData.message, sessionId: parsedData.sessionId })
         } catch (error) {
             console.error(error)
         }
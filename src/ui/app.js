@@ -152,7 +152,7 @@ async function populateModelSelector() {
     const options = [
         { value: '', label: 'Select a Model', disabled: true, selected: true },
         ...Storage.providers.flatMap((provider, idx) =>
-            provider.models.map((model, modelIdx) => ({
+            provider.models.filter(model => !model.embedding).map((model, modelIdx) => ({
                 value: model.id,
                 label: model.name,
                 selected: idx === 0 && modelIdx === 0
@@ -197,13 +197,34 @@ function updateLastMessage(sessionId, message) {
                 return
             }
 
-            session.messages[session.messages.length - 1].text = message.text
-            session.messages[session.messages.length - 1].toolRequests = message.toolRequests
+            const last = session.messages.find(m => m.id == message.id) || session.messages[session.messages.length - 1]
+            last.text = message.text
+            last.toolRequests = message.toolRequests
             sendEvent('chat:last-message-update', { sessionId: sessionId })
             break
         }
     }
 }
 
+// Messages of the active branch, from the first one to session.activeId.
+// Sessions without links between messages have a single branch.
+function branchMessages(session) {
+    const messages = session.messages || []
+    if (!session.activeId || !messages.some(m => m.parentId)) {
+        return messages
+    }
+    const byId = new Map(messages.map(m => [m.id, m]))
+    const branch = []
+    for (let message = byId.get(session.activeId); message && branch.length < messages.length; message = byId.get(message.parentId)) {
+        branch.push(message)
+    }
+    return branch.reverse()
+}
+
+// Alternatives to the message, including itself, oldest first.
+function messageSiblings(session, message) {
+    return (session.messages || []).filter(m => (m.parentId || '') == (message.parentId || ''))
+}
+
 function addMessage(sessionId, message) {
 }
\ No newline at end of file
@@ -54,6 +54,7 @@ class ChatView extends HTMLElement {
                 this.appendMessage(e.detail)
             }
         })
+        document.addEventListener('chat:jump', e => this.jumpToMessage(e.detail.sessionId, e.detail.messageId))
         document.addEventListener('keydown', e => this.onDocumentKeydown(e))
 
         this.findInput.addEventListener('input', () => this.queueSearch(this.findInput.value))
@@ -124,7 +125,8 @@ class ChatView extends HTMLElement {
                 const thinkContent = messageElement.querySelector('.thinking-content');
                 const toolContent = messageElement.querySelector('.tool-content');
                 const messageContent = messageElement.querySelector('.message-content')
-                this.setAssistantMessageContent(messageContent, thinkContent, thinkSummary, toolContent, this.chatSession.messages[this.chatSession.messages.length - 1].text, this.chatSession.messages[this.chatSession.messages.length - 1].toolRequests)
+                const last = branchMessages(this.chatSession).slice(-1)[0]
+                this.setAssistantMessageContent(messageContent, thinkContent, thinkSummary, toolContent, last.text, last.toolRequests)
                 this.reapplySearch()
             } catch {
                 console.error(`Trying to update last message in chat but it doesnt exist, session: ${sessionId} `)
@@ -426,6 +428,7 @@ class ChatView extends HTMLElement {
     appendMessage(message) {
         const messageElement = document.createElement('div')
         messageElement.classList.add('message', message.origin)
+        messageElement.dataset.id = message.id
 
         const messageInnerContent = document.createElement('div');
         messageInnerContent.classList.add('message-inner-content');
@@ -455,6 +458,7 @@ class ChatView extends HTMLElement {
                 <button title="Copy" class="img-button" alt="Copy">7</button>
                 <button title="Reload" class="img-button" alt="Generate again">Z</button>
                 <button title="Delete" class="img-button" alt="Delete">&#xe053;</button>
+                ${message.origin == 'user' ? '<button title="Edit" class="img-button" alt="Edit">*</button>' : ''}
             </div>`;
             messageElement.insertAdjacentHTML('beforeend', copyDeleteButtonsHTML);
 
@@ -469,31 +473,9 @@ class ChatView extends HTMLElement {
                 }
                 navigator.clipboard.writeText(contentToCopy)
             });
-            buttons[1].addEventListener('click', async () => {
-                let messageToDelete = message
-
-                if (message.origin != 'user') {
-                    for (let i = 0; i <= this.chatSession.messages.length - 1; i++) {
-                        let msg = this.chatSession.messages[i]
-                        if (msg.id == message.id) {
-                            for (let k = i - 1; k >= 0; k--) {
-                                let backMsg = this.chatSession.messages[k]
-                                if (backMsg.origin == 'user') {
-                                    messageToDelete = backMsg
-                                    break
-                                }
-                            }
-                            break
-                        }
-                    }
-                }
-                const messageText = messageToDelete.text
-                await apiTruncateSession(this.chatSession.id, messageToDelete.id)
-                if (this.toolsSelected) {
-                    apiToolChatStreaming(this.chatSession.id, messageText)
-                } else {
-                    apiDirectChatStreaming(this.chatSession.id, messageText)
-                }
+            buttons[1].addEventListener('click', () => {
+                // the answer is generated on a new branch, the old one stays reachable
+                apiRegenerateMessage(this.chatSession.id, message.id, this.toolsSelected)
             });
             buttons[2].addEventListener('click', async () => {
                 if (await confirmDialog("Delete this message?")) {
@@ -501,6 +483,27 @@ class ChatView extends HTMLElement {
                 }
             });
 
+            if (message.origin == 'user') {
+                buttons[3].addEventListener('click', () => this.editMessage(message, messageInnerContent))
+            }
+
+            const siblings = messageSiblings(this.chatSession, message)
+            if (siblings.length > 1) {
+                const index = siblings.findIndex(m => m.id == message.id)
+                const switcher = document.createElement('span')
+                switcher.classList.add('branch-switcher')
+                switcher.title = siblings.map((m, i) => `${i + 1}: ${m.modelId || m.origin}`).join('\n')
+                switcher.innerHTML = `<button title="Previous branch" class="img-button">&lsaquo;</button>
+                    <span>${index + 1}/${siblings.length}</span>
+                    <button title="Next branch" class="img-button">&rsaquo;</button>`
+                const [previous, next] = switcher.querySelectorAll('button')
+                previous.disabled = index == 0
+                next.disabled = index == siblings.length - 1
+                previous.addEventListener('click', () => apiSwitchBranch(this.chatSession.id, siblings[index - 1].id))
+                next.addEventListener('click', () => apiSwitchBranch(this.chatSession.id, siblings[index + 1].id))
+                messageElement.querySelector('.copy-delete-buttons').appendChild(switcher)
+            }
+
             if (message.origin != 'user') {
                 const copyDeleteButtons = messageElement.querySelector('.copy-delete-buttons');
                 if (copyDeleteButtons) {
@@ -517,6 +520,45 @@ class ChatView extends HTMLElement {
         this.reapplySearch()
     }
 
+    // jumpToMessage scrolls to the message, switching to its branch first when
+    // it is not on the active one.
+    jumpToMessage(sessionId, messageId) {
+        if (!this.chatSession || this.chatSession.id != sessionId) {
+            return
+        }
+        const element = this.chatView.querySelector(`.message[data-id="${messageId}"]`)
+        if (element) {
+            element.scrollIntoView({ block: 'center' })
+            element.classList.add('jump-target')
+            setTimeout(() => element.classList.remove('jump-target'), 1500)
+        } else if (this.chatSession.messages.some(m => m.id == messageId)) {
+            this.pendingJump = messageId
+            apiSwitchBranch(sessionId, messageId)
+        }
+    }
+
+    // editMessage replaces the user message with an input, the edited text is
+    // sent as an alternative to the message on Enter.
+    editMessage(message, messageInnerContent) {
+        const input = document.createElement('textarea')
+        input.classList.add('edit-input')
+        input.value = message.text
+        messageInnerContent.replaceChildren(input)
+        input.focus()
+
+        input.addEventListener('keydown', e => {
+            if (e.key === 'Enter' && !e.shiftKey) {
+                e.preventDefault()
+                const text = input.value.trim()
+                if (text) {
+                    apiEditMessage(this.chatSession.id, message.id, text, this.toolsSelected)
+                }
+            } else if (e.key === 'Escape') {
+                messageInnerContent.textContent = message.text
+            }
+        })
+    }
+
     async sendMessageStreaming() {
         this.closeSearch()
         sendEvent('sessions:touch')
@@ -550,11 +592,16 @@ class ChatView extends HTMLElement {
             }
             if (session.messages && Array.isArray(session.messages)) {
                 this.chatView.innerHTML = '';
-                session.messages.forEach(message => {
+                branchMessages(session).forEach(message => {
                     this.appendMessage(message);
                 });
                 this.scrollToBottom();
                 this.reapplySearch();
+                if (this.pendingJump) {
+                    const messageId = this.pendingJump
+                    this.pendingJump = null
+                    this.jumpToMessage(session.id, messageId)
+                }
             }
         } else {
             console.error('trying to change null session')
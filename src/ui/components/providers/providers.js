@@ -81,7 +81,8 @@ class ProviderList extends List {
 
                     setStatus('Testing provider...', false);
                     try {
-                        const ok = await apiTestProvider(values, this.testProviderController.signal);
+                        // the key of a saved provider is masked, the server uses the saved one
+                        const ok = await apiTestProvider({ ...values, id: initialValues.id }, this.testProviderController.signal);
                         if (ok) {
                             setStatus('Provider test successful!', false);
                         } else {
@@ -62,16 +62,49 @@ class RoleList extends List {
             { name: 'generalInstruction', label: 'General instruction', type: 'text', required: false, multiline: true },
             { name: 'role', label: 'AI role and personality', type: 'text', required: false, multiline: true },
             { name: 'style', label: 'Conversation style & tone', type: 'text', required: false, multiline: true },
+            {
+                name: 'codeRunner',
+                label: 'Code runner tool',
+                type: 'select',
+                required: false,
+                options: [
+                    { value: 'lua', label: 'Lua' },
+                    { value: 'js', label: 'JavaScript' },
+                    { value: 'both', label: 'JavaScript and Lua' },
+                    { value: 'none', label: 'None' }
+                ]
+            },
+            { name: 'knowledgeBases', label: 'Knowledge bases (comma separated names)', type: 'text', required: false },
+            { name: 'retrievalTopK', label: 'Knowledge chunks per message', type: 'number', integer: true, min: 0, default: 4, required: false },
+            { name: 'memory', label: 'Use long-term memory', type: 'checkbox', required: false },
+            { name: 'extractMemories', label: 'Remember facts from ended sessions', type: 'checkbox', required: false },
+            { name: 'responseSchema', label: 'Response JSON Schema (structured answers)', type: 'text', required: false, multiline: true },
         ];
         const res = await showEditDialog({
             title,
             fields,
-            values: initialValues,
+            values: {
+                ...initialValues,
+                knowledgeBases: (initialValues.knowledgeBases || []).join(', '),
+                responseSchema: initialValues.responseSchema ? JSON.stringify(initialValues.responseSchema, null, 2) : ''
+            },
             buttons: [],
             onClose: () => { }
         });
 
         if (res) {
+            res.knowledgeBases = res.knowledgeBases.split(',').map(name => name.trim()).filter(name => name);
+            if ((res.responseSchema || '').trim()) {
+                try {
+                    res.responseSchema = JSON.parse(res.responseSchema);
+                } catch (e) {
+                    const confirmed = await confirmDialog('Response schema is not valid JSON. Save the role without it?');
+                    if (!confirmed) return;
+                    delete res.responseSchema;
+                }
+            } else {
+                delete res.responseSchema;
+            }
             await onSave(res);
         }
     }
@@ -5,6 +5,7 @@ class SessionList extends List {
         super();
 
         document.addEventListener('sessions:new', async e => await this.createNewSession());
+        document.addEventListener('sessions:import', e => this.importSessions());
         document.addEventListener('sessions:touch', e => this.touch());
 
         document.addEventListener('storage:sessions', e => this.items = Storage.sessions || []);
@@ -12,6 +13,8 @@ class SessionList extends List {
         document.addEventListener('storage:current-session', e => this.updateSessionHighlight());
 
         this.filter = '';
+        // best search hit of each session, by session id
+        this.hits = new Map();
         this.searchInput = document.getElementById('sessionSearch');
         this.searchClear = document.getElementById('sessionSearchClear');
 
@@ -19,13 +22,14 @@ class SessionList extends List {
             this.searchInput.addEventListener('input', e => {
                 this.filter = e.target.value.trim().toLowerCase();
                 this.updateClearButton();
-                this.updateList();
+                this.queueSearch();
             });
         }
 
         if (this.searchClear) {
             this.searchClear.addEventListener('click', e => {
                 this.filter = '';
+                this.hits = new Map();
                 if (this.searchInput) {
                     this.searchInput.value = '';
                     this.searchInput.focus();
@@ -57,13 +61,20 @@ class SessionList extends List {
         }
 
         const summary = session.summary ? session.summary : 'New chat';
+        const hit = this.filter ? this.hits.get(session.id) : null;
+        // the snippet is HTML escaped by the server
         item.innerHTML = `
-            <span class="session-summary">${summary}</span>
+            <div class="session-text">
+                <span class="session-summary">${summary}</span>
+                ${hit ? `<span class="session-snippet">${hit.snippet}</span>` : ''}
+            </div>
+            <div alt="Export" class="export-icon img-button" data-id="${session.id}">&#x21e9;</div>
             <div alt="Delete" class="delete-icon img-button" data-id="${session.id}">&#xe053;</div>
         `;
 
+        item.querySelector('.export-icon').addEventListener('click', e => this.handleExportSession(e, session.id));
         item.querySelector('.delete-icon').addEventListener('click', e => this.handleDeleteSession(e, session.id));
-        item.addEventListener('click', e => this.onItemClick(item, session))
+        item.addEventListener('click', e => this.onItemClick(item, session, hit))
         return item;
     }
 
@@ -83,25 +94,34 @@ class SessionList extends List {
         }
     }
 
+    // queueSearch searches messages of all sessions on the server once typing
+    // pauses, titles are matched right away.
+    queueSearch() {
+        clearTimeout(this.searchTimer);
+        this.updateList();
+        const filter = this.filter;
+        this.searchTimer = setTimeout(async () => {
+            const hits = filter ? await apiSearchSessions(filter) : [];
+            if (filter != this.filter) {
+                return;
+            }
+            this.hits = new Map();
+            for (let hit of hits) {
+                if (!this.hits.has(hit.sessionId)) {
+                    this.hits.set(hit.sessionId, hit);
+                }
+            }
+            this.updateList();
+        }, 250);
+    }
+
     matchesFilter(session) {
         if (!this.filter) {
             return true;
         }
 
         const title = (session.summary ? session.summary : 'New chat').toLowerCase();
-        if (title.includes(this.filter)) {
-            return true;
-        }
-
-        if (session.messages && Array.isArray(session.messages)) {
-            for (let message of session.messages) {
-                if (message.text && message.text.toLowerCase().includes(this.filter)) {
-                    return true;
-                }
-            }
-        }
-
-        return false;
+        return title.includes(this.filter) || this.hits.has(session.id);
     }
 
     updateClearButton() {
@@ -110,8 +130,62 @@ class SessionList extends List {
         }
     }
 
-    onItemClick(item, session) {
+    onItemClick(item, session, hit) {
         Storage.currentSession = session;
+        if (hit) {
+            sendEvent('chat:jump', { sessionId: session.id, messageId: hit.messageId });
+        }
+    }
+
+    async handleExportSession(e, sessionId) {
+        e.stopPropagation();
+
+        const res = await showEditDialog({
+            title: 'Export Session',
+            fields: [
+                {
+                    name: 'format',
+                    label: 'Format',
+                    type: 'select',
+                    options: [
+                        { value: 'md', label: 'Markdown' },
+                        { value: 'html', label: 'HTML' },
+                        { value: 'json', label: 'JSON' }
+                    ]
+                },
+                { name: 'thinking', label: 'Keep model reasoning', type: 'checkbox', required: false }
+            ],
+            values: {}
+        });
+
+        if (res) {
+            // the desktop view can't save files, the browser downloads the export
+            const params = new URLSearchParams({ format: res.format, thinking: res.thinking ? 'keep' : 'strip' });
+            await apiOpenLink(`${location.origin}/agent/sessions/${sessionId}/export?${params}`);
+        }
+    }
+
+    // importSessions uploads JSON exports or ChatGPT conversations.json files
+    // and reloads the list with the imported sessions.
+    importSessions() {
+        const input = document.createElement('input');
+        input.type = 'file';
+        input.accept = '.json,.zip';
+        input.multiple = true;
+        input.addEventListener('change', async () => {
+            if (input.files.length == 0) {
+                return;
+            }
+            const report = await apiImportSessions(input.files);
+            if (report) {
+                console.info('Imported sessions:', report.imported, 'skipped:', report.skipped, 'errors:', report.errors);
+                const sessions = await apiListSessions();
+                if (sessions) {
+                    Storage.sessions = sessions;
+                }
+            }
+        });
+        input.click();
     }
 
     async handleDeleteSession(e, sessionId) {
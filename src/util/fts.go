@@ -0,0 +1,90 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// ftsMaxTerms is the maximum number of words FTSQuery keeps.
+const ftsMaxTerms = 32
+
+var ftsTermRe = regexp.MustCompile(`[\p{L}\p{N}_]+`)
+
+// FTSQuery turns free text into a full text query matching any of its words,
+// so that the syntax of the FTS query language in the text can't break the
+// search. It works with both FTS4 and FTS5.
+func FTSQuery(text string) string {
+	terms := make([]string, 0, 8)
+	seen := make(map[string]bool)
+	for _, term := range ftsTermRe.FindAllString(strings.ToLower(text), -1) {
+		if len([]rune(term)) < 2 || seen[term] {
+			continue
+		}
+		seen[term] = true
+		terms = append(terms, `"`+term+`"`)
+		if len(terms) == ftsMaxTerms {
+			break
+		}
+	}
+	return strings.Join(terms, " OR ")
+}
+
+// FTSVersion returns "fts5" or "fts4" depending on the module the virtual
+// table was created with. Migrations create FTS5 tables, FTS4 ones were
+// created by builds without the sqlite_fts5 tag.
+func FTSVersion(ctx context.Context, db *sql.DB, table string) (string, error) {
+	var ddl string
+	err := db.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE name=?;", table).Scan(&ddl)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errors.New("full text index " + table + " does not exist")
+	}
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(strings.ToLower(ddl), "fts5") {
+		return "fts5", nil
+	}
+	return "fts4", nil
+}
+
+// BM25 computes BM25 score of a row from FTS4 matchinfo with 'pcnalx' format
+// for a table with a single column, FTS4 has no ranking function of its own.
+// Higher is better, unlike bm25() of FTS5.
+func BM25(matchinfo []byte) float64 {
+	const k1, b = 1.2, 0.75
+
+	values := make([]uint32, len(matchinfo)/4)
+	for i := range values {
+		values[i] = binary.NativeEndian.Uint32(matchinfo[i*4:])
+	}
+	if len(values) < 5 {
+		return 0
+	}
+	phrases, columns := int(values[0]), int(values[1])
+	if columns != 1 || len(values) < 5+3*phrases {
+		return 0
+	}
+	rowCount := float64(values[2])
+	avgLen := math.Max(float64(values[3]), 1)
+	rowLen := float64(values[4])
+
+	score := 0.0
+	for p := 0; p < phrases; p++ {
+		x := values[5+3*p:]
+		tf, docs := float64(x[0]), float64(x[2])
+		if tf == 0 {
+			continue
+		}
+		idf := math.Log((rowCount - docs + 0.5) / (docs + 0.5))
+		if idf <= 0 {
+			idf = 1e-6
+		}
+		score += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*rowLen/avgLen))
+	}
+	return score
+}
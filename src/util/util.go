@@ -6,14 +6,48 @@ import "strings"
 var thinkTags = []string{"think", "thinking"}
 
 func CutThinking(text string) string {
+	_, text = SplitThinking(text)
+	return text
+}
+
+// SplitThinking separates the reasoning of the model the text starts with in
+// <think> or <thinking> tags from the answer, both trimmed. Reasoning that is
+// not closed yet is left in the answer.
+func SplitThinking(text string) (thinking string, answer string) {
 	for _, tag := range thinkTags {
 		if strings.HasPrefix(text, "<"+tag+">") {
 			pos := strings.Index(text, "</"+tag+">")
 			if pos != -1 {
+				thinking = strings.TrimSpace(text[len("<"+tag+">"):pos])
 				text = text[pos+len("</"+tag+">"):]
 			}
 			break
 		}
 	}
-	return strings.TrimSpace(text)
+	return thinking, strings.TrimSpace(text)
+}
+
+// secretMask replaces secrets in API responses
+const secretMask = "••••••••"
+
+// MaskSecret hides a secret, keeping the last 4 characters of long ones so
+// the user can tell which one is set. It is empty when the secret is.
+func MaskSecret(secret string) string {
+	if len(secret) < 16 {
+		if secret == "" {
+			return ""
+		}
+		return secretMask
+	}
+	return secretMask + secret[len(secret)-4:]
+}
+
+// UnmaskSecret returns the secret a value received from the API stands for,
+// the current secret when the value is its mask, so masked secrets sent back
+// unchanged don't replace it.
+func UnmaskSecret(value string, current string) string {
+	if value != "" && value == MaskSecret(current) {
+		return current
+	}
+	return value
 }